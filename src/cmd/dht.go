@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	dht "github.com/firozt/go-torrent/src/internal/DHT"
+)
+
+func dhtCommand(args []string) error {
+	usage := fmt.Errorf("usage: go-torrent dht keygen|put|get [flags]")
+	if len(args) < 1 {
+		return usage
+	}
+
+	switch args[0] {
+	case "keygen":
+		return dhtKeygen(args[1:])
+	case "put":
+		return dhtPut(args[1:])
+	case "get":
+		return dhtGet(args[1:])
+	}
+	return usage
+}
+
+// dhtFlags registers the flags shared by put and get, the returned func starts a node from them
+func dhtFlags(fs *flag.FlagSet) func(ctx context.Context) (*dht.Server, error) {
+	config := dht.DefaultConfig()
+	fs.StringVar(&config.Addr, "addr", ":0", "udp address of the dht node")
+	fs.StringVar(&config.StatePath, "state", "", "file the routing table is kept in between runs")
+	bootstrap := fs.String("bootstrap", strings.Join(config.BootstrapNodes, ","), "comma separated host:port of nodes to join through")
+
+	return func(ctx context.Context) (*dht.Server, error) {
+		config.BootstrapNodes = nil
+		for _, node := range strings.Split(*bootstrap, ",") {
+			if node != "" {
+				config.BootstrapNodes = append(config.BootstrapNodes, node)
+			}
+		}
+
+		server, err := dht.NewServer(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := server.Bootstrap(ctx); err != nil {
+			server.Close()
+			return nil, err
+		}
+		return server, nil
+	}
+}
+
+// the key file holds the hex encoded 32 byte ed25519 seed
+func readKey(path string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid key file %s", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func dhtKeygen(args []string) error {
+	fs := flag.NewFlagSet("dht keygen", flag.ContinueOnError)
+	path := fs.String("key", "dht.key", "file to write the private key to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*path, []byte(hex.EncodeToString(private.Seed())+"\n"), 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("public key %x\n", []byte(public))
+	return nil
+}
+
+func dhtPut(args []string) error {
+	fs := flag.NewFlagSet("dht put", flag.ContinueOnError)
+	start := dhtFlags(fs)
+	keyPath := fs.String("key", "", "key file from dht keygen, puts a mutable item when set")
+	salt := fs.String("salt", "", "salt of a mutable item, one key can hold an item per salt")
+	seq := fs.Int64("seq", -1, "sequence number of a mutable item, -1 uses one above the current item")
+	cas := fs.Int64("cas", -1, "only replace the mutable item if it has this sequence number, -1 to disable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: go-torrent dht put [flags] <value>")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	server, err := start(ctx)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	var item *dht.Item
+	if *keyPath == "" {
+		item, err = dht.NewImmutableItem(fs.Arg(0))
+	} else {
+		key, keyErr := readKey(*keyPath)
+		if keyErr != nil {
+			return keyErr
+		}
+		if *seq < 0 {
+			*seq = 1
+			current, err := server.GetItem(ctx, dht.MutableTarget(key.Public().(ed25519.PublicKey), []byte(*salt)), []byte(*salt))
+			if err == nil {
+				*seq = current.Seq + 1
+			} else if !errors.Is(err, dht.ErrItemNotFound) {
+				return err
+			}
+		}
+		item, err = dht.NewMutableItem(key, fs.Arg(0), []byte(*salt), *seq)
+	}
+	if err != nil {
+		return err
+	}
+
+	var casPtr *int64
+	if *cas >= 0 {
+		casPtr = cas
+	}
+	stored, err := server.PutItem(ctx, item, casPtr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("stored %s on %d nodes\n", item.Target(), stored)
+	if item.Mutable() {
+		fmt.Printf("public key %x seq %d\n", []byte(item.K), item.Seq)
+	}
+	return nil
+}
+
+func dhtGet(args []string) error {
+	fs := flag.NewFlagSet("dht get", flag.ContinueOnError)
+	start := dhtFlags(fs)
+	pubkey := fs.String("pubkey", "", "hex public key of a mutable item, otherwise the argument is the target of an immutable item")
+	salt := fs.String("salt", "", "salt of the mutable item")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var target dht.ID
+	switch {
+	case *pubkey != "":
+		key, err := hex.DecodeString(*pubkey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid public key %s", *pubkey)
+		}
+		target = dht.MutableTarget(key, []byte(*salt))
+	case fs.NArg() == 1:
+		raw, err := hex.DecodeString(fs.Arg(0))
+		if err != nil || len(raw) != 20 {
+			return fmt.Errorf("invalid target %s", fs.Arg(0))
+		}
+		target = dht.ID(raw)
+	default:
+		return fmt.Errorf("usage: go-torrent dht get [flags] <hex target> | -pubkey <hex key> [-salt salt]")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	server, err := start(ctx)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	item, err := server.GetItem(ctx, target, []byte(*salt))
+	if err != nil {
+		return err
+	}
+	v, err := item.Value()
+	if err != nil {
+		return err
+	}
+	if item.Mutable() {
+		fmt.Printf("seq %d\n", item.Seq)
+	}
+	if s, ok := v.(string); ok {
+		fmt.Println(s)
+	} else {
+		fmt.Printf("%v\n", v)
+	}
+	return nil
+}
@@ -2,13 +2,41 @@ package main
 
 import (
 	"fmt"
-	"strings"
-
-	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+	"os"
 )
 
+// command is a cli subcommand, args excludes the command name itself
+type command func(args []string) error
+
+var commands = map[string]command{
+	"dht":     dhtCommand,
+	"tracker": trackerCommand,
+	"verify":  verifyCommand,
+}
+
 func main() {
-	fmt.Println("Starting")
-	r := strings.NewReader("d8:announce41:http://bttracker.debian.org:6969/announce7:comment35:DebianCDfromcdimage.debian.org13:creationdatei1573903810ee")
-	bencodeparser.Read(r, nil)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: go-torrent <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  dht keygen|put|get  store and fetch signed or immutable items on the dht")
+	fmt.Fprintln(os.Stderr, "  tracker serve       run an embedded http/udp tracker")
+	fmt.Fprintln(os.Stderr, "  verify              hash check data on disk against a .torrent file")
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	trackerserver "github.com/firozt/go-torrent/src/internal/TrackerServer"
+)
+
+func trackerCommand(args []string) error {
+	if len(args) < 1 || args[0] != "serve" {
+		return fmt.Errorf("usage: go-torrent tracker serve [flags]")
+	}
+
+	config := trackerserver.DefaultConfig()
+	fs := flag.NewFlagSet("tracker serve", flag.ContinueOnError)
+	httpAddr := fs.String("http", ":6969", "address to serve http announce/scrape on, empty to disable")
+	udpAddr := fs.String("udp", ":6969", "address to serve udp (BEP 15) on, empty to disable")
+	allow := fs.String("allow", "", "comma separated hex info hashes, when set only these torrents are tracked")
+	fs.DurationVar(&config.Interval, "interval", config.Interval, "announce interval handed to clients")
+	fs.DurationVar(&config.PeerTTL, "peer-ttl", config.PeerTTL, "drop peers that have not announced within this window")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	for _, raw := range strings.Split(*allow, ",") {
+		if raw == "" {
+			continue
+		}
+		decoded, err := hex.DecodeString(raw)
+		if err != nil || len(decoded) != 20 {
+			return fmt.Errorf("invalid info hash in allowlist - %s", raw)
+		}
+		config.Allowlist = append(config.Allowlist, [20]byte(decoded))
+	}
+
+	server := trackerserver.NewServer(config)
+	defer server.Close()
+
+	if *httpAddr != "" {
+		addr, err := server.ListenHTTP(*httpAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("http tracker listening on %s\n", addr)
+	}
+	if *udpAddr != "" {
+		addr, err := server.ListenUDP(*udpAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("udp tracker listening on %s\n", addr)
+	}
+
+	// serve until interrupted
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+
+	return nil
+}
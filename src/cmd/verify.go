@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrentvalidator "github.com/firozt/go-torrent/src/internal/TorrentValidator"
+)
+
+func verifyCommand(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "directory the torrent data was saved to")
+	workers := fs.Int("workers", 0, "pieces hashed in parallel, 0 uses every cpu")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: go-torrent verify [flags] <file.torrent>")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	torrentFile, err := torrentvalidator.ParseTorrentFile(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("invalid torrent file - %w", err)
+	}
+
+	store, err := storage.NewFileStorage(torrentFile, *dir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	res, err := storage.Verify(ctx, store, torrentFile, *workers)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range res.Files {
+		percent := 100.0
+		if file.Length > 0 {
+			percent = float64(file.Verified) / float64(file.Length) * 100
+		}
+		fmt.Printf("%6.2f%%  %s\n", percent, file.Path)
+	}
+	fmt.Printf("%d/%d pieces verified, %d bytes left\n", res.Bitfield.Count(), len(torrentFile.Pieces), res.Left())
+	fmt.Printf("bitfield %x\n", []byte(res.Bitfield))
+
+	return nil
+}
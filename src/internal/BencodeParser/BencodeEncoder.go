@@ -0,0 +1,190 @@
+package bencodeparser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedType occurs when a value has no bencode representation (floats, bools, channels...)
+var ErrUnsupportedType = fmt.Errorf("type cannot be bencoded")
+
+/*
+Marshal takes any value and returns its bencoded form following the same CFG
+the parser uses
+@params
+v - value to encode, supported kinds are
+  - signed / unsigned integers -> integer
+  - string, []byte, [N]byte -> string
+  - slices and arrays -> list
+  - maps with string keys -> dict (keys are sorted as per spec)
+  - structs -> dict, keys taken from the `bencode` tag, then the field name
+
+@returns
+the encoded bytes or ErrUnsupportedType
+*/
+func Marshal(v any) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := Encode(buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes the bencoded form of v into w, see Marshal for supported types
+func Encode(w io.Writer, v any) error {
+	buf := new(bytes.Buffer)
+	if err := encodeValue(buf, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func encodeValue(buf *bytes.Buffer, val reflect.Value) error {
+	if !val.IsValid() {
+		return fmt.Errorf("%w - nil value", ErrUnsupportedType)
+	}
+
+	// unwrap interfaces and pointers until we reach a concrete value
+	for val.Kind() == reflect.Interface || val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return fmt.Errorf("%w - nil %s", ErrUnsupportedType, val.Kind())
+		}
+		val = val.Elem()
+	}
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(val.Int(), 10))
+		buf.WriteByte('e')
+		return nil
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(val.Uint(), 10))
+		buf.WriteByte('e')
+		return nil
+	case reflect.String:
+		encodeString(buf, val.String())
+		return nil
+	case reflect.Slice, reflect.Array:
+		// byte slices and arrays (info hashes, peer ids) are strings not lists
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			raw := make([]byte, val.Len())
+			reflect.Copy(reflect.ValueOf(raw), val)
+			encodeString(buf, string(raw))
+			return nil
+		}
+		buf.WriteByte('l')
+		for i := range val.Len() {
+			if err := encodeValue(buf, val.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+		return nil
+	case reflect.Map:
+		return encodeMap(buf, val)
+	case reflect.Struct:
+		return encodeStruct(buf, val)
+	case reflect.Uint8:
+		// lone bytes are treated as small integers
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(val.Uint(), 10))
+		buf.WriteByte('e')
+		return nil
+	default:
+		return fmt.Errorf("%w - %s", ErrUnsupportedType, val.Kind())
+	}
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+// dict keys must be written in raw byte order for the output to be canonical
+func encodeMap(buf *bytes.Buffer, val reflect.Value) error {
+	if val.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("%w - map keys must be strings", ErrUnsupportedType)
+	}
+
+	keys := make([]string, 0, val.Len())
+	for _, k := range val.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('d')
+	for _, k := range keys {
+		entry := val.MapIndex(reflect.ValueOf(k).Convert(val.Type().Key()))
+		// nil entries are skipped rather than failing the whole dict
+		if (entry.Kind() == reflect.Interface || entry.Kind() == reflect.Pointer) && entry.IsNil() {
+			continue
+		}
+		encodeString(buf, k)
+		if err := encodeValue(buf, entry); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+type structField struct {
+	key   string
+	value reflect.Value
+}
+
+// structs are encoded as dicts, supported tag options are `bencode:"key,omitempty"` and `bencode:"-"`
+func encodeStruct(buf *bytes.Buffer, val reflect.Value) error {
+	fields := []structField{}
+	typ := val.Type()
+
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		key := f.Name
+		omitEmpty := false
+		if tag, ok := f.Tag.Lookup("bencode"); ok {
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name != "" {
+				key = name
+			}
+			omitEmpty = opts == "omitempty"
+		}
+
+		fieldVal := val.Field(i)
+		if omitEmpty && fieldVal.IsZero() {
+			continue
+		}
+		if (fieldVal.Kind() == reflect.Pointer || fieldVal.Kind() == reflect.Interface) && fieldVal.IsNil() {
+			continue
+		}
+		fields = append(fields, structField{key: key, value: fieldVal})
+	}
+
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+
+	buf.WriteByte('d')
+	for _, f := range fields {
+		encodeString(buf, f.key)
+		if err := encodeValue(buf, f.value); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
@@ -0,0 +1,85 @@
+package bencodeparser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	type Nested struct {
+		Port     int      `bencode:"port"`
+		PeerID   [4]byte  `bencode:"peer id"`
+		Comment  string   `bencode:"comment,omitempty"`
+		Ignored  string   `bencode:"-"`
+		Tags     []string `bencode:"tags"`
+		internal int
+	}
+
+	type TestCase struct {
+		testname    string
+		input       any
+		expected    string
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"positive int", 42, "i42e", false},
+		{"negative int", int64(-7), "i-7e", false},
+		{"unsigned int", uint16(6881), "i6881e", false},
+		{"string", "spam", "4:spam", false},
+		{"empty string", "", "0:", false},
+		{"binary string", []byte{0x00, 0xff}, "2:\x00\xff", false},
+		{"list", []any{"a", 1, []any{}}, "l1:ai1elee", false},
+		{"dict keys sorted", map[string]any{"b": 1, "a": "x"}, "d1:a1:x1:bi1ee", false},
+		{
+			"struct with tags",
+			Nested{Port: 1, PeerID: [4]byte{'a', 'b', 'c', 'd'}, Ignored: "x", Tags: []string{"t"}},
+			"d7:peer id4:abcd4:porti1e4:tagsl1:tee",
+			false,
+		},
+		{"unsupported float", 1.5, "", true},
+		{"unsupported map key", map[int]any{1: 1}, "", true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := Marshal(tc.input)
+
+			if tc.throwsError && err == nil {
+				t.Errorf("Expected an error did not recieve any")
+				return
+			}
+			if !tc.throwsError && err != nil {
+				t.Errorf("Did not expect to throw an error, however did %s\n", err)
+				return
+			}
+			if string(got) != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%q\nWANT:%q\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMarshalDecodeRoundTrip(t *testing.T) {
+	input := map[string]any{
+		"interval": int64(1800),
+		"peers":    "\x7f\x00\x00\x01\x1a\xe1",
+		"files": []any{
+			map[string]any{"length": int64(10), "path": []any{"a", "b"}},
+		},
+	}
+
+	encoded, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("unexpected error - %s", err)
+	}
+
+	got, err := DecodeBytes(encoded)
+	if err != nil {
+		t.Fatalf("unexpected error - %s", err)
+	}
+
+	if !reflect.DeepEqual(got, input) {
+		t.Errorf("Got and want are not equal\nGOT:\n%#v\nWANT:\n%#v\n", got, input)
+	}
+}
@@ -1,6 +1,7 @@
 package bencodeparser
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
@@ -120,6 +121,59 @@ func Read(reader io.Reader, v any) error {
 	return nil
 }
 
+/*
+Decode takes in a reader and returns the intermediate representation of the
+first bencoded value found, unlike Read no struct mapping is done
+@params
+reader - reader object that represents the input stream
+@returns
+one of int64, string, []any or map[string]any, strings are raw bytes and may not be valid utf8
+*/
+func Decode(reader io.Reader) (any, error) {
+	if reader == nil {
+		return nil, fmt.Errorf("no reader supplied")
+	}
+
+	b := makeBencodeParser(&reader)
+	return b.parseValue()
+}
+
+// DecodeBytes is a helper for Decode when the data is already in memory (udp packets, resume files)
+func DecodeBytes(data []byte) (any, error) {
+	return Decode(bytes.NewReader(data))
+}
+
+/*
+DecodeTorrent decodes a .torrent file into its intermediate representation along with the
+info hash, the sha1 of the raw info dict bytes. Unlike Read no json bridge is involved so
+binary strings such as pieces are kept intact
+@params
+reader - reader object that represents the input stream
+@returns
+the top level dict and info hash, errors when the data is not a dict or has no info dict
+*/
+func DecodeTorrent(reader io.Reader) (map[string]any, [20]byte, error) {
+	if reader == nil {
+		return nil, [20]byte{}, fmt.Errorf("no reader supplied")
+	}
+
+	b := makeBencodeParser(&reader)
+	value, err := b.parseValue()
+	if err != nil {
+		return nil, [20]byte{}, fmt.Errorf("unable to parse bencode raw data - %s", err)
+	}
+
+	res, ok := value.(map[string]any)
+	if !ok {
+		return nil, [20]byte{}, fmt.Errorf("torrent data is not a dictionary")
+	}
+	if _, ok := res["info"].(map[string]any); !ok || len(b.infoBytes) == 0 {
+		return nil, [20]byte{}, fmt.Errorf("torrent data has no info dictionary")
+	}
+
+	return res, sha1.Sum(b.infoBytes), nil
+}
+
 func (b *BencodeParser) irToBencode(ir map[string]any, data any) error {
 	ir["peers"] = ""
 	prettyPrintMap(ir)
@@ -196,11 +250,12 @@ func (b *BencodeParser) unmarshal(data any) error {
 }
 
 func (b *BencodeParser) parseValue() (any, error) {
-	if b.cur_idx >= uint64(len(b.buf)) {
+	cur, err := b.peekToken()
+	if err != nil {
 		return nil, fmt.Errorf("index out of range of b.ffer")
 	}
 
-	switch string(b.buf[b.cur_idx]) {
+	switch string(cur) {
 	case "i": // int
 		// fmt.PrintLn("Parsing int")
 		return b.acceptInt()
@@ -214,7 +269,7 @@ func (b *BencodeParser) parseValue() (any, error) {
 		// fmt.PrintLn("Parsing List")
 		return b.acceptList()
 	default:
-		return nil, fmt.Errorf("could not find a suitable accept type for %s at index %d", string(b.buf[b.cur_idx]), b.cur_idx)
+		return nil, fmt.Errorf("could not find a suitable accept type for %s at index %d", string(cur), b.cur_idx)
 	}
 }
 
@@ -390,16 +445,18 @@ func (b *BencodeParser) acceptString() (string, error) {
 	}
 
 	// cur token should now be start of the string
-	res := ""
+	// collected as raw bytes, strings such as pieces and peers are binary blobs
+	raw := make([]byte, 0, min(stringLength, 1<<16))
 
 	// we know how long to scan for, only error can be EOF
-	for i := 0; i < int(stringLength); i++ {
+	for i := uint64(0); i < stringLength; i++ {
 		curval, consumeErr := b.consumeToken()
 		if consumeErr != nil {
 			return "", consumeErr
 		}
-		res += string(curval)
+		raw = append(raw, curval)
 	}
+	res := string(raw)
 
 	if res == "info" {
 		b.numDictsInInfoParsed = 0 // in info key but 0 depth, waiting for dict value
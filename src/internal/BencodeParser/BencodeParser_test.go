@@ -1,6 +1,7 @@
 package bencodeparser
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
@@ -458,3 +459,38 @@ func readTestDataFile(filename string) io.Reader {
 	}
 	return f
 }
+
+func TestDecodeTorrent(t *testing.T) {
+	type TestCase struct {
+		fileName         string
+		expectedInfoHash [20]byte
+		expectedPieces   int // number of 20 byte hashes
+	}
+
+	testcase := []TestCase{
+		{"alice.torrent", [20]byte{0x72, 0x2f, 0xe6, 0x5b, 0x2a, 0xa2, 0x6d, 0x14, 0xf3, 0x5b, 0x4a, 0xd6, 0x27, 0xd2, 0x02, 0x36, 0xe4, 0x81, 0xd9, 0x24}, 10},
+		{"cosmos-laundromat.torrent", [20]byte{0xc9, 0xe1, 0x57, 0x63, 0xf7, 0x22, 0xf2, 0x3e, 0x98, 0xa2, 0x9d, 0xec, 0xdf, 0xae, 0x34, 0x1b, 0x98, 0xd5, 0x30, 0x56}, 843},
+	}
+
+	for _, tc := range testcase {
+		t.Run(tc.fileName, func(t *testing.T) {
+			got, infoHash, err := DecodeTorrent(readTestDataFile(tc.fileName))
+			if err != nil {
+				t.Fatalf("unexpected error thrown by DecodeTorrent - %s\n", err)
+			}
+			if infoHash != tc.expectedInfoHash {
+				t.Errorf("Got and want are not equal\nGOT:%x\nWANT:%x\n", infoHash, tc.expectedInfoHash)
+			}
+
+			// the binary pieces string must survive byte for byte
+			pieces, _ := got["info"].(map[string]any)["pieces"].(string)
+			if len(pieces) != tc.expectedPieces*20 {
+				t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", len(pieces), tc.expectedPieces*20)
+			}
+		})
+	}
+
+	if _, _, err := DecodeTorrent(bytes.NewReader([]byte("li1ee"))); err == nil {
+		t.Errorf("Expected an error did not recieve any")
+	}
+}
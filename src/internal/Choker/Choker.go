@@ -0,0 +1,169 @@
+// Package choker decides which peers are allowed to download from us. Policies only see a snapshot
+// of each peer so they can be simulated without any connections
+package choker
+
+import (
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// ============ Struct Defs  ============ //
+
+// Peer is a snapshot of a connected peer taken every round, K identifies the peer across rounds
+type Peer[K comparable] struct {
+	Key          K
+	Interested   bool      // the peer wants pieces we have
+	Snubbed      bool      // the peer has not sent a block we requested for a while
+	DownloadRate float64   // bytes per second received from the peer
+	UploadRate   float64   // bytes per second sent to the peer
+	ConnectedAt  time.Time // when the connection was made
+}
+
+// Choker is a choking policy, Rechoke is called every round and whenever a peers interest changes.
+// seeding is set once every piece is held. Peers in the returned set are unchoked, every other peer is choked
+type Choker[K comparable] interface {
+	Rechoke(now time.Time, peers []Peer[K], seeding bool) map[K]bool
+}
+
+// Config holds the tunables of TitForTat
+type Config struct {
+	Slots              int           // peers unchoked at once, one of them optimistically
+	OptimisticInterval time.Duration // how long an optimistic unchoke lasts before rotating
+	NewPeerAge         time.Duration // peers connected for less than this are new
+	NewPeerWeight      int           // new peers are this many times more likely to be picked optimistically
+	Rand               *rand.Rand    // nil uses a randomly seeded source
+}
+
+func DefaultConfig() Config {
+	return Config{Slots: 4, OptimisticInterval: 30 * time.Second, NewPeerAge: time.Minute, NewPeerWeight: 3}
+}
+
+/*
+TitForTat is the choking algorithm of the BitTorrent spec. The Slots-1 interested peers with the
+best rate are unchoked, the rate being how fast they send to us while downloading and how fast
+we send to them while seeding. Peers that snub us lose their regular slot.
+One more interested peer is unchoked optimistically and rotated every OptimisticInterval, new
+peers are favoured as they have no pieces to trade yet
+*/
+type TitForTat[K comparable] struct {
+	config Config
+	rand   *rand.Rand
+
+	optimistic    K
+	hasOptimistic bool
+	rotateAt      time.Time
+}
+
+// ============ Method Defs  ============ //
+
+func NewTitForTat[K comparable](config Config) *TitForTat[K] {
+	if config.Rand == nil {
+		config.Rand = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	return &TitForTat[K]{config: config, rand: config.Rand}
+}
+
+func (c *TitForTat[K]) Rechoke(now time.Time, peers []Peer[K], seeding bool) map[K]bool {
+	rate := func(p Peer[K]) float64 {
+		if seeding {
+			return p.UploadRate
+		}
+		return p.DownloadRate
+	}
+
+	var regular []Peer[K]
+	for _, p := range peers {
+		// a seeding client downloads nothing so nobody can snub it
+		if p.Interested && (seeding || !p.Snubbed) {
+			regular = append(regular, p)
+		}
+	}
+	slices.SortStableFunc(regular, func(a, b Peer[K]) int {
+		switch {
+		case rate(a) > rate(b):
+			return -1
+		case rate(a) < rate(b):
+			return 1
+		}
+		return 0
+	})
+	regular = regular[:min(len(regular), max(c.config.Slots-1, 0))]
+
+	res := make(map[K]bool, c.config.Slots)
+	for _, p := range regular {
+		res[p.Key] = true
+	}
+
+	// keep the optimistic peer for its interval unless it left, lost interest or earned a regular slot.
+	// a rotation moves on to another peer when there is one
+	if !c.optimisticValid(now, peers, res) {
+		skip := func(K) bool { return false }
+		if previous := c.optimistic; c.hasOptimistic {
+			skip = func(key K) bool { return key == previous }
+		}
+		c.hasOptimistic = false
+
+		p, ok := c.pickOptimistic(now, peers, res, skip)
+		if !ok {
+			p, ok = c.pickOptimistic(now, peers, res, func(K) bool { return false })
+		}
+		if ok {
+			c.optimistic, c.hasOptimistic = p.Key, true
+			c.rotateAt = now.Add(c.config.OptimisticInterval)
+		}
+	}
+	if c.hasOptimistic {
+		res[c.optimistic] = true
+	}
+
+	return res
+}
+
+// Optimistic returns the peer currently unchoked optimistically
+func (c *TitForTat[K]) Optimistic() (K, bool) {
+	return c.optimistic, c.hasOptimistic
+}
+
+func (c *TitForTat[K]) optimisticValid(now time.Time, peers []Peer[K], unchoked map[K]bool) bool {
+	if !c.hasOptimistic || !now.Before(c.rotateAt) || unchoked[c.optimistic] {
+		return false
+	}
+	for _, p := range peers {
+		if p.Key == c.optimistic {
+			return p.Interested
+		}
+	}
+	return false
+}
+
+// pickOptimistic picks a random interested peer that is not unchoked already, new peers weigh more
+func (c *TitForTat[K]) pickOptimistic(now time.Time, peers []Peer[K], unchoked map[K]bool, skip func(K) bool) (Peer[K], bool) {
+	var candidates []Peer[K]
+	var weights []int
+	total := 0
+	for _, p := range peers {
+		if !p.Interested || unchoked[p.Key] || skip(p.Key) {
+			continue
+		}
+		weight := 1
+		if now.Sub(p.ConnectedAt) < c.config.NewPeerAge {
+			weight = max(c.config.NewPeerWeight, 1)
+		}
+		candidates = append(candidates, p)
+		weights = append(weights, weight)
+		total += weight
+	}
+	if total == 0 {
+		return Peer[K]{}, false
+	}
+
+	n := c.rand.IntN(total)
+	for i, weight := range weights {
+		if n < weight {
+			return candidates[i], true
+		}
+		n -= weight
+	}
+	return Peer[K]{}, false
+}
@@ -0,0 +1,191 @@
+package choker
+
+import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func makeChoker(slots int, seed uint64) *TitForTat[string] {
+	config := DefaultConfig()
+	config.Slots = slots
+	config.Rand = rand.New(rand.NewPCG(seed, seed))
+	return NewTitForTat[string](config)
+}
+
+// regular returns the unchoked peers without the optimistic one
+func regular(c *TitForTat[string], unchoked map[string]bool) []string {
+	res := []string{}
+	for _, key := range slices.Sorted(maps.Keys(unchoked)) {
+		if optimistic, ok := c.Optimistic(); !ok || key != optimistic {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+func TestRechokeTopPeers(t *testing.T) {
+	old := start.Add(-time.Hour)
+	type TestCase struct {
+		testname string
+		peers    []Peer[string]
+		seeding  bool
+		expected []string // regular unchokes, sorted
+	}
+
+	testcases := []TestCase{
+		{"fastest downloads", []Peer[string]{
+			{Key: "a", Interested: true, DownloadRate: 10, ConnectedAt: old},
+			{Key: "b", Interested: true, DownloadRate: 50, ConnectedAt: old},
+			{Key: "c", Interested: true, DownloadRate: 30, ConnectedAt: old},
+			{Key: "d", Interested: true, DownloadRate: 20, UploadRate: 100, ConnectedAt: old},
+			{Key: "e", Interested: true, DownloadRate: 0, ConnectedAt: old},
+		}, false, []string{"b", "c", "d"}},
+		{"fastest uploads when seeding", []Peer[string]{
+			{Key: "a", Interested: true, UploadRate: 40, ConnectedAt: old},
+			{Key: "b", Interested: true, DownloadRate: 50, ConnectedAt: old},
+			{Key: "c", Interested: true, UploadRate: 30, ConnectedAt: old},
+			{Key: "d", Interested: true, UploadRate: 20, ConnectedAt: old},
+			{Key: "e", Interested: true, UploadRate: 10, ConnectedAt: old},
+		}, true, []string{"a", "c", "d"}},
+		{"uninterested ignored", []Peer[string]{
+			{Key: "a", DownloadRate: 100, ConnectedAt: old},
+			{Key: "b", Interested: true, DownloadRate: 1, ConnectedAt: old},
+		}, false, []string{"b"}},
+		{"snubbed lose their slot", []Peer[string]{
+			{Key: "a", Interested: true, Snubbed: true, DownloadRate: 100, ConnectedAt: old},
+			{Key: "b", Interested: true, DownloadRate: 3, ConnectedAt: old},
+			{Key: "c", Interested: true, DownloadRate: 2, ConnectedAt: old},
+			{Key: "d", Interested: true, DownloadRate: 1, ConnectedAt: old},
+		}, false, []string{"b", "c", "d"}},
+		{"nobody snubs a seed", []Peer[string]{
+			{Key: "a", Interested: true, Snubbed: true, UploadRate: 100, ConnectedAt: old},
+			{Key: "b", Interested: true, UploadRate: 3, ConnectedAt: old},
+			{Key: "c", Interested: true, UploadRate: 2, ConnectedAt: old},
+			{Key: "d", Interested: true, UploadRate: 1, ConnectedAt: old},
+		}, true, []string{"a", "b", "c"}},
+		{"no peers", nil, false, []string{}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			c := makeChoker(4, 1)
+			unchoked := c.Rechoke(start, tc.peers, tc.seeding)
+
+			if got := regular(c, unchoked); !slices.Equal(got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, tc.expected)
+			}
+
+			// every interested peer left over is a candidate for the optimistic unchoke
+			optimistic, ok := c.Optimistic()
+			leftOver := slices.ContainsFunc(tc.peers, func(p Peer[string]) bool {
+				return p.Interested && !slices.Contains(tc.expected, p.Key)
+			})
+			if ok != leftOver {
+				t.Fatalf("Got and want are not equal\nGOT:%v\nWANT:%v\n", ok, leftOver)
+			}
+			if ok && (!unchoked[optimistic] || slices.Contains(tc.expected, optimistic)) {
+				t.Errorf("optimistic unchoke %q is not a left over peer, unchoked %v", optimistic, unchoked)
+			}
+		})
+	}
+}
+
+func TestOptimisticRotation(t *testing.T) {
+	old := start.Add(-time.Hour)
+	peers := []Peer[string]{
+		{Key: "a", Interested: true, DownloadRate: 100, ConnectedAt: old},
+		{Key: "b", Interested: true, ConnectedAt: old},
+		{Key: "c", Interested: true, ConnectedAt: old},
+		{Key: "d", Interested: true, ConnectedAt: old},
+	}
+
+	for seed := range uint64(20) {
+		c := makeChoker(2, seed)
+		c.Rechoke(start, peers, false)
+		first, ok := c.Optimistic()
+		if !ok || first == "a" {
+			t.Fatalf("Got and want are not equal\nGOT:%q %v\nWANT:a peer other than a\n", first, ok)
+		}
+
+		// rounds every 10 seconds keep the same optimistic peer until 30 seconds pass
+		for _, elapsed := range []time.Duration{10 * time.Second, 20 * time.Second} {
+			unchoked := c.Rechoke(start.Add(elapsed), peers, false)
+			if got, _ := c.Optimistic(); got != first || len(unchoked) != 2 {
+				t.Fatalf("Got and want are not equal\nGOT:%q %v\nWANT:%q\n", got, unchoked, first)
+			}
+		}
+
+		c.Rechoke(start.Add(30*time.Second), peers, false)
+		if got, _ := c.Optimistic(); got == first || got == "a" {
+			t.Fatalf("Got and want are not equal\nGOT:%q\nWANT:a peer other than %q and a\n", got, first)
+		}
+	}
+}
+
+func TestOptimisticReplaced(t *testing.T) {
+	old := start.Add(-time.Hour)
+	type TestCase struct {
+		testname string
+		change   func(peers []Peer[string]) []Peer[string] // applied to the peers after the first round
+	}
+
+	testcases := []TestCase{
+		{"peer left", func(peers []Peer[string]) []Peer[string] { return peers[:1] }},
+		{"peer lost interest", func(peers []Peer[string]) []Peer[string] {
+			peers[1].Interested = false
+			return peers
+		}},
+		{"peer earned a regular slot", func(peers []Peer[string]) []Peer[string] {
+			peers[1].DownloadRate = 1000
+			return peers
+		}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			peers := []Peer[string]{
+				{Key: "a", Interested: true, DownloadRate: 100, ConnectedAt: old},
+				{Key: "b", Interested: true, ConnectedAt: old},
+			}
+			c := makeChoker(2, 1)
+			c.Rechoke(start, peers, false)
+			if got, _ := c.Optimistic(); got != "b" {
+				t.Fatalf("DEV ERR: expected b to be unchoked optimistically, got %q", got)
+			}
+
+			c.Rechoke(start.Add(time.Second), tc.change(peers), false)
+			if got, ok := c.Optimistic(); ok && got == "b" {
+				t.Errorf("Got and want are not equal\nGOT:%q\nWANT:b replaced\n", got)
+			}
+		})
+	}
+}
+
+func TestOptimisticPrefersNew(t *testing.T) {
+	peers := []Peer[string]{
+		{Key: "new", Interested: true, ConnectedAt: start.Add(-time.Second)},
+		{Key: "old1", Interested: true, ConnectedAt: start.Add(-time.Hour)},
+		{Key: "old2", Interested: true, ConnectedAt: start.Add(-time.Hour)},
+		{Key: "old3", Interested: true, ConnectedAt: start.Add(-time.Hour)},
+	}
+
+	// with no regular slots the new peer weighs 3 against 1 for each old peer, picked half the time
+	const rounds = 2000
+	picked := 0
+	for seed := range uint64(rounds) {
+		c := makeChoker(1, seed)
+		c.Rechoke(start, peers, false)
+		if got, _ := c.Optimistic(); got == "new" {
+			picked++
+		}
+	}
+
+	if picked < rounds*4/10 || picked > rounds*6/10 {
+		t.Errorf("Got and want are not equal\nGOT:%d of %d\nWANT:about half\n", picked, rounds)
+	}
+}
@@ -0,0 +1,340 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+)
+
+// ============ Struct Defs  ============ //
+
+// limits set by BEP 44
+const (
+	MaxValueSize = 1000 // bytes of the bencoded value
+	MaxSaltSize  = 64
+)
+
+// error codes added by BEP 44
+const (
+	CodeMessageTooBig    = 205
+	CodeInvalidSignature = 206
+	CodeSaltTooBig       = 207
+	CodeCASMismatch      = 301
+	CodeSeqTooLow        = 302
+)
+
+/*
+Item is a value stored on the DHT (BEP 44). An immutable item is stored under the sha1 of its
+value. A mutable item is stored under the sha1 of its public key and salt, and only the holder of the private key can
+update it by signing a value with a higher sequence number
+*/
+type Item struct {
+	V    []byte            // bencoded value
+	K    ed25519.PublicKey // nil for immutable items
+	Salt []byte
+	Seq  int64
+	Sig  []byte
+}
+
+var (
+	// ErrItemTooBig occurs when the bencoded value of an item is larger than MaxValueSize
+	ErrItemTooBig = fmt.Errorf("item value too big")
+	// ErrSaltTooBig occurs when the salt of a mutable item is larger than MaxSaltSize
+	ErrSaltTooBig = fmt.Errorf("item salt too big")
+	// ErrInvalidSignature occurs when a mutable item was not signed by its key
+	ErrInvalidSignature = fmt.Errorf("invalid item signature")
+	// ErrItemNotFound occurs when no node holds the item looked up
+	ErrItemNotFound = fmt.Errorf("item not found")
+)
+
+// ============ Method Defs  ============ //
+
+// NewImmutableItem bencodes v into an item stored under the hash of the encoding
+func NewImmutableItem(v any) (*Item, error) {
+	encoded, err := bencodeparser.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	item := &Item{V: encoded}
+	return item, item.Verify()
+}
+
+// NewMutableItem bencodes v into an item signed by key, seq must grow with every update
+func NewMutableItem(key ed25519.PrivateKey, v any, salt []byte, seq int64) (*Item, error) {
+	encoded, err := bencodeparser.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	item := &Item{V: encoded, K: key.Public().(ed25519.PublicKey), Salt: salt, Seq: seq}
+	item.Sig = ed25519.Sign(key, signatureBuffer(salt, seq, encoded))
+	return item, item.Verify()
+}
+
+// ImmutableTarget is where an immutable item with the bencoded value v is stored
+func ImmutableTarget(v []byte) ID {
+	return sha1.Sum(v)
+}
+
+// MutableTarget is where the mutable item of key and salt is stored
+func MutableTarget(key ed25519.PublicKey, salt []byte) ID {
+	return sha1.Sum(append(bytes.Clone(key), salt...))
+}
+
+func (i *Item) Mutable() bool {
+	return i.K != nil
+}
+
+func (i *Item) Target() ID {
+	if i.Mutable() {
+		return MutableTarget(i.K, i.Salt)
+	}
+	return ImmutableTarget(i.V)
+}
+
+// Value decodes the bencoded value
+func (i *Item) Value() (any, error) {
+	return bencodeparser.DecodeBytes(i.V)
+}
+
+// Verify checks the size limits and, for mutable items, the signature
+func (i *Item) Verify() error {
+	if len(i.V) > MaxValueSize {
+		return fmt.Errorf("%w - %d bytes", ErrItemTooBig, len(i.V))
+	}
+	if !i.Mutable() {
+		return nil
+	}
+	if len(i.Salt) > MaxSaltSize {
+		return fmt.Errorf("%w - %d bytes", ErrSaltTooBig, len(i.Salt))
+	}
+	if len(i.K) != ed25519.PublicKeySize || !ed25519.Verify(i.K, signatureBuffer(i.Salt, i.Seq, i.V), i.Sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// signatureBuffer is what a mutable item signs, the bencoded salt, seq and v keys without the surrounding dict
+func signatureBuffer(salt []byte, seq int64, v []byte) []byte {
+	buf := []byte{}
+	if len(salt) > 0 {
+		buf = append(buf, "4:salt"...)
+		buf = strconv.AppendInt(buf, int64(len(salt)), 10)
+		buf = append(buf, ':')
+		buf = append(buf, salt...)
+	}
+	buf = append(buf, "3:seqi"...)
+	buf = strconv.AppendInt(buf, seq, 10)
+	buf = append(buf, "e1:v"...)
+	return append(buf, v...)
+}
+
+// encodeValue bencodes a value decoded off the wire, giving the canonical encoding items are hashed and signed over
+func encodeValue(v any) ([]byte, error) {
+	if v == nil {
+		return nil, fmt.Errorf("%w - no value", ErrInvalidMessage)
+	}
+	return bencodeparser.Marshal(v)
+}
+
+// itemError maps a failed Verify to its BEP 44 error code
+func itemError(err error) *Error {
+	switch {
+	case errors.Is(err, ErrItemTooBig):
+		return &Error{CodeMessageTooBig, "message (v field) too big"}
+	case errors.Is(err, ErrSaltTooBig):
+		return &Error{CodeSaltTooBig, "salt (salt field) too big"}
+	case errors.Is(err, ErrInvalidSignature):
+		return &Error{CodeInvalidSignature, "invalid signature"}
+	}
+	return &Error{CodeProtocol, err.Error()}
+}
+
+// handleGet returns the item stored under target, a mutable item's value is left out unless it is newer than the seq asked for
+func (s *Server) handleGet(args map[string]any, addr *net.UDPAddr) (map[string]any, *Error) {
+	target, ok := nodeID(args, "target")
+	if !ok {
+		return nil, &Error{CodeProtocol, "invalid target"}
+	}
+	r := map[string]any{"token": s.token(addr.IP), "nodes": encodeNodes(s.table.Closest(target, K))}
+
+	s.mu.Lock()
+	stored := s.items[target]
+	s.mu.Unlock()
+	if stored == nil {
+		return r, nil
+	}
+
+	item := stored.item
+	if seq, ok := args["seq"].(int64); !item.Mutable() || !ok || item.Seq > seq {
+		v, err := item.Value()
+		if err != nil {
+			return nil, &Error{CodeServer, "corrupt item"}
+		}
+		r["v"] = v
+	}
+	if item.Mutable() {
+		r["k"], r["seq"], r["sig"] = []byte(item.K), item.Seq, item.Sig
+	}
+	return r, nil
+}
+
+// handlePut stores an item, a mutable item only replaces one with a lower seq and, when cas is set, the seq it names
+func (s *Server) handlePut(args map[string]any) (map[string]any, *Error) {
+	v, err := encodeValue(args["v"])
+	if err != nil {
+		return nil, &Error{CodeProtocol, "invalid v"}
+	}
+	item := &Item{V: v}
+	if k := asString(args["k"]); k != "" {
+		item.K, item.Seq, item.Sig = ed25519.PublicKey(k), asInt(args["seq"]), []byte(asString(args["sig"]))
+		if salt := asString(args["salt"]); salt != "" {
+			item.Salt = []byte(salt)
+		}
+	}
+	if err := item.Verify(); err != nil {
+		return nil, itemError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target := item.Target()
+	existing, ok := s.items[target]
+	if !ok && len(s.items) >= s.config.MaxItems {
+		return nil, &Error{CodeServer, "storage full"}
+	}
+	if ok && item.Mutable() {
+		if cas, hasCAS := args["cas"].(int64); hasCAS && cas != existing.item.Seq {
+			return nil, &Error{CodeCASMismatch, "the CAS hash mismatched, re-read value and try again"}
+		}
+		if item.Seq < existing.item.Seq || (item.Seq == existing.item.Seq && !bytes.Equal(item.V, existing.item.V)) {
+			return nil, &Error{CodeSeqTooLow, "sequence number less than current"}
+		}
+	}
+	s.items[target] = &storedItem{item: item, put: time.Now()}
+	return map[string]any{}, nil
+}
+
+/*
+Get asks a node for the item stored under target, salt is needed to check the signature of a mutable item
+@returns
+the item, nil when the node holds none or sent one that does not match target, along with the
+nodes it knows closer to target and the token needed to put to it
+*/
+func (s *Server) Get(ctx context.Context, addr *net.UDPAddr, target ID, salt []byte) (*Item, []Node, string, error) {
+	r, err := s.query(ctx, addr, "get", map[string]any{"target": target[:]})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	nodes, err := decodeNodes([]byte(asString(r["nodes"])))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	token := asString(r["token"])
+
+	v, err := encodeValue(r["v"])
+	if err != nil {
+		return nil, nodes, token, nil
+	}
+	item := &Item{V: v}
+	if k := asString(r["k"]); k != "" {
+		item.K, item.Salt, item.Seq, item.Sig = ed25519.PublicKey(k), salt, asInt(r["seq"]), []byte(asString(r["sig"]))
+	}
+	if item.Verify() != nil || item.Target() != target {
+		return nil, nodes, token, nil
+	}
+	return item, nodes, token, nil
+}
+
+// Put stores an item on a node, cas when not nil makes the put fail unless the node holds that seq
+func (s *Server) Put(ctx context.Context, addr *net.UDPAddr, item *Item, token string, cas *int64) error {
+	v, err := item.Value()
+	if err != nil {
+		return err
+	}
+	args := map[string]any{"token": token, "v": v}
+	if item.Mutable() {
+		args["k"], args["seq"], args["sig"] = []byte(item.K), item.Seq, item.Sig
+		if len(item.Salt) > 0 {
+			args["salt"] = item.Salt
+		}
+		if cas != nil {
+			args["cas"] = *cas
+		}
+	}
+	_, err = s.query(ctx, addr, "put", args)
+	return err
+}
+
+// GetItem looks up the item stored under target, the mutable item with the highest seq wins. salt is only used by mutable items
+func (s *Server) GetItem(ctx context.Context, target ID, salt []byte) (*Item, error) {
+	var mu sync.Mutex
+	var best *Item
+	closest := s.lookup(ctx, target, func(node Node) ([]Node, string, error) {
+		item, nodes, token, err := s.Get(ctx, node.Addr, target, salt)
+		mu.Lock()
+		if item != nil && (best == nil || item.Seq > best.Seq) {
+			best = item
+		}
+		mu.Unlock()
+		return nodes, token, err
+	})
+
+	switch {
+	case best != nil:
+		return best, nil
+	case len(closest) == 0:
+		return nil, ErrNoNodes
+	}
+	return nil, ErrItemNotFound
+}
+
+/*
+PutItem stores an item on the K nodes closest to its target, items expire so they should be put
+again every hour or so
+@returns
+how many nodes stored the item, the errors of every node when none did
+*/
+func (s *Server) PutItem(ctx context.Context, item *Item, cas *int64) (int, error) {
+	if err := item.Verify(); err != nil {
+		return 0, err
+	}
+	closest := s.lookup(ctx, item.Target(), func(node Node) ([]Node, string, error) {
+		_, nodes, token, err := s.Get(ctx, node.Addr, item.Target(), item.Salt)
+		return nodes, token, err
+	})
+	if len(closest) == 0 {
+		return 0, ErrNoNodes
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(closest))
+	for i, c := range closest {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Put(ctx, c.node.Addr, item, c.token, cas)
+		}()
+	}
+	wg.Wait()
+
+	stored := 0
+	for _, err := range errs {
+		if err == nil {
+			stored++
+		}
+	}
+	if stored == 0 {
+		return 0, errors.Join(errs...)
+	}
+	return stored, nil
+}
@@ -0,0 +1,149 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("DEV ERR: invalid hex %s", s)
+	}
+	return b
+}
+
+// the test vectors of BEP 44
+func TestItemVectors(t *testing.T) {
+	immutable, err := NewImmutableItem("Hello World!")
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if got := immutable.Target().String(); got != "e5f96f6f38320f0f33959cb4d3d656452117aadb" {
+		t.Errorf("Got and want are not equal\nGOT:%s\nWANT:e5f96f6f38320f0f33959cb4d3d656452117aadb\n", got)
+	}
+
+	key := ed25519.PublicKey(unhex(t, "77ff84905a91936367c01360803104f92432fcd904a43511876df5cdf3e7e548"))
+	type TestCase struct {
+		testname string
+		salt     string
+		sig      string
+		target   string
+	}
+
+	testcases := []TestCase{
+		{"mutable", "", "305ac8aeb6c9c151fa120f120ea2cfb923564e11552d06a5d856091e5e853cff1260d3f39e4999684aa92eb73ffd136e6f4f3ecbfda0ce53a1608ecd7ae21f01", "4a533d47ec9c7d95b1ad75f576cffc641853b750"},
+		{"mutable with salt", "foobar", "6834284b6b24c3204eb2fea824d82f88883a3d95e8b4a21b8c0ded553d17d17ddf9a8a7104b1258f30bed3787e6cb896fca78c58f8e03b5f18f14951a87d9a08", "411eba73b6f087ca51a3795d9c8c938d365e32c1"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			item := &Item{V: []byte("12:Hello World!"), K: key, Seq: 1, Sig: unhex(t, tc.sig)}
+			if tc.salt != "" {
+				item.Salt = []byte(tc.salt)
+			}
+			if err := item.Verify(); err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+			}
+			if got := item.Target().String(); got != tc.target {
+				t.Errorf("Got and want are not equal\nGOT:%s\nWANT:%s\n", got, tc.target)
+			}
+
+			item.Seq = 2
+			if err := item.Verify(); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestItemLimits(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(nil)
+
+	if _, err := NewImmutableItem(string(make([]byte, MaxValueSize))); !errors.Is(err, ErrItemTooBig) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrItemTooBig)
+	}
+	if _, err := NewMutableItem(key, "v", make([]byte, MaxSaltSize+1), 1); !errors.Is(err, ErrSaltTooBig) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrSaltTooBig)
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	ctx := context.Background()
+	nodes := []*Server{newServer(t, testConfig())}
+	for range 9 {
+		config := testConfig()
+		config.BootstrapNodes = []string{nodes[0].Addr().String()}
+		s := newServer(t, config)
+		if err := s.Bootstrap(ctx); err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+		nodes = append(nodes, s)
+	}
+	writer, reader := nodes[3], nodes[7]
+
+	// immutable
+	immutable, _ := NewImmutableItem(map[string]any{"channel": "stable", "version": 3})
+	if _, err := writer.PutItem(ctx, immutable, nil); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	got, err := reader.GetItem(ctx, immutable.Target(), nil)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if string(got.V) != string(immutable.V) {
+		t.Errorf("Got and want are not equal\nGOT:%s\nWANT:%s\n", got.V, immutable.V)
+	}
+	if _, err := reader.GetItem(ctx, RandomID(), nil); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrItemNotFound)
+	}
+
+	// mutable, the highest seq wins and stale or mismatched puts are refused
+	public, key, _ := ed25519.GenerateKey(nil)
+	salt := []byte("release")
+	first, _ := NewMutableItem(key, "v1.0.0", salt, 1)
+	second, _ := NewMutableItem(key, "v1.1.0", salt, 2)
+	if _, err := writer.PutItem(ctx, first, nil); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	cas := int64(1)
+	if _, err := writer.PutItem(ctx, second, &cas); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	var kerr *Error
+	if _, err := writer.PutItem(ctx, first, nil); !errors.As(err, &kerr) || kerr.Code != CodeSeqTooLow {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:error %d\n", err, CodeSeqTooLow)
+	}
+	third, _ := NewMutableItem(key, "v1.2.0", salt, 3)
+	if _, err := writer.PutItem(ctx, third, &cas); !errors.As(err, &kerr) || kerr.Code != CodeCASMismatch {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:error %d\n", err, CodeCASMismatch)
+	}
+
+	got, err = reader.GetItem(ctx, MutableTarget(public, salt), salt)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if got.Seq != 2 || string(got.V) != "6:v1.1.0" {
+		t.Errorf("Got and want are not equal\nGOT:%d %s\nWANT:2 6:v1.1.0\n", got.Seq, got.V)
+	}
+
+	// a forged item is refused
+	forged := *second
+	forged.V = []byte("6:v6.6.6")
+	forged.Seq = 9
+	if _, err := writer.PutItem(ctx, &forged, nil); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidSignature)
+	}
+	_, _, token, err := writer.Get(ctx, nodes[0].Addr(), forged.Target(), salt)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if err := writer.Put(ctx, nodes[0].Addr(), &forged, token, nil); !errors.As(err, &kerr) || kerr.Code != CodeInvalidSignature {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:error %d\n", err, CodeInvalidSignature)
+	}
+}
@@ -0,0 +1,128 @@
+package dht
+
+import (
+	"fmt"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+)
+
+// ============ Struct Defs  ============ //
+
+// message types, the y key of every KRPC message
+const (
+	typeQuery    = "q"
+	typeResponse = "r"
+	typeError    = "e"
+)
+
+// error codes defined by BEP 5
+const (
+	CodeGeneric       = 201
+	CodeServer        = 202
+	CodeProtocol      = 203
+	CodeMethodUnknown = 204
+)
+
+/*
+message is a single KRPC message, a bencoded dict sent as one udp packet. Arguments and return
+values are kept as decoded dicts since every query type, including the BEP 44 ones, carries
+different keys
+*/
+type message struct {
+	T string         // transaction id, echoed back in the reply
+	Y string         // one of typeQuery, typeResponse or typeError
+	Q string         // method name of a query
+	A map[string]any // arguments of a query
+	R map[string]any // return values of a response
+	E *Error
+}
+
+// Error is an error reply of a remote node
+type Error struct {
+	Code    int
+	Message string
+}
+
+// ErrInvalidMessage occurs when a packet is not a valid KRPC message
+var ErrInvalidMessage = fmt.Errorf("invalid krpc message")
+
+// ============ Method Defs  ============ //
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("krpc error %d - %s", e.Code, e.Message)
+}
+
+func (m *message) encode() ([]byte, error) {
+	dict := map[string]any{"t": m.T, "y": m.Y}
+	switch m.Y {
+	case typeQuery:
+		dict["q"], dict["a"] = m.Q, m.A
+	case typeResponse:
+		dict["r"] = m.R
+	case typeError:
+		dict["e"] = []any{m.E.Code, m.E.Message}
+	}
+	return bencodeparser.Marshal(dict)
+}
+
+func decodeMessage(packet []byte) (*message, error) {
+	ir, err := bencodeparser.DecodeBytes(packet)
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrInvalidMessage, err)
+	}
+	dict, ok := ir.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w - not a dictionary", ErrInvalidMessage)
+	}
+
+	m := &message{T: asString(dict["t"]), Y: asString(dict["y"])}
+	if m.T == "" {
+		return nil, fmt.Errorf("%w - no transaction id", ErrInvalidMessage)
+	}
+
+	switch m.Y {
+	case typeQuery:
+		m.Q = asString(dict["q"])
+		m.A, ok = dict["a"].(map[string]any)
+		if m.Q == "" || !ok {
+			return nil, fmt.Errorf("%w - query without method or arguments", ErrInvalidMessage)
+		}
+	case typeResponse:
+		m.R, ok = dict["r"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w - response without return values", ErrInvalidMessage)
+		}
+	case typeError:
+		list, _ := dict["e"].([]any)
+		m.E = &Error{Code: CodeGeneric}
+		if len(list) > 0 {
+			m.E.Code = int(asInt(list[0]))
+		}
+		if len(list) > 1 {
+			m.E.Message = asString(list[1])
+		}
+	default:
+		return nil, fmt.Errorf("%w - unknown type %q", ErrInvalidMessage, m.Y)
+	}
+	return m, nil
+}
+
+// nodeID reads a 20 byte id out of a dict
+func nodeID(dict map[string]any, key string) (ID, bool) {
+	raw := asString(dict[key])
+	if len(raw) != 20 {
+		return ID{}, false
+	}
+	return ID([]byte(raw)), true
+}
+
+// asString is a lenient string accessor, mistyped values read as empty
+func asString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func asInt(v any) int64 {
+	i, _ := v.(int64)
+	return i
+}
@@ -0,0 +1,94 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    message
+	}
+
+	testcases := []TestCase{
+		{"query", message{T: "aa", Y: typeQuery, Q: "ping", A: map[string]any{"id": "abcdefghij0123456789"}}},
+		{"response", message{T: "aa", Y: typeResponse, R: map[string]any{"id": "mnopqrstuvwxyz123456", "token": "aoeusnth"}}},
+		{"error", message{T: "aa", Y: typeError, E: &Error{CodeProtocol, "bad token"}}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			packet, err := tc.input.encode()
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			got, err := decodeMessage(packet)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if !reflect.DeepEqual(*got, tc.input) {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", *got, tc.input)
+			}
+		})
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		input       string
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		// the examples of BEP 5
+		{"ping query", "d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe", false},
+		{"error", "d1:eli201e23:A Generic Error Ocurrede1:t2:aa1:y1:ee", false},
+		{"not a dict", "li1ee", true},
+		{"no transaction id", "d1:y1:re", true},
+		{"query without arguments", "d1:q4:ping1:t2:aa1:y1:qe", true},
+		{"unknown type", "d1:t2:aa1:y1:xe", true},
+		{"garbage", "d1:t2:aa", true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			_, err := decodeMessage([]byte(tc.input))
+			if tc.throwsError {
+				if !errors.Is(err, ErrInvalidMessage) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidMessage)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+			}
+		})
+	}
+}
+
+func TestCompactNodes(t *testing.T) {
+	nodes := []Node{
+		{ID{1}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881}},
+		{ID{2}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 51413}},
+	}
+	raw := encodeNodes(append(nodes, Node{ID{3}, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6881}}))
+	if len(raw) != 2*compactNodeLen {
+		t.Fatalf("Got and want are not equal\nGOT:%d bytes\nWANT:%d\n", len(raw), 2*compactNodeLen)
+	}
+
+	got, err := decodeNodes(raw)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if !reflect.DeepEqual(got, nodes) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, nodes)
+	}
+
+	if _, err := decodeNodes(raw[:30]); !errors.Is(err, ErrInvalidNodes) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidNodes)
+	}
+}
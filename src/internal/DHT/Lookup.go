@@ -0,0 +1,188 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// ============ Struct Defs  ============ //
+
+// states of a node during a lookup
+const (
+	unvisited = iota
+	visiting
+	visited
+	failed
+)
+
+type candidate struct {
+	node  Node
+	state int
+	token string // handed out by get_peers, needed to announce to the node
+}
+
+// ============ Method Defs  ============ //
+
+/*
+lookup runs an iterative Kademlia lookup. Starting from the closest nodes in the routing table
+it visits up to Alpha unvisited nodes among the K closest known at a time, learning closer nodes from each,
+until the K closest known nodes have all been visited
+@params
+visit - queries a single node, returning the nodes it knows closer to target and the token it handed out
+@returns
+the K closest nodes that replied, closest first
+*/
+func (s *Server) lookup(ctx context.Context, target ID, visit func(Node) ([]Node, string, error)) []*candidate {
+	var mu sync.Mutex
+	candidates := map[ID]*candidate{}
+	learn := func(nodes []Node) {
+		for _, node := range nodes {
+			if _, ok := candidates[node.ID]; !ok && node.ID != s.id {
+				candidates[node.ID] = &candidate{node: node}
+			}
+		}
+	}
+	learn(s.table.Closest(target, K))
+
+	closest := func() []*candidate {
+		res := []*candidate{}
+		for _, c := range candidates {
+			if c.state != failed {
+				res = append(res, c)
+			}
+		}
+		slices.SortFunc(res, func(a, b *candidate) int {
+			switch {
+			case closer(target, a.node.ID, b.node.ID):
+				return -1
+			case closer(target, b.node.ID, a.node.ID):
+				return 1
+			}
+			return 0
+		})
+		return res[:min(K, len(res))]
+	}
+
+	for ctx.Err() == nil {
+		mu.Lock()
+		batch := []*candidate{}
+		for _, c := range closest() {
+			if c.state == unvisited && len(batch) < s.config.Alpha {
+				c.state = visiting
+				batch = append(batch, c)
+			}
+		}
+		mu.Unlock()
+		if len(batch) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				nodes, token, err := visit(c.node)
+				if errors.Is(err, ErrTimeout) {
+					s.table.Failed(c.node.ID)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					c.state = failed
+					return
+				}
+				c.state, c.token = visited, token
+				learn(nodes)
+			}()
+		}
+		wg.Wait()
+	}
+
+	res := []*candidate{}
+	for _, c := range closest() {
+		if c.state == visited {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// Lookup returns the K nodes closest to target in the whole network, it also fills the routing table
+func (s *Server) Lookup(ctx context.Context, target ID) []Node {
+	res := []Node{}
+	for _, c := range s.lookup(ctx, target, func(node Node) ([]Node, string, error) {
+		nodes, err := s.FindNode(ctx, node.Addr, target)
+		return nodes, "", err
+	}) {
+		res = append(res, c.node)
+	}
+	return res
+}
+
+// getPeers runs a get_peers lookup, returning the closest nodes with their tokens and every peer found on the way
+func (s *Server) getPeers(ctx context.Context, infoHash ID) ([]*candidate, []peers.Peer) {
+	var mu sync.Mutex
+	found := map[string]peers.Peer{}
+
+	closest := s.lookup(ctx, infoHash, func(node Node) ([]Node, string, error) {
+		list, nodes, token, err := s.GetPeers(ctx, node.Addr, infoHash)
+		mu.Lock()
+		for _, peer := range list {
+			found[peer.Address()] = peer
+		}
+		mu.Unlock()
+		return nodes, token, err
+	})
+
+	res := make([]peers.Peer, 0, len(found))
+	for _, peer := range found {
+		res = append(res, peer)
+	}
+	return closest, res
+}
+
+// FindPeers looks up the peers of a torrent
+func (s *Server) FindPeers(ctx context.Context, infoHash [20]byte) ([]peers.Peer, error) {
+	closest, found := s.getPeers(ctx, ID(infoHash))
+	if len(closest) == 0 {
+		return nil, ErrNoNodes
+	}
+	return found, nil
+}
+
+/*
+Announce looks up the peers of a torrent and announces us as a peer listening on port to the K
+nodes closest to its info hash, a port of 0 asks them to use the port of our dht socket
+@returns
+the peers found, errors when no node accepted the announce
+*/
+func (s *Server) Announce(ctx context.Context, infoHash [20]byte, port int) ([]peers.Peer, error) {
+	closest, found := s.getPeers(ctx, ID(infoHash))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for _, c := range closest {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.AnnouncePeer(ctx, c.node.Addr, ID(infoHash), port, c.token); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted == 0 {
+		return found, ErrNoNodes
+	}
+	return found, nil
+}
@@ -0,0 +1,99 @@
+// Package dht implements a mainline DHT node (BEP 5), a Kademlia network over udp where peers of a torrent
+// are found by info hash without any tracker
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+)
+
+// ============ Struct Defs  ============ //
+
+// ID identifies a node, info hashes share the same 160 bit space so lookups for either work alike
+type ID [20]byte
+
+// Node is a DHT node and where it listens
+type Node struct {
+	ID   ID
+	Addr *net.UDPAddr
+}
+
+// compact node info is the 20 byte id followed by the 6 byte compact ipv4 address
+const compactNodeLen = 26
+
+// ErrInvalidNodes occurs when compact node info is not a multiple of 26 bytes
+var ErrInvalidNodes = fmt.Errorf("invalid compact node info")
+
+// ============ Method Defs  ============ //
+
+func RandomID() ID {
+	var id ID
+	rand.Read(id[:])
+	return id
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// commonPrefix is the number of leading bits id and other share, 160 when they are equal
+func (id ID) commonPrefix(other ID) int {
+	for i := range id {
+		if x := id[i] ^ other[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return 160
+}
+
+// closer reports whether a is closer to target than b by xor distance
+func closer(target, a, b ID) bool {
+	for i := range target {
+		da, db := a[i]^target[i], b[i]^target[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+func (n Node) String() string {
+	return fmt.Sprintf("%s@%s", n.ID.String()[:8], n.Addr)
+}
+
+// encodeNodes returns the compact node info of the ipv4 nodes
+func encodeNodes(nodes []Node) []byte {
+	res := make([]byte, 0, len(nodes)*compactNodeLen)
+	for _, node := range nodes {
+		ip := node.Addr.IP.To4()
+		if ip == nil {
+			continue
+		}
+		res = append(res, node.ID[:]...)
+		res = append(res, ip...)
+		res = binary.BigEndian.AppendUint16(res, uint16(node.Addr.Port))
+	}
+	return res
+}
+
+func decodeNodes(raw []byte) ([]Node, error) {
+	if len(raw)%compactNodeLen != 0 {
+		return nil, fmt.Errorf("%w - %d bytes", ErrInvalidNodes, len(raw))
+	}
+
+	res := make([]Node, 0, len(raw)/compactNodeLen)
+	for i := 0; i < len(raw); i += compactNodeLen {
+		entry := raw[i : i+compactNodeLen]
+		node := Node{ID: ID(entry[:20]), Addr: &net.UDPAddr{IP: net.IP(bytes.Clone(entry[20:24])), Port: int(binary.BigEndian.Uint16(entry[24:]))}}
+		if node.Addr.Port == 0 || node.Addr.IP.IsUnspecified() {
+			continue
+		}
+		res = append(res, node)
+	}
+	return res, nil
+}
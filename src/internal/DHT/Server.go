@@ -0,0 +1,541 @@
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// ============ Struct Defs  ============ //
+
+// Config holds the tunables of a DHT node
+type Config struct {
+	Addr            string        // udp address to listen on
+	ID              ID            // our node id, the persisted one or a random id when zero
+	BootstrapNodes  []string      // host:port of well known nodes used to join the network
+	StatePath       string        // where the routing table is persisted between runs, empty to disable
+	QueryTimeout    time.Duration // how long to wait for a reply before a node counts as failed
+	Alpha           int           // queries a lookup keeps in flight at once
+	RefreshInterval time.Duration // buckets nobody was heard from in this long are refreshed
+	PeerTTL         time.Duration // announced peers are forgotten after this long
+	MaxPeers        int           // peers returned per get_peers reply
+	ItemTTL         time.Duration // items put to us are forgotten after this long unless put again
+	MaxItems        int           // items stored at once, further puts of new items are refused
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr: ":6881",
+		BootstrapNodes: []string{
+			"router.bittorrent.com:6881",
+			"router.utorrent.com:6881",
+			"dht.transmissionbt.com:6881",
+		},
+		QueryTimeout:    5 * time.Second,
+		Alpha:           3,
+		RefreshInterval: 15 * time.Minute,
+		PeerTTL:         30 * time.Minute,
+		MaxPeers:        50,
+		ItemTTL:         2 * time.Hour,
+		MaxItems:        1000,
+	}
+}
+
+// how often the server rotates token secrets, expires peers and refreshes buckets
+const maintenanceInterval = time.Minute
+
+// tokens stay valid for one to two rotations, BEP 5 suggests up to ten minutes
+const tokenRotation = 5 * time.Minute
+
+/*
+Server is a DHT node. It answers ping, find_node, get_peers and announce_peer queries from other
+nodes along with the get and put queries of BEP 44, stores the peers and items sent to it and runs
+lookups for the rest of the client. Every node that answers or queries us goes through the routing table
+*/
+type Server struct {
+	config Config
+	id     ID
+	conn   *net.UDPConn
+	table  *Table
+
+	ctx    context.Context // cancelled on Close
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[string]*pendingQuery // keyed by transaction id
+	nextTx  uint16
+	stored  map[ID]map[string]time.Time // info hash -> compact peer -> when it was announced
+	items   map[ID]*storedItem          // BEP 44 items keyed by target
+	secrets [2][]byte                   // current and previous token secret
+	rotated time.Time
+}
+
+type storedItem struct {
+	item *Item
+	put  time.Time
+}
+
+type pendingQuery struct {
+	addr  string // replies from any other address are ignored
+	reply chan *message
+}
+
+var (
+	// ErrTimeout occurs when a node does not reply within QueryTimeout
+	ErrTimeout = fmt.Errorf("dht query timed out")
+	// ErrClosed occurs when querying through a closed server
+	ErrClosed = fmt.Errorf("dht server closed")
+	// ErrNoNodes occurs when no node could be reached
+	ErrNoNodes = fmt.Errorf("no reachable dht nodes")
+)
+
+// ============ Method Defs  ============ //
+
+// NewServer listens on config.Addr and starts serving, the routing table is loaded from StatePath if one was saved
+func NewServer(config Config) (*Server, error) {
+	id, nodes := config.ID, []Node(nil)
+	if config.StatePath != "" {
+		savedID, saved, err := loadState(config.StatePath)
+		switch {
+		case err == nil:
+			nodes = saved
+			if id == (ID{}) {
+				id = savedID
+			}
+		case !errors.Is(err, ErrCorruptState) && !errors.Is(err, errNoState):
+			return nil, err
+		}
+	}
+	if id == (ID{}) {
+		id = RandomID()
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		config:  config,
+		id:      id,
+		conn:    conn,
+		table:   NewTable(id),
+		pending: map[string]*pendingQuery{},
+		stored:  map[ID]map[string]time.Time{},
+		items:   map[ID]*storedItem{},
+		secrets: [2][]byte{randomSecret(), randomSecret()},
+		rotated: time.Now(),
+	}
+	for _, node := range nodes {
+		s.table.add(node)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.wg.Add(2)
+	go s.serve()
+	go s.maintain()
+	return s, nil
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	return secret
+}
+
+func (s *Server) ID() ID {
+	return s.id
+}
+
+// Addr is the address the server listens on
+func (s *Server) Addr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Nodes returns every node in the routing table
+func (s *Server) Nodes() []Node {
+	return s.table.Nodes()
+}
+
+// Close stops the server and persists the routing table when a StatePath is set
+func (s *Server) Close() error {
+	s.cancel()
+	err := s.conn.Close()
+	s.wg.Wait()
+
+	if s.config.StatePath != "" {
+		if saveErr := saveState(s.config.StatePath, s.id, s.table.Nodes()); saveErr != nil {
+			return saveErr
+		}
+	}
+	return err
+}
+
+// serve reads packets until the connection is closed
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		msg, err := decodeMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		if msg.Y == typeQuery {
+			s.handleQuery(msg, addr)
+			continue
+		}
+
+		s.mu.Lock()
+		p, ok := s.pending[msg.T]
+		if ok && p.addr == addr.String() {
+			delete(s.pending, msg.T)
+		}
+		s.mu.Unlock()
+		if ok && p.addr == addr.String() {
+			p.reply <- msg
+		}
+	}
+}
+
+// maintain rotates token secrets, expires announced peers and items and refreshes buckets until Close
+func (s *Server) maintain() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			if now.Sub(s.rotated) >= tokenRotation {
+				s.secrets = [2][]byte{randomSecret(), s.secrets[0]}
+				s.rotated = now
+			}
+			for infoHash, stored := range s.stored {
+				for peer, announced := range stored {
+					if now.Sub(announced) >= s.config.PeerTTL {
+						delete(stored, peer)
+					}
+				}
+				if len(stored) == 0 {
+					delete(s.stored, infoHash)
+				}
+			}
+			for target, stored := range s.items {
+				if now.Sub(stored.put) >= s.config.ItemTTL {
+					delete(s.items, target)
+				}
+			}
+			s.mu.Unlock()
+
+			for _, target := range s.table.Stale(s.config.RefreshInterval) {
+				go s.Lookup(s.ctx, target)
+			}
+		}
+	}
+}
+
+func (s *Server) send(msg *message, addr *net.UDPAddr) error {
+	packet, err := msg.encode()
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteToUDP(packet, addr)
+	return err
+}
+
+// seen adds a node to the routing table, a questionable node in its way is pinged and replaced if it is gone
+func (s *Server) seen(node Node) {
+	added, questionable := s.table.Seen(node)
+	if added || questionable == nil {
+		return
+	}
+	go func() {
+		if _, err := s.Ping(s.ctx, questionable.Addr); errors.Is(err, ErrTimeout) {
+			s.table.Replace(questionable.ID, node)
+		}
+	}()
+}
+
+/*
+query sends a query to addr and waits for the reply. Our id is added to args and the node that
+replied goes into the routing table
+@returns
+the return values of the reply, a *Error when the node replied with an error, or ErrTimeout
+*/
+func (s *Server) query(ctx context.Context, addr *net.UDPAddr, method string, args map[string]any) (map[string]any, error) {
+	args["id"] = s.id[:]
+
+	reply := make(chan *message, 1)
+	s.mu.Lock()
+	s.nextTx++
+	tx := string(binary.BigEndian.AppendUint16(nil, s.nextTx))
+	s.pending[tx] = &pendingQuery{addr: addr.String(), reply: reply}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, tx)
+		s.mu.Unlock()
+	}()
+
+	if err := s.send(&message{T: tx, Y: typeQuery, Q: method, A: args}, addr); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(s.config.QueryTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.ctx.Done():
+		return nil, ErrClosed
+	case <-timer.C:
+		return nil, fmt.Errorf("%w - %s %s", ErrTimeout, method, addr)
+	case msg := <-reply:
+		if msg.Y == typeError {
+			return nil, msg.E
+		}
+		id, ok := nodeID(msg.R, "id")
+		if !ok {
+			return nil, fmt.Errorf("%w - reply without id", ErrInvalidMessage)
+		}
+		s.seen(Node{ID: id, Addr: addr})
+		return msg.R, nil
+	}
+}
+
+// handleQuery answers a query from another node
+func (s *Server) handleQuery(msg *message, addr *net.UDPAddr) {
+	id, ok := nodeID(msg.A, "id")
+	if !ok {
+		s.send(&message{T: msg.T, Y: typeError, E: &Error{CodeProtocol, "invalid id"}}, addr)
+		return
+	}
+	// read only nodes (BEP 43) never answer queries so they are kept out of the table
+	if asInt(msg.A["ro"]) != 1 {
+		s.seen(Node{ID: id, Addr: addr})
+	}
+
+	r, kerr := s.handle(msg.Q, msg.A, addr)
+	if kerr != nil {
+		s.send(&message{T: msg.T, Y: typeError, E: kerr}, addr)
+		return
+	}
+	r["id"] = s.id[:]
+	s.send(&message{T: msg.T, Y: typeResponse, R: r}, addr)
+}
+
+// handle returns the reply to a single query
+func (s *Server) handle(method string, args map[string]any, addr *net.UDPAddr) (map[string]any, *Error) {
+	switch method {
+	case "ping":
+		return map[string]any{}, nil
+
+	case "find_node":
+		target, ok := nodeID(args, "target")
+		if !ok {
+			return nil, &Error{CodeProtocol, "invalid target"}
+		}
+		return map[string]any{"nodes": encodeNodes(s.table.Closest(target, K))}, nil
+
+	case "get_peers":
+		infoHash, ok := nodeID(args, "info_hash")
+		if !ok {
+			return nil, &Error{CodeProtocol, "invalid info_hash"}
+		}
+		r := map[string]any{"token": s.token(addr.IP)}
+		// peers when we have them, otherwise the nodes closer to the info hash that may
+		if values := s.storedPeers(infoHash); len(values) > 0 {
+			r["values"] = values
+		} else {
+			r["nodes"] = encodeNodes(s.table.Closest(infoHash, K))
+		}
+		return r, nil
+
+	case "announce_peer":
+		infoHash, ok := nodeID(args, "info_hash")
+		if !ok {
+			return nil, &Error{CodeProtocol, "invalid info_hash"}
+		}
+		if !s.validToken(asString(args["token"]), addr.IP) {
+			return nil, &Error{CodeProtocol, "bad token"}
+		}
+		port := asInt(args["port"])
+		if asInt(args["implied_port"]) == 1 {
+			port = int64(addr.Port)
+		}
+		if port <= 0 || port > 65535 {
+			return nil, &Error{CodeProtocol, "invalid port"}
+		}
+		s.store(infoHash, peers.NewPeer(addr.IP, uint16(port)))
+		return map[string]any{}, nil
+
+	case "get":
+		return s.handleGet(args, addr)
+
+	case "put":
+		if !s.validToken(asString(args["token"]), addr.IP) {
+			return nil, &Error{CodeProtocol, "bad token"}
+		}
+		return s.handlePut(args)
+	}
+	return nil, &Error{CodeMethodUnknown, "method unknown"}
+}
+
+// token is handed to nodes that ask for peers, they present it again to announce from the same ip
+func (s *Server) token(ip net.IP) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return tokenFor(s.secrets[0], ip)
+}
+
+func (s *Server) validToken(token string, ip net.IP) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return token != "" && (token == tokenFor(s.secrets[0], ip) || token == tokenFor(s.secrets[1], ip))
+}
+
+func tokenFor(secret []byte, ip net.IP) string {
+	sum := sha1.Sum(append(slices.Clone(secret), ip.To16()...))
+	return string(sum[:8])
+}
+
+func (s *Server) store(infoHash ID, peer peers.Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stored[infoHash] == nil {
+		s.stored[infoHash] = map[string]time.Time{}
+	}
+	s.stored[infoHash][string(peer.Compact())] = time.Now()
+}
+
+// storedPeers returns up to MaxPeers compact peers announced for infoHash
+func (s *Server) storedPeers(infoHash ID) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := []string{}
+	for peer := range s.stored[infoHash] {
+		if len(res) >= s.config.MaxPeers {
+			break
+		}
+		res = append(res, peer)
+	}
+	return res
+}
+
+// Ping checks that a node is alive and returns its id
+func (s *Server) Ping(ctx context.Context, addr *net.UDPAddr) (ID, error) {
+	r, err := s.query(ctx, addr, "ping", map[string]any{})
+	if err != nil {
+		return ID{}, err
+	}
+	id, _ := nodeID(r, "id")
+	return id, nil
+}
+
+// AddNode pings a node in the background so it joins the routing table if it answers, used for PORT messages
+func (s *Server) AddNode(addr *net.UDPAddr) {
+	go s.Ping(s.ctx, addr)
+}
+
+// FindNode asks a node for the nodes it knows closest to target
+func (s *Server) FindNode(ctx context.Context, addr *net.UDPAddr, target ID) ([]Node, error) {
+	r, err := s.query(ctx, addr, "find_node", map[string]any{"target": target[:]})
+	if err != nil {
+		return nil, err
+	}
+	return decodeNodes([]byte(asString(r["nodes"])))
+}
+
+/*
+GetPeers asks a node for peers of a torrent
+@returns
+the peers the node knows, otherwise the nodes it knows closer to the info hash, along with the
+token needed to announce to it
+*/
+func (s *Server) GetPeers(ctx context.Context, addr *net.UDPAddr, infoHash ID) ([]peers.Peer, []Node, string, error) {
+	r, err := s.query(ctx, addr, "get_peers", map[string]any{"info_hash": infoHash[:]})
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	nodes, err := decodeNodes([]byte(asString(r["nodes"])))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	found := []peers.Peer{}
+	values, _ := r["values"].([]any)
+	for _, v := range values {
+		var list []peers.Peer
+		switch raw := []byte(asString(v)); len(raw) {
+		case 6:
+			list, _ = peers.MakePeer(raw)
+		case 18:
+			list, _ = peers.MakePeer6(raw)
+		}
+		found = append(found, list...)
+	}
+	return found, nodes, asString(r["token"]), nil
+}
+
+// AnnouncePeer tells a node we are a peer of the torrent listening on port, 0 meaning the port we query from
+func (s *Server) AnnouncePeer(ctx context.Context, addr *net.UDPAddr, infoHash ID, port int, token string) error {
+	args := map[string]any{"info_hash": infoHash[:], "port": port, "token": token}
+	if port == 0 {
+		args["implied_port"] = 1
+	}
+	_, err := s.query(ctx, addr, "announce_peer", args)
+	return err
+}
+
+// Bootstrap joins the network through the configured bootstrap nodes and extra, such as the nodes of a torrent file
+func (s *Server) Bootstrap(ctx context.Context, extra ...string) error {
+	var wg sync.WaitGroup
+	for _, address := range slices.Concat(s.config.BootstrapNodes, extra) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, err := net.ResolveUDPAddr("udp", address)
+			if err != nil {
+				return
+			}
+			s.Ping(ctx, addr)
+		}()
+	}
+	wg.Wait()
+
+	s.Lookup(ctx, s.id)
+	if s.table.Len() == 0 {
+		return ErrNoNodes
+	}
+	return nil
+}
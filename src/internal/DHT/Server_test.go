@@ -0,0 +1,179 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testConfig() Config {
+	config := DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+	config.BootstrapNodes = nil
+	config.QueryTimeout = time.Second
+	return config
+}
+
+func newServer(t *testing.T, config Config) *Server {
+	t.Helper()
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("DEV ERR: unable to start dht node, %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestQueries(t *testing.T) {
+	a, b := newServer(t, testConfig()), newServer(t, testConfig())
+	ctx := context.Background()
+
+	id, err := a.Ping(ctx, b.Addr())
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if id != b.ID() {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", id, b.ID())
+	}
+	// both ends learn of each other
+	if len(a.Nodes()) != 1 || len(b.Nodes()) != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%d and %d nodes\nWANT:1 and 1\n", len(a.Nodes()), len(b.Nodes()))
+	}
+
+	infoHash := RandomID()
+	found, _, token, err := a.GetPeers(ctx, b.Addr(), infoHash)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if len(found) != 0 || token == "" {
+		t.Fatalf("Got and want are not equal\nGOT:%v %q\nWANT:no peers and a token\n", found, token)
+	}
+
+	var kerr *Error
+	if err := a.AnnouncePeer(ctx, b.Addr(), infoHash, 4000, "wrong"); !errors.As(err, &kerr) || kerr.Code != CodeProtocol {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:error %d\n", err, CodeProtocol)
+	}
+	if err := a.AnnouncePeer(ctx, b.Addr(), infoHash, 4000, token); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if err := a.AnnouncePeer(ctx, b.Addr(), infoHash, 0, token); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	found, _, _, err = a.GetPeers(ctx, b.Addr(), infoHash)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	expected := map[string]bool{"127.0.0.1:4000": true, a.Addr().String(): true}
+	if len(found) != len(expected) {
+		t.Fatalf("Got and want are not equal\nGOT:%v\nWANT:%v\n", found, expected)
+	}
+	for _, peer := range found {
+		if !expected[peer.Address()] {
+			t.Errorf("unexpected peer %s", peer.Address())
+		}
+	}
+
+	if _, err := a.query(ctx, b.Addr(), "vote", map[string]any{}); !errors.As(err, &kerr) || kerr.Code != CodeMethodUnknown {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:error %d\n", err, CodeMethodUnknown)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	config := testConfig()
+	config.QueryTimeout = 50 * time.Millisecond
+	s := newServer(t, config)
+
+	// a socket that never replies
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("DEV ERR: unable to listen, %v", err)
+	}
+	defer silent.Close()
+
+	if _, err := s.Ping(context.Background(), silent.LocalAddr().(*net.UDPAddr)); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrTimeout)
+	}
+}
+
+func TestToken(t *testing.T) {
+	s := newServer(t, testConfig())
+	ip, other := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	token := s.token(ip)
+	if !s.validToken(token, ip) || s.validToken(token, other) || s.validToken("", ip) {
+		t.Errorf("token only valid for the ip it was handed to")
+	}
+
+	// a token survives one rotation but not two
+	s.secrets = [2][]byte{randomSecret(), s.secrets[0]}
+	if !s.validToken(token, ip) {
+		t.Errorf("token invalid after one rotation")
+	}
+	s.secrets = [2][]byte{randomSecret(), s.secrets[0]}
+	if s.validToken(token, ip) {
+		t.Errorf("token still valid after two rotations")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	config := testConfig()
+	config.StatePath = filepath.Join(t.TempDir(), "dht.dat")
+
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("DEV ERR: unable to start dht node, %v", err)
+	}
+	other := newServer(t, testConfig())
+	if _, err := s.Ping(context.Background(), other.Addr()); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	restarted := newServer(t, config)
+	if restarted.ID() != s.ID() {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", restarted.ID(), s.ID())
+	}
+	nodes := restarted.Nodes()
+	if len(nodes) != 1 || nodes[0].ID != other.ID() || nodes[0].Addr.String() != other.Addr().String() {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:[%v]\n", nodes, Node{other.ID(), other.Addr()})
+	}
+}
+
+// TestNetwork runs a small dht in process, one node announces a torrent and another finds it
+func TestNetwork(t *testing.T) {
+	ctx := context.Background()
+	nodes := []*Server{newServer(t, testConfig())}
+	for range 19 {
+		config := testConfig()
+		config.BootstrapNodes = []string{nodes[0].Addr().String()}
+		s := newServer(t, config)
+		if err := s.Bootstrap(ctx); err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+		nodes = append(nodes, s)
+	}
+
+	infoHash := [20]byte(RandomID())
+	if _, err := nodes[5].Announce(ctx, infoHash, 4000); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	found, err := nodes[15].FindPeers(ctx, infoHash)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if len(found) != 1 || found[0].Address() != "127.0.0.1:4000" {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:[127.0.0.1:4000]\n", found)
+	}
+
+	// a node that never bootstrapped knows nobody
+	if _, err := newServer(t, testConfig()).FindPeers(ctx, infoHash); !errors.Is(err, ErrNoNodes) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrNoNodes)
+	}
+}
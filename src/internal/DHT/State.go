@@ -0,0 +1,72 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+)
+
+// ============ Struct Defs  ============ //
+
+// state is what is persisted between runs so the node keeps its id and rejoins without bootstrap nodes
+type state struct {
+	ID    []byte `bencode:"id"`
+	Nodes []byte `bencode:"nodes"` // compact node info
+}
+
+var (
+	// ErrCorruptState occurs when a state file cannot be decoded, the node then starts afresh
+	ErrCorruptState = fmt.Errorf("corrupt dht state")
+	errNoState      = fmt.Errorf("no dht state")
+)
+
+// ============ Method Defs  ============ //
+
+// saveState writes the id and routing table through a temporary file so a crash never leaves a partial file behind
+func saveState(path string, id ID, nodes []Node) error {
+	encoded, err := bencodeparser.Marshal(state{ID: id[:], Nodes: encodeNodes(nodes)})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, encoded, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func loadState(path string) (ID, []Node, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ID{}, nil, errNoState
+	}
+	if err != nil {
+		return ID{}, nil, err
+	}
+
+	ir, err := bencodeparser.DecodeBytes(raw)
+	if err != nil {
+		return ID{}, nil, fmt.Errorf("%w - %s", ErrCorruptState, err)
+	}
+	dict, ok := ir.(map[string]any)
+	if !ok {
+		return ID{}, nil, fmt.Errorf("%w - not a dictionary", ErrCorruptState)
+	}
+	id, ok := nodeID(dict, "id")
+	if !ok {
+		return ID{}, nil, fmt.Errorf("%w - invalid id", ErrCorruptState)
+	}
+	nodes, err := decodeNodes([]byte(asString(dict["nodes"])))
+	if err != nil {
+		return ID{}, nil, fmt.Errorf("%w - %s", ErrCorruptState, err)
+	}
+	return id, nodes, nil
+}
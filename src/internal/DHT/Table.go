@@ -0,0 +1,238 @@
+package dht
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// ============ Struct Defs  ============ //
+
+// K is the size of a bucket and the number of nodes a lookup converges on
+const K = 8
+
+// a node that failed to answer this many queries in a row is removed
+const maxFailures = 3
+
+// a node not heard from for this long is questionable and may be replaced
+const questionableAfter = 15 * time.Minute
+
+/*
+Table is the Kademlia routing table of a node. Bucket i holds up to K nodes whose id shares
+exactly i leading bits with ours, so the table knows many nodes close to us and few far away.
+Nodes within a bucket are ordered least recently seen first
+*/
+type Table struct {
+	self ID
+
+	mu      sync.Mutex
+	buckets [160]bucket
+}
+
+type bucket struct {
+	entries []*entry
+	changed time.Time // last time a node was added or heard from
+}
+
+type entry struct {
+	node     Node
+	lastSeen time.Time
+	failures int
+}
+
+// ============ Method Defs  ============ //
+
+func NewTable(self ID) *Table {
+	t := &Table{self: self}
+	now := time.Now()
+	for i := range t.buckets {
+		t.buckets[i].changed = now
+	}
+	return t
+}
+
+// index returns the bucket of id, -1 for our own id
+func (t *Table) index(id ID) int {
+	prefix := t.self.commonPrefix(id)
+	if prefix == 160 {
+		return -1
+	}
+	return prefix
+}
+
+/*
+Seen records that a node answered or queried us. A node already known is moved to the back of its bucket,
+a new node is added if there is room. When the bucket is full the new node is dropped and the
+least recently seen node is returned if it is questionable, the caller should ping it and
+call Replace if it does not answer
+*/
+func (t *Table) Seen(node Node) (added bool, questionable *Node) {
+	i := t.index(node.ID)
+	if i < 0 {
+		return false, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	b := &t.buckets[i]
+	for j, e := range b.entries {
+		if e.node.ID == node.ID {
+			e.node.Addr, e.lastSeen, e.failures = node.Addr, now, 0
+			b.entries = append(slices.Delete(b.entries, j, j+1), e)
+			b.changed = now
+			return true, nil
+		}
+	}
+
+	if len(b.entries) < K {
+		b.entries = append(b.entries, &entry{node: node, lastSeen: now})
+		b.changed = now
+		return true, nil
+	}
+
+	if oldest := b.entries[0]; now.Sub(oldest.lastSeen) >= questionableAfter {
+		n := oldest.node
+		return false, &n
+	}
+	return false, nil
+}
+
+// add inserts a node without it having been heard from, used for nodes loaded from disk
+func (t *Table) add(node Node) {
+	i := t.index(node.ID)
+	if i < 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[i]
+	if len(b.entries) < K && !slices.ContainsFunc(b.entries, func(e *entry) bool { return e.node.ID == node.ID }) {
+		b.entries = append([]*entry{{node: node}}, b.entries...)
+	}
+}
+
+// Failed records a query the node did not answer, it is removed after maxFailures in a row
+func (t *Table) Failed(id ID) {
+	i := t.index(id)
+	if i < 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[i]
+	for j, e := range b.entries {
+		if e.node.ID == id {
+			e.failures++
+			if e.failures >= maxFailures {
+				b.entries = slices.Delete(b.entries, j, j+1)
+			}
+			return
+		}
+	}
+}
+
+// Replace evicts a node that stopped answering in favour of node, provided the bucket still has no room
+func (t *Table) Replace(old ID, node Node) {
+	i := t.index(old)
+	if i < 0 || i != t.index(node.ID) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[i]
+	b.entries = slices.DeleteFunc(b.entries, func(e *entry) bool { return e.node.ID == old })
+	if len(b.entries) < K && !slices.ContainsFunc(b.entries, func(e *entry) bool { return e.node.ID == node.ID }) {
+		now := time.Now()
+		b.entries = append(b.entries, &entry{node: node, lastSeen: now})
+		b.changed = now
+	}
+}
+
+// Closest returns up to n known nodes closest to target, closest first
+func (t *Table) Closest(target ID, n int) []Node {
+	res := t.Nodes()
+	slices.SortFunc(res, func(a, b Node) int {
+		switch {
+		case closer(target, a.ID, b.ID):
+			return -1
+		case closer(target, b.ID, a.ID):
+			return 1
+		}
+		return 0
+	})
+	return res[:min(n, len(res))]
+}
+
+// Nodes returns every node in the table
+func (t *Table) Nodes() []Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := []Node{}
+	for i := range t.buckets {
+		for _, e := range t.buckets[i].entries {
+			res = append(res, e.node)
+		}
+	}
+	return res
+}
+
+func (t *Table) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := 0
+	for i := range t.buckets {
+		res += len(t.buckets[i].entries)
+	}
+	return res
+}
+
+/*
+Stale returns a random id within every bucket that has not changed for age, looking those ids
+up refreshes the buckets. Only buckets up to the deepest one holding nodes are considered as
+the buckets past it cover too small a part of the id space to ever hold a node
+*/
+func (t *Table) Stale(age time.Duration) []ID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	deepest := -1
+	for i := range t.buckets {
+		if len(t.buckets[i].entries) > 0 {
+			deepest = i
+		}
+	}
+
+	res := []ID{}
+	now := time.Now()
+	for i := 0; i <= deepest; i++ {
+		if now.Sub(t.buckets[i].changed) >= age {
+			res = append(res, t.randomIDIn(i))
+			t.buckets[i].changed = now
+		}
+	}
+	return res
+}
+
+// randomIDIn returns a random id sharing exactly i leading bits with ours
+func (t *Table) randomIDIn(i int) ID {
+	id := RandomID()
+	for bit := 0; bit <= i; bit++ {
+		mask := byte(0x80) >> (bit % 8)
+		own := t.self[bit/8] & mask
+		if bit == i {
+			own ^= mask // the first differing bit
+		}
+		id[bit/8] = id[bit/8]&^mask | own
+	}
+	return id
+}
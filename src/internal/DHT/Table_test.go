@@ -0,0 +1,109 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// idWithPrefix returns an id sharing exactly prefix leading bits with self, the rest set from n
+func idWithPrefix(self ID, prefix int, n byte) ID {
+	id := self
+	id[prefix/8] ^= 0x80 >> (prefix % 8)
+	id[19] ^= n
+	return id
+}
+
+func node(id ID) Node {
+	return Node{ID: id, Addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6881}}
+}
+
+func TestTableBucketFull(t *testing.T) {
+	self := ID{0xff}
+	table := NewTable(self)
+
+	if added, _ := table.Seen(node(self)); added {
+		t.Errorf("our own id was added to the table")
+	}
+	for i := range K {
+		if added, _ := table.Seen(node(idWithPrefix(self, 0, byte(i+1)))); !added {
+			t.Fatalf("node %d was not added to a bucket with room", i)
+		}
+	}
+
+	extra := node(idWithPrefix(self, 0, 0x7f))
+	if added, questionable := table.Seen(extra); added || questionable != nil {
+		t.Errorf("Got and want are not equal\nGOT:%v %v\nWANT:false <nil>\n", added, questionable)
+	}
+
+	// once the oldest node is questionable it is offered for replacement
+	oldest := table.buckets[0].entries[0]
+	oldest.lastSeen = time.Now().Add(-questionableAfter)
+	_, questionable := table.Seen(extra)
+	if questionable == nil || questionable.ID != oldest.node.ID {
+		t.Fatalf("Got and want are not equal\nGOT:%v\nWANT:%v\n", questionable, oldest.node)
+	}
+	table.Replace(questionable.ID, extra)
+	if table.Len() != K {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", table.Len(), K)
+	}
+	if last := table.buckets[0].entries[K-1]; last.node.ID != extra.ID {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", last.node, extra)
+	}
+
+	// a node is only removed after failing maxFailures queries in a row
+	for range maxFailures - 1 {
+		table.Failed(extra.ID)
+	}
+	table.Seen(extra)
+	table.Failed(extra.ID)
+	if table.Len() != K {
+		t.Errorf("a node that answered again was removed")
+	}
+	for range maxFailures - 1 {
+		table.Failed(extra.ID)
+	}
+	if table.Len() != K-1 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", table.Len(), K-1)
+	}
+}
+
+func TestTableClosest(t *testing.T) {
+	self := ID{}
+	table := NewTable(self)
+	for _, b := range []byte{0x80, 0x40, 0x20, 0x10, 0x08} {
+		table.Seen(node(ID{b}))
+	}
+
+	got := table.Closest(ID{0x21}, 3)
+	expected := []ID{{0x20}, {0x08}, {0x10}}
+	if len(got) != len(expected) {
+		t.Fatalf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, expected)
+	}
+	for i := range expected {
+		if got[i].ID != expected[i] {
+			t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got[i].ID, expected[i])
+		}
+	}
+}
+
+func TestTableStale(t *testing.T) {
+	self := RandomID()
+	table := NewTable(self)
+	table.Seen(node(idWithPrefix(self, 3, 1)))
+
+	if stale := table.Stale(time.Hour); len(stale) != 0 {
+		t.Errorf("Got and want are not equal\nGOT:%d stale buckets\nWANT:0\n", len(stale))
+	}
+
+	// every bucket up to the deepest one holding a node is refreshed with an id that falls in it
+	stale := table.Stale(0)
+	if len(stale) != 4 {
+		t.Fatalf("Got and want are not equal\nGOT:%d stale buckets\nWANT:4\n", len(stale))
+	}
+	for i, id := range stale {
+		if got := table.index(id); got != i {
+			t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", got, i)
+		}
+	}
+}
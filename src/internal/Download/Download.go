@@ -0,0 +1,635 @@
+// Package download contains the engine that downloads the pieces of a torrent from connected peers
+package download
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	choker "github.com/firozt/go-torrent/src/internal/Choker"
+	extension "github.com/firozt/go-torrent/src/internal/Extension"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	piecepicker "github.com/firozt/go-torrent/src/internal/PiecePicker"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ============ Struct Defs  ============ //
+
+// Config holds the tunables of the engine
+type Config struct {
+	BlockSize       uint32        // bytes per request, 16KiB is the largest size every client serves
+	PipelineDepth   int           // outstanding requests kept per unchoked peer
+	RequestTimeout  time.Duration // a request unanswered this long is cancelled and given to another peer
+	MaxUploadQueue  int           // requests queued per peer we are uploading to, further requests are dropped
+	RechokeInterval time.Duration // how often rates are measured and the choker decides who to upload to
+	SnubTimeout     time.Duration // an unchoked peer that sends no block for this long is snubbing us
+	AllowedFast     int           // pieces a peer with the fast extension may request from us while choked, 0 allows none
+	// NewChoker creates the choking policy of an engine, nil uses a choker.TitForTat
+	NewChoker func() choker.Choker[*peers.PeerConn]
+	// Extensions receives the extended messages of peers that support the extension protocol, nil ignores them
+	Extensions *extension.Registry
+	// OnPort is handed the dht port peers advertise in PORT messages, nil ignores them. It runs on the engine goroutine so must not block
+	OnPort func(conn *peers.PeerConn, port uint16)
+	// OnBadPeer is handed peers proven to have sent blocks of a piece that failed its hash check, the peer is
+	// neither closed nor banned by the engine. nil ignores them. It runs on the engine goroutine so must not block
+	OnBadPeer func(conn *peers.PeerConn)
+	Picker    piecepicker.Config
+	PeerConn  peers.PeerConnConfig
+}
+
+func DefaultConfig() Config {
+	return Config{
+		BlockSize:       16 * 1024,
+		PipelineDepth:   10,
+		RequestTimeout:  30 * time.Second,
+		MaxUploadQueue:  250,
+		RechokeInterval: 10 * time.Second,
+		SnubTimeout:     time.Minute,
+		AllowedFast:     10,
+		Picker:          piecepicker.DefaultConfig(),
+		PeerConn:        peers.DefaultPeerConnConfig(),
+	}
+}
+
+// Stats is a snapshot of the engines progress
+type Stats struct {
+	Pieces       int    // verified pieces held
+	Downloaded   uint64 // bytes of verified pieces downloaded this session
+	Uploaded     uint64 // bytes of blocks sent this session
+	HashFailures int    // pieces discarded as their hash did not match
+	BadPeers     int    // peers found to have sent bad data
+	Peers        int
+}
+
+// PeerStats is a snapshot of a single connected peer
+type PeerStats struct {
+	Addr         net.Addr
+	PeerID       [20]byte
+	Client       peers.Client // decoded from the peer id
+	Downloaded   uint64       // bytes of blocks received this session
+	Uploaded     uint64
+	DownloadRate float64 // bytes per second over the last rechoke interval
+	UploadRate   float64
+	HashFailures int // pieces that failed their hash check the peer sent blocks of
+}
+
+// ErrEngineStopped occurs when adding a peer to an engine that is no longer running
+var ErrEngineStopped = fmt.Errorf("download engine stopped")
+
+/*
+Engine downloads a torrent from the peers handed to it and uploads the pieces it holds to them. All state is owned by the goroutine
+running Run, peers report to it through a single events channel so no locking is needed
+beyond the snapshot returned by Stats.
+Each piece is split into BlockSize blocks, up to PipelineDepth blocks are requested from every
+peer that has unchoked us and a piece is hashed once its last block arrives. Which blocks are
+requested is left to a piecepicker.Picker and who we upload to is left to a choker.Choker
+*/
+type Engine struct {
+	torrentFile *torrent.TorrentFile
+	config      Config
+	store       storage.Storage
+
+	events   chan peers.PeerEvent
+	newPeers chan addPeerRequest
+	calls    chan func()
+	stopped  chan struct{}
+	done     chan struct{}
+
+	// owned by the Run goroutine
+	peers    map[*peers.PeerConn]*peerState
+	picker   *piecepicker.Picker[*peers.PeerConn]
+	buffers  map[int][]byte            // data of pieces with blocks in flight
+	sources  map[int][]*peers.PeerConn // who sent each block of the pieces in buffers
+	suspects map[int][]blockRecord     // blocks of failed pieces downloaded again from a single peer
+	choker   choker.Choker[*peers.PeerConn]
+	rates    time.Time // when the peer rates were last measured
+
+	mu    sync.Mutex
+	have  peers.Bitfield
+	stats Stats
+}
+
+type addPeerRequest struct {
+	conn   net.Conn
+	remote *peers.PeerHandshake
+	reply  chan *peers.PeerConn
+}
+
+type peerState struct {
+	conn        *peers.PeerConn
+	requests    map[piecepicker.Block]time.Time // outstanding requests and when they were sent
+	upload      *uploader
+	allowedFast map[uint32]bool // pieces the peer may request while we choke it
+
+	connectedAt  time.Time
+	lastBlock    time.Time // last block received, or when the peer unchoked us
+	downloaded   uint64
+	hashFailures int
+	uploaded     atomic.Uint64 // written by the uploader
+	// totals at the last measurement and the rates since, in bytes per second
+	lastDownloaded, lastUploaded uint64
+	downloadRate, uploadRate     float64
+}
+
+// ============ Method Defs  ============ //
+
+// NewEngine creates an engine for torrentFile writing verified pieces to store, have lists
+// pieces already held and may be nil
+func NewEngine(torrentFile *torrent.TorrentFile, store storage.Storage, have peers.Bitfield, config Config) *Engine {
+	if have == nil {
+		have = peers.MakeBitfield(len(torrentFile.Pieces))
+	}
+	config.Picker.BlockSize = config.BlockSize
+	if config.NewChoker == nil {
+		config.NewChoker = func() choker.Choker[*peers.PeerConn] {
+			return choker.NewTitForTat[*peers.PeerConn](choker.DefaultConfig())
+		}
+	}
+
+	e := &Engine{
+		torrentFile: torrentFile,
+		config:      config,
+		store:       store,
+		events:      make(chan peers.PeerEvent, 64),
+		newPeers:    make(chan addPeerRequest),
+		calls:       make(chan func()),
+		stopped:     make(chan struct{}),
+		done:        make(chan struct{}),
+		peers:       map[*peers.PeerConn]*peerState{},
+		picker:      piecepicker.New[*peers.PeerConn](torrentFile, have, config.Picker),
+		buffers:     map[int][]byte{},
+		sources:     map[int][]*peers.PeerConn{},
+		suspects:    map[int][]blockRecord{},
+		choker:      config.NewChoker(),
+		rates:       time.Now(),
+		have:        append(peers.Bitfield{}, have...),
+	}
+	e.stats.Pieces = e.have.Count()
+	e.checkDone()
+
+	return e
+}
+
+// AddPeer hands a connection that completed its handshake to the engine, the returned PeerConn
+// is owned by the engine and closes when Run returns
+func (e *Engine) AddPeer(conn net.Conn, remote *peers.PeerHandshake) (*peers.PeerConn, error) {
+	req := addPeerRequest{conn: conn, remote: remote, reply: make(chan *peers.PeerConn, 1)}
+	select {
+	case e.newPeers <- req:
+		return <-req.reply, nil
+	case <-e.stopped:
+		return nil, ErrEngineStopped
+	}
+}
+
+// SetPriority changes the priority of a piece, skipped pieces are not downloaded
+func (e *Engine) SetPriority(index int, priority piecepicker.Priority) error {
+	return e.do(func() {
+		e.picker.SetPriority(index, priority)
+		e.checkDone()
+		for _, ps := range e.sortedPeers() {
+			e.updateInterest(ps)
+		}
+		e.fillAll()
+	})
+}
+
+// do runs fn on the Run goroutine and waits for it to return
+func (e *Engine) do(fn func()) error {
+	finished := make(chan struct{})
+	select {
+	case e.calls <- func() { fn(); close(finished) }:
+		<-finished
+		return nil
+	case <-e.stopped:
+		return ErrEngineStopped
+	}
+}
+
+// Done is closed once every piece that is not skipped has been downloaded and verified
+func (e *Engine) Done() <-chan struct{} {
+	return e.done
+}
+
+// Bitfield returns a copy of the verified pieces
+func (e *Engine) Bitfield() peers.Bitfield {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append(peers.Bitfield{}, e.have...)
+}
+
+func (e *Engine) Stats() Stats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stats
+}
+
+// PeerStats returns a snapshot of every connected peer ordered by address
+func (e *Engine) PeerStats() []PeerStats {
+	var res []PeerStats
+	e.do(func() {
+		for _, ps := range e.sortedPeers() {
+			res = append(res, PeerStats{
+				Addr:         ps.conn.RemoteAddr(),
+				PeerID:       ps.conn.PeerID(),
+				Client:       ps.conn.Client(),
+				Downloaded:   ps.downloaded,
+				Uploaded:     ps.uploaded.Load(),
+				DownloadRate: ps.downloadRate,
+				UploadRate:   ps.uploadRate,
+				HashFailures: ps.hashFailures,
+			})
+		}
+	})
+	return res
+}
+
+// Run processes peer events until ctx is cancelled, every peer is closed on return.
+// The engine keeps running after the download completes so peers may still be served
+func (e *Engine) Run(ctx context.Context) error {
+	defer close(e.stopped)
+	defer e.closeAll()
+
+	ticker := time.NewTicker(max(e.config.RequestTimeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	rechoke := time.NewTicker(max(e.config.RechokeInterval, 10*time.Millisecond))
+	defer rechoke.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case req := <-e.newPeers:
+			req.reply <- e.addPeer(req)
+		case fn := <-e.calls:
+			fn()
+		case ev := <-e.events:
+			e.handleEvent(ev)
+		case now := <-ticker.C:
+			e.expireRequests(now)
+		case now := <-rechoke.C:
+			e.measureRates(now)
+			e.rechoke(now)
+		}
+	}
+}
+
+// addPeer creates the PeerConn from the loop so our bitfield is queued before anything else is sent
+func (e *Engine) addPeer(req addPeerRequest) *peers.PeerConn {
+	conn := peers.NewPeerConn(req.conn, req.remote, len(e.torrentFile.Pieces), e.events, e.config.PeerConn)
+
+	now := time.Now()
+	ps := &peerState{conn: conn, requests: map[piecepicker.Block]time.Time{}, allowedFast: map[uint32]bool{}, connectedAt: now, lastBlock: now}
+	e.greet(ps)
+	ps.upload = newUploader(conn, e.store, e.config.MaxUploadQueue, func(n int) {
+		ps.uploaded.Add(uint64(n))
+		e.setStats(func(s *Stats) { s.Uploaded += uint64(n) })
+	})
+	go ps.upload.run()
+
+	e.peers[conn] = ps
+	e.setStats(func(s *Stats) { s.Peers = len(e.peers) })
+	if e.config.Extensions != nil {
+		e.config.Extensions.AddPeer(conn)
+	}
+	return conn
+}
+
+func (e *Engine) closeAll() {
+	for conn := range e.peers {
+		conn.Close()
+	}
+	// drain until every reader reported closing so none are left blocked on the channel
+	for len(e.peers) > 0 {
+		ev := <-e.events
+		if ev.Message == nil {
+			delete(e.peers, ev.Conn)
+		}
+	}
+}
+
+func (e *Engine) handleEvent(ev peers.PeerEvent) {
+	ps, ok := e.peers[ev.Conn]
+	if !ok {
+		return
+	}
+
+	// the connection closed
+	if ev.Message == nil {
+		if e.config.Extensions != nil {
+			e.config.Extensions.RemovePeer(ps.conn)
+		}
+		clear(ps.requests)
+		e.picker.PeerGone(ps.conn, ps.conn.Bitfield())
+		delete(e.peers, ev.Conn)
+		e.setStats(func(s *Stats) { s.Peers = len(e.peers) })
+		e.rechoke(time.Now())
+		e.fillAll()
+		return
+	}
+
+	switch ev.Message.ID {
+	case peers.MsgChoke:
+		// a choking peer discards every request it has not answered, unless it uses the fast extension
+		// where every request is answered with a block or a reject
+		if !ps.conn.Fast() {
+			e.releaseRequests(ps)
+		}
+		e.fillAll()
+	case peers.MsgUnchoke:
+		// the snub timeout starts once the peer allows requests
+		ps.lastBlock = time.Now()
+		e.fillPipeline(ps)
+	case peers.MsgHave, peers.MsgBitfield, peers.MsgHaveAll, peers.MsgHaveNone:
+		if ev.Message.ID != peers.MsgHave {
+			e.picker.PeerBitfield(ps.conn.Bitfield())
+		} else if index, err := peers.ParseHave(ev.Message); err == nil {
+			e.picker.PeerHave(int(index))
+		}
+		e.updateInterest(ps)
+		e.fillPipeline(ps)
+	case peers.MsgPiece:
+		e.handleBlock(ps, ev.Message)
+	case peers.MsgInterested, peers.MsgNotInterested:
+		// rechoke right away so an interested peer need not wait for the next round when a slot is free
+		e.rechoke(time.Now())
+	case peers.MsgRequest:
+		e.handleRequest(ps, ev.Message)
+	case peers.MsgExtended:
+		// extended messages that are malformed or for extensions we never offered are dropped
+		if e.config.Extensions != nil {
+			e.config.Extensions.HandleMessage(ps.conn, ev.Message)
+		}
+	case peers.MsgPort:
+		if port, err := peers.ParsePort(ev.Message); err == nil && port != 0 && e.config.OnPort != nil {
+			e.config.OnPort(ps.conn, port)
+		}
+	case peers.MsgCancel:
+		if index, begin, length, err := peers.ParseRequest(ev.Message); err == nil {
+			block := piecepicker.Block{Index: index, Begin: begin, Length: length}
+			// a fast peer expects every request answered, a block already sent answers it
+			if ps.upload.cancel(block) {
+				e.reject(ps, block)
+			}
+		}
+	case peers.MsgRejectRequest:
+		e.handleReject(ps, ev.Message)
+	case peers.MsgAllowedFast:
+		// a piece we may fetch while choked
+		e.fillPipeline(ps)
+	case peers.MsgSuggest:
+		// suggestions are only hints, pieces are still picked rarest first
+	}
+}
+
+// measureRates works out how fast every peer sent and received since the last measurement
+func (e *Engine) measureRates(now time.Time) {
+	elapsed := now.Sub(e.rates).Seconds()
+	e.rates = now
+	if elapsed <= 0 {
+		return
+	}
+
+	for _, ps := range e.peers {
+		uploaded := ps.uploaded.Load()
+		ps.downloadRate = float64(ps.downloaded-ps.lastDownloaded) / elapsed
+		ps.uploadRate = float64(uploaded-ps.lastUploaded) / elapsed
+		ps.lastDownloaded, ps.lastUploaded = ps.downloaded, uploaded
+	}
+}
+
+// rechoke asks the choker who to upload to, choked peers have their queued requests dropped
+func (e *Engine) rechoke(now time.Time) {
+	sorted := e.sortedPeers()
+	snapshot := make([]choker.Peer[*peers.PeerConn], len(sorted))
+	for i, ps := range sorted {
+		state := ps.conn.State()
+		snapshot[i] = choker.Peer[*peers.PeerConn]{
+			Key:          ps.conn,
+			Interested:   state.PeerInterested,
+			Snubbed:      state.AmInterested && !state.PeerChoking && now.Sub(ps.lastBlock) >= e.config.SnubTimeout,
+			DownloadRate: ps.downloadRate,
+			UploadRate:   ps.uploadRate,
+			ConnectedAt:  ps.connectedAt,
+		}
+	}
+
+	unchoke := e.choker.Rechoke(now, snapshot, e.picker.Remaining() == 0)
+	for _, ps := range sorted {
+		if unchoke[ps.conn] {
+			ps.conn.Unchoke()
+			continue
+		}
+		ps.conn.Choke()
+		for _, block := range ps.upload.clear(func(b piecepicker.Block) bool { return ps.allowedFast[b.Index] }) {
+			e.reject(ps, block)
+		}
+	}
+}
+
+// handleRequest queues a block for upload, requests from peers we choke or that are not interested,
+// for pieces we do not have, past the end of a piece or beyond MaxUploadQueue are dropped.
+// A peer we choke may still request its allowed fast pieces
+func (e *Engine) handleRequest(ps *peerState, msg *peers.Message) {
+	index, begin, length, err := peers.ParseRequest(msg)
+	if err != nil {
+		return
+	}
+	block := piecepicker.Block{Index: index, Begin: begin, Length: length}
+
+	state := ps.conn.State()
+	if (state.AmChoking && !ps.allowedFast[index]) || !state.PeerInterested || !e.picker.HasPiece(int(index)) {
+		e.reject(ps, block)
+		return
+	}
+	if length == 0 || length > maxRequestLength || uint64(begin)+uint64(length) > e.torrentFile.PieceSize(int(index)) {
+		e.reject(ps, block)
+		return
+	}
+	if !ps.upload.push(block) {
+		e.reject(ps, block)
+	}
+}
+
+// updateInterest tells the peer whether it has any piece we still need
+func (e *Engine) updateInterest(ps *peerState) {
+	theirs := ps.conn.Bitfield()
+
+	for index := range e.torrentFile.Pieces {
+		if theirs.HasPiece(index) && e.picker.Wanted(index) {
+			ps.conn.Interested()
+			return
+		}
+	}
+	ps.conn.NotInterested()
+}
+
+func (e *Engine) fillAll() {
+	for _, ps := range e.sortedPeers() {
+		e.fillPipeline(ps)
+	}
+}
+
+// fillPipeline requests blocks from the peer until PipelineDepth requests are outstanding, a peer
+// that chokes us is only asked for its allowed fast pieces
+func (e *Engine) fillPipeline(ps *peerState) {
+	state := ps.conn.State()
+	if !state.AmInterested || (state.PeerChoking && !ps.conn.Fast()) {
+		return
+	}
+
+	peerHas := ps.conn.Bitfield()
+	if state.PeerChoking {
+		peerHas = peerHas.Intersect(ps.conn.AllowedFast())
+	}
+
+	now := time.Now()
+	for len(ps.requests) < e.config.PipelineDepth {
+		block, ok := e.picker.Next(ps.conn, peerHas)
+		if !ok {
+			return
+		}
+		ps.requests[block] = now
+
+		if err := ps.conn.Send(peers.NewRequest(block.Index, block.Begin, block.Length)); err != nil {
+			return
+		}
+	}
+}
+
+// releaseRequests frees every block requested from the peer so other peers may request them
+func (e *Engine) releaseRequests(ps *peerState) {
+	e.picker.ReleasePeer(ps.conn)
+	clear(ps.requests)
+}
+
+// expireRequests cancels requests that went unanswered for RequestTimeout and hands them to other peers
+func (e *Engine) expireRequests(now time.Time) {
+	expired := false
+	for _, ps := range e.sortedPeers() {
+		for block, sentAt := range ps.requests {
+			if now.Sub(sentAt) < e.config.RequestTimeout {
+				continue
+			}
+			delete(ps.requests, block)
+			e.picker.Release(ps.conn, block)
+			ps.conn.Send(peers.NewCancel(block.Index, block.Begin, block.Length))
+			expired = true
+		}
+	}
+
+	if expired {
+		e.fillAll()
+	}
+}
+
+func (e *Engine) handleBlock(ps *peerState, msg *peers.Message) {
+	index, begin, data, err := peers.ParsePiece(msg)
+	if err != nil {
+		return
+	}
+
+	block := piecepicker.Block{Index: index, Begin: begin, Length: uint32(len(data))}
+	delete(ps.requests, block)
+	defer e.fillPipeline(ps)
+
+	// unrequested, late or duplicate blocks are dropped
+	cancels, complete, ok := e.picker.Received(ps.conn, block)
+	if !ok {
+		return
+	}
+	ps.lastBlock = time.Now()
+	ps.downloaded += uint64(len(data))
+
+	// in endgame the block may also be requested from other peers, they need not send it anymore
+	for _, cancel := range cancels {
+		if other, ok := e.peers[cancel.Peer]; ok {
+			delete(other.requests, cancel.Block)
+			other.conn.Send(peers.NewCancel(cancel.Block.Index, cancel.Block.Begin, cancel.Block.Length))
+		}
+	}
+
+	buf, ok := e.buffers[int(index)]
+	if !ok {
+		buf = make([]byte, e.torrentFile.PieceSize(int(index)))
+		e.buffers[int(index)] = buf
+		e.sources[int(index)] = make([]*peers.PeerConn, (len(buf)+int(e.config.BlockSize)-1)/int(e.config.BlockSize))
+	}
+	copy(buf[begin:], data)
+	sources := e.sources[int(index)]
+	sources[begin/e.config.BlockSize] = ps.conn
+
+	if complete {
+		delete(e.buffers, int(index))
+		delete(e.sources, int(index))
+		e.finishPiece(int(index), buf, sources)
+	}
+}
+
+// finishPiece verifies a fully received piece, a bad piece is discarded and downloaded again.
+// sources is the peer each block came from
+func (e *Engine) finishPiece(index int, data []byte, sources []*peers.PeerConn) {
+	if sha1.Sum(data) != e.torrentFile.Pieces[index] {
+		e.pieceFailed(index, data, sources)
+		return
+	}
+	e.pieceVerified(index, data)
+
+	if _, err := e.store.WriteAt(index, data, 0); err != nil {
+		e.picker.Failed(index)
+		return
+	}
+	if err := e.store.MarkComplete(index); err != nil {
+		e.picker.Failed(index)
+		return
+	}
+
+	e.picker.Complete(index)
+	e.mu.Lock()
+	e.have.SetPiece(index)
+	e.stats.Pieces++
+	e.stats.Downloaded += uint64(len(data))
+	e.mu.Unlock()
+
+	for _, ps := range e.sortedPeers() {
+		ps.conn.Send(peers.NewHave(uint32(index)))
+		e.updateInterest(ps)
+	}
+
+	e.checkDone()
+}
+
+// checkDone closes done once no wanted piece is missing
+func (e *Engine) checkDone() {
+	select {
+	case <-e.done:
+	default:
+		if e.picker.Remaining() == 0 {
+			close(e.done)
+		}
+	}
+}
+
+func (e *Engine) setStats(update func(*Stats)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	update(&e.stats)
+}
+
+// sortedPeers gives a stable order so scheduling is deterministic for a given set of events
+func (e *Engine) sortedPeers() []*peerState {
+	res := make([]*peerState, 0, len(e.peers))
+	for _, ps := range e.peers {
+		res = append(res, ps)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].conn.RemoteAddr().String() < res[j].conn.RemoteAddr().String()
+	})
+	return res
+}
@@ -0,0 +1,408 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	extension "github.com/firozt/go-torrent/src/internal/Extension"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	piecepicker "github.com/firozt/go-torrent/src/internal/PiecePicker"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// readAll reads every piece of the torrent back from store
+func readAll(t *testing.T, tf *torrent.TorrentFile, store storage.Storage) []byte {
+	t.Helper()
+	var res []byte
+	for index := range tf.Pieces {
+		buf := make([]byte, tf.PieceSize(index))
+		if _, err := store.ReadAt(index, buf, 0); err != nil {
+			t.Fatalf("DEV ERR: cannot read piece %d - %s", index, err)
+		}
+		res = append(res, buf...)
+	}
+	return res
+}
+
+// makeTorrent builds a torrent over random data, the last piece is short
+func makeTorrent(t *testing.T, pieceLength, length int) (*torrent.TorrentFile, []byte) {
+	t.Helper()
+	data := make([]byte, length)
+	rand.Read(data)
+
+	tf := &torrent.TorrentFile{InfoHash: [20]byte{'D', 'L'}, PieceLength: uint64(pieceLength), Length: uint64(length)}
+	for begin := 0; begin < length; begin += pieceLength {
+		tf.Pieces = append(tf.Pieces, sha1.Sum(data[begin:min(begin+pieceLength, length)]))
+	}
+	return tf, data
+}
+
+// fakePeer serves blocks of data over the raw end of a pipe
+type fakePeer struct {
+	data        []byte
+	pieceLength int
+	has         peers.Bitfield
+	chokeAfter  int  // choke once after serving this many blocks, 0 never chokes
+	silent      bool // unchokes but never answers a request
+	corrupt     int  // number of blocks served with bad data before serving good data
+	// fast extension behaviour, the handshake must set CapabilityFast
+	rejects      bool // rejects every request
+	neverUnchoke bool // keeps us choked, only allowedFast pieces are served
+	allowedFast  []uint32
+
+	mu     sync.Mutex // guards writes
+	served int
+	haves  atomic.Int32
+}
+
+func (f *fakePeer) write(conn net.Conn, msg *peers.Message) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	peers.WriteMessage(conn, msg)
+}
+
+func (f *fakePeer) run(conn net.Conn) {
+	f.write(conn, peers.NewBitfield(f.has))
+	for _, index := range f.allowedFast {
+		f.write(conn, peers.NewAllowedFast(index))
+	}
+	var choked atomic.Bool
+
+	for {
+		msg, err := peers.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if msg == nil {
+			continue
+		}
+
+		switch msg.ID {
+		case peers.MsgInterested:
+			if !f.neverUnchoke {
+				f.write(conn, &peers.Message{ID: peers.MsgUnchoke})
+			}
+		case peers.MsgHave:
+			f.haves.Add(1)
+		case peers.MsgRequest:
+			if f.silent || choked.Load() {
+				continue
+			}
+			index, begin, length, _ := peers.ParseRequest(msg)
+			if f.rejects {
+				f.write(conn, peers.NewReject(index, begin, length))
+				continue
+			}
+			offset := int(index)*f.pieceLength + int(begin)
+			block := append([]byte{}, f.data[offset:offset+int(length)]...)
+			if f.corrupt > 0 {
+				f.corrupt--
+				block[0] ^= 0xff
+			}
+			f.write(conn, peers.NewPiece(index, begin, block))
+			f.served++
+
+			if f.served == f.chokeAfter {
+				// outstanding requests are discarded by the choke, the engine must request them again
+				choked.Store(true)
+				f.write(conn, &peers.Message{ID: peers.MsgChoke})
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					choked.Store(false)
+					f.write(conn, &peers.Message{ID: peers.MsgUnchoke})
+				}()
+			}
+		}
+	}
+}
+
+func fullBitfield(numPieces int) peers.Bitfield {
+	bf := peers.MakeBitfield(numPieces)
+	for i := range numPieces {
+		bf.SetPiece(i)
+	}
+	return bf
+}
+
+func TestEngineDownload(t *testing.T) {
+	const pieceLength = 40 * 1024 // 3 blocks, the last one short
+	tf, data := makeTorrent(t, pieceLength, 5*pieceLength+1000)
+	numPieces := len(tf.Pieces)
+
+	firstHalf, secondHalf := peers.MakeBitfield(numPieces), peers.MakeBitfield(numPieces)
+	for i := range numPieces {
+		if i < numPieces/2 {
+			firstHalf.SetPiece(i)
+		} else {
+			secondHalf.SetPiece(i)
+		}
+	}
+
+	type TestCase struct {
+		testname     string
+		peers        []*fakePeer
+		hashFailures int
+	}
+
+	testcases := []TestCase{
+		{"single seeder", []*fakePeer{{has: fullBitfield(numPieces)}}, 0},
+		{"pieces split across peers", []*fakePeer{{has: firstHalf}, {has: secondHalf}}, 0},
+		{"choke mid download", []*fakePeer{{has: fullBitfield(numPieces), chokeAfter: 2}}, 0},
+		{"unresponsive peer", []*fakePeer{{has: fullBitfield(numPieces), silent: true}, {has: fullBitfield(numPieces)}}, 0},
+		{"corrupt block", []*fakePeer{{has: fullBitfield(numPieces), corrupt: 1}}, 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			config := DefaultConfig()
+			config.PipelineDepth = 4
+			config.RequestTimeout = 100 * time.Millisecond
+
+			store := storage.NewMemoryStorage(tf)
+			engine := NewEngine(tf, store, nil, config)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			runErr := make(chan error, 1)
+			go func() { runErr <- engine.Run(ctx) }()
+
+			for _, fake := range tc.peers {
+				fake.data, fake.pieceLength = data, pieceLength
+				local, remote := net.Pipe()
+				defer remote.Close()
+				go fake.run(remote)
+
+				peerID, _ := peers.NewPeerID("TR", "2940")
+				handshake := peers.NewBitTorrentProtocolHandshake(tf.InfoHash, peerID)
+				if _, err := engine.AddPeer(local, handshake); err != nil {
+					t.Fatalf("An error was thrown none expected, %v", err)
+				}
+			}
+
+			select {
+			case <-engine.Done():
+			case <-ctx.Done():
+				t.Fatalf("download did not complete, stats %+v", engine.Stats())
+			}
+
+			if got := readAll(t, tf, store); !bytes.Equal(got, data) {
+				t.Errorf("downloaded data does not match the torrent data")
+			}
+
+			stats := engine.Stats()
+			if stats.Pieces != numPieces || stats.Downloaded != uint64(len(data)) || stats.HashFailures != tc.hashFailures {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%d pieces %d bytes %d failures\n", stats, numPieces, len(data), tc.hashFailures)
+			}
+
+			// every peer is reported with its client, together they sent at least the whole torrent
+			peerStats := engine.PeerStats()
+			var received uint64
+			for _, ps := range peerStats {
+				received += ps.Downloaded
+				if ps.Client != (peers.Client{Name: "Transmission", Version: "2.94"}) {
+					t.Errorf("Got and want are not equal\nGOT:%s\nWANT:Transmission 2.94\n", ps.Client)
+				}
+			}
+			if len(peerStats) != len(tc.peers) || received < uint64(len(data)) {
+				t.Errorf("Got and want are not equal\nGOT:%d peers sent %d bytes\nWANT:%d peers sent at least %d\n", len(peerStats), received, len(tc.peers), len(data))
+			}
+
+			cancel()
+			if err := <-runErr; err != context.Canceled {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, context.Canceled)
+			}
+		})
+	}
+}
+
+func TestEngineSendsHave(t *testing.T) {
+	tf, data := makeTorrent(t, 16*1024, 4*16*1024)
+	engine := NewEngine(tf, storage.NewMemoryStorage(tf), nil, DefaultConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	seeder := &fakePeer{data: data, pieceLength: 16 * 1024, has: fullBitfield(4)}
+	leecher := &fakePeer{data: data, pieceLength: 16 * 1024, has: peers.MakeBitfield(4)}
+	for i, fake := range []*fakePeer{seeder, leecher} {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		go fake.run(remote)
+		engine.AddPeer(local, peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{byte(i + 1)}))
+	}
+
+	select {
+	case <-engine.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("download did not complete")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for leecher.haves.Load() < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := leecher.haves.Load(); got != 4 {
+		t.Errorf("Got and want are not equal\nGOT:%d haves\nWANT:4\n", got)
+	}
+}
+
+func TestEngineAlreadyComplete(t *testing.T) {
+	tf, _ := makeTorrent(t, 16*1024, 2*16*1024)
+	engine := NewEngine(tf, storage.NewMemoryStorage(tf), fullBitfield(2), DefaultConfig())
+
+	select {
+	case <-engine.Done():
+	default:
+		t.Errorf("engine with every piece is not done")
+	}
+
+	// our bitfield is the first message a new peer receives
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	engine.AddPeer(local, peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{1}))
+
+	remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := peers.ReadMessage(remote)
+	if err != nil || msg == nil || msg.ID != peers.MsgBitfield || !bytes.Equal(msg.Payload, fullBitfield(2)) {
+		t.Errorf("Got and want are not equal\nGOT:%v %v\nWANT:bitfield\n", msg, err)
+	}
+}
+
+func TestEngineSkipsPieces(t *testing.T) {
+	tf, data := makeTorrent(t, 16*1024, 4*16*1024)
+	store := storage.NewMemoryStorage(tf)
+	engine := NewEngine(tf, store, nil, DefaultConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	for _, index := range []int{0, 2} {
+		if err := engine.SetPriority(index, piecepicker.PrioritySkip); err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+	}
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go (&fakePeer{data: data, pieceLength: 16 * 1024, has: fullBitfield(4)}).run(remote)
+	engine.AddPeer(local, peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{1}))
+
+	select {
+	case <-engine.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("download did not complete, stats %+v", engine.Stats())
+	}
+
+	for index := range 4 {
+		ok := store.Complete(index)
+		if skipped := index%2 == 0; ok == skipped {
+			t.Errorf("Got and want are not equal\nGOT:piece %d stored %v\nWANT:%v\n", index, ok, !skipped)
+		}
+	}
+}
+
+// echoExtension sends every message it receives back to the peer
+type echoExtension struct{}
+
+func (echoExtension) Name() string                 { return "echo" }
+func (echoExtension) Added(peer *extension.Peer)   {}
+func (echoExtension) Removed(peer *extension.Peer) {}
+func (echoExtension) Message(peer *extension.Peer, payload []byte) error {
+	return peer.Send("echo", payload)
+}
+
+func TestEngineExtensions(t *testing.T) {
+	tf, _ := makeTorrent(t, 16*1024, 16*1024)
+	config := DefaultConfig()
+	config.Extensions = extension.NewRegistry(extension.Config{Version: "test"})
+	config.Extensions.Register(echoExtension{})
+
+	engine := NewEngine(tf, storage.NewMemoryStorage(tf), nil, config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	handshake := peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{1})
+	handshake.SetCapability(peers.CapabilityExtensions)
+	go engine.AddPeer(local, handshake)
+
+	remote.SetDeadline(time.Now().Add(2 * time.Second))
+	readExtended := func() (uint8, []byte) {
+		t.Helper()
+		for {
+			msg, err := peers.ReadMessage(remote)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if msg != nil && msg.ID == peers.MsgExtended {
+				id, payload, _ := peers.ParseExtended(msg)
+				return id, payload
+			}
+		}
+	}
+
+	id, payload := readExtended()
+	ours, err := peers.ParseExtendedHandshake(payload)
+	if err != nil || id != peers.ExtendedHandshakeID || ours.V != "test" || ours.M["echo"] != 1 {
+		t.Fatalf("Got and want are not equal\nGOT:%d %+v %v\nWANT:a handshake offering echo as 1\n", id, ours, err)
+	}
+
+	// we receive echo as 7, the engine must answer with that id
+	theirs, _ := (&peers.ExtendedHandshake{M: map[string]int{"echo": 7}}).Message()
+	peers.WriteMessage(remote, theirs)
+	peers.WriteMessage(remote, peers.NewExtended(1, []byte("ping")))
+
+	if id, payload := readExtended(); id != 7 || string(payload) != "ping" {
+		t.Errorf("Got and want are not equal\nGOT:%d %s\nWANT:7 ping\n", id, payload)
+	}
+}
+
+func TestEnginePort(t *testing.T) {
+	tf, _ := makeTorrent(t, 16*1024, 16*1024)
+	ports := make(chan uint16, 1)
+	config := DefaultConfig()
+	config.OnPort = func(conn *peers.PeerConn, port uint16) { ports <- port }
+
+	engine := NewEngine(tf, storage.NewMemoryStorage(tf), nil, config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go engine.AddPeer(local, peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{1}))
+	go func() {
+		// drain whatever the engine sends so its writes never block
+		for {
+			if _, err := peers.ReadMessage(remote); err != nil {
+				return
+			}
+		}
+	}()
+
+	peers.WriteMessage(remote, peers.NewPort(6881))
+	select {
+	case port := <-ports:
+		if port != 6881 {
+			t.Errorf("Got and want are not equal\nGOT:%d\nWANT:6881\n", port)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("the port was never reported")
+	}
+}
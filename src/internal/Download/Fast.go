@@ -0,0 +1,75 @@
+package download
+
+import (
+	"net"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	piecepicker "github.com/firozt/go-torrent/src/internal/PiecePicker"
+)
+
+// ============ Method Defs  ============ //
+
+// greet sends a new peer the pieces we have, a peer with the fast extension (BEP 6) is always told even
+// when we have none and is sent the pieces it may request while we choke it
+func (e *Engine) greet(ps *peerState) {
+	have := e.Bitfield()
+	numPieces := len(e.torrentFile.Pieces)
+
+	switch {
+	case ps.conn.Fast() && have.Count() == numPieces:
+		ps.conn.Send(peers.NewHaveAll())
+	case ps.conn.Fast() && have.Count() == 0:
+		ps.conn.Send(peers.NewHaveNone())
+	case have.Count() > 0:
+		ps.conn.Send(peers.NewBitfield(have))
+	}
+
+	if !ps.conn.Fast() {
+		return
+	}
+	for _, index := range peers.AllowedFastSet(e.config.AllowedFast, numPieces, e.torrentFile.InfoHash, remoteIP(ps.conn.RemoteAddr())) {
+		ps.allowedFast[index] = true
+		ps.conn.Send(peers.NewAllowedFast(index))
+	}
+}
+
+// reject tells a fast peer a request will not be answered, other peers assume so when they are choked
+func (e *Engine) reject(ps *peerState, block piecepicker.Block) {
+	if ps.conn.Fast() {
+		ps.conn.Send(peers.NewReject(block.Index, block.Begin, block.Length))
+	}
+}
+
+// handleReject hands a rejected request to other peers straight away rather than waiting for it to time out.
+// The peer that rejected it is not asked again until it sends something else, so a peer rejecting
+// everything is not asked in a loop
+func (e *Engine) handleReject(ps *peerState, msg *peers.Message) {
+	index, begin, length, err := peers.ParseRequest(msg)
+	if err != nil {
+		return
+	}
+
+	block := piecepicker.Block{Index: index, Begin: begin, Length: length}
+	if _, ok := ps.requests[block]; !ok {
+		return
+	}
+	delete(ps.requests, block)
+	e.picker.Release(ps.conn, block)
+
+	for _, other := range e.sortedPeers() {
+		if other != ps {
+			e.fillPipeline(other)
+		}
+	}
+}
+
+// remoteIP returns the ip of a tcp or udp address, nil for anything else such as a pipe
+func remoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
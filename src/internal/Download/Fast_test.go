@@ -0,0 +1,180 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	choker "github.com/firozt/go-torrent/src/internal/Choker"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+)
+
+func TestEngineFastDownload(t *testing.T) {
+	const pieceLength = 32 * 1024
+	tf, data := makeTorrent(t, pieceLength, 4*pieceLength)
+	numPieces := len(tf.Pieces)
+
+	type TestCase struct {
+		testname string
+		peers    []*fakePeer
+	}
+
+	// the request timeout outlasts the test, so neither download may rely on it
+	testcases := []TestCase{
+		{"rejected requests go to other peers", []*fakePeer{{has: fullBitfield(numPieces), rejects: true}, {has: fullBitfield(numPieces)}}},
+		{"allowed fast pieces while choked", []*fakePeer{{has: fullBitfield(numPieces), neverUnchoke: true, allowedFast: []uint32{0, 1, 2, 3}}}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			config := DefaultConfig()
+			config.RequestTimeout = time.Minute
+
+			store := storage.NewMemoryStorage(tf)
+			engine := NewEngine(tf, store, nil, config)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			go engine.Run(ctx)
+
+			for i, fake := range tc.peers {
+				fake.data, fake.pieceLength = data, pieceLength
+				local, remote := net.Pipe()
+				defer remote.Close()
+				go fake.run(remote)
+
+				handshake := peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{byte(i + 1)})
+				handshake.SetCapability(peers.CapabilityFast)
+				if _, err := engine.AddPeer(local, handshake); err != nil {
+					t.Fatalf("An error was thrown none expected, %v", err)
+				}
+			}
+
+			select {
+			case <-engine.Done():
+			case <-ctx.Done():
+				t.Fatalf("download did not complete, stats %+v", engine.Stats())
+			}
+
+			if got := readAll(t, tf, store); !bytes.Equal(got, data) {
+				t.Errorf("downloaded data does not match the torrent data")
+			}
+		})
+	}
+}
+
+func TestEngineFastUpload(t *testing.T) {
+	const pieceLength = 32 * 1024
+	tf, data := makeTorrent(t, pieceLength, 2*pieceLength)
+	store := storage.NewMemoryStorage(tf)
+	for index := range 2 {
+		store.WriteAt(index, data[index*pieceLength:(index+1)*pieceLength], 0)
+	}
+
+	// the peer stays choked so every request must be rejected
+	config := DefaultConfig()
+	config.NewChoker = func() choker.Choker[*peers.PeerConn] {
+		return chokerFunc(func(now time.Time, snapshot []choker.Peer[*peers.PeerConn], seeding bool) map[*peers.PeerConn]bool {
+			return nil
+		})
+	}
+
+	engine := NewEngine(tf, store, fullBitfield(2), config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	handshake := peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{1})
+	handshake.SetCapability(peers.CapabilityFast)
+	engine.AddPeer(local, handshake)
+
+	remote.SetDeadline(time.Now().Add(2 * time.Second))
+	read := func() *peers.Message {
+		t.Helper()
+		for {
+			msg, err := peers.ReadMessage(remote)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if msg != nil {
+				return msg
+			}
+		}
+	}
+
+	// a fast peer is told we have every piece without a bitfield
+	if msg := read(); msg.ID != peers.MsgHaveAll {
+		t.Fatalf("Got and want are not equal\nGOT:%v\nWANT:have all\n", msg)
+	}
+
+	peers.WriteMessage(remote, &peers.Message{ID: peers.MsgInterested})
+	peers.WriteMessage(remote, peers.NewRequest(1, 0, 16*1024))
+	msg := read()
+	index, begin, length, err := peers.ParseRequest(msg)
+	if err != nil || msg.ID != peers.MsgRejectRequest || index != 1 || begin != 0 || length != 16*1024 {
+		t.Errorf("Got and want are not equal\nGOT:%v %v\nWANT:reject of piece 1\n", msg, err)
+	}
+}
+
+// unreadableStore fails every read, as storage does when a file was deleted under it
+type unreadableStore struct {
+	storage.Storage
+}
+
+func (unreadableStore) ReadAt(index int, p []byte, begin int64) (int, error) {
+	return 0, fmt.Errorf("file removed")
+}
+
+func TestEngineFastUploadReadFailure(t *testing.T) {
+	const pieceLength = 32 * 1024
+	tf, _ := makeTorrent(t, pieceLength, 2*pieceLength)
+
+	// the peer is unchoked so the request reaches the store
+	config := DefaultConfig()
+	config.NewChoker = func() choker.Choker[*peers.PeerConn] {
+		return chokerFunc(func(now time.Time, snapshot []choker.Peer[*peers.PeerConn], seeding bool) map[*peers.PeerConn]bool {
+			unchoked := map[*peers.PeerConn]bool{}
+			for _, peer := range snapshot {
+				unchoked[peer.Key] = true
+			}
+			return unchoked
+		})
+	}
+
+	engine := NewEngine(tf, unreadableStore{storage.NewMemoryStorage(tf)}, fullBitfield(2), config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	handshake := peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{1})
+	handshake.SetCapability(peers.CapabilityFast)
+	engine.AddPeer(local, handshake)
+
+	remote.SetDeadline(time.Now().Add(2 * time.Second))
+	peers.WriteMessage(remote, &peers.Message{ID: peers.MsgInterested})
+	peers.WriteMessage(remote, peers.NewRequest(1, 0, 16*1024))
+
+	// a block that cannot be read is rejected rather than left unanswered
+	for {
+		msg, err := peers.ReadMessage(remote)
+		if err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+		if msg == nil || msg.ID != peers.MsgRejectRequest {
+			continue
+		}
+		index, begin, length, err := peers.ParseRequest(msg)
+		if err != nil || index != 1 || begin != 0 || length != 16*1024 {
+			t.Errorf("Got and want are not equal\nGOT:%v %v\nWANT:reject of piece 1\n", msg, err)
+		}
+		return
+	}
+}
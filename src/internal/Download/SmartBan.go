@@ -0,0 +1,97 @@
+package download
+
+import (
+	"crypto/sha1"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// ============ Struct Defs  ============ //
+
+// blockRecord is who sent a block of a piece that failed its hash check and the hash of what it sent
+type blockRecord struct {
+	conn   *peers.PeerConn
+	digest [20]byte
+}
+
+// ============ Method Defs  ============ //
+
+/*
+pieceFailed discards a piece that did not match its hash and works out who to blame. A piece sent by
+a single peer is that peers fault. A piece several peers contributed to has the hash of every block
+kept and is downloaded again from a single peer, once it verifies the blocks that differ from the
+good copy name the peers that sent bad data, see pieceVerified
+*/
+func (e *Engine) pieceFailed(index int, data []byte, sources []*peers.PeerConn) {
+	e.picker.Failed(index)
+	e.setStats(func(s *Stats) { s.HashFailures++ })
+
+	contributors := unique(sources)
+	for _, conn := range contributors {
+		if ps, ok := e.peers[conn]; ok {
+			ps.hashFailures++
+		}
+	}
+
+	if len(contributors) == 1 {
+		// a single peer retry that failed again leaves the earlier suspects to the next retry
+		e.badPeer(contributors[0])
+		return
+	}
+
+	records := make([]blockRecord, len(sources))
+	for block, conn := range sources {
+		begin, end := e.blockBounds(block, len(data))
+		records[block] = blockRecord{conn: conn, digest: sha1.Sum(data[begin:end])}
+	}
+	e.suspects[index] = records
+	e.picker.SetSinglePeer(index, true)
+}
+
+// pieceVerified compares a piece that failed before against the blocks kept from that attempt,
+// every peer that sent a block which differs from the verified data is reported
+func (e *Engine) pieceVerified(index int, data []byte) {
+	records, ok := e.suspects[index]
+	if !ok {
+		return
+	}
+	delete(e.suspects, index)
+
+	var culprits []*peers.PeerConn
+	for block, record := range records {
+		begin, end := e.blockBounds(block, len(data))
+		if sha1.Sum(data[begin:end]) != record.digest {
+			culprits = append(culprits, record.conn)
+		}
+	}
+	for _, conn := range unique(culprits) {
+		e.badPeer(conn)
+	}
+}
+
+// badPeer reports a peer proven to have sent bad data, it may have disconnected since
+func (e *Engine) badPeer(conn *peers.PeerConn) {
+	e.setStats(func(s *Stats) { s.BadPeers++ })
+	if e.config.OnBadPeer != nil {
+		e.config.OnBadPeer(conn)
+	}
+}
+
+// blockBounds returns where a block lies within a piece of pieceSize bytes
+func (e *Engine) blockBounds(block, pieceSize int) (int, int) {
+	begin := block * int(e.config.BlockSize)
+	return begin, min(begin+int(e.config.BlockSize), pieceSize)
+}
+
+// unique returns conns without duplicates keeping the first occurrence of each
+func unique(conns []*peers.PeerConn) []*peers.PeerConn {
+	var res []*peers.PeerConn
+	seen := map[*peers.PeerConn]bool{}
+	for _, conn := range conns {
+		if conn != nil && !seen[conn] {
+			seen[conn] = true
+			res = append(res, conn)
+		}
+	}
+	return res
+}
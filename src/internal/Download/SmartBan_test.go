@@ -0,0 +1,84 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+)
+
+func TestEngineBadPeer(t *testing.T) {
+	const pieceLength = 40 * 1024
+	tf, data := makeTorrent(t, pieceLength, 8*pieceLength)
+	numPieces := len(tf.Pieces)
+
+	type TestCase struct {
+		testname string
+		corrupt  int // blocks the bad peer corrupts
+	}
+
+	testcases := []TestCase{
+		{"corrupt once", 1},
+		{"corrupt a few", 3},
+		{"always corrupt", 1 << 20},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			bad := make(chan *peers.PeerConn, 64)
+			config := DefaultConfig()
+			config.PipelineDepth = 2
+			config.RequestTimeout = 100 * time.Millisecond
+			config.OnBadPeer = func(conn *peers.PeerConn) {
+				bad <- conn
+				conn.Close()
+			}
+
+			store := storage.NewMemoryStorage(tf)
+			engine := NewEngine(tf, store, nil, config)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			go engine.Run(ctx)
+
+			var conns []*peers.PeerConn
+			for _, fake := range []*fakePeer{{corrupt: tc.corrupt}, {}} {
+				fake.data, fake.pieceLength, fake.has = data, pieceLength, fullBitfield(numPieces)
+				local, remote := net.Pipe()
+				defer remote.Close()
+				go fake.run(remote)
+
+				conn, err := engine.AddPeer(local, peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{byte(len(conns))}))
+				if err != nil {
+					t.Fatalf("An error was thrown none expected, %v", err)
+				}
+				conns = append(conns, conn)
+			}
+
+			select {
+			case <-engine.Done():
+			case <-ctx.Done():
+				t.Fatalf("download did not complete, stats %+v", engine.Stats())
+			}
+			if !bytes.Equal(readAll(t, tf, store), data) {
+				t.Errorf("downloaded data does not match the torrent data")
+			}
+
+			// only the corrupting peer is ever blamed
+			close(bad)
+			reported := 0
+			for conn := range bad {
+				reported++
+				if conn != conns[0] {
+					t.Errorf("Got and want are not equal\nGOT:%s\nWANT:%s\n", conn, conns[0])
+				}
+			}
+			if stats := engine.Stats(); reported == 0 || stats.BadPeers != reported || stats.HashFailures == 0 {
+				t.Errorf("Got and want are not equal\nGOT:%d reported, stats %+v\nWANT:the bad peer reported\n", reported, stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package download
+
+import (
+	"slices"
+	"sync"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	piecepicker "github.com/firozt/go-torrent/src/internal/PiecePicker"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+)
+
+// ============ Struct Defs  ============ //
+
+// requests asking for more than this are ignored, every common client asks for 16KiB
+const maxRequestLength = 128 * 1024
+
+/*
+uploader answers the requests of a single peer. The engine queues requests as they arrive and
+the uploader reads the blocks from storage on its own goroutine so slow disks never stall the
+engine. A queued request is dropped when the peer cancels it or is choked
+*/
+type uploader struct {
+	conn   *peers.PeerConn
+	store  storage.Storage
+	max    int             // queued requests, further requests are dropped
+	onSent func(bytes int) // called after every block sent
+
+	mu    sync.Mutex
+	queue []piecepicker.Block
+	wake  chan struct{}
+}
+
+// ============ Method Defs  ============ //
+
+func newUploader(conn *peers.PeerConn, store storage.Storage, max int, onSent func(int)) *uploader {
+	return &uploader{conn: conn, store: store, max: max, onSent: onSent, wake: make(chan struct{}, 1)}
+}
+
+// push queues a request, false when the queue is full. A block already queued is only answered once
+func (u *uploader) push(block piecepicker.Block) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if slices.Contains(u.queue, block) {
+		return true
+	}
+	if len(u.queue) >= u.max {
+		return false
+	}
+	u.queue = append(u.queue, block)
+
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// cancel removes a queued request, false when it was not queued as it was already sent or never asked for
+func (u *uploader) cancel(block piecepicker.Block) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	before := len(u.queue)
+	u.queue = slices.DeleteFunc(u.queue, func(queued piecepicker.Block) bool { return queued == block })
+	return len(u.queue) != before
+}
+
+// clear drops the queued requests keep does not hold on to and returns them, a choked peer expects none
+// of them to be answered. keep may be nil to drop every request
+func (u *uploader) clear(keep func(piecepicker.Block) bool) []piecepicker.Block {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var dropped []piecepicker.Block
+	u.queue = slices.DeleteFunc(u.queue, func(queued piecepicker.Block) bool {
+		if keep != nil && keep(queued) {
+			return false
+		}
+		dropped = append(dropped, queued)
+		return true
+	})
+	return dropped
+}
+
+func (u *uploader) pending() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.queue)
+}
+
+// pop takes the oldest request, blocks are taken one at a time so a cancel still applies to the rest
+func (u *uploader) pop() (piecepicker.Block, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.queue) == 0 {
+		return piecepicker.Block{}, false
+	}
+	block := u.queue[0]
+	u.queue = u.queue[1:]
+	return block, true
+}
+
+// run serves queued requests until the connection closes
+func (u *uploader) run() {
+	for {
+		select {
+		case <-u.wake:
+		case <-u.conn.Done():
+			return
+		}
+
+		for {
+			block, ok := u.pop()
+			if !ok {
+				break
+			}
+
+			data := make([]byte, block.Length)
+			if _, err := u.store.ReadAt(int(block.Index), data, int64(block.Begin)); err != nil {
+				// fast peers wait on an answer to every request
+				if u.conn.Fast() {
+					u.conn.Send(peers.NewReject(block.Index, block.Begin, block.Length))
+				}
+				continue
+			}
+			if err := u.conn.Send(peers.NewPiece(block.Index, block.Begin, data)); err != nil {
+				return
+			}
+			u.onSent(len(data))
+		}
+	}
+}
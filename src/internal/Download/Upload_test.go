@@ -0,0 +1,195 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	choker "github.com/firozt/go-torrent/src/internal/Choker"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	piecepicker "github.com/firozt/go-torrent/src/internal/PiecePicker"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+)
+
+func TestUploaderQueue(t *testing.T) {
+	type TestCase struct {
+		testname string
+		apply    func(u *uploader)
+		expected int // requests left queued
+	}
+
+	block := func(index uint32) piecepicker.Block { return piecepicker.Block{Index: index, Length: 16 * 1024} }
+
+	testcases := []TestCase{
+		{"queued", func(u *uploader) { u.push(block(0)); u.push(block(1)) }, 2},
+		{"duplicate dropped", func(u *uploader) { u.push(block(0)); u.push(block(0)) }, 1},
+		{"over the limit dropped", func(u *uploader) {
+			for i := range 5 {
+				u.push(block(uint32(i)))
+			}
+		}, 3},
+		{"cancel", func(u *uploader) { u.push(block(0)); u.push(block(1)); u.cancel(block(0)) }, 1},
+		{"cancel unknown", func(u *uploader) { u.push(block(0)); u.cancel(block(1)) }, 1},
+		{"clear on choke", func(u *uploader) { u.push(block(0)); u.push(block(1)); u.clear(nil) }, 0},
+		{"clear keeps allowed fast", func(u *uploader) {
+			u.push(block(0))
+			u.push(block(1))
+			u.clear(func(b piecepicker.Block) bool { return b.Index == 1 })
+		}, 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			u := newUploader(nil, nil, 3, func(int) {})
+			tc.apply(u)
+			if got := u.pending(); got != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestEngineSeeds(t *testing.T) {
+	const pieceLength = 32 * 1024
+	tf, data := makeTorrent(t, pieceLength, 2*pieceLength)
+	store := storage.NewMemoryStorage(tf)
+	for index := range 2 {
+		store.WriteAt(index, data[index*pieceLength:(index+1)*pieceLength], 0)
+	}
+
+	engine := NewEngine(tf, store, fullBitfield(2), DefaultConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	engine.AddPeer(local, peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{1}))
+
+	remote.SetDeadline(time.Now().Add(2 * time.Second))
+	read := func() *peers.Message {
+		t.Helper()
+		for {
+			msg, err := peers.ReadMessage(remote)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if msg != nil {
+				return msg
+			}
+		}
+	}
+
+	if msg := read(); msg.ID != peers.MsgBitfield {
+		t.Fatalf("Got and want are not equal\nGOT:%v\nWANT:bitfield\n", msg)
+	}
+
+	// requests before we unchoke are ignored, as are ones past the end of a piece
+	peers.WriteMessage(remote, peers.NewRequest(0, 0, 16*1024))
+	peers.WriteMessage(remote, &peers.Message{ID: peers.MsgInterested})
+	if msg := read(); msg.ID != peers.MsgUnchoke {
+		t.Fatalf("Got and want are not equal\nGOT:%v\nWANT:unchoke\n", msg)
+	}
+	peers.WriteMessage(remote, peers.NewRequest(1, pieceLength-10, 16*1024))
+
+	expected := []piecepicker.Block{{Index: 1, Begin: 16 * 1024, Length: 16 * 1024}, {Index: 0, Begin: 100, Length: 1000}}
+	for _, block := range expected {
+		peers.WriteMessage(remote, peers.NewRequest(block.Index, block.Begin, block.Length))
+	}
+	for _, block := range expected {
+		msg := read()
+		index, begin, got, err := peers.ParsePiece(msg)
+		offset := int(block.Index)*pieceLength + int(block.Begin)
+		if err != nil || index != block.Index || begin != block.Begin || !bytes.Equal(got, data[offset:offset+int(block.Length)]) {
+			t.Fatalf("Got and want are not equal\nGOT:%v %v\nWANT:block %+v\n", msg, err, block)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for engine.Stats().Uploaded != 16*1024+1000 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := engine.Stats().Uploaded; got != 16*1024+1000 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", got, 16*1024+1000)
+	}
+}
+
+// chokerFunc adapts a function to the choker.Choker interface
+type chokerFunc func(now time.Time, peers []choker.Peer[*peers.PeerConn], seeding bool) map[*peers.PeerConn]bool
+
+func (f chokerFunc) Rechoke(now time.Time, peers []choker.Peer[*peers.PeerConn], seeding bool) map[*peers.PeerConn]bool {
+	return f(now, peers, seeding)
+}
+
+func TestEngineChoker(t *testing.T) {
+	const pieceLength = 32 * 1024
+	tf, data := makeTorrent(t, pieceLength, pieceLength)
+	store := storage.NewMemoryStorage(tf)
+	store.WriteAt(0, data, 0)
+
+	// the policy unchokes the peer while it is interested and records what the engine told it
+	rounds := make(chan []choker.Peer[*peers.PeerConn], 16)
+	config := DefaultConfig()
+	config.RechokeInterval = 20 * time.Millisecond
+	config.NewChoker = func() choker.Choker[*peers.PeerConn] {
+		return chokerFunc(func(now time.Time, snapshot []choker.Peer[*peers.PeerConn], seeding bool) map[*peers.PeerConn]bool {
+			if !seeding {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", seeding, true)
+			}
+			select {
+			case rounds <- snapshot:
+			default:
+			}
+			res := map[*peers.PeerConn]bool{}
+			for _, p := range snapshot {
+				res[p.Key] = p.Interested
+			}
+			return res
+		})
+	}
+
+	engine := NewEngine(tf, store, fullBitfield(1), config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	engine.AddPeer(local, peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{1}))
+
+	remote.SetDeadline(time.Now().Add(2 * time.Second))
+	read := func(id peers.MessageID) *peers.Message {
+		t.Helper()
+		for {
+			msg, err := peers.ReadMessage(remote)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if msg != nil && msg.ID == id {
+				return msg
+			}
+		}
+	}
+
+	read(peers.MsgBitfield)
+	peers.WriteMessage(remote, &peers.Message{ID: peers.MsgInterested})
+	read(peers.MsgUnchoke)
+
+	// uploads show up in the rate of the next round
+	peers.WriteMessage(remote, peers.NewRequest(0, 0, 16*1024))
+	read(peers.MsgPiece)
+	deadline := time.After(time.Second)
+	for measured := false; !measured; {
+		select {
+		case snapshot := <-rounds:
+			measured = len(snapshot) == 1 && snapshot[0].UploadRate > 0
+		case <-deadline:
+			t.Fatalf("Got and want are not equal\nGOT:no upload rate\nWANT:a rate above zero\n")
+		}
+	}
+
+	peers.WriteMessage(remote, &peers.Message{ID: peers.MsgNotInterested})
+	read(peers.MsgChoke)
+}
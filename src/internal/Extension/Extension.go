@@ -0,0 +1,275 @@
+// Package extension implements the BEP 10 extension protocol, extensions such as ut_metadata or ut_pex
+// register with a Registry which negotiates their message ids with every peer
+package extension
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// ============ Struct Defs  ============ //
+
+/*
+Extension is a single extension, it is only told about peers that advertised it in their
+extended handshake. The methods are called from the goroutine handling peer messages and must not block,
+bar Added for peers already connected when the extension is registered which runs on the caller of
+Register. An extension may send from any goroutine through Peer.Send
+*/
+type Extension interface {
+	Name() string // the key in the m dictionary, e.g. ut_pex
+	Added(peer *Peer)
+	Message(peer *Peer, payload []byte) error
+	// Removed is called when the peer disconnects or disables the extension
+	Removed(peer *Peer)
+}
+
+// HandshakeFiller is implemented by extensions that add fields to our extended handshake, such as metadata_size
+type HandshakeFiller interface {
+	FillHandshake(h *peers.ExtendedHandshake)
+}
+
+// Config holds the optional fields of our extended handshake
+type Config struct {
+	Version string // client name and version
+	Port    uint16 // our listen port
+	Reqq    int    // requests we queue per peer
+	// Fill is called each time a handshake is built to set fields that may change after the registry
+	// is made, such as a port bound later, may be nil
+	Fill func(h *peers.ExtendedHandshake)
+}
+
+// Registry holds the extensions of a torrent and the extended handshakes of its peers, the id
+// peers use to send us the extension at index i is i+1
+type Registry struct {
+	config Config
+
+	mu         sync.Mutex
+	extensions []Extension
+	peers      map[*peers.PeerConn]*Peer
+}
+
+// Peer is a connection that supports the extension protocol
+type Peer struct {
+	Conn *peers.PeerConn
+
+	mu        sync.Mutex
+	handshake *peers.ExtendedHandshake // nil until the peer sent its handshake
+}
+
+var (
+	// ErrDuplicateExtension occurs when registering a second extension under the same name
+	ErrDuplicateExtension = fmt.Errorf("extension already registered")
+	// ErrUnsupported occurs when sending an extension message to a peer that did not advertise the extension
+	ErrUnsupported = fmt.Errorf("extension not supported by peer")
+	// ErrUnknownID occurs when a peer sends an extended message with an id we never handed out
+	ErrUnknownID = fmt.Errorf("unknown extended message id")
+)
+
+// ============ Method Defs  ============ //
+
+func NewRegistry(config Config) *Registry {
+	return &Registry{config: config, peers: map[*peers.PeerConn]*Peer{}}
+}
+
+// Register adds an extension, peers already connected are sent a new handshake advertising it and
+// those that advertised it already are handed to Added
+func (r *Registry) Register(ext Extension) error {
+	r.mu.Lock()
+	for _, registered := range r.extensions {
+		if registered.Name() == ext.Name() {
+			r.mu.Unlock()
+			return fmt.Errorf("%w - %s", ErrDuplicateExtension, ext.Name())
+		}
+	}
+	r.extensions = append(r.extensions, ext)
+	connected := r.peerList()
+	r.mu.Unlock()
+
+	for _, peer := range connected {
+		r.sendHandshake(peer.Conn)
+		if peer.Supports(ext.Name()) {
+			ext.Added(peer)
+		}
+	}
+	return nil
+}
+
+// Handshake builds our extended handshake for conn
+func (r *Registry) Handshake(conn *peers.PeerConn) *peers.ExtendedHandshake {
+	r.mu.Lock()
+	extensions := append([]Extension{}, r.extensions...)
+	r.mu.Unlock()
+
+	h := &peers.ExtendedHandshake{
+		M:    make(map[string]int, len(extensions)),
+		V:    r.config.Version,
+		P:    r.config.Port,
+		Reqq: r.config.Reqq,
+	}
+	if r.config.Fill != nil {
+		r.config.Fill(h)
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		h.YourIP = addr.IP
+	}
+	for i, ext := range extensions {
+		h.M[ext.Name()] = i + 1
+		if filler, ok := ext.(HandshakeFiller); ok {
+			filler.FillHandshake(h)
+		}
+	}
+	return h
+}
+
+// AddPeer sends our handshake to a new connection, peers that did not set CapabilityExtensions are ignored
+func (r *Registry) AddPeer(conn *peers.PeerConn) {
+	if !conn.Supports(peers.CapabilityExtensions) {
+		return
+	}
+
+	r.mu.Lock()
+	r.peers[conn] = &Peer{Conn: conn}
+	r.mu.Unlock()
+
+	r.sendHandshake(conn)
+}
+
+func (r *Registry) sendHandshake(conn *peers.PeerConn) error {
+	msg, err := r.Handshake(conn).Message()
+	if err != nil {
+		return err
+	}
+	return conn.Send(msg)
+}
+
+// RemovePeer tells the extensions the peer used that it disconnected
+func (r *Registry) RemovePeer(conn *peers.PeerConn) {
+	r.mu.Lock()
+	peer, ok := r.peers[conn]
+	delete(r.peers, conn)
+	extensions := append([]Extension{}, r.extensions...)
+	r.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	for _, ext := range extensions {
+		if peer.Supports(ext.Name()) {
+			ext.Removed(peer)
+		}
+	}
+}
+
+/*
+HandleMessage handles an extended message from conn. A handshake updates the extensions the peer
+supports, telling extensions it enabled or disabled. Any other message goes to the extension we
+handed its id to, as long as the peer advertised that extension
+*/
+func (r *Registry) HandleMessage(conn *peers.PeerConn, msg *peers.Message) error {
+	id, payload, err := peers.ParseExtended(msg)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	peer, ok := r.peers[conn]
+	extensions := append([]Extension{}, r.extensions...)
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w - %d from a peer without the extension protocol", ErrUnknownID, id)
+	}
+
+	if id == peers.ExtendedHandshakeID {
+		h, err := peers.ParseExtendedHandshake(payload)
+		if err != nil {
+			return err
+		}
+
+		before := make([]bool, len(extensions))
+		for i, ext := range extensions {
+			before[i] = peer.Supports(ext.Name())
+		}
+		peer.mu.Lock()
+		peer.handshake = h
+		peer.mu.Unlock()
+
+		for i, ext := range extensions {
+			switch now := peer.Supports(ext.Name()); {
+			case now && !before[i]:
+				ext.Added(peer)
+			case !now && before[i]:
+				ext.Removed(peer)
+			}
+		}
+		return nil
+	}
+
+	if int(id) > len(extensions) {
+		return fmt.Errorf("%w - %d", ErrUnknownID, id)
+	}
+	ext := extensions[id-1]
+	if !peer.Supports(ext.Name()) {
+		return fmt.Errorf("%w - %s", ErrUnsupported, ext.Name())
+	}
+	return ext.Message(peer, payload)
+}
+
+// Peers returns every connected peer that advertised the extension
+func (r *Registry) Peers(name string) []*Peer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := []*Peer{}
+	for _, peer := range r.peerList() {
+		if peer.Supports(name) {
+			res = append(res, peer)
+		}
+	}
+	return res
+}
+
+// peerList returns the peers in no particular order, callers hold the lock
+func (r *Registry) peerList() []*Peer {
+	res := make([]*Peer, 0, len(r.peers))
+	for _, peer := range r.peers {
+		res = append(res, peer)
+	}
+	return res
+}
+
+// Handshake returns the latest extended handshake of the peer, nil until one arrived
+func (p *Peer) Handshake() *peers.ExtendedHandshake {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.handshake
+}
+
+// Supports reports whether the peer advertised the extension in its latest handshake
+func (p *Peer) Supports(name string) bool {
+	_, ok := p.id(name)
+	return ok
+}
+
+// id is what the peer wants to receive the extension as
+func (p *Peer) id(name string) (uint8, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.handshake == nil {
+		return 0, false
+	}
+	id := p.handshake.M[name]
+	return uint8(id), id > 0
+}
+
+// Send sends a message of the extension under the id the peer chose for it
+func (p *Peer) Send(name string, payload []byte) error {
+	id, ok := p.id(name)
+	if !ok {
+		return fmt.Errorf("%w - %s", ErrUnsupported, name)
+	}
+	return p.Conn.Send(peers.NewExtended(id, payload))
+}
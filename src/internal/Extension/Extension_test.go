@@ -0,0 +1,182 @@
+package extension
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// recorder is an extension reporting every call it receives
+type recorder struct {
+	name  string
+	calls chan string
+}
+
+func newRecorder(name string) *recorder {
+	return &recorder{name: name, calls: make(chan string, 16)}
+}
+
+func (r *recorder) Name() string       { return r.name }
+func (r *recorder) Added(peer *Peer)   { r.calls <- "added" }
+func (r *recorder) Removed(peer *Peer) { r.calls <- "removed" }
+func (r *recorder) Message(peer *Peer, payload []byte) error {
+	r.calls <- "message " + string(payload)
+	return nil
+}
+
+func (r *recorder) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-r.calls:
+		if got != want {
+			t.Fatalf("Got and want are not equal\nGOT:%s\nWANT:%s\n", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %q on %s", want, r.name)
+	}
+}
+
+func (r *recorder) expectNothing(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-r.calls:
+		t.Fatalf("Got and want are not equal\nGOT:%s\nWANT:nothing\n", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// serve hands the extended messages of conn to registry the way the engine does
+func serve(registry *Registry, conn *peers.PeerConn, events chan peers.PeerEvent) {
+	registry.AddPeer(conn)
+	for ev := range events {
+		if ev.Message == nil {
+			registry.RemovePeer(conn)
+			return
+		}
+		if ev.Message.ID == peers.MsgExtended {
+			registry.HandleMessage(conn, ev.Message)
+		}
+	}
+}
+
+// connect joins two registries over a pipe, capable sets CapabilityExtensions in both handshakes
+func connect(t *testing.T, left, right *Registry, capable bool) (*peers.PeerConn, *peers.PeerConn) {
+	t.Helper()
+	handshake := peers.NewBitTorrentProtocolHandshake([20]byte{1}, [20]byte{2})
+	if capable {
+		handshake.SetCapability(peers.CapabilityExtensions)
+	}
+
+	a, b := net.Pipe()
+	leftEvents, rightEvents := make(chan peers.PeerEvent, 16), make(chan peers.PeerEvent, 16)
+	leftConn := peers.NewPeerConn(a, handshake, 0, leftEvents, peers.DefaultPeerConnConfig())
+	rightConn := peers.NewPeerConn(b, handshake, 0, rightEvents, peers.DefaultPeerConnConfig())
+	t.Cleanup(func() {
+		leftConn.Close()
+		rightConn.Close()
+	})
+
+	go serve(left, leftConn, leftEvents)
+	go serve(right, rightConn, rightEvents)
+	return leftConn, rightConn
+}
+
+func waitForPeer(t *testing.T, registry *Registry, name string) *Peer {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if found := registry.Peers(name); len(found) == 1 {
+			return found[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for a peer supporting %s", name)
+	return nil
+}
+
+func TestNegotiation(t *testing.T) {
+	leftOnly, leftShared := newRecorder("left_only"), newRecorder("shared")
+	rightShared := newRecorder("shared")
+
+	// shared is id 2 on the left and 1 on the right, each end sends using the others id
+	left := NewRegistry(Config{Version: "left 1.0", Port: 6881, Reqq: 100})
+	left.Register(leftOnly)
+	left.Register(leftShared)
+	right := NewRegistry(Config{})
+	right.Register(rightShared)
+
+	connect(t, left, right, true)
+	leftShared.expect(t, "added")
+	rightShared.expect(t, "added")
+
+	toRight := waitForPeer(t, left, "shared")
+	toLeft := waitForPeer(t, right, "shared")
+
+	if err := toRight.Send("shared", []byte("hello right")); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	rightShared.expect(t, "message hello right")
+	if err := toLeft.Send("shared", []byte("hello left")); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	leftShared.expect(t, "message hello left")
+
+	// the right never advertised left_only so the left neither sends nor accepts it
+	if err := toRight.Send("left_only", []byte("x")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrUnsupported)
+	}
+	toLeft.Send("left_only", []byte("x"))
+	leftOnly.expectNothing(t)
+
+	h := toLeft.Handshake()
+	if h.V != "left 1.0" || h.P != 6881 || h.Reqq != 100 || h.M["left_only"] != 1 || h.M["shared"] != 2 {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:the left handshake\n", h)
+	}
+}
+
+func TestLateRegister(t *testing.T) {
+	leftLate := newRecorder("late")
+	left := NewRegistry(Config{})
+	left.Register(leftLate)
+	right := NewRegistry(Config{})
+
+	leftConn, _ := connect(t, left, right, true)
+	leftLate.expectNothing(t)
+
+	// registering sends the handshake again, the left end learns the right now supports it
+	rightLate := newRecorder("late")
+	if err := right.Register(rightLate); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	leftLate.expect(t, "added")
+	rightLate.expect(t, "added")
+
+	if err := right.Register(newRecorder("late")); !errors.Is(err, ErrDuplicateExtension) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrDuplicateExtension)
+	}
+
+	leftConn.Close()
+	leftLate.expect(t, "removed")
+	rightLate.expect(t, "removed")
+}
+
+func TestWithoutCapability(t *testing.T) {
+	leftExt, rightExt := newRecorder("ext"), newRecorder("ext")
+	left, right := NewRegistry(Config{}), NewRegistry(Config{})
+	left.Register(leftExt)
+	right.Register(rightExt)
+
+	leftConn, _ := connect(t, left, right, false)
+	leftExt.expectNothing(t)
+	rightExt.expectNothing(t)
+
+	if err := left.HandleMessage(leftConn, peers.NewExtended(1, nil)); !errors.Is(err, ErrUnknownID) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrUnknownID)
+	}
+	if got := left.Peers("ext"); len(got) != 0 {
+		t.Errorf("Got and want are not equal\nGOT:%d peers\nWANT:0\n", len(got))
+	}
+}
@@ -0,0 +1,294 @@
+// Package lsd implements local service discovery (BEP 14), torrents are announced over multicast so
+// peers on the same network find each other without a tracker or the dht
+package lsd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// ============ Struct Defs  ============ //
+
+// the multicast groups of BEP 14
+const (
+	Group4 = "239.192.152.143:6771"
+	Group6 = "[ff15::efc0:988f]:6771"
+)
+
+// infohashes per announce, keeping packets well below the mtu
+const maxHashesPerAnnounce = 20
+
+// Config holds the tunables of LSD
+type Config struct {
+	Group       string         // multicast group and port announces are sent to
+	Interface   *net.Interface // interface to announce and listen on, nil lets the system pick
+	Interval    time.Duration  // between announces of every torrent
+	MinInterval time.Duration  // announces of a torrent from the same peer sooner than this are ignored
+}
+
+func DefaultConfig() Config {
+	return Config{Group: Group4, Interval: 5 * time.Minute, MinInterval: time.Minute}
+}
+
+// Found is a peer announcing a torrent on the local network
+type Found struct {
+	InfoHash [20]byte
+	Peer     peers.Peer
+}
+
+/*
+LSD announces the torrents added to it every Interval and when they are added, and listens for the
+announces of other peers. Announces for torrents that were not added, and our own announces
+recognised by their cookie, are ignored
+*/
+type LSD struct {
+	config  Config
+	port    uint16 // where we accept peer connections
+	cookie  string
+	group   *net.UDPAddr
+	listen  *net.UDPConn
+	send    *net.UDPConn
+	onFound func(Found)
+
+	ctx    context.Context // cancelled on Close
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	torrents map[[20]byte]struct{}
+	heard    map[string]time.Time // keyed by peer address and info hash
+}
+
+// ErrInvalidAnnounce occurs when a packet is not a BT-SEARCH announce
+var ErrInvalidAnnounce = fmt.Errorf("invalid lsd announce")
+
+// ============ Method Defs  ============ //
+
+// New joins the multicast group and starts listening, port is where we accept peer connections. onFound must not block
+func New(config Config, port uint16, onFound func(Found)) (*LSD, error) {
+	group, err := net.ResolveUDPAddr("udp", config.Group)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if group.IP.To4() == nil {
+		network = "udp6"
+	}
+
+	listen, err := net.ListenMulticastUDP(network, config.Interface, group)
+	if err != nil {
+		return nil, err
+	}
+	// announces leave through the interface holding the address the socket is bound to
+	send, err := net.ListenUDP(network, &net.UDPAddr{IP: interfaceIP(config.Interface, network)})
+	if err != nil {
+		listen.Close()
+		return nil, err
+	}
+
+	cookie := make([]byte, 8)
+	rand.Read(cookie)
+	l := &LSD{
+		config:   config,
+		port:     port,
+		cookie:   hex.EncodeToString(cookie),
+		group:    group,
+		listen:   listen,
+		send:     send,
+		onFound:  onFound,
+		torrents: map[[20]byte]struct{}{},
+		heard:    map[string]time.Time{},
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+
+	l.wg.Add(2)
+	go l.serve()
+	go l.run()
+	return l, nil
+}
+
+// interfaceIP returns the first address of ifi in the family of network, nil when ifi is nil or has none
+func interfaceIP(ifi *net.Interface, network string) net.IP {
+	if ifi == nil {
+		return nil
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if ok && (ipnet.IP.To4() != nil) == (network == "udp4") {
+			return ipnet.IP
+		}
+	}
+	return nil
+}
+
+// Add announces a torrent right away and every Interval from then on, peers announcing it are handed to onFound
+func (l *LSD) Add(infoHash [20]byte) {
+	l.mu.Lock()
+	l.torrents[infoHash] = struct{}{}
+	l.mu.Unlock()
+
+	l.announce([][20]byte{infoHash})
+}
+
+func (l *LSD) Remove(infoHash [20]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.torrents, infoHash)
+}
+
+func (l *LSD) Close() error {
+	l.cancel()
+	err := l.listen.Close()
+	l.send.Close()
+	l.wg.Wait()
+	return err
+}
+
+// run announces every torrent each Interval and forgets rate limits that expired
+func (l *LSD) run() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			hashes := make([][20]byte, 0, len(l.torrents))
+			for infoHash := range l.torrents {
+				hashes = append(hashes, infoHash)
+			}
+			for key, at := range l.heard {
+				if now.Sub(at) >= l.config.MinInterval {
+					delete(l.heard, key)
+				}
+			}
+			l.mu.Unlock()
+
+			l.announce(hashes)
+		}
+	}
+}
+
+// announce sends the info hashes in as few packets as possible
+func (l *LSD) announce(hashes [][20]byte) {
+	for len(hashes) > 0 {
+		n := min(len(hashes), maxHashesPerAnnounce)
+		l.send.WriteToUDP(announceMessage(l.config.Group, l.port, l.cookie, hashes[:n]), l.group)
+		hashes = hashes[n:]
+	}
+}
+
+// serve reads announces until Close
+func (l *LSD) serve() {
+	defer l.wg.Done()
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := l.listen.ReadFromUDP(buf)
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		port, hashes, cookie, err := parseAnnounce(buf[:n])
+		if err != nil || cookie == l.cookie {
+			continue
+		}
+		peer := peers.NewPeer(addr.IP, port)
+		for _, infoHash := range hashes {
+			if l.accept(peer, infoHash) {
+				l.onFound(Found{InfoHash: infoHash, Peer: peer})
+			}
+		}
+	}
+}
+
+// accept reports whether an announce is for one of our torrents and not too soon after the last from the peer
+func (l *LSD) accept(peer peers.Peer, infoHash [20]byte) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.torrents[infoHash]; !ok {
+		return false
+	}
+	key := peer.Address() + string(infoHash[:])
+	now := time.Now()
+	if at, ok := l.heard[key]; ok && now.Sub(at) < l.config.MinInterval {
+		return false
+	}
+	l.heard[key] = now
+	return true
+}
+
+// announceMessage builds a BT-SEARCH announce, an http request without a body
+func announceMessage(host string, port uint16, cookie string, hashes [][20]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("BT-SEARCH * HTTP/1.1\r\n")
+	fmt.Fprintf(&buf, "Host: %s\r\n", host)
+	fmt.Fprintf(&buf, "Port: %d\r\n", port)
+	for _, infoHash := range hashes {
+		fmt.Fprintf(&buf, "Infohash: %x\r\n", infoHash)
+	}
+	if cookie != "" {
+		fmt.Fprintf(&buf, "cookie: %s\r\n", cookie)
+	}
+	buf.WriteString("\r\n\r\n")
+	return buf.Bytes()
+}
+
+/*
+parseAnnounce reads a BT-SEARCH announce
+@returns
+the port the peer accepts connections on, the info hashes it announced and its cookie, empty if it sent none
+*/
+func parseAnnounce(packet []byte) (uint16, [][20]byte, string, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(packet)))
+	line, err := reader.ReadLine()
+	if err != nil || line != "BT-SEARCH * HTTP/1.1" {
+		return 0, nil, "", fmt.Errorf("%w - bad request line %q", ErrInvalidAnnounce, line)
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return 0, nil, "", fmt.Errorf("%w - %s", ErrInvalidAnnounce, err)
+	}
+
+	port, err := strconv.ParseUint(strings.TrimSpace(header.Get("Port")), 10, 16)
+	if err != nil || port == 0 {
+		return 0, nil, "", fmt.Errorf("%w - invalid port %q", ErrInvalidAnnounce, header.Get("Port"))
+	}
+
+	hashes := [][20]byte{}
+	for _, raw := range header.Values("Infohash") {
+		decoded, err := hex.DecodeString(strings.TrimSpace(raw))
+		if err != nil || len(decoded) != 20 {
+			return 0, nil, "", fmt.Errorf("%w - invalid infohash %q", ErrInvalidAnnounce, raw)
+		}
+		hashes = append(hashes, [20]byte(decoded))
+	}
+	if len(hashes) == 0 {
+		return 0, nil, "", fmt.Errorf("%w - no infohash", ErrInvalidAnnounce)
+	}
+	return uint16(port), hashes, strings.TrimSpace(header.Get("Cookie")), nil
+}
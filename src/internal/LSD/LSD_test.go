@@ -0,0 +1,125 @@
+package lsd
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseAnnounce(t *testing.T) {
+	a, b := [20]byte{0xaa}, [20]byte{0xbb}
+
+	type TestCase struct {
+		testname       string
+		input          string
+		expectedPort   uint16
+		expectedHashes [][20]byte
+		expectedCookie string
+		throwsError    bool
+	}
+
+	testcases := []TestCase{
+		{"round trip", string(announceMessage(Group4, 6881, "c00k1e", [][20]byte{a, b})), 6881, [][20]byte{a, b}, "c00k1e", false},
+		{"no cookie, lowercase headers", "BT-SEARCH * HTTP/1.1\r\nhost: 239.192.152.143:6771\r\nport: 51413\r\ninfohash: " +
+			"aa00000000000000000000000000000000000000\r\n\r\n\r\n", 51413, [][20]byte{a}, "", false},
+		{"not a search", "NOTIFY * HTTP/1.1\r\nPort: 6881\r\n\r\n", 0, nil, "", true},
+		{"no port", "BT-SEARCH * HTTP/1.1\r\nInfohash: aa00000000000000000000000000000000000000\r\n\r\n", 0, nil, "", true},
+		{"port out of range", "BT-SEARCH * HTTP/1.1\r\nPort: 70000\r\nInfohash: aa00000000000000000000000000000000000000\r\n\r\n", 0, nil, "", true},
+		{"short infohash", "BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\nInfohash: aa00\r\n\r\n", 0, nil, "", true},
+		{"no infohash", "BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\n\r\n", 0, nil, "", true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			port, hashes, cookie, err := parseAnnounce([]byte(tc.input))
+			if tc.throwsError {
+				if !errors.Is(err, ErrInvalidAnnounce) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidAnnounce)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if port != tc.expectedPort || !reflect.DeepEqual(hashes, tc.expectedHashes) || cookie != tc.expectedCookie {
+				t.Errorf("Got and want are not equal\nGOT:%d %x %q\nWANT:%d %x %q\n", port, hashes, cookie, tc.expectedPort, tc.expectedHashes, tc.expectedCookie)
+			}
+		})
+	}
+}
+
+// loopbackConfig announces on the loopback interface to a free port so tests never reach the real network
+func loopbackConfig(t *testing.T) Config {
+	t.Helper()
+	lo, err := loopback()
+	if err != nil {
+		t.Skipf("no loopback interface, %v", err)
+	}
+	free, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("DEV ERR: unable to listen, %v", err)
+	}
+	port := free.LocalAddr().(*net.UDPAddr).Port
+	free.Close()
+
+	config := DefaultConfig()
+	config.Group = net.JoinHostPort("239.192.152.143", strconv.Itoa(port))
+	config.Interface = lo
+	return config
+}
+
+func loopback() (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return &ifi, nil
+		}
+	}
+	return nil, errors.New("none up")
+}
+
+func TestDiscovery(t *testing.T) {
+	config := loopbackConfig(t)
+	shared, other := [20]byte{1}, [20]byte{2}
+
+	found := make(chan Found, 8)
+	left, err := New(config, 6881, func(f Found) { found <- f })
+	if err != nil {
+		t.Skipf("multicast unavailable, %v", err)
+	}
+	defer left.Close()
+	left.Add(shared)
+
+	right, err := New(config, 51413, func(Found) {})
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	defer right.Close()
+	// other is unknown to the left so only shared is reported
+	right.Add(other)
+	right.Add(shared)
+
+	select {
+	case f := <-found:
+		if f.InfoHash != shared || f.Peer.Address() != "127.0.0.1:51413" {
+			t.Errorf("Got and want are not equal\nGOT:%x %s\nWANT:%x 127.0.0.1:51413\n", f.InfoHash, f.Peer.Address(), shared)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("the announce never arrived")
+	}
+
+	// a repeat within MinInterval, and our own announces, are ignored
+	right.Add(shared)
+	left.Add(shared)
+	select {
+	case f := <-found:
+		t.Errorf("unexpected announce %x from %s", f.InfoHash, f.Peer.Address())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,62 @@
+package mse
+
+import (
+	"crypto/rc4"
+	"io"
+	"net"
+	"sync"
+)
+
+// ============ Struct Defs  ============ //
+
+/*
+Conn is a peer connection after the MSE handshake, reads and writes pass through RC4 when it was
+selected. Bytes read ahead during the handshake and the initial payload of the peer are read first.
+Conn is also returned for plaintext connections that skipped the handshake, it then adds nothing
+*/
+type Conn struct {
+	net.Conn
+	r       io.Reader // the connection behind the bytes read ahead during the handshake
+	pending []byte    // decrypted initial payload not read yet
+	method  Method
+
+	dec *rc4.Cipher // nil unless the method is RC4
+
+	wmu sync.Mutex
+	enc *rc4.Cipher
+}
+
+// ============ Method Defs  ============ //
+
+// Method is the crypto method selected during the handshake, 0 when the peer skipped the handshake
+func (c *Conn) Method() Method {
+	return c.method
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	n, err := c.r.Read(b)
+	if c.dec != nil {
+		c.dec.XORKeyStream(b[:n], b[:n])
+	}
+	return n, err
+}
+
+// Write encrypts into a copy, b is left untouched. The keystream advances by all of b even when the
+// write fails part way, the connection is useless after a failed write anyway
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(b)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	buf := make([]byte, len(b))
+	c.enc.XORKeyStream(buf, b)
+	return c.Conn.Write(buf)
+}
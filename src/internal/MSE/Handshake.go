@@ -0,0 +1,241 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rc4"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+/*
+The handshake between the initiator A and the receiver B, SKEY is the info hash of the torrent and
+S the shared secret
+1 A->B: Ya, PadA
+2 B->A: Yb, PadB
+3 A->B: HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S), ENCRYPT(VC, crypto_provide, len(PadC), PadC, len(IA)), ENCRYPT(IA)
+4 B->A: ENCRYPT(VC, crypto_select, len(PadD), PadD), ENCRYPT2(payload stream)
+5 A->B: ENCRYPT2(payload stream)
+ENCRYPT is RC4 keyed with keyA from A to B and keyB from B to A, ENCRYPT2 is RC4 with the same keys
+or plaintext depending on crypto_select. The padding makes each side search for the start of the next
+step, HASH('req1', S) for B and ENCRYPT(VC) for A
+*/
+
+// ============ Method Defs  ============ //
+
+// Initiate obfuscates an outgoing connection to a peer of the torrent with infoHash. Prefer offers
+// both methods and lets the peer choose, require offers RC4 only. A disabled policy skips the handshake.
+// The caller sets deadlines on conn
+func Initiate(conn net.Conn, infoHash [20]byte, policy Policy) (*Conn, error) {
+	if policy == PolicyDisabled {
+		return &Conn{Conn: conn, r: conn}, nil
+	}
+	return initiate(conn, infoHash, policy.provides())
+}
+
+// Accept reads the start of an incoming connection. A plaintext BitTorrent handshake is passed through
+// untouched unless the policy requires encryption, anything else is taken to be the MSE handshake of a
+// peer of one of infoHashes. A disabled policy skips the handshake. The caller sets deadlines on conn
+func Accept(conn net.Conn, infoHashes [][20]byte, policy Policy) (*Conn, error) {
+	if policy == PolicyDisabled {
+		return &Conn{Conn: conn, r: conn}, nil
+	}
+
+	br := bufio.NewReader(conn)
+	start, err := br.Peek(20)
+	if err != nil {
+		return nil, err
+	}
+	if start[0] == 19 && string(start[1:]) == "BitTorrent protocol" {
+		if policy == PolicyRequire {
+			return nil, ErrPlaintext
+		}
+		return &Conn{Conn: conn, r: br}, nil
+	}
+	return accept(conn, br, infoHashes, policy.provides())
+}
+
+// initiate does the handshake as A offering provide
+func initiate(conn net.Conn, skey [20]byte, provide Method) (*Conn, error) {
+	keys, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+
+	// step 1 and 2
+	if _, err := conn.Write(append(keys.public[:], padding(maxPadding)...)); err != nil {
+		return nil, err
+	}
+	yb := make([]byte, keySize)
+	if _, err := io.ReadFull(br, yb); err != nil {
+		return nil, err
+	}
+	s, err := keys.secret(yb)
+	if err != nil {
+		return nil, err
+	}
+
+	// step 3, without padding or initial payload
+	enc, dec := newCipher("keyA", s[:], skey), newCipher("keyB", s[:], skey)
+	req1, req2, req3 := hash([]byte("req1"), s[:]), hash([]byte("req2"), skey[:]), hash([]byte("req3"), s[:])
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+	header := binary.BigEndian.AppendUint32(vc[:], uint32(provide))
+	header = binary.BigEndian.AppendUint16(header, 0) // len(PadC)
+	header = binary.BigEndian.AppendUint16(header, 0) // len(IA)
+	enc.XORKeyStream(header, header)
+	msg := append(append(req1[:], req2[:]...), header...)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	// step 4, PadB runs until the encrypted VC
+	encVC := make([]byte, len(vc))
+	dec.XORKeyStream(encVC, vc[:])
+	if err := synchronize(br, encVC); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 6)
+	if _, err := io.ReadFull(br, reply); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(reply, reply)
+	selected := Method(binary.BigEndian.Uint32(reply))
+	if (selected != MethodPlaintext && selected != MethodRC4) || selected&provide == 0 {
+		return nil, fmt.Errorf("%w - peer selected %s, offered %#x", ErrNoMethod, selected, uint32(provide))
+	}
+	if err := skip(br, dec, binary.BigEndian.Uint16(reply[4:])); err != nil {
+		return nil, err
+	}
+
+	c := &Conn{Conn: conn, r: br, method: selected}
+	if selected == MethodRC4 {
+		c.enc, c.dec = enc, dec
+	}
+	return c, nil
+}
+
+// accept does the handshake as B for a peer of one of skeys, selecting from what it offers and allowed
+func accept(conn net.Conn, br *bufio.Reader, skeys [][20]byte, allowed Method) (*Conn, error) {
+	keys, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	// step 1 and 2
+	ya := make([]byte, keySize)
+	if _, err := io.ReadFull(br, ya); err != nil {
+		return nil, err
+	}
+	s, err := keys.secret(ya)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(keys.public[:], padding(maxPadding)...)); err != nil {
+		return nil, err
+	}
+
+	// step 3, PadA runs until HASH('req1', S)
+	req1 := hash([]byte("req1"), s[:])
+	if err := synchronize(br, req1[:]); err != nil {
+		return nil, err
+	}
+	var req2 [20]byte
+	if _, err := io.ReadFull(br, req2[:]); err != nil {
+		return nil, err
+	}
+	req3 := hash([]byte("req3"), s[:])
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+	skey, ok := [20]byte{}, false
+	for _, infoHash := range skeys {
+		if hash([]byte("req2"), infoHash[:]) == req2 {
+			skey, ok = infoHash, true
+			break
+		}
+	}
+	if !ok {
+		return nil, ErrUnknownSKEY
+	}
+
+	enc, dec := newCipher("keyB", s[:], skey), newCipher("keyA", s[:], skey)
+	header := make([]byte, len(vc)+6)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(header, header)
+	if !bytes.Equal(header[:len(vc)], vc[:]) {
+		return nil, fmt.Errorf("%w - verification constant mismatch", ErrHandshake)
+	}
+	provide := Method(binary.BigEndian.Uint32(header[len(vc):]))
+	if err := skip(br, dec, binary.BigEndian.Uint16(header[len(vc)+4:])); err != nil {
+		return nil, err
+	}
+	var iaLen [2]byte
+	if _, err := io.ReadFull(br, iaLen[:]); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(iaLen[:], iaLen[:])
+	ia := make([]byte, binary.BigEndian.Uint16(iaLen[:]))
+	if _, err := io.ReadFull(br, ia); err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(ia, ia)
+
+	selected, err := selectMethod(provide, allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	// step 4, without padding
+	reply := binary.BigEndian.AppendUint32(vc[:], uint32(selected))
+	reply = binary.BigEndian.AppendUint16(reply, 0) // len(PadD)
+	enc.XORKeyStream(reply, reply)
+	if _, err := conn.Write(reply); err != nil {
+		return nil, err
+	}
+
+	c := &Conn{Conn: conn, r: br, pending: ia, method: selected}
+	if selected == MethodRC4 {
+		c.enc, c.dec = enc, dec
+	}
+	return c, nil
+}
+
+// synchronize reads past up to maxPadding bytes of padding until mark, leaving br just after it
+func synchronize(br *bufio.Reader, mark []byte) error {
+	window := make([]byte, len(mark))
+	if _, err := io.ReadFull(br, window); err != nil {
+		return err
+	}
+	for skipped := 0; !bytes.Equal(window, mark); skipped++ {
+		if skipped == maxPadding {
+			return fmt.Errorf("%w - no sync point within %d bytes of padding", ErrHandshake, maxPadding)
+		}
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		copy(window, window[1:])
+		window[len(window)-1] = b
+	}
+	return nil
+}
+
+// skip reads past encrypted padding, advancing the keystream with it
+func skip(br *bufio.Reader, dec *rc4.Cipher, length uint16) error {
+	if length > maxPadding {
+		return fmt.Errorf("%w - %d bytes of padding", ErrHandshake, length)
+	}
+	pad := make([]byte, length)
+	if _, err := io.ReadFull(br, pad); err != nil {
+		return err
+	}
+	dec.XORKeyStream(pad, pad)
+	return nil
+}
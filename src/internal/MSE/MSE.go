@@ -0,0 +1,165 @@
+// Package mse implements message stream encryption, also known as protocol encryption. A Diffie-Hellman
+// key exchange and RC4 obfuscate a peer connection beneath the BitTorrent handshake so it cannot be told
+// apart from random bytes by networks that throttle BitTorrent. It hides traffic, it does not authenticate peers
+package mse
+
+import (
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"fmt"
+	"math/big"
+	mathrand "math/rand/v2"
+)
+
+// ============ Struct Defs  ============ //
+
+// Policy decides whether connections are encrypted
+type Policy int
+
+const (
+	PolicyDisabled Policy = iota // plaintext only
+	PolicyPrefer                 // encrypted where the peer supports it, plaintext otherwise
+	PolicyRequire                // encrypted only, other connections fail
+)
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyDisabled:
+		return "disabled"
+	case PolicyPrefer:
+		return "prefer"
+	case PolicyRequire:
+		return "require"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(p))
+	}
+}
+
+// Method is how the stream is obfuscated once the handshake is done, methods are offered as a bitmask
+type Method uint32
+
+const (
+	MethodPlaintext Method = 0x01 // only the handshake is encrypted
+	MethodRC4       Method = 0x02
+)
+
+func (m Method) String() string {
+	switch m {
+	case MethodPlaintext:
+		return "plaintext"
+	case MethodRC4:
+		return "rc4"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint32(m))
+	}
+}
+
+const (
+	keySize = 96 // bytes of a public key and the shared secret
+	// random padding after the public keys hides the length of the handshake
+	maxPadding = 512
+	// bytes of the RC4 keystream thrown away, the start of the keystream is biased
+	discard = 1024
+)
+
+var (
+	// the 768 bit prime modulus and generator of the key exchange
+	prime, _  = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+	generator = big.NewInt(2)
+	// the verification constant, 8 zero bytes that prove both ends derived the same keys
+	vc [8]byte
+)
+
+// ErrHandshake occurs when the peer does not follow the MSE handshake
+var ErrHandshake = fmt.Errorf("mse handshake failed")
+
+// ErrUnknownSKEY occurs when an incoming connection is for none of the torrents we have
+var ErrUnknownSKEY = fmt.Errorf("mse connection for an unknown torrent")
+
+// ErrNoMethod occurs when the peers share no crypto method the policy allows
+var ErrNoMethod = fmt.Errorf("no common mse crypto method")
+
+// ErrPlaintext occurs when a plaintext connection comes in and the policy requires encryption
+var ErrPlaintext = fmt.Errorf("plaintext connection refused, encryption is required")
+
+// ============ Method Defs  ============ //
+
+// provides is what we offer and accept under a policy
+func (p Policy) provides() Method {
+	switch p {
+	case PolicyPrefer:
+		return MethodPlaintext | MethodRC4
+	case PolicyRequire:
+		return MethodRC4
+	default:
+		return MethodPlaintext
+	}
+}
+
+// selectMethod picks the method of an offer we allow, RC4 wins when both are possible
+func selectMethod(offered, allowed Method) (Method, error) {
+	common := offered & allowed
+	switch {
+	case common&MethodRC4 != 0:
+		return MethodRC4, nil
+	case common&MethodPlaintext != 0:
+		return MethodPlaintext, nil
+	default:
+		return 0, fmt.Errorf("%w - offered %#x, allowed %#x", ErrNoMethod, uint32(offered), uint32(allowed))
+	}
+}
+
+// keyPair is one side of the key exchange
+type keyPair struct {
+	private *big.Int
+	public  [keySize]byte
+}
+
+func newKeyPair() (*keyPair, error) {
+	// a 160 bit private key is as strong as the 768 bit group allows
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	k := &keyPair{private: new(big.Int).SetBytes(b)}
+	new(big.Int).Exp(generator, k.private, prime).FillBytes(k.public[:])
+	return k, nil
+}
+
+// secret is S, the shared secret derived from the peers public key
+func (k *keyPair) secret(peerPublic []byte) ([keySize]byte, error) {
+	var s [keySize]byte
+	y := new(big.Int).SetBytes(peerPublic)
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(new(big.Int).Sub(prime, big.NewInt(1))) >= 0 {
+		return s, fmt.Errorf("%w - invalid public key", ErrHandshake)
+	}
+	new(big.Int).Exp(y, k.private, prime).FillBytes(s[:])
+	return s, nil
+}
+
+func hash(parts ...[]byte) [20]byte {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	var res [20]byte
+	h.Sum(res[:0])
+	return res
+}
+
+// newCipher keys RC4 for one direction, name is keyA for the stream the initiator sends and keyB for the other
+func newCipher(name string, secret []byte, skey [20]byte) *rc4.Cipher {
+	key := hash([]byte(name), secret, skey[:])
+	c, _ := rc4.NewCipher(key[:])
+	var drop [discard]byte
+	c.XORKeyStream(drop[:], drop[:])
+	return c
+}
+
+// padding returns up to max random bytes
+func padding(max int) []byte {
+	pad := make([]byte, mathrand.IntN(max+1))
+	rand.Read(pad)
+	return pad
+}
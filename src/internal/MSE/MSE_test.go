@@ -0,0 +1,248 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// wiretap records every byte written to a connection
+type wiretap struct {
+	net.Conn
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (w *wiretap) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	w.written.Write(b)
+	w.mu.Unlock()
+	return w.Conn.Write(b)
+}
+
+func (w *wiretap) bytes() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return bytes.Clone(w.written.Bytes())
+}
+
+// tcpPair returns both ends of a loopback tcp connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot listen - %s", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot dial - %s", err)
+	}
+	theirs := <-accepted
+	for _, conn := range []net.Conn{dialed, theirs} {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		t.Cleanup(func() { conn.Close() })
+	}
+	return dialed, theirs
+}
+
+type result struct {
+	conn *Conn
+	err  error
+}
+
+func TestHandshake(t *testing.T) {
+	type TestCase struct {
+		testname      string
+		provide       Method // offered by the initiator
+		allowed       Method // accepted by the receiver
+		skeys         [][20]byte
+		expected      Method
+		expectedError error
+	}
+
+	infoHash := [20]byte{'M', 'S', 'E'}
+	other := [20]byte{'O', 'T', 'H', 'E', 'R'}
+	testcases := []TestCase{
+		{"rc4 preferred", MethodPlaintext | MethodRC4, MethodPlaintext | MethodRC4, [][20]byte{other, infoHash}, MethodRC4, nil},
+		{"rc4 only", MethodRC4, MethodPlaintext | MethodRC4, [][20]byte{infoHash}, MethodRC4, nil},
+		{"plaintext selected", MethodPlaintext | MethodRC4, MethodPlaintext, [][20]byte{infoHash}, MethodPlaintext, nil},
+		{"no common method", MethodRC4, MethodPlaintext, [][20]byte{infoHash}, 0, ErrNoMethod},
+		{"unknown torrent", MethodRC4, MethodRC4, [][20]byte{other}, 0, ErrUnknownSKEY},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			a, b := tcpPair(t)
+			tapA, tapB := &wiretap{Conn: a}, &wiretap{Conn: b}
+
+			initiated := make(chan result, 1)
+			go func() {
+				conn, err := initiate(tapA, infoHash, tc.provide)
+				initiated <- result{conn, err}
+			}()
+			accepted, err := accept(tapB, bufio.NewReader(tapB), tc.skeys, tc.allowed)
+			if err != nil {
+				b.Close() // the initiator waits on a reply that never comes
+			}
+			ours := <-initiated
+
+			if tc.expectedError != nil {
+				if !errors.Is(err, tc.expectedError) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, tc.expectedError)
+				}
+				if ours.err == nil {
+					t.Errorf("Expected an error did not recieve any")
+				}
+				return
+			}
+			if err != nil || ours.err != nil {
+				t.Fatalf("An error was thrown none expected, %v %v", err, ours.err)
+			}
+			if ours.conn.Method() != tc.expected || accepted.Method() != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%s and %s\nWANT:%s\n", ours.conn.Method(), accepted.Method(), tc.expected)
+			}
+
+			// the stream flows both ways
+			message, answer := []byte("\x13BitTorrent protocol hello"), []byte("\x13BitTorrent protocol world")
+			go ours.conn.Write(message)
+			got := make([]byte, len(message))
+			if _, err := io.ReadFull(accepted, got); err != nil || !bytes.Equal(got, message) {
+				t.Errorf("Got and want are not equal\nGOT:%q %v\nWANT:%q\n", got, err, message)
+			}
+			go accepted.Write(answer)
+			if _, err := io.ReadFull(ours.conn, got); err != nil || !bytes.Equal(got, answer) {
+				t.Errorf("Got and want are not equal\nGOT:%q %v\nWANT:%q\n", got, err, answer)
+			}
+
+			// rc4 hides the handshake on the wire, plaintext leaves it readable
+			visible := bytes.Contains(tapA.bytes(), message) && bytes.Contains(tapB.bytes(), answer)
+			if visible != (tc.expected == MethodPlaintext) {
+				t.Errorf("Got and want are not equal\nGOT:visible %v\nWANT:%v\n", visible, tc.expected == MethodPlaintext)
+			}
+		})
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	type TestCase struct {
+		testname      string
+		outgoing      Policy
+		incoming      Policy
+		expected      Method // 0 when the handshake is skipped
+		expectedError error
+	}
+
+	testcases := []TestCase{
+		{"both disabled", PolicyDisabled, PolicyDisabled, 0, nil},
+		{"plaintext accepted", PolicyDisabled, PolicyPrefer, 0, nil},
+		{"plaintext refused", PolicyDisabled, PolicyRequire, 0, ErrPlaintext},
+		{"prefer", PolicyPrefer, PolicyPrefer, MethodRC4, nil},
+		{"require", PolicyRequire, PolicyRequire, MethodRC4, nil},
+		{"require meets prefer", PolicyRequire, PolicyPrefer, MethodRC4, nil},
+		{"prefer meets require", PolicyPrefer, PolicyRequire, MethodRC4, nil},
+	}
+
+	infoHash := [20]byte{'M', 'S', 'E'}
+	handshake := append([]byte("\x13BitTorrent protocol"), make([]byte, 48)...)
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			a, b := tcpPair(t)
+
+			initiated := make(chan result, 1)
+			go func() {
+				conn, err := Initiate(a, infoHash, tc.outgoing)
+				if err == nil {
+					_, err = conn.Write(handshake)
+				}
+				initiated <- result{conn, err}
+			}()
+			accepted, err := Accept(b, [][20]byte{infoHash}, tc.incoming)
+			ours := <-initiated
+
+			if tc.expectedError != nil {
+				if !errors.Is(err, tc.expectedError) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, tc.expectedError)
+				}
+				return
+			}
+			if err != nil || ours.err != nil {
+				t.Fatalf("An error was thrown none expected, %v %v", err, ours.err)
+			}
+			if accepted.Method() != tc.expected || ours.conn.Method() != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%s and %s\nWANT:%s\n", ours.conn.Method(), accepted.Method(), tc.expected)
+			}
+
+			// the bitTorrent handshake reads the same whatever happened beneath it
+			got := make([]byte, len(handshake))
+			if _, err := io.ReadFull(accepted, got); err != nil || !bytes.Equal(got, handshake) {
+				t.Errorf("Got and want are not equal\nGOT:%q %v\nWANT:%q\n", got, err, handshake)
+			}
+		})
+	}
+}
+
+func TestInitialPayload(t *testing.T) {
+	// we never send an initial payload but peers may, it is read before the stream
+	a, b := tcpPair(t)
+	infoHash := [20]byte{'I', 'A'}
+
+	go func() {
+		keys, _ := newKeyPair()
+		a.Write(keys.public[:])
+		yb := make([]byte, keySize)
+		io.ReadFull(a, yb)
+		s, _ := keys.secret(yb)
+
+		enc := newCipher("keyA", s[:], infoHash)
+		req1, req2, req3 := hash([]byte("req1"), s[:]), hash([]byte("req2"), infoHash[:]), hash([]byte("req3"), s[:])
+		for i := range req2 {
+			req2[i] ^= req3[i]
+		}
+		// VC, crypto_provide, len(PadC), PadC, len(IA), IA then the stream
+		rest := append(vc[:], 0, 0, 0, byte(MethodRC4), 0, 3, 'p', 'a', 'd', 0, 5)
+		rest = append(rest, "first"...)
+		rest = append(rest, "second"...)
+		enc.XORKeyStream(rest, rest)
+		a.Write(append(append(req1[:], req2[:]...), rest...))
+	}()
+
+	accepted, err := Accept(b, [][20]byte{infoHash}, PolicyRequire)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	got := make([]byte, len("firstsecond"))
+	if _, err := io.ReadFull(accepted, got); err != nil || string(got) != "firstsecond" {
+		t.Errorf("Got and want are not equal\nGOT:%q %v\nWANT:firstsecond\n", got, err)
+	}
+}
+
+func TestKeyExchange(t *testing.T) {
+	a, _ := newKeyPair()
+	b, _ := newKeyPair()
+	sa, errA := a.secret(b.public[:])
+	sb, errB := b.secret(a.public[:])
+	if errA != nil || errB != nil || sa != sb {
+		t.Errorf("Got and want are not equal\nGOT:%x\nWANT:%x\n", sa, sb)
+	}
+
+	// trivial public keys would give a known secret
+	for _, public := range [][]byte{{0}, {1}, new(big.Int).Sub(prime, big.NewInt(1)).Bytes(), prime.Bytes()} {
+		if _, err := a.secret(public); !errors.Is(err, ErrHandshake) {
+			t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrHandshake)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// Package pex implements peer exchange (ut_pex, BEP 11), connected peers tell each other who else
+// they are connected to so a swarm can be found with few or no working trackers
+package pex
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+	extension "github.com/firozt/go-torrent/src/internal/Extension"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// ============ Struct Defs  ============ //
+
+// Name is the key of the extension in the extended handshake
+const Name = "ut_pex"
+
+// flags sent alongside every added peer
+const (
+	FlagEncryption byte = 0x01 // prefers encrypted connections
+	FlagSeed       byte = 0x02 // has every piece
+	FlagUTP        byte = 0x04 // accepts uTP connections
+	FlagHolepunch  byte = 0x08
+	FlagReachable  byte = 0x10 // we connected to it, so it accepts incoming connections
+)
+
+// Config holds the tunables of PEX
+type Config struct {
+	Interval    time.Duration // between messages sent to a peer, the spec asks for no more than one a minute
+	MinInterval time.Duration // messages a peer sends sooner than this after its last are ignored
+	MaxPeers    int           // added and dropped peers per message, larger messages are ignored
+}
+
+func DefaultConfig() Config {
+	return Config{Interval: time.Minute, MinInterval: 30 * time.Second, MaxPeers: 50}
+}
+
+// Found is a peer learnt through PEX
+type Found struct {
+	Peer  peers.Peer
+	Flags byte
+}
+
+/*
+PEX is the ut_pex extension of a single torrent. Connections are made known to it through
+Connected, or through the listen port in the extended handshake for peers that connected to us,
+and every Interval each peer supporting ut_pex is sent the connections added and dropped since
+its last message. Peers received from others are handed to onFound
+*/
+type PEX struct {
+	config    Config
+	numPieces int
+	onFound   func([]Found)
+
+	mu    sync.Mutex
+	live  map[*peers.PeerConn]liveConn
+	peers map[*extension.Peer]*peerState
+}
+
+// liveConn is a connection we advertise
+type liveConn struct {
+	addr  peers.Peer // where the peer accepts connections
+	flags byte
+}
+
+// peerState is what a ut_pex peer knows from us and when it last sent to us
+type peerState struct {
+	sent     map[string]peers.Peer // keyed by address
+	received time.Time
+}
+
+// message is the bencoded payload, compact peers of 6 bytes for ipv4 and 18 for ipv6 with one flags byte each
+type message struct {
+	Added    []byte `bencode:"added"`
+	AddedF   []byte `bencode:"added.f"`
+	Added6   []byte `bencode:"added6"`
+	Added6F  []byte `bencode:"added6.f"`
+	Dropped  []byte `bencode:"dropped"`
+	Dropped6 []byte `bencode:"dropped6"`
+}
+
+var (
+	// ErrRateLimited occurs when a peer sends messages faster than MinInterval
+	ErrRateLimited = fmt.Errorf("pex message too soon")
+	// ErrInvalidMessage occurs when a message cannot be decoded or lists too many peers
+	ErrInvalidMessage = fmt.Errorf("invalid pex message")
+)
+
+// ============ Method Defs  ============ //
+
+// New creates the extension for a torrent of numPieces pieces, onFound must not block
+func New(config Config, numPieces int, onFound func([]Found)) *PEX {
+	return &PEX{
+		config:    config,
+		numPieces: numPieces,
+		onFound:   onFound,
+		live:      map[*peers.PeerConn]liveConn{},
+		peers:     map[*extension.Peer]*peerState{},
+	}
+}
+
+func (x *PEX) Name() string {
+	return Name
+}
+
+// Connected advertises a connection, addr is where the peer accepts connections. It is dropped
+// again once the connection closes
+func (x *PEX) Connected(conn *peers.PeerConn, addr peers.Peer, flags byte) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	entry := x.live[conn]
+	x.live[conn] = liveConn{addr: addr, flags: entry.flags | flags}
+}
+
+// Added learns the listen port of peers that connected to us from their extended handshake
+func (x *PEX) Added(peer *extension.Peer) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.peers[peer] = &peerState{sent: map[string]peers.Peer{}}
+	if _, ok := x.live[peer.Conn]; ok {
+		return
+	}
+	h := peer.Handshake()
+	if h == nil || h.P == 0 {
+		return
+	}
+	switch addr := peer.Conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		x.live[peer.Conn] = liveConn{addr: peers.NewPeer(addr.IP, h.P)}
+	case *net.UDPAddr:
+		x.live[peer.Conn] = liveConn{addr: peers.NewPeer(addr.IP, h.P), flags: FlagUTP}
+	}
+}
+
+func (x *PEX) Removed(peer *extension.Peer) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.peers, peer)
+}
+
+// Message ingests the peers another peer is connected to
+func (x *PEX) Message(peer *extension.Peer, payload []byte) error {
+	x.mu.Lock()
+	state, ok := x.peers[peer]
+	if !ok {
+		x.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if !state.received.IsZero() && now.Sub(state.received) < x.config.MinInterval {
+		x.mu.Unlock()
+		return ErrRateLimited
+	}
+	state.received = now
+
+	// peers we are connected to already are left out
+	connected := map[string]bool{}
+	for _, entry := range x.live {
+		connected[entry.addr.Address()] = true
+	}
+	x.mu.Unlock()
+
+	found, err := x.decode(payload)
+	if err != nil {
+		return err
+	}
+
+	res := make([]Found, 0, len(found))
+	for _, f := range found {
+		if !connected[f.Peer.Address()] {
+			connected[f.Peer.Address()] = true
+			res = append(res, f)
+		}
+	}
+	if len(res) > 0 {
+		x.onFound(res)
+	}
+	return nil
+}
+
+// decode returns the valid added peers of a message
+func (x *PEX) decode(payload []byte) ([]Found, error) {
+	ir, err := bencodeparser.DecodeBytes(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrInvalidMessage, err)
+	}
+	dict, ok := ir.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w - not a dictionary", ErrInvalidMessage)
+	}
+
+	added, _ := dict["added"].(string)
+	addedF, _ := dict["added.f"].(string)
+	added6, _ := dict["added6"].(string)
+	added6F, _ := dict["added6.f"].(string)
+
+	v4, err := peers.MakePeer([]byte(added))
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrInvalidMessage, err)
+	}
+	v6, err := peers.MakePeer6([]byte(added6))
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrInvalidMessage, err)
+	}
+	if len(v4) > x.config.MaxPeers || len(v6) > x.config.MaxPeers {
+		return nil, fmt.Errorf("%w - %d ipv4 and %d ipv6 peers added", ErrInvalidMessage, len(v4), len(v6))
+	}
+
+	res := []Found{}
+	for _, list := range []struct {
+		peers []peers.Peer
+		flags string
+	}{{v4, addedF}, {v6, added6F}} {
+		for i, peer := range list.peers {
+			if !valid(peer) {
+				continue
+			}
+			f := Found{Peer: peer}
+			// flags are optional, a list of the wrong length is ignored
+			if len(list.flags) == len(list.peers) {
+				f.Flags = list.flags[i]
+			}
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
+
+// valid rejects addresses no peer can be reached at
+func valid(peer peers.Peer) bool {
+	ip := peer.IP()
+	return peer.Port() != 0 && !ip.IsUnspecified() && !ip.IsMulticast() && !ip.Equal(net.IPv4bcast)
+}
+
+// Run sends every Interval until ctx is cancelled
+func (x *PEX) Run(ctx context.Context) {
+	ticker := time.NewTicker(x.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			x.send()
+		}
+	}
+}
+
+// send tells every ut_pex peer about connections added and dropped since its last message
+func (x *PEX) send() {
+	for peer, payload := range x.messages() {
+		peer.Send(Name, payload)
+	}
+}
+
+// messages builds the message for every peer that has something to learn, sending is left to
+// the caller so a full send queue never holds the lock
+func (x *PEX) messages() map[*extension.Peer][]byte {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	current := map[string]liveConn{}
+	for conn, entry := range x.live {
+		select {
+		case <-conn.Done():
+			delete(x.live, conn)
+			continue
+		default:
+		}
+		if x.numPieces > 0 && conn.Bitfield().Count() == x.numPieces {
+			entry.flags |= FlagSeed
+		}
+		current[entry.addr.Address()] = entry
+	}
+
+	res := map[*extension.Peer][]byte{}
+	for peer, state := range x.peers {
+		self := x.live[peer.Conn].addr.Address()
+
+		var msg message
+		added, dropped := 0, 0
+		for address, entry := range current {
+			if _, ok := state.sent[address]; ok || address == self || added >= x.config.MaxPeers {
+				continue
+			}
+			if entry.addr.IP().To4() != nil {
+				msg.Added = append(msg.Added, entry.addr.Compact()...)
+				msg.AddedF = append(msg.AddedF, entry.flags)
+			} else {
+				msg.Added6 = append(msg.Added6, entry.addr.Compact()...)
+				msg.Added6F = append(msg.Added6F, entry.flags)
+			}
+			state.sent[address] = entry.addr
+			added++
+		}
+		for address, addr := range state.sent {
+			if _, ok := current[address]; ok || dropped >= x.config.MaxPeers {
+				continue
+			}
+			if addr.IP().To4() != nil {
+				msg.Dropped = append(msg.Dropped, addr.Compact()...)
+			} else {
+				msg.Dropped6 = append(msg.Dropped6, addr.Compact()...)
+			}
+			delete(state.sent, address)
+			dropped++
+		}
+
+		if added+dropped == 0 {
+			continue
+		}
+		if payload, err := bencodeparser.Marshal(msg); err == nil {
+			res[peer] = payload
+		}
+	}
+	return res
+}
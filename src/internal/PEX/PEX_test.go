@@ -0,0 +1,197 @@
+package pex
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+	extension "github.com/firozt/go-torrent/src/internal/Extension"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+func compact(list ...peers.Peer) []byte {
+	res := []byte{}
+	for _, peer := range list {
+		res = append(res, peer.Compact()...)
+	}
+	return res
+}
+
+func encode(t *testing.T, msg message) []byte {
+	t.Helper()
+	payload, err := bencodeparser.Marshal(msg)
+	if err != nil {
+		t.Fatalf("DEV ERR: unable to encode message, %v", err)
+	}
+	return payload
+}
+
+func TestDecode(t *testing.T) {
+	a := peers.NewPeer(net.ParseIP("10.0.0.1"), 6881)
+	b := peers.NewPeer(net.ParseIP("10.0.0.2"), 51413)
+	v6 := peers.NewPeer(net.ParseIP("2001:db8::1"), 6881)
+
+	tooMany := make([]peers.Peer, 51)
+	for i := range tooMany {
+		tooMany[i] = peers.NewPeer(net.IPv4(10, 0, 1, byte(i)), 6881)
+	}
+
+	type TestCase struct {
+		testname    string
+		input       message
+		expected    []Found
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"ipv4 and ipv6 with flags", message{Added: compact(a, b), AddedF: []byte{FlagSeed, FlagReachable}, Added6: compact(v6), Added6F: []byte{FlagUTP}},
+			[]Found{{a, FlagSeed}, {b, FlagReachable}, {v6, FlagUTP}}, false},
+		{"flags of the wrong length ignored", message{Added: compact(a, b), AddedF: []byte{FlagSeed}}, []Found{{a, 0}, {b, 0}}, false},
+		{"unreachable addresses dropped", message{Added: compact(
+			peers.NewPeer(net.IPv4zero, 6881),
+			peers.NewPeer(net.IPv4(224, 0, 0, 1), 6881),
+			peers.NewPeer(net.IPv4bcast, 6881),
+			peers.NewPeer(net.ParseIP("10.0.0.3"), 0),
+			a,
+		)}, []Found{{a, 0}}, false},
+		{"only dropped", message{Dropped: compact(a)}, []Found{}, false},
+		{"truncated peer", message{Added: compact(a)[:5]}, nil, true},
+		{"more than max peers", message{Added: compact(tooMany...)}, nil, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			x := New(DefaultConfig(), 0, nil)
+			got, err := x.decode(encode(t, tc.input))
+			if tc.throwsError {
+				if !errors.Is(err, ErrInvalidMessage) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidMessage)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, tc.expected)
+			}
+		})
+	}
+
+	if _, err := New(DefaultConfig(), 0, nil).decode([]byte("li1ee")); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidMessage)
+	}
+}
+
+// serve hands extended messages to registry the way the engine does
+func serve(registry *extension.Registry, conn *peers.PeerConn, events chan peers.PeerEvent) {
+	registry.AddPeer(conn)
+	for ev := range events {
+		if ev.Message == nil {
+			registry.RemovePeer(conn)
+			return
+		}
+		if ev.Message.ID == peers.MsgExtended {
+			registry.HandleMessage(conn, ev.Message)
+		}
+	}
+}
+
+// pipe returns both ends of a connection between two peers that support the extension protocol
+func pipe(t *testing.T) (*peers.PeerConn, chan peers.PeerEvent, *peers.PeerConn, chan peers.PeerEvent) {
+	t.Helper()
+	handshake := peers.NewBitTorrentProtocolHandshake([20]byte{1}, [20]byte{2})
+	handshake.SetCapability(peers.CapabilityExtensions)
+
+	a, b := net.Pipe()
+	aEvents, bEvents := make(chan peers.PeerEvent, 16), make(chan peers.PeerEvent, 16)
+	aConn := peers.NewPeerConn(a, handshake, 0, aEvents, peers.DefaultPeerConnConfig())
+	bConn := peers.NewPeerConn(b, handshake, 0, bEvents, peers.DefaultPeerConnConfig())
+	t.Cleanup(func() {
+		aConn.Close()
+		bConn.Close()
+	})
+	return aConn, aEvents, bConn, bEvents
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	found := make(chan []Found, 4)
+	config := DefaultConfig()
+	config.MinInterval = 0
+
+	left := New(config, 0, func([]Found) {})
+	right := New(config, 0, func(f []Found) { found <- f })
+	leftRegistry, rightRegistry := extension.NewRegistry(extension.Config{}), extension.NewRegistry(extension.Config{})
+	leftRegistry.Register(left)
+	rightRegistry.Register(right)
+
+	toRight, toRightEvents, toLeft, toLeftEvents := pipe(t)
+	go serve(leftRegistry, toRight, toRightEvents)
+	go serve(rightRegistry, toLeft, toLeftEvents)
+	waitFor(t, func() bool { return len(leftRegistry.Peers(Name)) == 1 })
+
+	// the left is connected to one more peer the right should learn about
+	other, _, _, _ := pipe(t)
+	otherAddr := peers.NewPeer(net.ParseIP("10.0.0.1"), 6881)
+	left.Connected(other, otherAddr, FlagReachable)
+	left.Connected(toRight, peers.NewPeer(net.ParseIP("10.0.0.2"), 6881), FlagReachable)
+
+	left.send()
+	select {
+	case got := <-found:
+		if expected := []Found{{otherAddr, FlagReachable}}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, expected)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("right never learnt of the other peer")
+	}
+
+	// nothing changed so nothing is sent, once the other peer leaves it is dropped
+	if msgs := left.messages(); len(msgs) != 0 {
+		t.Errorf("Got and want are not equal\nGOT:%d messages\nWANT:0\n", len(msgs))
+	}
+	other.Close()
+	msgs := left.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Got and want are not equal\nGOT:%d messages\nWANT:1\n", len(msgs))
+	}
+	for _, payload := range msgs {
+		ir, _ := bencodeparser.DecodeBytes(payload)
+		dict, _ := ir.(map[string]any)
+		if dropped, _ := dict["dropped"].(string); dropped != string(otherAddr.Compact()) {
+			t.Errorf("Got and want are not equal\nGOT:%x\nWANT:%x\n", dropped, otherAddr.Compact())
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	found := 0
+	x := New(DefaultConfig(), 0, func(f []Found) { found += len(f) })
+	peer := &extension.Peer{}
+	x.peers[peer] = &peerState{sent: map[string]peers.Peer{}}
+
+	payload := encode(t, message{Added: compact(peers.NewPeer(net.ParseIP("10.0.0.1"), 6881))})
+	if err := x.Message(peer, payload); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if err := x.Message(peer, payload); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrRateLimited)
+	}
+	if found != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:1\n", found)
+	}
+}
@@ -0,0 +1,60 @@
+package peers
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Bitfield records which pieces a peer has, the high bit of the first byte is piece 0
+type Bitfield []byte
+
+// MakeBitfield returns an empty bitfield large enough for numPieces
+func MakeBitfield(numPieces int) Bitfield {
+	return make(Bitfield, (numPieces+7)/8)
+}
+
+func (b Bitfield) HasPiece(index int) bool {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(b) {
+		return false
+	}
+	return b[byteIndex]>>(7-uint(index%8))&1 != 0
+}
+
+// SetPiece marks a piece as present, out of range indexes are ignored
+func (b Bitfield) SetPiece(index int) {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(b) {
+		return
+	}
+	b[byteIndex] |= 1 << (7 - uint(index%8))
+}
+
+// Count returns the number of pieces set
+func (b Bitfield) Count() int {
+	res := 0
+	for _, v := range b {
+		res += bits.OnesCount8(v)
+	}
+	return res
+}
+
+// Validate checks the bitfield is sized for numPieces and the spare bits at the end are clear
+func (b Bitfield) Validate(numPieces int) error {
+	if len(b) != (numPieces+7)/8 {
+		return fmt.Errorf("bitfield is %d bytes, expected %d for %d pieces", len(b), (numPieces+7)/8, numPieces)
+	}
+	if spare := len(b)*8 - numPieces; spare > 0 && b[len(b)-1]&(1<<spare-1) != 0 {
+		return fmt.Errorf("bitfield has spare bits set")
+	}
+	return nil
+}
+
+// Intersect returns the pieces set in both bitfields, sized as b
+func (b Bitfield) Intersect(other Bitfield) Bitfield {
+	res := make(Bitfield, len(b))
+	for i := range min(len(b), len(other)) {
+		res[i] = b[i] & other[i]
+	}
+	return res
+}
@@ -0,0 +1,112 @@
+package peers
+
+import (
+	"fmt"
+	"net"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+)
+
+// ============ Struct Defs  ============ //
+
+// ExtendedHandshakeID is the extended message id of the handshake, every other id is negotiated through it
+const ExtendedHandshakeID uint8 = 0
+
+/*
+ExtendedHandshake is the first extended message sent on a connection where both ends set
+CapabilityExtensions, it may be sent again later to enable or disable extensions.
+M maps extension names to the id the sender wants to receive them as, an id of 0 disables it.
+Every other field is optional and left out when zero
+*/
+type ExtendedHandshake struct {
+	M            map[string]int
+	V            string // client name and version
+	P            uint16 // listen port of the sender
+	Reqq         int    // requests the sender queues without dropping
+	YourIP       net.IP // the address the receiver was seen at
+	MetadataSize int    // size of the info dictionary, for ut_metadata
+}
+
+// extendedHandshakeWire is the bencoded form, yourip is a 4 or 16 byte string
+type extendedHandshakeWire struct {
+	M            map[string]int `bencode:"m"`
+	V            string         `bencode:"v,omitempty"`
+	P            uint16         `bencode:"p,omitempty"`
+	Reqq         int            `bencode:"reqq,omitempty"`
+	YourIP       []byte         `bencode:"yourip,omitempty"`
+	MetadataSize int            `bencode:"metadata_size,omitempty"`
+}
+
+// ErrInvalidExtendedHandshake occurs when an extended handshake is not a bencoded dictionary
+var ErrInvalidExtendedHandshake = fmt.Errorf("invalid extended handshake")
+
+// ============ Method Defs  ============ //
+
+// NewExtended carries a message of an extension, payload is id || extension payload
+func NewExtended(id uint8, payload []byte) *Message {
+	return &Message{ID: MsgExtended, Payload: append([]byte{id}, payload...)}
+}
+
+// ParseExtended returns the extended id and payload of an extended message, payload aliases the message
+func ParseExtended(m *Message) (id uint8, payload []byte, err error) {
+	if err := expectID(m, MsgExtended); err != nil {
+		return 0, nil, err
+	}
+	return m.Payload[0], m.Payload[1:], nil
+}
+
+// Message encodes the handshake as an extended message
+func (h *ExtendedHandshake) Message() (*Message, error) {
+	wire := extendedHandshakeWire{M: h.M, V: h.V, P: h.P, Reqq: h.Reqq, MetadataSize: h.MetadataSize}
+	if wire.M == nil {
+		wire.M = map[string]int{}
+	}
+	if ip4 := h.YourIP.To4(); ip4 != nil {
+		wire.YourIP = ip4
+	} else if len(h.YourIP) == net.IPv6len {
+		wire.YourIP = h.YourIP
+	}
+
+	payload, err := bencodeparser.Marshal(wire)
+	if err != nil {
+		return nil, err
+	}
+	return NewExtended(ExtendedHandshakeID, payload), nil
+}
+
+// ParseExtendedHandshake decodes the payload of an extended handshake, unknown keys and mistyped
+// optional fields are ignored as clients send all sorts
+func ParseExtendedHandshake(payload []byte) (*ExtendedHandshake, error) {
+	ir, err := bencodeparser.DecodeBytes(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrInvalidExtendedHandshake, err)
+	}
+	dict, ok := ir.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w - not a dictionary", ErrInvalidExtendedHandshake)
+	}
+
+	h := &ExtendedHandshake{M: map[string]int{}}
+	m, _ := dict["m"].(map[string]any)
+	for name, raw := range m {
+		if id, ok := raw.(int64); ok && id >= 0 && id <= 255 {
+			h.M[name] = int(id)
+		}
+	}
+
+	h.V, _ = dict["v"].(string)
+	if port, ok := dict["p"].(int64); ok && port > 0 && port <= 65535 {
+		h.P = uint16(port)
+	}
+	if reqq, ok := dict["reqq"].(int64); ok && reqq > 0 {
+		h.Reqq = int(reqq)
+	}
+	if ip, ok := dict["yourip"].(string); ok && (len(ip) == net.IPv4len || len(ip) == net.IPv6len) {
+		h.YourIP = net.IP(ip)
+	}
+	if size, ok := dict["metadata_size"].(int64); ok && size > 0 {
+		h.MetadataSize = int(size)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,100 @@
+package peers
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestExtendedHandshake(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    ExtendedHandshake
+		expected ExtendedHandshake
+	}
+
+	testcases := []TestCase{
+		{"empty", ExtendedHandshake{}, ExtendedHandshake{M: map[string]int{}}},
+		{
+			"every field",
+			ExtendedHandshake{M: map[string]int{"ut_pex": 1, "ut_metadata": 2}, V: "go-torrent 0.1", P: 6881, Reqq: 250, YourIP: net.ParseIP("10.0.0.1"), MetadataSize: 31235},
+			ExtendedHandshake{M: map[string]int{"ut_pex": 1, "ut_metadata": 2}, V: "go-torrent 0.1", P: 6881, Reqq: 250, YourIP: net.IP{10, 0, 0, 1}, MetadataSize: 31235},
+		},
+		{
+			"ipv6 and a disabled extension",
+			ExtendedHandshake{M: map[string]int{"ut_pex": 0}, YourIP: net.ParseIP("2001:db8::1")},
+			ExtendedHandshake{M: map[string]int{"ut_pex": 0}, YourIP: net.ParseIP("2001:db8::1")},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			msg, err := tc.input.Message()
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+
+			id, payload, err := ParseExtended(msg)
+			if err != nil || id != ExtendedHandshakeID {
+				t.Fatalf("Got and want are not equal\nGOT:%d %v\nWANT:%d\n", id, err, ExtendedHandshakeID)
+			}
+
+			got, err := ParseExtendedHandshake(payload)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if !reflect.DeepEqual(*got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", *got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseExtendedHandshakeMalformed(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		input       string
+		expected    ExtendedHandshake
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"not bencode", "nope", ExtendedHandshake{}, true},
+		{"not a dictionary", "li1ee", ExtendedHandshake{}, true},
+		{"mistyped fields ignored", "d1:m3:abc1:pi99999e4:reqqi-1e6:yourip3:abce", ExtendedHandshake{M: map[string]int{}}, false},
+		{"out of range ids ignored", "d1:md1:ai300e1:bi3e1:c1:xee", ExtendedHandshake{M: map[string]int{"b": 3}}, false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := ParseExtendedHandshake([]byte(tc.input))
+			if tc.throwsError {
+				if !errors.Is(err, ErrInvalidExtendedHandshake) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidExtendedHandshake)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if !reflect.DeepEqual(*got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", *got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseExtended(t *testing.T) {
+	id, payload, err := ParseExtended(NewExtended(3, []byte("data")))
+	if err != nil || id != 3 || string(payload) != "data" {
+		t.Errorf("Got and want are not equal\nGOT:%d %s %v\nWANT:3 data\n", id, payload, err)
+	}
+
+	if _, _, err := ParseExtended(&Message{ID: MsgExtended}); !errors.Is(err, ErrInvalidMessageLength) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidMessageLength)
+	}
+	if _, _, err := ParseExtended(NewHave(1)); err == nil {
+		t.Errorf("Expected an error did not recieve any")
+	}
+}
@@ -0,0 +1,36 @@
+package peers
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"slices"
+)
+
+// AllowedFastSet generates the k pieces a peer at ip may request while choked, as defined in BEP 6.
+// The set only depends on the /24 of the peer and the info hash so a peer reconnecting from the
+// same network is offered the same pieces. The BEP only defines the set for ipv4, ipv6 peers get none
+func AllowedFastSet(k, numPieces int, infoHash [20]byte, ip net.IP) []uint32 {
+	ip4 := ip.To4()
+	if ip4 == nil || numPieces <= 0 {
+		return nil
+	}
+	k = min(k, numPieces)
+
+	x := make([]byte, 0, 24)
+	x = append(x, ip4[0], ip4[1], ip4[2], 0)
+	x = append(x, infoHash[:]...)
+
+	res := make([]uint32, 0, k)
+	for len(res) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(res) < k; i++ {
+			index := binary.BigEndian.Uint32(x[i*4:]) % uint32(numPieces)
+			if !slices.Contains(res, index) {
+				res = append(res, index)
+			}
+		}
+	}
+	return res
+}
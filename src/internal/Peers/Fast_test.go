@@ -0,0 +1,142 @@
+package peers
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestAllowedFastSet(t *testing.T) {
+	type TestCase struct {
+		testname  string
+		k         int
+		numPieces int
+		ip        net.IP
+		expected  []uint32
+	}
+
+	var infoHash [20]byte
+	for i := range infoHash {
+		infoHash[i] = 0xaa
+	}
+
+	// the first two are the examples given in BEP 6
+	testcases := []TestCase{
+		{"bep 6 k=7", 7, 1313, net.ParseIP("80.4.4.200"), []uint32{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"bep 6 k=9", 9, 1313, net.ParseIP("80.4.4.200"), []uint32{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}},
+		{"same /24", 7, 1313, net.ParseIP("80.4.4.1"), []uint32{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"k above piece count", 10, 3, net.ParseIP("80.4.4.200"), []uint32{2, 0, 1}},
+		{"ipv6", 7, 1313, net.ParseIP("2001:db8::1"), nil},
+		{"no pieces", 7, 0, net.ParseIP("80.4.4.200"), nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got := AllowedFastSet(tc.k, tc.numPieces, infoHash, tc.ip)
+			if tc.expected == nil {
+				if len(got) != 0 {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:empty\n", got)
+				}
+				return
+			}
+			if len(got) != len(tc.expected) {
+				t.Fatalf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, tc.expected)
+			}
+			// only the bep examples fix the order, small sets are compared as sets
+			if tc.numPieces > tc.k && !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, tc.expected)
+			}
+			for _, index := range tc.expected {
+				found := false
+				for _, g := range got {
+					found = found || g == index
+				}
+				if !found {
+					t.Errorf("piece %d missing from %v", index, got)
+				}
+			}
+		})
+	}
+}
+
+// fastPeerConn returns a PeerConn where both ends negotiated the fast extension
+func fastPeerConn(t *testing.T, numPieces int) (*PeerConn, net.Conn, chan PeerEvent) {
+	t.Helper()
+	local, remote := net.Pipe()
+	events := make(chan PeerEvent, 16)
+	handshake := NewBitTorrentProtocolHandshake([20]byte{'I', 'H'}, [20]byte{'R', 'E', 'M', 'O', 'T', 'E'})
+	handshake.SetCapability(CapabilityFast)
+	conn := NewPeerConn(local, handshake, numPieces, events, DefaultPeerConnConfig())
+	t.Cleanup(func() {
+		conn.Close()
+		remote.Close()
+	})
+	return conn, remote, events
+}
+
+func TestPeerConnFast(t *testing.T) {
+	conn, remote, events := fastPeerConn(t, 10)
+	if !conn.Fast() {
+		t.Fatalf("fast extension not negotiated")
+	}
+
+	go WriteMessage(remote, NewHaveAll())
+	nextEvent(t, events)
+	if got := conn.Bitfield(); got.Count() != 10 || got.Validate(10) != nil {
+		t.Errorf("Got and want are not equal\nGOT:%08b\nWANT:every piece\n", got)
+	}
+
+	go WriteMessage(remote, NewHaveNone())
+	nextEvent(t, events)
+	if got := conn.Bitfield(); got.Count() != 0 {
+		t.Errorf("Got and want are not equal\nGOT:%08b\nWANT:no piece\n", got)
+	}
+
+	for _, index := range []uint32{2, 9} {
+		go WriteMessage(remote, NewAllowedFast(index))
+		nextEvent(t, events)
+	}
+	expected := Bitfield{0b00100000, 0b01000000}
+	if got := conn.AllowedFast(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got and want are not equal\nGOT:%08b\nWANT:%08b\n", got, expected)
+	}
+
+	go WriteMessage(remote, NewReject(1, 0, 16384))
+	if ev := nextEvent(t, events); ev.Message == nil || ev.Message.ID != MsgRejectRequest {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:reject request\n", ev)
+	}
+}
+
+func TestPeerConnFastViolation(t *testing.T) {
+	type TestCase struct {
+		testname string
+		fast     bool
+		input    *Message
+	}
+
+	testcases := []TestCase{
+		{"have all not negotiated", false, NewHaveAll()},
+		{"reject not negotiated", false, NewReject(0, 0, 16384)},
+		{"allowed fast not negotiated", false, NewAllowedFast(1)},
+		{"allowed fast out of range", true, NewAllowedFast(10)},
+		{"suggest out of range", true, NewSuggest(10)},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			var remote net.Conn
+			var events chan PeerEvent
+			if tc.fast {
+				_, remote, events = fastPeerConn(t, 10)
+			} else {
+				_, remote, events = pipePeerConn(t, 10, DefaultPeerConnConfig())
+			}
+			go WriteMessage(remote, tc.input)
+
+			ev := nextEvent(t, events)
+			if ev.Message != nil || ev.Err == nil {
+				t.Errorf("expected the connection to close with an error, got %+v", ev)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package peers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ============ Message Defs  ============ //
+
+// MessageID is the single byte following the length prefix of every message bar keep-alive
+type MessageID uint8
+
+const (
+	MsgChoke         MessageID = 0
+	MsgUnchoke       MessageID = 1
+	MsgInterested    MessageID = 2
+	MsgNotInterested MessageID = 3
+	MsgHave          MessageID = 4
+	MsgBitfield      MessageID = 5
+	MsgRequest       MessageID = 6
+	MsgPiece         MessageID = 7
+	MsgCancel        MessageID = 8
+	MsgPort          MessageID = 9
+	// BEP 6, only sent when both ends set CapabilityFast
+	MsgSuggest       MessageID = 13
+	MsgHaveAll       MessageID = 14
+	MsgHaveNone      MessageID = 15
+	MsgRejectRequest MessageID = 16
+	MsgAllowedFast   MessageID = 17
+	MsgExtended      MessageID = 20 // BEP 10, the payload starts with the extended message id
+)
+
+func (id MessageID) String() string {
+	switch id {
+	case MsgChoke:
+		return "choke"
+	case MsgUnchoke:
+		return "unchoke"
+	case MsgInterested:
+		return "interested"
+	case MsgNotInterested:
+		return "not interested"
+	case MsgHave:
+		return "have"
+	case MsgBitfield:
+		return "bitfield"
+	case MsgRequest:
+		return "request"
+	case MsgPiece:
+		return "piece"
+	case MsgCancel:
+		return "cancel"
+	case MsgPort:
+		return "port"
+	case MsgSuggest:
+		return "suggest piece"
+	case MsgHaveAll:
+		return "have all"
+	case MsgHaveNone:
+		return "have none"
+	case MsgRejectRequest:
+		return "reject request"
+	case MsgAllowedFast:
+		return "allowed fast"
+	case MsgExtended:
+		return "extended"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(id))
+	}
+}
+
+// MaxMessageSize caps the length prefix accepted by ReadMessage, large enough for a 16KiB block
+// or the bitfield of a torrent with a million pieces
+const MaxMessageSize = 1 << 20
+
+// ErrMessageTooLarge occurs when a length prefix exceeds MaxMessageSize
+var ErrMessageTooLarge = fmt.Errorf("message exceeds max message size")
+
+// ErrInvalidMessageLength occurs when a payload does not have the length its message id requires
+var ErrInvalidMessageLength = fmt.Errorf("invalid message length")
+
+/*
+Message is a single peer wire message, a nil *Message is a keep-alive
+every message is framed as
+<length prefix 4 bytes big endian><message id 1 byte><payload length-1 bytes>
+a keep-alive is a length prefix of 0 with no id or payload
+*/
+type Message struct {
+	ID      MessageID
+	Payload []byte
+}
+
+// payloadLength gives the exact payload length for fixed size messages, -1 means variable
+func payloadLength(id MessageID) int {
+	switch id {
+	case MsgChoke, MsgUnchoke, MsgInterested, MsgNotInterested, MsgHaveAll, MsgHaveNone:
+		return 0
+	case MsgHave, MsgSuggest, MsgAllowedFast:
+		return 4
+	case MsgRequest, MsgCancel, MsgRejectRequest:
+		return 12
+	case MsgPort:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// Validate checks the payload length is correct for the message id, unknown ids are left to
+// extensions and are only checked by them
+func (m *Message) Validate() error {
+	if m == nil {
+		return nil
+	}
+
+	if want := payloadLength(m.ID); want >= 0 && len(m.Payload) != want {
+		return fmt.Errorf("%w - %s payload must be %d bytes got %d", ErrInvalidMessageLength, m.ID, want, len(m.Payload))
+	}
+
+	// index || begin || block, an empty block is allowed
+	if m.ID == MsgPiece && len(m.Payload) < 8 {
+		return fmt.Errorf("%w - piece payload must be at least 8 bytes got %d", ErrInvalidMessageLength, len(m.Payload))
+	}
+	if m.ID == MsgExtended && len(m.Payload) < 1 {
+		return fmt.Errorf("%w - extended payload must carry an id", ErrInvalidMessageLength)
+	}
+
+	return nil
+}
+
+// Serialize frames the message with its length prefix, nil serializes to a keep-alive
+func (m *Message) Serialize() []byte {
+	if m == nil {
+		return make([]byte, 4)
+	}
+
+	buf := make([]byte, 5+len(m.Payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(m.Payload)))
+	buf[4] = byte(m.ID)
+	copy(buf[5:], m.Payload)
+
+	return buf
+}
+
+func (m *Message) String() string {
+	if m == nil {
+		return "keep-alive"
+	}
+	return fmt.Sprintf("%s [%d bytes]", m.ID, len(m.Payload))
+}
+
+// ReadMessage reads a single framed message from r, a keep-alive returns nil, nil
+// An error will return if the length exceeds MaxMessageSize or does not suit the message id
+func ReadMessage(r io.Reader) (*Message, error) {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length == 0 {
+		return nil, nil
+	}
+	if length > MaxMessageSize {
+		return nil, fmt.Errorf("%w - got %d bytes", ErrMessageTooLarge, length)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	msg := &Message{ID: MessageID(buf[0]), Payload: buf[1:]}
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// WriteMessage validates and writes a single framed message to w, nil writes a keep-alive
+func WriteMessage(w io.Writer, m *Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	if m != nil && 1+len(m.Payload) > MaxMessageSize {
+		return fmt.Errorf("%w - got %d bytes", ErrMessageTooLarge, 1+len(m.Payload))
+	}
+
+	_, err := w.Write(m.Serialize())
+	return err
+}
+
+// ============ Constructors  ============ //
+
+func NewHave(index uint32) *Message {
+	return &Message{ID: MsgHave, Payload: binary.BigEndian.AppendUint32(nil, index)}
+}
+
+func NewBitfield(bitfield []byte) *Message {
+	return &Message{ID: MsgBitfield, Payload: bitfield}
+}
+
+// NewRequest asks for length bytes of a piece starting at begin, payload is index || begin || length
+func NewRequest(index, begin, length uint32) *Message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload, index)
+	binary.BigEndian.PutUint32(payload[4:], begin)
+	binary.BigEndian.PutUint32(payload[8:], length)
+	return &Message{ID: MsgRequest, Payload: payload}
+}
+
+// NewCancel withdraws a request, the payload mirrors the request it cancels
+func NewCancel(index, begin, length uint32) *Message {
+	msg := NewRequest(index, begin, length)
+	msg.ID = MsgCancel
+	return msg
+}
+
+// NewPiece carries a block of data, payload is index || begin || block
+func NewPiece(index, begin uint32, block []byte) *Message {
+	payload := make([]byte, 8, 8+len(block))
+	binary.BigEndian.PutUint32(payload, index)
+	binary.BigEndian.PutUint32(payload[4:], begin)
+	return &Message{ID: MsgPiece, Payload: append(payload, block...)}
+}
+
+// NewPort advertises the port of our DHT node
+func NewPort(port uint16) *Message {
+	return &Message{ID: MsgPort, Payload: binary.BigEndian.AppendUint16(nil, port)}
+}
+
+// NewSuggest hints that the peer should download a piece, usually one we have in cache
+func NewSuggest(index uint32) *Message {
+	return &Message{ID: MsgSuggest, Payload: binary.BigEndian.AppendUint32(nil, index)}
+}
+
+// NewHaveAll replaces the bitfield when we have every piece
+func NewHaveAll() *Message {
+	return &Message{ID: MsgHaveAll}
+}
+
+// NewHaveNone replaces the bitfield when we have no piece
+func NewHaveNone() *Message {
+	return &Message{ID: MsgHaveNone}
+}
+
+// NewReject tells the peer a request will not be answered, the payload mirrors the request it rejects
+func NewReject(index, begin, length uint32) *Message {
+	msg := NewRequest(index, begin, length)
+	msg.ID = MsgRejectRequest
+	return msg
+}
+
+// NewAllowedFast lets the peer request a piece even while we choke it
+func NewAllowedFast(index uint32) *Message {
+	return &Message{ID: MsgAllowedFast, Payload: binary.BigEndian.AppendUint32(nil, index)}
+}
+
+// ============ Parsers  ============ //
+
+func ParseHave(m *Message) (uint32, error) {
+	if err := expectID(m, MsgHave); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(m.Payload), nil
+}
+
+// ParseSuggest returns the piece index of a suggest piece message
+func ParseSuggest(m *Message) (uint32, error) {
+	if err := expectID(m, MsgSuggest); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(m.Payload), nil
+}
+
+// ParseAllowedFast returns the piece index of an allowed fast message
+func ParseAllowedFast(m *Message) (uint32, error) {
+	if err := expectID(m, MsgAllowedFast); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(m.Payload), nil
+}
+
+// ParseRequest returns index, begin and length of a request, cancel or reject request message
+func ParseRequest(m *Message) (index, begin, length uint32, err error) {
+	if m == nil || (m.ID != MsgRequest && m.ID != MsgCancel && m.ID != MsgRejectRequest) {
+		return 0, 0, 0, fmt.Errorf("expected request, cancel or reject message got %s", m)
+	}
+	if err := m.Validate(); err != nil {
+		return 0, 0, 0, err
+	}
+	return binary.BigEndian.Uint32(m.Payload), binary.BigEndian.Uint32(m.Payload[4:]), binary.BigEndian.Uint32(m.Payload[8:]), nil
+}
+
+// ParsePiece returns index, begin and the block of a piece message, block aliases the payload
+func ParsePiece(m *Message) (index, begin uint32, block []byte, err error) {
+	if err := expectID(m, MsgPiece); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint32(m.Payload), binary.BigEndian.Uint32(m.Payload[4:]), m.Payload[8:], nil
+}
+
+func ParsePort(m *Message) (uint16, error) {
+	if err := expectID(m, MsgPort); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(m.Payload), nil
+}
+
+func expectID(m *Message, id MessageID) error {
+	if m == nil || m.ID != id {
+		return fmt.Errorf("expected %s message got %s", id, m)
+	}
+	return m.Validate()
+}
@@ -0,0 +1,163 @@
+package peers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    *Message
+		expected []byte // serialized form
+	}
+
+	testcases := []TestCase{
+		{"keep-alive", nil, []byte{0, 0, 0, 0}},
+		{"choke", &Message{ID: MsgChoke}, []byte{0, 0, 0, 1, 0}},
+		{"unchoke", &Message{ID: MsgUnchoke}, []byte{0, 0, 0, 1, 1}},
+		{"interested", &Message{ID: MsgInterested}, []byte{0, 0, 0, 1, 2}},
+		{"not interested", &Message{ID: MsgNotInterested}, []byte{0, 0, 0, 1, 3}},
+		{"have", NewHave(0x01020304), []byte{0, 0, 0, 5, 4, 1, 2, 3, 4}},
+		{"bitfield", NewBitfield([]byte{0xf0, 0x01}), []byte{0, 0, 0, 3, 5, 0xf0, 0x01}},
+		{"request", NewRequest(1, 0x4000, 0x4000), []byte{0, 0, 0, 13, 6, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0x40, 0}},
+		{"piece", NewPiece(2, 8, []byte("abc")), []byte{0, 0, 0, 12, 7, 0, 0, 0, 2, 0, 0, 0, 8, 'a', 'b', 'c'}},
+		{"cancel", NewCancel(1, 0x4000, 0x4000), []byte{0, 0, 0, 13, 8, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0x40, 0}},
+		{"port", NewPort(6881), []byte{0, 0, 0, 3, 9, 0x1a, 0xe1}},
+		{"suggest piece", NewSuggest(7), []byte{0, 0, 0, 5, 13, 0, 0, 0, 7}},
+		{"have all", NewHaveAll(), []byte{0, 0, 0, 1, 14}},
+		{"have none", NewHaveNone(), []byte{0, 0, 0, 1, 15}},
+		{"reject request", NewReject(1, 0x4000, 0x4000), []byte{0, 0, 0, 13, 16, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0x40, 0}},
+		{"allowed fast", NewAllowedFast(0x0102), []byte{0, 0, 0, 5, 17, 0, 0, 1, 2}},
+		{"unknown id passes through", &Message{ID: 20, Payload: []byte{0, 'd', 'e'}}, []byte{0, 0, 0, 4, 20, 0, 'd', 'e'}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := WriteMessage(buf, tc.input); err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", buf.Bytes(), tc.expected)
+			}
+
+			got, err := ReadMessage(buf)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if tc.input == nil {
+				if got != nil {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:keep-alive\n", got)
+				}
+				return
+			}
+			if got.ID != tc.input.ID || !bytes.Equal(got.Payload, tc.input.Payload) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, tc.input)
+			}
+		})
+	}
+}
+
+func TestReadMessageMalformed(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    []byte
+		expected error // nil only checks that an error was returned
+	}
+
+	testcases := []TestCase{
+		{"empty input", []byte{}, io.EOF},
+		{"partial length", []byte{0, 0}, io.ErrUnexpectedEOF},
+		{"truncated payload", []byte{0, 0, 0, 5, 4, 0}, io.ErrUnexpectedEOF},
+		{"length without id", []byte{0, 0, 0, 5}, io.ErrUnexpectedEOF},
+		{"too large", []byte{0, 0x20, 0, 0, 7}, ErrMessageTooLarge},
+		{"choke with payload", []byte{0, 0, 0, 2, 0, 1}, ErrInvalidMessageLength},
+		{"short have", []byte{0, 0, 0, 4, 4, 0, 0, 1}, ErrInvalidMessageLength},
+		{"long request", []byte{0, 0, 0, 14, 6, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0x40, 0, 0}, ErrInvalidMessageLength},
+		{"short piece", []byte{0, 0, 0, 5, 7, 0, 0, 0, 1}, ErrInvalidMessageLength},
+		{"short port", []byte{0, 0, 0, 2, 9, 1}, ErrInvalidMessageLength},
+		{"have all with payload", []byte{0, 0, 0, 2, 14, 1}, ErrInvalidMessageLength},
+		{"short reject", []byte{0, 0, 0, 5, 16, 0, 0, 0, 1}, ErrInvalidMessageLength},
+		{"short allowed fast", []byte{0, 0, 0, 3, 17, 0, 1}, ErrInvalidMessageLength},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := ReadMessage(bytes.NewReader(tc.input))
+			if err == nil {
+				t.Fatalf("Expected an error did not recieve any, got %v", got)
+			}
+			if tc.expected != nil && !errors.Is(err, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestWriteMessageInvalid(t *testing.T) {
+	testcases := map[string]*Message{
+		"interested with payload": {ID: MsgInterested, Payload: []byte{1}},
+		"short cancel":            {ID: MsgCancel, Payload: []byte{0, 0, 0, 1}},
+		"too large":               NewBitfield(make([]byte, MaxMessageSize)),
+	}
+
+	for testname, input := range testcases {
+		t.Run(testname, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := WriteMessage(buf, input); err == nil {
+				t.Errorf("Expected an error did not recieve any")
+			}
+			if buf.Len() != 0 {
+				t.Errorf("invalid message was written - %d bytes", buf.Len())
+			}
+		})
+	}
+}
+
+func TestParseMessages(t *testing.T) {
+	index, err := ParseHave(NewHave(42))
+	if err != nil || index != 42 {
+		t.Errorf("Got and want are not equal\nGOT:%d %v\nWANT:42\n", index, err)
+	}
+
+	index, begin, length, err := ParseRequest(NewCancel(1, 2, 3))
+	if err != nil || !reflect.DeepEqual([]uint32{index, begin, length}, []uint32{1, 2, 3}) {
+		t.Errorf("Got and want are not equal\nGOT:%d %d %d %v\nWANT:1 2 3\n", index, begin, length, err)
+	}
+
+	index, begin, block, err := ParsePiece(NewPiece(4, 5, []byte("block")))
+	if err != nil || index != 4 || begin != 5 || string(block) != "block" {
+		t.Errorf("Got and want are not equal\nGOT:%d %d %s %v\nWANT:4 5 block\n", index, begin, block, err)
+	}
+
+	index, begin, length, err = ParseRequest(NewReject(6, 7, 8))
+	if err != nil || !reflect.DeepEqual([]uint32{index, begin, length}, []uint32{6, 7, 8}) {
+		t.Errorf("Got and want are not equal\nGOT:%d %d %d %v\nWANT:6 7 8\n", index, begin, length, err)
+	}
+
+	index, err = ParseSuggest(NewSuggest(9))
+	if err != nil || index != 9 {
+		t.Errorf("Got and want are not equal\nGOT:%d %v\nWANT:9\n", index, err)
+	}
+
+	index, err = ParseAllowedFast(NewAllowedFast(10))
+	if err != nil || index != 10 {
+		t.Errorf("Got and want are not equal\nGOT:%d %v\nWANT:10\n", index, err)
+	}
+
+	port, err := ParsePort(NewPort(6881))
+	if err != nil || port != 6881 {
+		t.Errorf("Got and want are not equal\nGOT:%d %v\nWANT:6881\n", port, err)
+	}
+
+	if _, err := ParseHave(NewPort(1)); err == nil {
+		t.Errorf("Expected an error did not recieve any")
+	}
+	if _, _, _, err := ParseRequest(nil); err == nil {
+		t.Errorf("Expected an error did not recieve any")
+	}
+}
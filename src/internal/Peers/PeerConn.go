@@ -0,0 +1,454 @@
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ============ Struct Defs  ============ //
+
+// PeerConnConfig holds the timeouts used by a PeerConn
+type PeerConnConfig struct {
+	KeepAliveInterval time.Duration // a keep-alive is sent when nothing else was written for this long
+	IdleTimeout       time.Duration // the connection is dropped when nothing was heard for this long
+	WriteTimeout      time.Duration
+	SendQueue         int  // piece messages buffered before Send blocks
+	ControlQueue      int  // other messages buffered, a peer that lets them overflow is disconnected
+	Fast              bool // we set CapabilityFast in our handshake, the fast extension is used when the peer set it too
+}
+
+// DefaultPeerConnConfig keeps inside the spec, peers may drop connections silent for two minutes
+func DefaultPeerConnConfig() PeerConnConfig {
+	return PeerConnConfig{
+		KeepAliveInterval: 90 * time.Second,
+		IdleTimeout:       3 * time.Minute,
+		WriteTimeout:      30 * time.Second,
+		SendQueue:         64,
+		ControlQueue:      256,
+		Fast:              true,
+	}
+}
+
+// PeerEvent is delivered for every message received from a peer, once the connection has
+// closed a final event with a nil Message is delivered carrying the reason in Err
+type PeerEvent struct {
+	Conn    *PeerConn
+	Message *Message
+	Err     error // nil when closed locally
+}
+
+// PeerState is a snapshot of the choke / interest state of both ends of a connection
+type PeerState struct {
+	AmChoking      bool
+	AmInterested   bool
+	PeerChoking    bool
+	PeerInterested bool
+}
+
+// ErrConnClosed occurs when sending on a connection that has been closed
+var ErrConnClosed = fmt.Errorf("peer connection closed")
+
+// ErrIdleTimeout is the close reason of a connection the peer went silent on
+var ErrIdleTimeout = fmt.Errorf("peer connection idle")
+
+// ErrSendQueueFull is the close reason of a connection that could not keep up with the messages sent to it
+var ErrSendQueueFull = fmt.Errorf("peer connection send queue full")
+
+/*
+PeerConn owns a connection after a successful handshake, a reader goroutine applies incoming
+messages to the connection state before handing them to the events channel and a writer
+goroutine drains the send queues, sending keep-alives when idle. Piece messages have their own
+queue so the small messages sent from the download engine never wait behind block data
+*/
+type PeerConn struct {
+	conn      net.Conn
+	remote    PeerHandshake
+	client    Client // decoded from the peer id
+	numPieces int    // 0 skips bitfield / have validation
+	config    PeerConnConfig
+	events    chan<- PeerEvent
+	control   chan *Message // every message but pieces, written first
+	data      chan *Message // piece messages
+
+	mu          sync.Mutex
+	state       PeerState
+	bitfield    Bitfield
+	allowedFast Bitfield // pieces the peer lets us request while it chokes us
+	lastSeen    time.Time
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	err       error
+}
+
+// ============ Handshake  ============ //
+
+// ReadHandshake reads and validates the 68 byte handshake from r
+func ReadHandshake(r io.Reader) (*PeerHandshake, error) {
+	var raw [68]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return nil, err
+	}
+	return DeserializePeerHandshake(raw)
+}
+
+// Handshake sends ours and reads the peers handshake, the peer must answer for the same info hash
+func Handshake(conn net.Conn, ours *PeerHandshake, timeout time.Duration) (*PeerHandshake, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(ours.SerializePeerHandshake()); err != nil {
+		return nil, err
+	}
+
+	theirs, err := ReadHandshake(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if theirs.InfoHash != ours.InfoHash {
+		return nil, fmt.Errorf("the infohash returned in the handshake are not equivilant, expected %x, got %x", ours.InfoHash, theirs.InfoHash)
+	}
+
+	return theirs, nil
+}
+
+// ============ Method Defs  ============ //
+
+// NewPeerConn takes ownership of conn, remote is the handshake the peer sent. Both ends start
+// choked and not interested as per the spec
+func NewPeerConn(conn net.Conn, remote *PeerHandshake, numPieces int, events chan<- PeerEvent, config PeerConnConfig) *PeerConn {
+	c := &PeerConn{
+		conn:        conn,
+		remote:      *remote,
+		numPieces:   numPieces,
+		config:      config,
+		events:      events,
+		control:     make(chan *Message, config.ControlQueue),
+		data:        make(chan *Message, config.SendQueue),
+		state:       PeerState{AmChoking: true, PeerChoking: true},
+		bitfield:    MakeBitfield(numPieces),
+		allowedFast: MakeBitfield(numPieces),
+		lastSeen:    time.Now(),
+		closed:      make(chan struct{}),
+	}
+
+	c.client, _ = ParseClient(remote.PeerID)
+
+	go c.readLoop()
+	go c.writeLoop()
+
+	return c
+}
+
+func (c *PeerConn) PeerID() [20]byte {
+	return c.remote.PeerID
+}
+
+// Client is the software the peer runs as told by its peer id, the name is empty when it is not recognised
+func (c *PeerConn) Client() Client {
+	return c.client
+}
+
+// String identifies the peer in logs by its address and client
+func (c *PeerConn) String() string {
+	return fmt.Sprintf("%s (%s)", c.conn.RemoteAddr(), c.client)
+}
+
+// Reserved returns the reserved bytes of the peers handshake, used to negotiate extensions
+func (c *PeerConn) Reserved() [8]byte {
+	return c.remote.Reserved
+}
+
+// Supports reports whether the peer advertised a capability in its handshake
+func (c *PeerConn) Supports(capability Capability) bool {
+	return c.remote.Supports(capability)
+}
+
+// Fast reports whether both ends negotiated the fast extension, without it the fast messages are a protocol violation
+func (c *PeerConn) Fast() bool {
+	return c.config.Fast && c.remote.Supports(CapabilityFast)
+}
+
+func (c *PeerConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *PeerConn) State() PeerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Bitfield returns a copy of the pieces the peer has announced
+func (c *PeerConn) Bitfield() Bitfield {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(Bitfield{}, c.bitfield...)
+}
+
+// AllowedFast returns a copy of the pieces the peer allows us to request while it chokes us
+func (c *PeerConn) AllowedFast() Bitfield {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(Bitfield{}, c.allowedFast...)
+}
+
+func (c *PeerConn) HasPiece(index int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bitfield.HasPiece(index)
+}
+
+// LastSeen is when a message, including keep-alives, was last received
+func (c *PeerConn) LastSeen() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastSeen
+}
+
+// Send queues a message for the writer. Piece messages block while their queue is full, every other message
+// is queued without blocking and the connection is closed when the peer is too slow to take them
+func (c *PeerConn) Send(msg *Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	// both cases may be ready, never queue on a closed connection
+	select {
+	case <-c.closed:
+		return ErrConnClosed
+	default:
+	}
+
+	if msg != nil && msg.ID == MsgPiece {
+		select {
+		case c.data <- msg:
+			return nil
+		case <-c.closed:
+			return ErrConnClosed
+		}
+	}
+
+	select {
+	case c.control <- msg:
+		return nil
+	default:
+		c.closeWithError(ErrSendQueueFull)
+		return ErrSendQueueFull
+	}
+}
+
+func (c *PeerConn) Choke() error {
+	return c.setAndSend(func(s *PeerState) { s.AmChoking = true }, MsgChoke)
+}
+
+func (c *PeerConn) Unchoke() error {
+	return c.setAndSend(func(s *PeerState) { s.AmChoking = false }, MsgUnchoke)
+}
+
+func (c *PeerConn) Interested() error {
+	return c.setAndSend(func(s *PeerState) { s.AmInterested = true }, MsgInterested)
+}
+
+func (c *PeerConn) NotInterested() error {
+	return c.setAndSend(func(s *PeerState) { s.AmInterested = false }, MsgNotInterested)
+}
+
+// setAndSend updates our side of the state, the message is only sent when the state changed
+func (c *PeerConn) setAndSend(update func(*PeerState), id MessageID) error {
+	c.mu.Lock()
+	before := c.state
+	update(&c.state)
+	changed := before != c.state
+	c.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	return c.Send(&Message{ID: id})
+}
+
+// Done is closed once the connection has closed
+func (c *PeerConn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns why the connection closed, nil while open or when closed locally
+func (c *PeerConn) Err() error {
+	select {
+	case <-c.closed:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (c *PeerConn) Close() error {
+	c.closeWithError(nil)
+	return nil
+}
+
+func (c *PeerConn) closeWithError(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+func (c *PeerConn) readLoop() {
+	var err error
+	defer func() {
+		c.closeWithError(err)
+		// the owner of the events channel must drain it until every connection reported closing
+		c.events <- PeerEvent{Conn: c, Err: c.Err()}
+	}()
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout))
+
+		var msg *Message
+		msg, err = ReadMessage(c.conn)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				err = ErrIdleTimeout
+			}
+			return
+		}
+
+		if err = c.apply(msg); err != nil {
+			return
+		}
+
+		// keep-alives only refresh lastSeen
+		if msg == nil {
+			continue
+		}
+
+		select {
+		case c.events <- PeerEvent{Conn: c, Message: msg}:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// apply updates the connection state from an incoming message, a protocol violation is returned as an error
+func (c *PeerConn) apply(msg *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastSeen = time.Now()
+	if msg == nil {
+		return nil
+	}
+
+	switch msg.ID {
+	case MsgSuggest, MsgHaveAll, MsgHaveNone, MsgRejectRequest, MsgAllowedFast:
+		if !c.Fast() {
+			return fmt.Errorf("peer sent %s without negotiating the fast extension", msg.ID)
+		}
+	}
+
+	switch msg.ID {
+	case MsgChoke:
+		c.state.PeerChoking = true
+	case MsgUnchoke:
+		c.state.PeerChoking = false
+	case MsgInterested:
+		c.state.PeerInterested = true
+	case MsgNotInterested:
+		c.state.PeerInterested = false
+	case MsgHave:
+		index, _ := ParseHave(msg)
+		if err := c.checkIndex(msg.ID, index); err != nil {
+			return err
+		}
+		c.bitfield = c.setPiece(c.bitfield, int(index))
+	case MsgBitfield:
+		if c.numPieces > 0 {
+			if err := Bitfield(msg.Payload).Validate(c.numPieces); err != nil {
+				return err
+			}
+		}
+		c.bitfield = append(Bitfield{}, msg.Payload...)
+	case MsgHaveAll:
+		// with an unknown piece count there is nothing to set
+		c.bitfield = MakeBitfield(c.numPieces)
+		for index := range c.numPieces {
+			c.bitfield.SetPiece(index)
+		}
+	case MsgHaveNone:
+		c.bitfield = MakeBitfield(c.numPieces)
+	case MsgSuggest:
+		index, _ := ParseSuggest(msg)
+		return c.checkIndex(msg.ID, index)
+	case MsgAllowedFast:
+		index, _ := ParseAllowedFast(msg)
+		if err := c.checkIndex(msg.ID, index); err != nil {
+			return err
+		}
+		c.allowedFast = c.setPiece(c.allowedFast, int(index))
+	}
+
+	return nil
+}
+
+// maxUnknownPieces caps the bitfields grown while the piece count is unknown, no bitfield message can describe more
+const maxUnknownPieces = MaxMessageSize * 8
+
+// checkIndex rejects a piece index past the end of the torrent, when the piece count is unknown any index
+// a bitfield message could describe is accepted
+func (c *PeerConn) checkIndex(id MessageID, index uint32) error {
+	if c.numPieces > 0 && int(index) >= c.numPieces {
+		return fmt.Errorf("peer sent %s for piece %d of %d", id, index, c.numPieces)
+	}
+	if c.numPieces == 0 && index >= maxUnknownPieces {
+		return fmt.Errorf("peer sent %s for piece %d, more than a bitfield can hold", id, index)
+	}
+	return nil
+}
+
+// setPiece sets a piece in bf, growing it first when the piece count is unknown
+func (c *PeerConn) setPiece(bf Bitfield, index int) Bitfield {
+	if c.numPieces == 0 && index >= len(bf)*8 {
+		bf = append(bf, make(Bitfield, index/8+1-len(bf))...)
+	}
+	bf.SetPiece(index)
+	return bf
+}
+
+func (c *PeerConn) writeLoop() {
+	// restarted after every write so keep-alives are only sent on a quiet connection
+	keepAlive := time.NewTimer(c.config.KeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		// control messages go out ahead of queued piece data
+		var msg *Message
+		select {
+		case msg = <-c.control:
+		default:
+			select {
+			case msg = <-c.control:
+			case msg = <-c.data:
+			case <-keepAlive.C:
+				msg = nil // keep-alive
+			case <-c.closed:
+				return
+			}
+		}
+
+		c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+		if err := WriteMessage(c.conn, msg); err != nil {
+			c.closeWithError(err)
+			return
+		}
+		keepAlive.Reset(c.config.KeepAliveInterval)
+	}
+}
@@ -0,0 +1,296 @@
+package peers
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pipePeerConn returns a PeerConn over one end of a pipe and the raw remote end
+func pipePeerConn(t *testing.T, numPieces int, config PeerConnConfig) (*PeerConn, net.Conn, chan PeerEvent) {
+	t.Helper()
+	local, remote := net.Pipe()
+	events := make(chan PeerEvent, 16)
+	handshake := NewBitTorrentProtocolHandshake([20]byte{'I', 'H'}, [20]byte{'R', 'E', 'M', 'O', 'T', 'E'})
+	conn := NewPeerConn(local, handshake, numPieces, events, config)
+	t.Cleanup(func() {
+		conn.Close()
+		remote.Close()
+	})
+	return conn, remote, events
+}
+
+func nextEvent(t *testing.T, events chan PeerEvent) PeerEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for peer event")
+		return PeerEvent{}
+	}
+}
+
+func TestPeerConnStateMachine(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    *Message
+		expected PeerState
+	}
+
+	// applied in order, each builds on the last
+	testcases := []TestCase{
+		{"unchoke", &Message{ID: MsgUnchoke}, PeerState{AmChoking: true}},
+		{"interested", &Message{ID: MsgInterested}, PeerState{AmChoking: true, PeerInterested: true}},
+		{"not interested", &Message{ID: MsgNotInterested}, PeerState{AmChoking: true}},
+		{"choke", &Message{ID: MsgChoke}, PeerState{AmChoking: true, PeerChoking: true}},
+	}
+
+	conn, remote, events := pipePeerConn(t, 10, DefaultPeerConnConfig())
+	if got := conn.State(); got != (PeerState{AmChoking: true, PeerChoking: true}) {
+		t.Errorf("connections must start choked and not interested, got %+v", got)
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			go WriteMessage(remote, tc.input)
+			ev := nextEvent(t, events)
+			if ev.Message.ID != tc.input.ID || ev.Conn != conn {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", ev.Message, tc.input)
+			}
+			if got := conn.State(); got != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPeerConnBitfieldAndHave(t *testing.T) {
+	conn, remote, events := pipePeerConn(t, 10, DefaultPeerConnConfig())
+
+	go WriteMessage(remote, NewBitfield([]byte{0b10100000, 0b01000000}))
+	nextEvent(t, events)
+	go WriteMessage(remote, NewHave(3))
+	nextEvent(t, events)
+
+	expected := Bitfield{0b10110000, 0b01000000}
+	if got := conn.Bitfield(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got and want are not equal\nGOT:%08b\nWANT:%08b\n", got, expected)
+	}
+	if !conn.HasPiece(9) || conn.HasPiece(1) {
+		t.Errorf("HasPiece does not match bitfield %08b", conn.Bitfield())
+	}
+}
+
+func TestPeerConnProtocolViolation(t *testing.T) {
+	type TestCase struct {
+		testname  string
+		numPieces int // 0 when unknown
+		input     *Message
+	}
+
+	testcases := []TestCase{
+		{"have out of range", 10, NewHave(10)},
+		{"bitfield wrong size", 10, NewBitfield([]byte{0xff})},
+		{"bitfield spare bits set", 10, NewBitfield([]byte{0xff, 0xff})},
+		{"have past any bitfield", 0, NewHave(MaxMessageSize * 8)},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			conn, remote, events := pipePeerConn(t, tc.numPieces, DefaultPeerConnConfig())
+			go WriteMessage(remote, tc.input)
+
+			ev := nextEvent(t, events)
+			if ev.Message != nil || ev.Err == nil {
+				t.Errorf("expected the connection to close with an error, got %+v", ev)
+			}
+			if conn.Send(&Message{ID: MsgInterested}) != ErrConnClosed {
+				t.Errorf("send succeeded on a closed connection")
+			}
+		})
+	}
+}
+
+func TestPeerConnSend(t *testing.T) {
+	conn, remote, _ := pipePeerConn(t, 10, DefaultPeerConnConfig())
+
+	// repeated state changes are only sent once
+	go func() {
+		conn.Interested()
+		conn.Interested()
+		conn.Unchoke()
+		conn.Send(NewRequest(1, 0, 16384))
+	}()
+
+	expected := []*Message{{ID: MsgInterested}, {ID: MsgUnchoke}, NewRequest(1, 0, 16384)}
+	for _, want := range expected {
+		remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+		got, err := ReadMessage(remote)
+		if err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+		if got.ID != want.ID || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, want)
+		}
+	}
+
+	if got := conn.State(); got != (PeerState{AmInterested: true, PeerChoking: true}) {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:am interested and unchoking\n", got)
+	}
+}
+
+func TestPeerConnSendQueues(t *testing.T) {
+	config := DefaultPeerConnConfig()
+	config.SendQueue = 1
+	config.ControlQueue = 2
+	conn, remote, _ := pipePeerConn(t, 10, config)
+
+	// the remote does not read yet, one piece is being written and one is queued
+	block := make([]byte, 16384)
+	queued := make(chan struct{})
+	go func() {
+		conn.Send(NewPiece(1, 0, block))
+		conn.Send(NewPiece(2, 0, block))
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("piece messages were not queued")
+	}
+
+	// other messages do not wait behind the pieces and are written first
+	if err := conn.Send(NewHave(3)); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	expected := []MessageID{MsgPiece, MsgHave, MsgPiece}
+	for _, want := range expected {
+		remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+		got, err := ReadMessage(remote)
+		if err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+		if got.ID != want {
+			t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got.ID, want)
+		}
+	}
+
+	// a peer that stops reading is dropped rather than blocking the sender
+	go conn.Send(NewPiece(4, 0, block))
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		err = conn.Send(NewHave(uint32(i)))
+	}
+	if !errors.Is(err, ErrSendQueueFull) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrSendQueueFull)
+	}
+	select {
+	case <-conn.Done():
+	case <-time.After(2 * time.Second):
+		t.Errorf("connection is not done after its send queue overflowed")
+	}
+}
+
+func TestPeerConnKeepAliveAndIdle(t *testing.T) {
+	config := DefaultPeerConnConfig()
+	config.KeepAliveInterval = 20 * time.Millisecond
+	config.IdleTimeout = 100 * time.Millisecond
+	conn, remote, events := pipePeerConn(t, 10, config)
+
+	remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, err := ReadMessage(remote)
+	if err != nil || got != nil {
+		t.Fatalf("Got and want are not equal\nGOT:%v %v\nWANT:keep-alive\n", got, err)
+	}
+
+	// keep reading so writes do not block, the remote never speaks so the connection goes idle
+	go func() {
+		for {
+			if _, err := ReadMessage(remote); err != nil {
+				return
+			}
+		}
+	}()
+
+	ev := nextEvent(t, events)
+	if ev.Message != nil || !errors.Is(ev.Err, ErrIdleTimeout) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", ev.Err, ErrIdleTimeout)
+	}
+	select {
+	case <-conn.Done():
+	default:
+		t.Errorf("connection is not done after idle timeout")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		remoteHash  [20]byte
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"matching info hash", [20]byte{'I', 'H'}, false},
+		{"different info hash", [20]byte{'O', 'T', 'H'}, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer local.Close()
+			defer remote.Close()
+
+			go func() {
+				if _, err := ReadHandshake(remote); err != nil {
+					return
+				}
+				remote.Write(NewBitTorrentProtocolHandshake(tc.remoteHash, [20]byte{'R'}).SerializePeerHandshake())
+			}()
+
+			got, err := Handshake(local, NewBitTorrentProtocolHandshake([20]byte{'I', 'H'}, [20]byte{'L'}), 2*time.Second)
+			if tc.throwsError && err == nil {
+				t.Fatalf("Expected an error did not recieve any")
+			}
+			if !tc.throwsError && err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if !tc.throwsError && got.PeerID != [20]byte{'R'} {
+				t.Errorf("Got and want are not equal\nGOT:%s\nWANT:R\n", got.PeerID[:])
+			}
+		})
+	}
+}
+
+func TestBitfieldValidate(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		input       Bitfield
+		numPieces   int
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"exact bytes", Bitfield{0xff}, 8, false},
+		{"spare bits clear", Bitfield{0xff, 0b11000000}, 10, false},
+		{"spare bits set", Bitfield{0xff, 0b11100000}, 10, true},
+		{"too short", Bitfield{0xff}, 10, true},
+		{"too long", Bitfield{0xff, 0, 0}, 10, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			err := tc.input.Validate(tc.numPieces)
+			if tc.throwsError && err == nil {
+				t.Errorf("Expected an error did not recieve any")
+			}
+			if !tc.throwsError && err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+			}
+		})
+	}
+}
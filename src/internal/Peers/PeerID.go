@@ -0,0 +1,172 @@
+package peers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ============ Struct Defs  ============ //
+
+// Client is the software a peer runs as told by its peer id
+type Client struct {
+	Name    string // empty when the peer id follows no known convention
+	Version string
+}
+
+// ErrInvalidClientID occurs when a client code or version cannot be written into an Azureus style peer id
+var ErrInvalidClientID = fmt.Errorf("invalid client id")
+
+/*
+azureusClients are the common client codes of Azureus style peer ids, '-' two character client code
+four character version '-' followed by random bytes, such as -TR2940-. Codes missing here are reported
+as the code itself
+*/
+var azureusClients = map[string]string{
+	"AG": "Ares",
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BI": "BiglyBT",
+	"BT": "BitTorrent",
+	"CD": "Enhanced CTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"FW": "FrostWire",
+	"GT": "go-torrent",
+	"HL": "Halite",
+	"KG": "KGet",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "libTorrent",
+	"MO": "MonoTorrent",
+	"PI": "PicoTorrent",
+	"qB": "qBittorrent",
+	"QD": "QQDownload",
+	"SD": "Thunder",
+	"SZ": "Shareaza",
+	"TL": "Tribler",
+	"TR": "Transmission",
+	"UM": "µTorrent Mac",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"WD": "WebTorrent Desktop",
+	"WW": "WebTorrent",
+	"XL": "Xunlei",
+}
+
+// shadowClients are the clients of Shadow style peer ids, a client character followed by up to five
+// version characters and at least two '-', such as S58B-----
+var shadowClients = map[byte]string{
+	'A': "ABC",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow",
+	'T': "BitTornado",
+	'U': "UPnP NAT Bit Torrent",
+}
+
+// every version character of a Shadow style peer id stands for its index
+const shadowAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz.-"
+
+// ============ Method Defs  ============ //
+
+func (c Client) String() string {
+	if c.Name == "" {
+		return "unknown"
+	}
+	if c.Version == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Version
+}
+
+// NewPeerID generates an Azureus style peer id, code is two alphanumeric characters naming the client
+// and version four alphanumeric characters, the remaining 12 bytes are random
+func NewPeerID(code, version string) ([20]byte, error) {
+	var id [20]byte
+	if len(code) != 2 || len(version) != 4 || !alphanumeric(code) || !alphanumeric(version) {
+		return id, fmt.Errorf("%w - code %q version %q", ErrInvalidClientID, code, version)
+	}
+
+	copy(id[:], "-"+code+version+"-")
+	if _, err := rand.Read(id[8:]); err != nil {
+		return id, err
+	}
+	return id, nil
+}
+
+// ParseClient decodes the client of Azureus and Shadow style peer ids, ok is false for other peer ids
+func ParseClient(id [20]byte) (client Client, ok bool) {
+	if client, ok := parseAzureus(id); ok {
+		return client, true
+	}
+	return parseShadow(id)
+}
+
+func parseAzureus(id [20]byte) (Client, bool) {
+	if id[0] != '-' || id[7] != '-' || !alphanumeric(string(id[1:7])) {
+		return Client{}, false
+	}
+
+	code, version := string(id[1:3]), string(id[3:7])
+	name, ok := azureusClients[code]
+	if !ok {
+		name = code
+	}
+
+	switch code {
+	case "TR":
+		// major then a two digit minor, 2940 is 2.94
+		return Client{name, version[:1] + "." + version[1:3]}, true
+	case "UT", "UM", "UW", "BT":
+		// the last character is the kind of release, not part of the version
+		version = version[:3]
+	}
+
+	var parts []string
+	for _, c := range version {
+		switch {
+		case c >= '0' && c <= '9':
+			parts = append(parts, string(c))
+		case c >= 'A' && c <= 'Z':
+			parts = append(parts, strconv.Itoa(int(c-'A')+10))
+		}
+	}
+	// trailing zero parts are dropped, leaving at least major and minor
+	for len(parts) > 2 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return Client{name, strings.Join(parts, ".")}, true
+}
+
+func parseShadow(id [20]byte) (Client, bool) {
+	name, ok := shadowClients[id[0]]
+	if !ok {
+		return Client{}, false
+	}
+
+	var parts []string
+	end := 1
+	for ; end < 6 && id[end] != '-'; end++ {
+		index := strings.IndexByte(shadowAlphabet, id[end])
+		if index < 0 {
+			return Client{}, false
+		}
+		parts = append(parts, strconv.Itoa(index))
+	}
+	if len(parts) == 0 || id[end] != '-' || id[end+1] != '-' {
+		return Client{}, false
+	}
+	return Client{name, strings.Join(parts, ".")}, true
+}
+
+func alphanumeric(s string) bool {
+	for _, c := range []byte(s) {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package peers
+
+import (
+	"errors"
+	"testing"
+)
+
+func peerID(prefix string) [20]byte {
+	var id [20]byte
+	copy(id[:], prefix)
+	copy(id[len(prefix):], "0123456789abcdefghij")
+	return id
+}
+
+func TestNewPeerID(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		code        string
+		version     string
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"sanity check", "GT", "0100", false},
+		{"lowercase code", "qB", "4250", false},
+		{"code too long", "GTX", "0100", true},
+		{"version too short", "GT", "01", true},
+		{"dash in version", "GT", "01-0", true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			id, err := NewPeerID(tc.code, tc.version)
+			if tc.throwsError {
+				if !errors.Is(err, ErrInvalidClientID) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidClientID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+
+			if prefix := string(id[:8]); prefix != "-"+tc.code+tc.version+"-" {
+				t.Errorf("Got and want are not equal\nGOT:%q\nWANT:%q\n", prefix, "-"+tc.code+tc.version+"-")
+			}
+			if other, _ := NewPeerID(tc.code, tc.version); other == id {
+				t.Errorf("two generated peer ids are equal, %q", id)
+			}
+		})
+	}
+}
+
+func TestParseClient(t *testing.T) {
+	type TestCase struct {
+		testname string
+		id       [20]byte
+		expected Client
+		ok       bool
+	}
+
+	ours, _ := NewPeerID("GT", "0100")
+	testcases := []TestCase{
+		{"our own", ours, Client{"go-torrent", "0.1"}, true},
+		{"transmission", peerID("-TR2940-"), Client{"Transmission", "2.94"}, true},
+		{"utorrent release kind", peerID("-UT355W-"), Client{"µTorrent", "3.5.5"}, true},
+		{"qbittorrent", peerID("-qB4250-"), Client{"qBittorrent", "4.2.5"}, true},
+		{"letters as version numbers", peerID("-DE13F0-"), Client{"Deluge", "1.3.15"}, true},
+		{"libtorrent", peerID("-LT1210-"), Client{"libtorrent", "1.2.1"}, true},
+		{"unknown azureus code", peerID("-ZZ1000-"), Client{"ZZ", "1.0"}, true},
+		{"shadow", peerID("S58B-----"), Client{"Shadow", "5.8.11"}, true},
+		{"bittornado", peerID("T03I--"), Client{"BitTornado", "0.3.18"}, true},
+		{"shadow without padding", peerID("T03I-a"), Client{}, false},
+		{"random", [20]byte{0x9f, 0x13, 0x2d, 0x00, 0x41}, Client{}, false},
+		{"azureus with bad code", peerID("-T!1000-"), Client{}, false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, ok := ParseClient(tc.id)
+			if got != tc.expected || ok != tc.ok {
+				t.Errorf("Got and want are not equal\nGOT:%+v %v\nWANT:%+v %v\n", got, ok, tc.expected, tc.ok)
+			}
+		})
+	}
+
+	if s := (Client{"Transmission", "2.94"}).String(); s != "Transmission 2.94" {
+		t.Errorf("Got and want are not equal\nGOT:%s\nWANT:Transmission 2.94\n", s)
+	}
+	if s := (Client{}).String(); s != "unknown" {
+		t.Errorf("Got and want are not equal\nGOT:%s\nWANT:unknown\n", s)
+	}
+}
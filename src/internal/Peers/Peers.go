@@ -9,14 +9,10 @@ import (
 )
 
 type Peer struct {
-	ipv4Addr net.IP
-	port     uint16
-	PeerID   [20]byte
-	// amChoking      bool
-	// amInterested   bool
-	// peerChoking    bool
-	// peerInterested bool
-	// lastSeen       int64
+	ip     net.IP // 4 bytes for ipv4, 16 for ipv6
+	port   uint16
+	PeerID [20]byte
+	// choke / interest state lives on the PeerConn once connected
 }
 
 // ErrInvalidPeerBlob occurs when raw peer blob data is malformed
@@ -50,8 +46,8 @@ func MakePeer(peerBlob []byte) ([]Peer, error) {
 		startIdx := peerBlobSize * i
 		// account for network byte order
 		res[insertPos] = Peer{
-			ipv4Addr: net.IP(peerBlob[startIdx : startIdx+portOffset]),
-			port:     binary.BigEndian.Uint16(peerBlob[startIdx+portOffset : startIdx+peerBlobSize]),
+			ip:   net.IP(peerBlob[startIdx : startIdx+portOffset]),
+			port: binary.BigEndian.Uint16(peerBlob[startIdx+portOffset : startIdx+peerBlobSize]),
 		}
 
 		insertPos++
@@ -59,8 +55,30 @@ func MakePeer(peerBlob []byte) ([]Peer, error) {
 	return res, nil
 }
 
+// MakePeer6 parses the compact ipv6 form of BEP 7, 16 bytes of address followed by 2 bytes of port per peer
+func MakePeer6(peerBlob []byte) ([]Peer, error) {
+	if len(peerBlob)%18 != 0 {
+		return nil, ErrInvalidPeerBlob
+	}
+
+	res := make([]Peer, len(peerBlob)/18)
+	for i := range res {
+		entry := peerBlob[i*18 : (i+1)*18]
+		res[i] = Peer{ip: net.IP(entry[:16]), port: binary.BigEndian.Uint16(entry[16:])}
+	}
+	return res, nil
+}
+
+// NewPeer creates a peer from an address, ipv4 addresses are stored in their 4 byte form
+func NewPeer(ip net.IP, port uint16) Peer {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return Peer{ip: ip, port: port}
+}
+
 func (p Peer) IP() net.IP {
-	return p.ipv4Addr
+	return p.ip
 }
 
 func (p Peer) Port() uint16 {
@@ -68,7 +86,16 @@ func (p Peer) Port() uint16 {
 }
 
 func (p Peer) Address() string {
-	return p.ipv4Addr.String() + ":" + strconv.Itoa(int(p.port))
+	return net.JoinHostPort(p.ip.String(), strconv.Itoa(int(p.port)))
+}
+
+// Compact returns the 6 byte ipv4 or 18 byte ipv6 form of the peer, as read by MakePeer and MakePeer6
+func (p Peer) Compact() []byte {
+	ip := p.ip.To4()
+	if ip == nil {
+		ip = p.ip.To16()
+	}
+	return binary.BigEndian.AppendUint16(append([]byte{}, ip...), p.port)
 }
 
 // PeerHandshake represents the initial messages given in the peer protocol
@@ -80,15 +107,35 @@ type PeerHandshake struct {
 	PeerID       [20]byte
 }
 
+// Capability is a feature advertised in the reserved bytes of the handshake, the value is the bit
+// number counted from the last bit of the last reserved byte. A capability is used only when both ends set it
+type Capability uint8
+
+const (
+	CapabilityDHT        Capability = 0  // BEP 5, reserved[7] & 0x01
+	CapabilityFast       Capability = 2  // BEP 6, reserved[7] & 0x04
+	CapabilityExtensions Capability = 20 // BEP 10, reserved[5] & 0x10
+)
+
 func NewBitTorrentProtocolHandshake(infoHash, peerID [20]byte) *PeerHandshake {
 	return &PeerHandshake{
-		StrLen:       18,
+		StrLen:       19,
 		ProtocolName: "BitTorrent protocol",
 		Reserved:     [8]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		InfoHash:     infoHash,
 		PeerID:       peerID,
 	}
 }
 
+// SetCapability advertises a capability in the reserved bytes
+func (p *PeerHandshake) SetCapability(c Capability) {
+	p.Reserved[7-c/8] |= 1 << (c % 8)
+}
+
+func (p PeerHandshake) Supports(c Capability) bool {
+	return p.Reserved[7-c/8]&(1<<(c%8)) != 0
+}
+
 // SerializePeerHandshake builds the message defined in the bittorrent spec for initialising a peer connection
 // message structure -> <strlen uint8><pstr 19byte><reserved 8 bytes><info_hash 20 bytes><peer_id 20 bytes>
 func (p PeerHandshake) SerializePeerHandshake() []byte {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"net"
 	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -25,9 +26,9 @@ func TestMakePeer(t *testing.T) {
 				0x08, 0x08, 0x08, 0x08, 0x00, 0x35,
 			},
 			expected: []Peer{
-				{ipv4Addr: net.IP([]byte{0x7F, 0x00, 0x00, 0x01}), port: 6881},
-				{ipv4Addr: net.IP([]byte{0xC0, 0xA8, 0x01, 0x0A}), port: 51413},
-				{ipv4Addr: net.IP([]byte{0x08, 0x08, 0x08, 0x08}), port: 53},
+				{ip: net.IP([]byte{0x7F, 0x00, 0x00, 0x01}), port: 6881},
+				{ip: net.IP([]byte{0xC0, 0xA8, 0x01, 0x0A}), port: 51413},
+				{ip: net.IP([]byte{0x08, 0x08, 0x08, 0x08}), port: 53},
 			},
 			throwsError: false,
 		},
@@ -37,7 +38,7 @@ func TestMakePeer(t *testing.T) {
 				0xAC, 0x10, 0x00, 0x02, 0x1F, 0x90, // 172.16.0.2:8080
 			},
 			expected: []Peer{
-				{ipv4Addr: net.IP([]byte{0xAC, 0x10, 0x00, 0x02}), port: 8080},
+				{ip: net.IP([]byte{0xAC, 0x10, 0x00, 0x02}), port: 8080},
 			},
 			throwsError: false,
 		},
@@ -296,3 +297,92 @@ func TestDeserialize(t *testing.T) {
 	}
 
 }
+
+func TestCapabilities(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    []Capability
+		expected [8]byte
+	}
+
+	testcases := []TestCase{
+		{"none", nil, [8]byte{}},
+		{"dht", []Capability{CapabilityDHT}, [8]byte{0, 0, 0, 0, 0, 0, 0, 0x01}},
+		{"fast", []Capability{CapabilityFast}, [8]byte{0, 0, 0, 0, 0, 0, 0, 0x04}},
+		{"extensions", []Capability{CapabilityExtensions}, [8]byte{0, 0, 0, 0, 0, 0x10, 0, 0}},
+		{"all", []Capability{CapabilityDHT, CapabilityFast, CapabilityExtensions}, [8]byte{0, 0, 0, 0, 0, 0x10, 0, 0x05}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			h := NewBitTorrentProtocolHandshake([20]byte{}, [20]byte{})
+			for _, c := range tc.input {
+				h.SetCapability(c)
+			}
+			if h.Reserved != tc.expected {
+				t.Fatalf("Got and want are not equal\nGOT:%x\nWANT:%x\n", h.Reserved, tc.expected)
+			}
+
+			// the bits survive the wire
+			raw := [68]byte(h.SerializePeerHandshake())
+			parsed, err := DeserializePeerHandshake(raw)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			for _, c := range []Capability{CapabilityDHT, CapabilityFast, CapabilityExtensions} {
+				if got, want := parsed.Supports(c), slices.Contains(tc.input, c); got != want {
+					t.Errorf("Got and want are not equal for capability %d\nGOT:%v\nWANT:%v\n", c, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMakePeer6(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		input       []byte
+		expected    []string // addresses
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"empty", nil, []string{}, false},
+		{"two peers", append(
+			append(net.ParseIP("2001:db8::1").To16(), 0x1A, 0xE1),
+			append(net.ParseIP("::1").To16(), 0x00, 0x35)...,
+		), []string{"[2001:db8::1]:6881", "[::1]:53"}, false},
+		{"truncated", make([]byte, 17), nil, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := MakePeer6(tc.input)
+			if tc.throwsError {
+				if err == nil {
+					t.Errorf("Expected an error did not recieve any")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+
+			addresses := []string{}
+			for _, peer := range got {
+				addresses = append(addresses, peer.Address())
+				// compact form round trips
+				if !bytes.Equal(peer.Compact(), tc.input[len(addresses)*18-18:len(addresses)*18]) {
+					t.Errorf("Got and want are not equal\nGOT:%x\nWANT:%x\n", peer.Compact(), tc.input[len(addresses)*18-18:len(addresses)*18])
+				}
+			}
+			if !slices.Equal(addresses, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", addresses, tc.expected)
+			}
+		})
+	}
+
+	if got := NewPeer(net.ParseIP("10.0.0.1"), 6881).Compact(); !bytes.Equal(got, []byte{10, 0, 0, 1, 0x1A, 0xE1}) {
+		t.Errorf("Got and want are not equal\nGOT:%x\nWANT:0a0000011ae1\n", got)
+	}
+}
@@ -0,0 +1,403 @@
+// Package piecepicker decides which block to request next from which peer, it holds no connections
+// so the download engine and tests can drive it directly
+package piecepicker
+
+import (
+	"math/rand/v2"
+	"slices"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ============ Struct Defs  ============ //
+
+// Priority orders pieces ahead of rarity, skipped pieces are never picked
+type Priority int
+
+const (
+	PrioritySkip   Priority = -2
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// Config holds the tunables of the picker
+type Config struct {
+	BlockSize         uint32
+	RandomFirstPieces int        // pieces are picked at random until this many are complete, so there is something to trade
+	Rand              *rand.Rand // nil uses a randomly seeded source
+}
+
+func DefaultConfig() Config {
+	return Config{BlockSize: 16 * 1024, RandomFirstPieces: 4}
+}
+
+// Block is a single request within a piece
+type Block struct {
+	Index  uint32
+	Begin  uint32
+	Length uint32
+}
+
+// Cancel is a request another peer no longer needs to answer, sent once a block requested from
+// several peers during endgame arrives
+type Cancel[P comparable] struct {
+	Peer  P
+	Block Block
+}
+
+/*
+Picker tracks which pieces peers have and which blocks are requested from whom. P identifies a peer,
+the engine uses its connections.
+Pieces are picked rarest first with random tie breaking, except for the first few which are picked
+at random. Pieces already started are finished before new ones are picked and once every remaining
+block is requested endgame mode hands out blocks already requested from other peers.
+Pieces marked with SetSinglePeer take every block from the first peer requesting one, so a piece
+that failed its hash check can be blamed on a single peer
+*/
+type Picker[P comparable] struct {
+	torrentFile *torrent.TorrentFile
+	config      Config
+	rand        *rand.Rand
+
+	have         peers.Bitfield
+	completed    int
+	availability []int
+	priority     []Priority
+	pieces       map[int]*pieceState[P] // started pieces
+	singlePeer   map[int]bool           // pieces downloaded from a single peer
+}
+
+type pieceState[P comparable] struct {
+	received   []bool
+	requesters [][]P // peers each block is requested from, more than one only in endgame
+	remaining  int
+	owner      P // the peer every block is requested from, only for single peer pieces
+	hasOwner   bool
+}
+
+// ============ Method Defs  ============ //
+
+// New creates a picker for torrentFile, have lists pieces already held and may be nil
+func New[P comparable](torrentFile *torrent.TorrentFile, have peers.Bitfield, config Config) *Picker[P] {
+	numPieces := len(torrentFile.Pieces)
+	if have == nil {
+		have = peers.MakeBitfield(numPieces)
+	}
+	if config.Rand == nil {
+		config.Rand = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+
+	return &Picker[P]{
+		torrentFile:  torrentFile,
+		config:       config,
+		rand:         config.Rand,
+		have:         append(peers.Bitfield{}, have...),
+		completed:    have.Count(),
+		availability: make([]int, numPieces),
+		priority:     make([]Priority, numPieces),
+		pieces:       map[int]*pieceState[P]{},
+		singlePeer:   map[int]bool{},
+	}
+}
+
+// PeerBitfield counts every piece of a newly connected peer
+func (p *Picker[P]) PeerBitfield(bitfield peers.Bitfield) {
+	for index := range p.availability {
+		if bitfield.HasPiece(index) {
+			p.availability[index]++
+		}
+	}
+}
+
+// PeerHave counts a piece a peer announced
+func (p *Picker[P]) PeerHave(index int) {
+	if index >= 0 && index < len(p.availability) {
+		p.availability[index]++
+	}
+}
+
+// PeerGone removes a disconnected peer, bitfield is every piece it had
+func (p *Picker[P]) PeerGone(peer P, bitfield peers.Bitfield) {
+	for index := range p.availability {
+		if bitfield.HasPiece(index) && p.availability[index] > 0 {
+			p.availability[index]--
+		}
+	}
+	p.ReleasePeer(peer)
+}
+
+func (p *Picker[P]) Availability(index int) int {
+	return p.availability[index]
+}
+
+func (p *Picker[P]) SetPriority(index int, priority Priority) {
+	if index >= 0 && index < len(p.priority) {
+		p.priority[index] = priority
+	}
+}
+
+func (p *Picker[P]) HasPiece(index int) bool {
+	return p.have.HasPiece(index)
+}
+
+// Bitfield returns a copy of the pieces marked complete
+func (p *Picker[P]) Bitfield() peers.Bitfield {
+	return append(peers.Bitfield{}, p.have...)
+}
+
+// Remaining is the number of wanted pieces not yet complete
+func (p *Picker[P]) Remaining() int {
+	res := 0
+	for index := range p.priority {
+		if p.Wanted(index) {
+			res++
+		}
+	}
+	return res
+}
+
+// Wanted reports whether a piece is missing and not skipped
+func (p *Picker[P]) Wanted(index int) bool {
+	return !p.have.HasPiece(index) && p.priority[index] != PrioritySkip
+}
+
+// Next returns the next block to request from peer, peerHas is the pieces the peer has
+func (p *Picker[P]) Next(peer P, peerHas peers.Bitfield) (Block, bool) {
+	// finish what was started, highest priority first
+	for _, index := range p.startedPieces() {
+		state := p.pieces[index]
+		if !peerHas.HasPiece(index) || !state.allows(peer) {
+			continue
+		}
+		for block := range state.received {
+			if !state.received[block] && len(state.requesters[block]) == 0 {
+				return p.request(peer, index, block), true
+			}
+		}
+	}
+
+	if index, ok := p.pickPiece(peerHas); ok {
+		p.startPiece(index)
+		return p.request(peer, index, 0), true
+	}
+
+	if !p.InEndgame() {
+		return Block{}, false
+	}
+
+	// endgame, request blocks others are already fetching, fewest requesters first
+	var best Block
+	bestRequesters := -1
+	for _, index := range p.startedPieces() {
+		state := p.pieces[index]
+		if !peerHas.HasPiece(index) || !state.allows(peer) {
+			continue
+		}
+		for block := range state.received {
+			requesters := state.requesters[block]
+			if state.received[block] || slices.Contains(requesters, peer) {
+				continue
+			}
+			if bestRequesters == -1 || len(requesters) < bestRequesters {
+				best, bestRequesters = p.blockAt(index, block), len(requesters)
+			}
+		}
+	}
+	if bestRequesters == -1 {
+		return Block{}, false
+	}
+	return p.request(peer, int(best.Index), int(best.Begin/p.config.BlockSize)), true
+}
+
+// InEndgame reports whether every block still needed has been requested, no new piece can be started
+// and no free block remains
+func (p *Picker[P]) InEndgame() bool {
+	started := 0
+	for index := range p.priority {
+		if !p.Wanted(index) {
+			continue
+		}
+		state, ok := p.pieces[index]
+		if !ok {
+			return false
+		}
+		started++
+		for block := range state.received {
+			if !state.received[block] && len(state.requesters[block]) == 0 {
+				return false
+			}
+		}
+	}
+	return started > 0
+}
+
+// pickPiece chooses a piece to start, random while fewer than RandomFirstPieces are complete
+// otherwise the rarest, in both cases only among the highest priority candidates
+func (p *Picker[P]) pickPiece(peerHas peers.Bitfield) (int, bool) {
+	var candidates []int
+	bestPriority := PrioritySkip
+	for index := range p.priority {
+		if !p.Wanted(index) || !peerHas.HasPiece(index) {
+			continue
+		}
+		if _, started := p.pieces[index]; started {
+			continue
+		}
+		switch {
+		case p.priority[index] > bestPriority:
+			bestPriority = p.priority[index]
+			candidates = append(candidates[:0], index)
+		case p.priority[index] == bestPriority:
+			candidates = append(candidates, index)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	if p.completed >= p.config.RandomFirstPieces {
+		rarest := p.availability[candidates[0]]
+		for _, index := range candidates {
+			rarest = min(rarest, p.availability[index])
+		}
+		candidates = slices.DeleteFunc(candidates, func(index int) bool { return p.availability[index] != rarest })
+	}
+
+	return candidates[p.rand.IntN(len(candidates))], true
+}
+
+// startedPieces returns the started pieces ordered by priority then index
+func (p *Picker[P]) startedPieces() []int {
+	res := make([]int, 0, len(p.pieces))
+	for index := range p.pieces {
+		res = append(res, index)
+	}
+	slices.SortFunc(res, func(a, b int) int {
+		if p.priority[a] != p.priority[b] {
+			return int(p.priority[b] - p.priority[a])
+		}
+		return a - b
+	})
+	return res
+}
+
+func (p *Picker[P]) startPiece(index int) {
+	numBlocks := int((p.torrentFile.PieceSize(index) + uint64(p.config.BlockSize) - 1) / uint64(p.config.BlockSize))
+	p.pieces[index] = &pieceState[P]{
+		received:   make([]bool, numBlocks),
+		requesters: make([][]P, numBlocks),
+		remaining:  numBlocks,
+	}
+}
+
+func (p *Picker[P]) request(peer P, index, block int) Block {
+	state := p.pieces[index]
+	if p.singlePeer[index] && !state.hasOwner {
+		state.owner, state.hasOwner = peer, true
+	}
+	state.requesters[block] = append(state.requesters[block], peer)
+	return p.blockAt(index, block)
+}
+
+// blockAt returns the bounds of a block, the last block of a piece may be short
+func (p *Picker[P]) blockAt(index, block int) Block {
+	begin := uint32(block) * p.config.BlockSize
+	size := uint32(p.torrentFile.PieceSize(index))
+	return Block{Index: uint32(index), Begin: begin, Length: min(p.config.BlockSize, size-begin)}
+}
+
+// lookup returns the state and block number of b, ok is false for blocks that are not part of a started piece
+func (p *Picker[P]) lookup(b Block) (*pieceState[P], int, bool) {
+	state, ok := p.pieces[int(b.Index)]
+	if !ok || b.Begin%p.config.BlockSize != 0 {
+		return nil, 0, false
+	}
+	block := int(b.Begin / p.config.BlockSize)
+	if block >= len(state.received) || p.blockAt(int(b.Index), block) != b {
+		return nil, 0, false
+	}
+	return state, block, true
+}
+
+// Received marks a block as arrived from peer. ok is false for blocks that were not expected,
+// such as duplicates or blocks never requested from peer or released since, cancels lists the
+// other peers the block was requested from and complete is set once every block of the piece has arrived
+func (p *Picker[P]) Received(peer P, b Block) (cancels []Cancel[P], complete bool, ok bool) {
+	state, block, ok := p.lookup(b)
+	if !ok || state.received[block] || !slices.Contains(state.requesters[block], peer) {
+		return nil, false, false
+	}
+
+	for _, requester := range state.requesters[block] {
+		if requester != peer {
+			cancels = append(cancels, Cancel[P]{Peer: requester, Block: b})
+		}
+	}
+	state.received[block] = true
+	state.requesters[block] = nil
+	state.remaining--
+
+	return cancels, state.remaining == 0, true
+}
+
+// Release forgets a request made to peer so the block may be requested again, used on timeouts.
+// A single peer piece is started over so another peer may take it
+func (p *Picker[P]) Release(peer P, b Block) {
+	state, block, ok := p.lookup(b)
+	if !ok {
+		return
+	}
+	if state.hasOwner && state.owner == peer {
+		delete(p.pieces, int(b.Index))
+		return
+	}
+	state.requesters[block] = slices.DeleteFunc(state.requesters[block], func(requester P) bool { return requester == peer })
+}
+
+// ReleasePeer forgets every request made to peer, used when it chokes us or disconnects
+func (p *Picker[P]) ReleasePeer(peer P) {
+	for index, state := range p.pieces {
+		if state.hasOwner && state.owner == peer {
+			delete(p.pieces, index)
+			continue
+		}
+		for block := range state.requesters {
+			state.requesters[block] = slices.DeleteFunc(state.requesters[block], func(requester P) bool { return requester == peer })
+		}
+	}
+}
+
+// Failed discards a piece that did not match its hash so it is downloaded again
+func (p *Picker[P]) Failed(index int) {
+	delete(p.pieces, index)
+}
+
+/*
+SetSinglePeer makes every block of a piece come from the first peer it is requested from, blocks
+other peers send for it are not expected. It applies from the next time the piece is started,
+so it is set right after Failed, and is cleared once the piece is complete
+*/
+func (p *Picker[P]) SetSinglePeer(index int, single bool) {
+	if single {
+		p.singlePeer[index] = true
+	} else {
+		delete(p.singlePeer, index)
+	}
+}
+
+// Complete marks a verified piece as held
+func (p *Picker[P]) Complete(index int) {
+	delete(p.pieces, index)
+	delete(p.singlePeer, index)
+	if !p.have.HasPiece(index) {
+		p.have.SetPiece(index)
+		p.completed++
+	}
+}
+
+// allows reports whether blocks of the piece may be requested from peer
+func (s *pieceState[P]) allows(peer P) bool {
+	return !s.hasOwner || s.owner == peer
+}
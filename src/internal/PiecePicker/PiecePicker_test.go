@@ -0,0 +1,256 @@
+package piecepicker
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+const testBlock = 4
+
+// makeTorrent returns a torrent of numPieces pieces each two blocks long
+func makeTorrent(numPieces int) *torrent.TorrentFile {
+	return &torrent.TorrentFile{PieceLength: 2 * testBlock, Length: uint64(numPieces) * 2 * testBlock, Pieces: make([][20]byte, numPieces)}
+}
+
+func makePicker(numPieces, randomFirst int, seed uint64) *Picker[string] {
+	return New[string](makeTorrent(numPieces), nil, Config{BlockSize: testBlock, RandomFirstPieces: randomFirst, Rand: rand.New(rand.NewPCG(seed, seed))})
+}
+
+func bitfield(numPieces int, pieces ...int) peers.Bitfield {
+	bf := peers.MakeBitfield(numPieces)
+	for _, index := range pieces {
+		bf.SetPiece(index)
+	}
+	return bf
+}
+
+func TestPickOrder(t *testing.T) {
+	type TestCase struct {
+		testname   string
+		others     []peers.Bitfield // bitfields of other peers, setting availability
+		priorities map[int]Priority
+		peerHas    peers.Bitfield
+		expected   []int // acceptable pieces for the first pick, nil when nothing can be picked
+	}
+
+	testcases := []TestCase{
+		{"rarest first", []peers.Bitfield{bitfield(4, 0, 1, 2), bitfield(4, 0, 1)}, nil, bitfield(4, 0, 1, 2, 3), []int{3}},
+		{"rarest among peers pieces", []peers.Bitfield{bitfield(4, 0, 1, 2), bitfield(4, 0, 1)}, nil, bitfield(4, 0, 1), []int{0, 1}},
+		{"priority before rarity", []peers.Bitfield{bitfield(4, 0, 1, 2), bitfield(4, 0, 1)}, map[int]Priority{0: PriorityHigh}, bitfield(4, 0, 1, 2, 3), []int{0}},
+		{"low priority last", nil, map[int]Priority{0: PriorityLow, 1: PriorityLow}, bitfield(4, 0, 1, 2), []int{2}},
+		{"skipped never picked", nil, map[int]Priority{0: PrioritySkip}, bitfield(4, 0), nil},
+		{"peer has nothing", nil, nil, bitfield(4), nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			for seed := range uint64(20) {
+				picker := makePicker(4, 0, seed)
+				for _, bf := range tc.others {
+					picker.PeerBitfield(bf)
+				}
+				picker.PeerBitfield(tc.peerHas)
+				for index, priority := range tc.priorities {
+					picker.SetPriority(index, priority)
+				}
+
+				block, ok := picker.Next("peer", tc.peerHas)
+				if tc.expected == nil {
+					if ok {
+						t.Fatalf("Got and want are not equal\nGOT:%+v\nWANT:nothing\n", block)
+					}
+					continue
+				}
+				if !ok || !slices.Contains(tc.expected, int(block.Index)) || block.Begin != 0 {
+					t.Fatalf("Got and want are not equal\nGOT:%+v %v\nWANT:first block of %v\n", block, ok, tc.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestRandomFirstPieces(t *testing.T) {
+	// piece 3 is the rarest, picked every time once enough pieces are complete
+	others := bitfield(4, 0, 1, 2)
+	peerHas := bitfield(4, 0, 1, 2, 3)
+
+	picks := map[int]bool{}
+	for seed := range uint64(50) {
+		picker := makePicker(4, 1, seed)
+		picker.PeerBitfield(others)
+		picker.PeerBitfield(peerHas)
+		block, _ := picker.Next("peer", peerHas)
+		picks[int(block.Index)] = true
+	}
+	if len(picks) < 2 {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:random pieces before the first completes\n", picks)
+	}
+
+	picker := makePicker(4, 1, 0)
+	picker.Complete(0)
+	picker.PeerBitfield(others)
+	picker.PeerBitfield(peerHas)
+	if block, _ := picker.Next("peer", peerHas); block.Index != 3 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:3\n", block.Index)
+	}
+}
+
+func TestFinishStartedPieces(t *testing.T) {
+	picker := makePicker(4, 0, 0)
+	all := bitfield(4, 0, 1, 2, 3)
+	picker.PeerBitfield(all)
+
+	first, _ := picker.Next("a", all)
+	second, _ := picker.Next("b", all)
+	if second.Index != first.Index || second.Begin != testBlock {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:second block of piece %d\n", second, first.Index)
+	}
+
+	// the released block is handed out again before a new piece is started
+	picker.Release("a", first)
+	again, _ := picker.Next("c", all)
+	if again != first {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", again, first)
+	}
+}
+
+func TestReceived(t *testing.T) {
+	type TestCase struct {
+		testname string
+		block    Block
+		ok       bool
+		complete bool
+	}
+
+	// blocks are received in order from a picker where both blocks of piece 0 are requested
+	testcases := []TestCase{
+		{"wrong length", Block{0, 0, testBlock - 1}, false, false},
+		{"unaligned", Block{0, 1, testBlock}, false, false},
+		{"not started", Block{1, 0, testBlock}, false, false},
+		{"first block", Block{0, 0, testBlock}, true, false},
+		{"duplicate", Block{0, 0, testBlock}, false, false},
+		{"last block", Block{0, testBlock, testBlock}, true, true},
+	}
+
+	picker := makePicker(2, 0, 0)
+	has := bitfield(2, 0)
+	picker.Next("peer", has)
+	picker.Next("peer", has)
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			_, complete, ok := picker.Received("peer", tc.block)
+			if ok != tc.ok || complete != tc.complete {
+				t.Errorf("Got and want are not equal\nGOT:ok %v complete %v\nWANT:ok %v complete %v\n", ok, complete, tc.ok, tc.complete)
+			}
+		})
+	}
+
+	picker.Failed(0)
+	if block, ok := picker.Next("peer", has); !ok || block.Index != 0 {
+		t.Errorf("a failed piece is not picked again, got %+v %v", block, ok)
+	}
+}
+
+func TestReceivedUnrequested(t *testing.T) {
+	picker := makePicker(1, 0, 0)
+	has := bitfield(1, 0)
+	block, _ := picker.Next("a", has)
+
+	// a peer cannot slip a block into a piece it was never asked for
+	if _, _, ok := picker.Received("b", block); ok {
+		t.Errorf("a block never requested from the peer was accepted")
+	}
+	// nor send it late once its requests were released on a choke
+	picker.ReleasePeer("a")
+	if _, _, ok := picker.Received("a", block); ok {
+		t.Errorf("a block released from the peer was accepted")
+	}
+
+	again, _ := picker.Next("b", has)
+	if _, _, ok := picker.Received("b", again); !ok || again != block {
+		t.Errorf("Got and want are not equal\nGOT:%+v %v\nWANT:%+v accepted\n", again, ok, block)
+	}
+}
+
+func TestEndgame(t *testing.T) {
+	picker := makePicker(1, 0, 0)
+	has := bitfield(1, 0)
+	picker.PeerBitfield(has)
+	picker.PeerBitfield(has)
+
+	first, _ := picker.Next("a", has)
+	if picker.InEndgame() {
+		t.Errorf("endgame entered with a free block left")
+	}
+	second, _ := picker.Next("a", has)
+	if !picker.InEndgame() {
+		t.Errorf("endgame not entered once every block was requested")
+	}
+
+	// a has requested everything, b is given the same blocks
+	if _, ok := picker.Next("a", has); ok {
+		t.Errorf("a peer was given a block it already requested")
+	}
+	dup, ok := picker.Next("b", has)
+	if !ok || (dup != first && dup != second) {
+		t.Fatalf("Got and want are not equal\nGOT:%+v %v\nWANT:%+v or %+v\n", dup, ok, first, second)
+	}
+
+	cancels, _, _ := picker.Received("b", dup)
+	expected := []Cancel[string]{{Peer: "a", Block: dup}}
+	if !slices.Equal(cancels, expected) {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", cancels, expected)
+	}
+
+	// once a peer leaves its blocks are free again and endgame ends
+	picker.PeerGone("a", has)
+	if picker.InEndgame() || picker.Availability(0) != 1 {
+		t.Errorf("Got and want are not equal\nGOT:endgame %v availability %d\nWANT:false 1\n", picker.InEndgame(), picker.Availability(0))
+	}
+}
+
+func TestSinglePeer(t *testing.T) {
+	picker := makePicker(1, 0, 0)
+	has := bitfield(1, 0)
+	picker.PeerBitfield(has)
+	picker.PeerBitfield(has)
+	picker.SetSinglePeer(0, true)
+
+	first, _ := picker.Next("a", has)
+	if block, ok := picker.Next("b", has); ok {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:no block for a peer not owning the piece\n", block)
+	}
+	// not even in endgame
+	second, _ := picker.Next("a", has)
+	if block, ok := picker.Next("b", has); ok {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:no endgame block for a peer not owning the piece\n", block)
+	}
+	if _, _, ok := picker.Received("b", first); ok {
+		t.Errorf("a block from a peer not owning the piece was accepted")
+	}
+	if _, _, ok := picker.Received("a", first); !ok {
+		t.Errorf("a block from the owner was refused")
+	}
+
+	// the owner leaving starts the piece over for someone else
+	picker.PeerGone("a", has)
+	if block, ok := picker.Next("b", has); !ok || block != first {
+		t.Errorf("Got and want are not equal\nGOT:%+v %v\nWANT:%+v\n", block, ok, first)
+	}
+	picker.Next("b", has)
+	picker.Received("b", first)
+	if _, complete, _ := picker.Received("b", second); !complete {
+		t.Errorf("the piece did not complete from its new owner")
+	}
+
+	// pieces are shared again once complete
+	picker.Complete(0)
+	if picker.singlePeer[0] {
+		t.Errorf("single peer mode not cleared on completion")
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPConnectDialer tunnels tcp connections through a http proxy using the CONNECT method,
+// udp cannot be relayed this way so it does not implement PacketDialer and refuses udp networks
+type HTTPConnectDialer struct {
+	proxyAddr string
+	auth      *Auth
+	forward   net.Dialer
+}
+
+func NewHTTPConnectDialer(proxyAddr string, auth *Auth) *HTTPConnectDialer {
+	return &HTTPConnectDialer{
+		proxyAddr: proxyAddr,
+		auth:      auth,
+		forward:   net.Dialer{Timeout: 10 * time.Second},
+	}
+}
+
+// DialContext sends a CONNECT request for address and returns the tunnelled connection once the proxy answers 200
+func (d *HTTPConnectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("http CONNECT only supports tcp, got %s", network)
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if d.auth != nil {
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.auth.Username + ":" + d.auth.Password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	req += "\r\n"
+
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s with status %s", address, resp.Status)
+	}
+
+	// the remote may have spoken first, keep anything the reader already pulled off the wire
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
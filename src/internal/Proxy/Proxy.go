@@ -0,0 +1,129 @@
+// Package proxy contains the dialers used by the client to reach trackers and peers, either directly
+// or through a SOCKS5 (https://www.rfc-editor.org/rfc/rfc1928) or HTTP CONNECT proxy
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// ============ Interface Defs  ============ //
+
+// Dialer opens stream connections, net.Dialer satisfies this interface
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// PacketDialer is implemented by dialers able to relay udp datagrams (udp trackers, DHT)
+// the returned PacketConn accepts HostAddr destinations so hostnames can be resolved by the proxy
+type PacketDialer interface {
+	Dialer
+	ListenPacket(ctx context.Context) (net.PacketConn, error)
+}
+
+// ============ Struct Defs  ============ //
+
+// Direct connects without a proxy
+type Direct struct {
+	net.Dialer
+}
+
+// HostAddr is a "host:port" destination that has not been resolved yet
+type HostAddr string
+
+func (a HostAddr) Network() string { return "udp" }
+func (a HostAddr) String() string  { return string(a) }
+
+// ErrUnsupportedScheme occurs when a proxy url is neither socks5 nor http
+var ErrUnsupportedScheme = fmt.Errorf("unsupported proxy scheme")
+
+// ============ Method Defs  ============ //
+
+// ListenPacket opens an unconnected udp socket, HostAddr destinations are resolved locally
+func (d *Direct) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	var lc net.ListenConfig
+	conn, err := lc.ListenPacket(ctx, "udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	return &directPacketConn{PacketConn: conn}, nil
+}
+
+type directPacketConn struct {
+	net.PacketConn
+}
+
+func (c *directPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if host, ok := addr.(HostAddr); ok {
+		resolved, err := net.ResolveUDPAddr("udp", string(host))
+		if err != nil {
+			return 0, err
+		}
+		addr = resolved
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+/*
+FromURL builds a dialer from a proxy url
+@params
+raw - one of
+  - socks5://[user:pass@]host:port (socks5h is accepted as an alias)
+  - http://[user:pass@]host:port
+  - empty string for a direct connection
+
+@returns
+the dialer or ErrUnsupportedScheme
+*/
+func FromURL(raw string) (Dialer, error) {
+	if raw == "" {
+		return &Direct{}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %s - %w", raw, err)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("proxy url %s is missing a port", raw)
+	}
+
+	var auth *Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &Auth{Username: u.User.Username(), Password: password}
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return NewSOCKS5Dialer(u.Host, auth), nil
+	case "http":
+		return NewHTTPConnectDialer(u.Host, auth), nil
+	default:
+		return nil, fmt.Errorf("%w - %s", ErrUnsupportedScheme, u.Scheme)
+	}
+}
+
+// Auth holds credentials for proxies requiring them
+type Auth struct {
+	Username string
+	Password string
+}
+
+// ============ Helpers  ============ //
+
+// SplitHostPort is net.SplitHostPort with the port parsed into a uint16
+func SplitHostPort(address string) (string, uint16, error) {
+	host, rawPort, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %s", address)
+	}
+	return host, uint16(port), nil
+}
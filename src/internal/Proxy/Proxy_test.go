@@ -0,0 +1,294 @@
+package proxy_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	proxy "github.com/firozt/go-torrent/src/internal/Proxy"
+	proxytest "github.com/firozt/go-torrent/src/internal/ProxyTest"
+)
+
+// credentials converts the auth of a dialer into what the test proxy expects
+func credentials(auth *proxy.Auth) *proxytest.Credentials {
+	if auth == nil {
+		return nil
+	}
+	return &proxytest.Credentials{Username: auth.Username, Password: auth.Password}
+}
+
+// startTCPEcho runs a server echoing everything it reads back to the sender
+func startTCPEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot listen - %s", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func startUDPEcho(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot listen - %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	msg := []byte("hello through the proxy")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("unable to write - %s", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("unable to read - %s", err)
+	}
+	if string(got) != string(msg) {
+		t.Errorf("Got and want are not equal\nGOT:%s\nWANT:%s\n", got, msg)
+	}
+}
+
+func TestSOCKS5Connect(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		serverAuth  *proxy.Auth
+		clientAuth  *proxy.Auth
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"no auth", nil, nil, false},
+		{"valid credentials", &proxy.Auth{Username: "user", Password: "pass"}, &proxy.Auth{Username: "user", Password: "pass"}, false},
+		{"invalid credentials", &proxy.Auth{Username: "user", Password: "pass"}, &proxy.Auth{Username: "user", Password: "wrong"}, true},
+		{"missing credentials", &proxy.Auth{Username: "user", Password: "pass"}, nil, true},
+	}
+
+	echoAddr := startTCPEcho(t)
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			server, err := proxytest.NewSOCKS5Server("127.0.0.1:0", credentials(tc.serverAuth))
+			if err != nil {
+				t.Fatalf("DEV ERR: cannot start proxy - %s", err)
+			}
+			defer server.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			dialer := proxy.NewSOCKS5Dialer(server.Addr().String(), tc.clientAuth)
+			conn, err := dialer.DialContext(ctx, "tcp", echoAddr)
+
+			if tc.throwsError && err == nil {
+				conn.Close()
+				t.Fatalf("Expected an error did not recieve any")
+			}
+			if !tc.throwsError && err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if tc.throwsError {
+				return
+			}
+			defer conn.Close()
+
+			assertEcho(t, conn)
+			if log := server.RequestLog(); !reflect.DeepEqual(log, []string{echoAddr}) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", log, []string{echoAddr})
+			}
+		})
+	}
+}
+
+func TestSOCKS5UDPAssociate(t *testing.T) {
+	echoAddr := startUDPEcho(t)
+	server, err := proxytest.NewSOCKS5Server("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot start proxy - %s", err)
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	pc, err := proxy.NewSOCKS5Dialer(server.Addr().String(), nil).ListenPacket(ctx)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	defer pc.Close()
+
+	pc.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := pc.WriteTo([]byte("ping"), proxy.HostAddr(echoAddr)); err != nil {
+		t.Fatalf("unable to write - %s", err)
+	}
+
+	buf := make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unable to read - %s", err)
+	}
+	if string(buf[:n]) != "ping" || from.String() != echoAddr {
+		t.Errorf("Got and want are not equal\nGOT:%s from %s\nWANT:ping from %s\n", buf[:n], from, echoAddr)
+	}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	echoAddr := startTCPEcho(t)
+
+	// a CONNECT only proxy that requires basic auth
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		// BasicAuth only reads the Authorization header
+		r.Header.Set("Authorization", r.Header.Get("Proxy-Authorization"))
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		remote, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			defer conn.Close()
+			defer remote.Close()
+			go io.Copy(remote, conn)
+			io.Copy(conn, remote)
+		}()
+	}))
+	defer proxyServer.Close()
+
+	proxyAddr := proxyServer.Listener.Addr().String()
+
+	type TestCase struct {
+		testname    string
+		auth        *proxy.Auth
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"valid credentials", &proxy.Auth{Username: "user", Password: "pass"}, false},
+		{"no credentials", nil, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			conn, err := proxy.NewHTTPConnectDialer(proxyAddr, tc.auth).DialContext(ctx, "tcp", echoAddr)
+			if tc.throwsError && err == nil {
+				conn.Close()
+				t.Fatalf("Expected an error did not recieve any")
+			}
+			if !tc.throwsError && err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if tc.throwsError {
+				return
+			}
+			defer conn.Close()
+			assertEcho(t, conn)
+		})
+	}
+}
+
+func TestFromURL(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		input       string
+		expected    proxy.Dialer
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"empty is direct", "", &proxy.Direct{}, false},
+		{"socks5", "socks5://127.0.0.1:1080", proxy.NewSOCKS5Dialer("127.0.0.1:1080", nil), false},
+		{"socks5 with credentials", "socks5://u:p@proxy:1080", proxy.NewSOCKS5Dialer("proxy:1080", &proxy.Auth{Username: "u", Password: "p"}), false},
+		{"http connect", "http://proxy:3128", proxy.NewHTTPConnectDialer("proxy:3128", nil), false},
+		{"missing port", "socks5://proxy", nil, true},
+		{"unsupported scheme", "ftp://proxy:21", nil, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := proxy.FromURL(tc.input)
+
+			if tc.throwsError && err == nil {
+				t.Errorf("Expected an error did not recieve any")
+				return
+			}
+			if !tc.throwsError && err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%#v\nWANT:%#v\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSOCKS5DialUDP(t *testing.T) {
+	echoAddr := startUDPEcho(t)
+	server, err := proxytest.NewSOCKS5Server("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot start proxy - %s", err)
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := proxy.NewSOCKS5Dialer(server.Addr().String(), nil).DialContext(ctx, "udp", echoAddr)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	defer conn.Close()
+
+	if conn.RemoteAddr().String() != echoAddr {
+		t.Errorf("Got and want are not equal\nGOT:%s\nWANT:%s\n", conn.RemoteAddr(), echoAddr)
+	}
+	assertEcho(t, conn)
+}
@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// protocol constants from RFC 1928 and RFC 1929, shared with the proxytest server
+const (
+	SOCKS5Version       byte = 0x05
+	SOCKS5AuthNone      byte = 0x00
+	SOCKS5AuthPassword  byte = 0x02
+	SOCKS5AuthNoMethods byte = 0xff
+	SOCKS5CmdConnect    byte = 0x01
+	SOCKS5CmdUDP        byte = 0x03
+	SOCKS5AtypIPv4      byte = 0x01
+	SOCKS5AtypDomain    byte = 0x03
+	SOCKS5AtypIPv6      byte = 0x04
+	SOCKS5ReplySuccess  byte = 0x00
+)
+
+// SOCKS5Dialer tunnels tcp connections through CONNECT and udp datagrams through UDP ASSOCIATE
+type SOCKS5Dialer struct {
+	proxyAddr string
+	auth      *Auth
+	forward   net.Dialer // used to reach the proxy itself
+}
+
+func NewSOCKS5Dialer(proxyAddr string, auth *Auth) *SOCKS5Dialer {
+	return &SOCKS5Dialer{
+		proxyAddr: proxyAddr,
+		auth:      auth,
+		forward:   net.Dialer{Timeout: 10 * time.Second},
+	}
+}
+
+// DialContext connects to address through the proxy, hostnames are resolved by the proxy
+// udp networks are relayed with UDP ASSOCIATE and return a connection bound to address
+func (d *SOCKS5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	case "udp", "udp4", "udp6":
+		pc, err := d.ListenPacket(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &socks5UDPConn{socks5PacketConn: pc.(*socks5PacketConn), remote: HostAddr(address)}, nil
+	default:
+		return nil, fmt.Errorf("socks5 does not support network %s", network)
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.negotiate(ctx, conn, SOCKS5CmdConnect, address); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// ListenPacket sets up a UDP ASSOCIATE relay, the control connection is kept open for the
+// lifetime of the returned PacketConn as the proxy tears down the relay when it closes
+func (d *SOCKS5Dialer) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	ctrl, err := d.forward.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// we do not know our external address, so ask the proxy to accept any source
+	relayAddr, err := d.negotiate(ctx, ctrl, SOCKS5CmdUDP, "0.0.0.0:0")
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	// proxies commonly answer with an unspecified bind address, meaning "same host as the proxy"
+	if relayAddr.IP.IsUnspecified() {
+		if proxyHost, ok := ctrl.RemoteAddr().(*net.TCPAddr); ok {
+			relayAddr.IP = proxyHost.IP
+		}
+	}
+
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	pc := &socks5PacketConn{UDPConn: udpConn, ctrl: ctrl, relay: relayAddr}
+
+	// the relay is only valid while the control connection lives, close the socket with it
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		pc.Close()
+	}()
+
+	return pc, nil
+}
+
+// negotiate performs method selection, optional authentication and the command request
+// it returns the BND.ADDR given in the reply
+func (d *SOCKS5Dialer) negotiate(ctx context.Context, conn net.Conn, cmd byte, address string) (*net.UDPAddr, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	methods := []byte{SOCKS5AuthNone}
+	if d.auth != nil {
+		methods = append(methods, SOCKS5AuthPassword)
+	}
+
+	greeting := append([]byte{SOCKS5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return nil, err
+	}
+
+	choice := make([]byte, 2)
+	if _, err := io.ReadFull(conn, choice); err != nil {
+		return nil, err
+	}
+	if choice[0] != SOCKS5Version {
+		return nil, fmt.Errorf("proxy is not socks5, version byte was %d", choice[0])
+	}
+
+	switch choice[1] {
+	case SOCKS5AuthNone:
+	case SOCKS5AuthPassword:
+		if d.auth == nil {
+			return nil, fmt.Errorf("proxy requested credentials but none were configured")
+		}
+		if err := d.authenticate(conn); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("proxy rejected all authentication methods")
+	}
+
+	host, port, err := SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	req := []byte{SOCKS5Version, cmd, 0x00}
+	req = AppendSOCKS5Addr(req, host, port)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[1] != SOCKS5ReplySuccess {
+		return nil, fmt.Errorf("proxy refused request with code %d", header[1])
+	}
+
+	bindHost, bindPort, err := ReadSOCKS5Addr(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.UDPAddr{IP: net.ParseIP(bindHost), Port: int(bindPort)}, nil
+}
+
+// RFC 1929 username / password sub negotiation
+func (d *SOCKS5Dialer) authenticate(conn net.Conn) error {
+	if len(d.auth.Username) > 255 || len(d.auth.Password) > 255 {
+		return fmt.Errorf("socks5 credentials can be at most 255 bytes")
+	}
+
+	msg := []byte{0x01, byte(len(d.auth.Username))}
+	msg = append(msg, d.auth.Username...)
+	msg = append(msg, byte(len(d.auth.Password)))
+	msg = append(msg, d.auth.Password...)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[1] != 0x00 {
+		return fmt.Errorf("proxy rejected credentials")
+	}
+	return nil
+}
+
+/*
+socks5PacketConn wraps every datagram in the UDP request header
++----+------+------+----------+----------+----------+
+|RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
++----+------+------+----------+----------+----------+
+| 2  |  1   |  1   | Variable |    2     | Variable |
++----+------+------+----------+----------+----------+
+*/
+type socks5PacketConn struct {
+	*net.UDPConn
+	ctrl      net.Conn
+	relay     *net.UDPAddr
+	closeOnce sync.Once
+}
+
+func (c *socks5PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	host, port, err := SplitHostPort(addr.String())
+	if err != nil {
+		return 0, err
+	}
+
+	packet := AppendSOCKS5Addr([]byte{0x00, 0x00, 0x00}, host, port)
+	packet = append(packet, b...)
+	if _, err := c.UDPConn.WriteTo(packet, c.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *socks5PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+262) // room for the largest header
+	for {
+		n, from, err := c.UDPConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		// datagrams not coming from the relay are dropped as per the rfc
+		if udpFrom, ok := from.(*net.UDPAddr); !ok || !udpFrom.IP.Equal(c.relay.IP) || udpFrom.Port != c.relay.Port {
+			continue
+		}
+		if n < 4 || buf[2] != 0x00 { // fragments are not supported
+			continue
+		}
+
+		payload := buf[3:n]
+		reader := bytes.NewReader(payload)
+		host, port, err := ReadSOCKS5Addr(reader)
+		if err != nil {
+			continue
+		}
+
+		copied := copy(b, payload[len(payload)-reader.Len():])
+		return copied, &net.UDPAddr{IP: net.ParseIP(host), Port: int(port)}, nil
+	}
+}
+
+func (c *socks5PacketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.ctrl.Close()
+		err = c.UDPConn.Close()
+	})
+	return err
+}
+
+// socks5UDPConn is a relayed udp socket that only talks to a single destination, like a connected net.UDPConn
+type socks5UDPConn struct {
+	*socks5PacketConn
+	remote HostAddr
+}
+
+func (c *socks5UDPConn) Read(b []byte) (int, error) {
+	n, _, err := c.socks5PacketConn.ReadFrom(b)
+	return n, err
+}
+
+func (c *socks5UDPConn) Write(b []byte) (int, error) {
+	return c.socks5PacketConn.WriteTo(b, c.remote)
+}
+
+func (c *socks5UDPConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// ============ Helpers  ============ //
+
+// AppendSOCKS5Addr writes ATYP || ADDR || PORT, hostnames that are not ips are sent as domains
+func AppendSOCKS5Addr(buf []byte, host string, port uint16) []byte {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append(buf, SOCKS5AtypIPv4)
+			buf = append(buf, ip4...)
+		} else {
+			buf = append(buf, SOCKS5AtypIPv6)
+			buf = append(buf, ip.To16()...)
+		}
+	} else {
+		buf = append(buf, SOCKS5AtypDomain, byte(len(host)))
+		buf = append(buf, host...)
+	}
+	return binary.BigEndian.AppendUint16(buf, port)
+}
+
+// ReadSOCKS5Addr reads ATYP || ADDR || PORT and returns the host as a string
+func ReadSOCKS5Addr(r io.Reader) (string, uint16, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", 0, err
+	}
+
+	var host string
+	switch atyp[0] {
+	case SOCKS5AtypIPv4, SOCKS5AtypIPv6:
+		size := net.IPv4len
+		if atyp[0] == SOCKS5AtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(ip).String()
+	case SOCKS5AtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", 0, err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", 0, err
+		}
+		host = string(domain)
+	default:
+		return "", 0, fmt.Errorf("unknown socks5 address type %d", atyp[0])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", 0, err
+	}
+
+	return host, binary.BigEndian.Uint16(port), nil
+}
@@ -0,0 +1,269 @@
+// Package proxytest runs an in-process SOCKS5 proxy so proxied traffic can be exercised on loopback
+// in tests, it is not meant for production use
+package proxytest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	proxy "github.com/firozt/go-torrent/src/internal/Proxy"
+)
+
+// Credentials are the username and password clients must authenticate with
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// SOCKS5Server is a minimal in-process SOCKS5 proxy supporting CONNECT and UDP ASSOCIATE
+type SOCKS5Server struct {
+	auth     *Credentials // when set clients must authenticate with these credentials
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu          sync.Mutex
+	connections map[net.Conn]struct{}
+	requests    []string // destinations requested so far
+}
+
+// NewSOCKS5Server starts listening on addr, use "127.0.0.1:0" for a random port
+func NewSOCKS5Server(addr string, auth *Credentials) (*SOCKS5Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SOCKS5Server{auth: auth, listener: ln, connections: map[net.Conn]struct{}{}}
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return s, nil
+}
+
+func (s *SOCKS5Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// RequestLog returns a copy of every destination requested through the proxy
+func (s *SOCKS5Server) RequestLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// Close stops accepting and tears down every open tunnel
+func (s *SOCKS5Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.connections {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *SOCKS5Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.track(conn, true)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.track(conn, false)
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *SOCKS5Server) track(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		s.connections[conn] = struct{}{}
+	} else {
+		delete(s.connections, conn)
+	}
+}
+
+func (s *SOCKS5Server) handle(conn net.Conn) {
+	// method selection
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != proxy.SOCKS5Version {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	wanted := proxy.SOCKS5AuthNone
+	if s.auth != nil {
+		wanted = proxy.SOCKS5AuthPassword
+	}
+	if !bytes.Contains(methods, []byte{wanted}) {
+		conn.Write([]byte{proxy.SOCKS5Version, proxy.SOCKS5AuthNoMethods})
+		return
+	}
+	conn.Write([]byte{proxy.SOCKS5Version, wanted})
+
+	if s.auth != nil && !s.checkCredentials(conn) {
+		return
+	}
+
+	// request
+	req := make([]byte, 3)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	host, port, err := proxy.ReadSOCKS5Addr(conn)
+	if err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	s.mu.Lock()
+	s.requests = append(s.requests, target)
+	s.mu.Unlock()
+
+	switch req[1] {
+	case proxy.SOCKS5CmdConnect:
+		s.handleConnect(conn, target)
+	case proxy.SOCKS5CmdUDP:
+		s.handleUDPAssociate(conn)
+	default:
+		conn.Write(socks5Reply(0x07, nil)) // command not supported
+	}
+}
+
+func (s *SOCKS5Server) checkCredentials(conn net.Conn) bool {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return false
+	}
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return false
+	}
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return false
+	}
+	password := make([]byte, length[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return false
+	}
+
+	if string(username) != s.auth.Username || string(password) != s.auth.Password {
+		conn.Write([]byte{0x01, 0x01})
+		return false
+	}
+	conn.Write([]byte{0x01, 0x00})
+	return true
+}
+
+func (s *SOCKS5Server) handleConnect(conn net.Conn, target string) {
+	remote, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write(socks5Reply(0x05, nil)) // connection refused
+		return
+	}
+	defer remote.Close()
+	s.track(remote, true)
+	defer s.track(remote, false)
+
+	conn.Write(socks5Reply(proxy.SOCKS5ReplySuccess, remote.LocalAddr()))
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// handleUDPAssociate relays datagrams between the client and any destination until the control connection closes
+func (s *SOCKS5Server) handleUDPAssociate(conn net.Conn) {
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	relay, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		conn.Write(socks5Reply(0x01, nil))
+		return
+	}
+	defer relay.Close()
+
+	conn.Write(socks5Reply(proxy.SOCKS5ReplySuccess, relay.LocalAddr()))
+
+	go func() {
+		var client net.Addr
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := relay.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+
+			// the first sender is taken to be the client, anything else is a reply to relay back
+			if client == nil {
+				client = from
+			}
+
+			if from.String() == client.String() {
+				if n < 4 || buf[2] != 0x00 {
+					continue
+				}
+				payload := buf[3:n]
+				reader := bytes.NewReader(payload)
+				dstHost, dstPort, err := proxy.ReadSOCKS5Addr(reader)
+				if err != nil {
+					continue
+				}
+				dst, err := net.ResolveUDPAddr("udp", net.JoinHostPort(dstHost, strconv.Itoa(int(dstPort))))
+				if err != nil {
+					continue
+				}
+				relay.WriteTo(payload[len(payload)-reader.Len():], dst)
+				continue
+			}
+
+			udpFrom := from.(*net.UDPAddr)
+			packet := proxy.AppendSOCKS5Addr([]byte{0x00, 0x00, 0x00}, udpFrom.IP.String(), uint16(udpFrom.Port))
+			packet = append(packet, buf[:n]...)
+			relay.WriteTo(packet, client)
+		}
+	}()
+
+	// the association lives as long as the control connection
+	io.Copy(io.Discard, conn)
+}
+
+// socks5Reply builds VER || REP || RSV || ATYP || BND.ADDR || BND.PORT
+func socks5Reply(code byte, bound net.Addr) []byte {
+	reply := []byte{proxy.SOCKS5Version, code, 0x00}
+	if bound == nil {
+		return proxy.AppendSOCKS5Addr(reply, "0.0.0.0", 0)
+	}
+	host, port, err := proxy.SplitHostPort(bound.String())
+	if err != nil {
+		return proxy.AppendSOCKS5Addr(reply, "0.0.0.0", 0)
+	}
+	return proxy.AppendSOCKS5Addr(reply, host, port)
+}
@@ -0,0 +1,185 @@
+// Package resume saves the state of a torrent between runs so restarting does not require hashing
+// every piece again, the state is a bencoded file per torrent
+package resume
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ============ Struct Defs  ============ //
+
+// Data is the saved state of a single torrent
+type Data struct {
+	InfoHash   [20]byte       `bencode:"info hash"`
+	Bitfield   []byte         `bencode:"bitfield"` // verified pieces
+	Files      []FileState    `bencode:"files"`    // in the order of storage.Files
+	Uploaded   uint64         `bencode:"uploaded"`
+	Downloaded uint64         `bencode:"downloaded"`
+	Peers      []byte         `bencode:"peers"` // compact ipv4 peers, 6 bytes each
+	Trackers   []TrackerState `bencode:"trackers"`
+}
+
+// FileState is the stat of a file when the state was saved, a file never created has a size of -1
+type FileState struct {
+	Size    int64 `bencode:"size"`
+	ModTime int64 `bencode:"mtime"` // unix nanoseconds
+}
+
+// TrackerState is the announce schedule of a tracker, times are unix seconds
+type TrackerState struct {
+	URL          string `bencode:"url"`
+	LastAnnounce int64  `bencode:"last announce"`
+	NextAnnounce int64  `bencode:"next announce"`
+	Failures     int64  `bencode:"failures"`
+}
+
+var (
+	// ErrCorrupt occurs when a resume file cannot be decoded
+	ErrCorrupt = fmt.Errorf("corrupt resume data")
+	// ErrStale occurs when resume data no longer matches the data on disk, the torrent must be hash checked
+	ErrStale = fmt.Errorf("stale resume data")
+)
+
+// ============ Method Defs  ============ //
+
+// Path returns where the resume data of a torrent is kept within dir
+func Path(dir string, infoHash [20]byte) string {
+	return filepath.Join(dir, hex.EncodeToString(infoHash[:])+".resume")
+}
+
+// Stat records the size and modification time of every file of the torrent under dir
+func Stat(torrentFile *torrent.TorrentFile, dir string) ([]FileState, error) {
+	files, err := storage.Files(torrentFile)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]FileState, len(files))
+	for i, file := range files {
+		info, err := os.Stat(filepath.Join(dir, file.Path))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			res[i] = FileState{Size: -1}
+		case err != nil:
+			return nil, err
+		default:
+			res[i] = FileState{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+		}
+	}
+	return res, nil
+}
+
+// Save writes data to path, through a temporary file so a crash never leaves a partial file behind
+func Save(path string, data *Data) error {
+	encoded, err := bencodeparser.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, encoded, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads resume data written by Save
+func Load(path string) (*Data, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ir, err := bencodeparser.DecodeBytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrCorrupt, err)
+	}
+	dict, ok := ir.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w - not a dictionary", ErrCorrupt)
+	}
+
+	infoHash, _ := dict["info hash"].(string)
+	if len(infoHash) != 20 {
+		return nil, fmt.Errorf("%w - invalid info hash", ErrCorrupt)
+	}
+
+	data := &Data{
+		InfoHash:   [20]byte([]byte(infoHash)),
+		Bitfield:   []byte(asString(dict["bitfield"])),
+		Uploaded:   uint64(asInt(dict["uploaded"])),
+		Downloaded: uint64(asInt(dict["downloaded"])),
+		Peers:      []byte(asString(dict["peers"])),
+	}
+
+	files, _ := dict["files"].([]any)
+	for _, raw := range files {
+		file, _ := raw.(map[string]any)
+		data.Files = append(data.Files, FileState{Size: asInt(file["size"]), ModTime: asInt(file["mtime"])})
+	}
+
+	trackers, _ := dict["trackers"].([]any)
+	for _, raw := range trackers {
+		trk, _ := raw.(map[string]any)
+		data.Trackers = append(data.Trackers, TrackerState{
+			URL:          asString(trk["url"]),
+			LastAnnounce: asInt(trk["last announce"]),
+			NextAnnounce: asInt(trk["next announce"]),
+			Failures:     asInt(trk["failures"]),
+		})
+	}
+
+	return data, nil
+}
+
+// asString and asInt return the zero value for missing or mistyped fields
+func asString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func asInt(v any) int64 {
+	i, _ := v.(int64)
+	return i
+}
+
+/*
+Check validates the resume data against the torrent and the files under dir, any file whose size
+or modification time changed since the state was saved makes the whole state stale as the
+pieces it holds may have changed
+*/
+func (d *Data) Check(torrentFile *torrent.TorrentFile, dir string) error {
+	if d.InfoHash != torrentFile.InfoHash {
+		return fmt.Errorf("%w - info hash %x does not match %x", ErrStale, d.InfoHash, torrentFile.InfoHash)
+	}
+	if err := peers.Bitfield(d.Bitfield).Validate(len(torrentFile.Pieces)); err != nil {
+		return fmt.Errorf("%w - %s", ErrStale, err)
+	}
+
+	current, err := Stat(torrentFile, dir)
+	if err != nil {
+		return err
+	}
+	if len(current) != len(d.Files) {
+		return fmt.Errorf("%w - %d files recorded, torrent has %d", ErrStale, len(d.Files), len(current))
+	}
+	for i := range current {
+		if current[i] != d.Files[i] {
+			return fmt.Errorf("%w - file %d changed", ErrStale, i)
+		}
+	}
+	return nil
+}
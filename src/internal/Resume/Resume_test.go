@@ -0,0 +1,107 @@
+package resume
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// makeTorrent returns a two file torrent of 3 pieces with both files written under dir
+func makeTorrent(t *testing.T, dir string) *torrent.TorrentFile {
+	t.Helper()
+	tf := &torrent.TorrentFile{
+		Name:        "resume",
+		InfoHash:    [20]byte{'R', 'E', 'S'},
+		PieceLength: 4,
+		Pieces:      make([][20]byte, 3),
+		Files: []torrent.TorrentFileField{
+			{Path: []string{"a"}, Length: 6},
+			{Path: []string{"b"}, Length: 4},
+		},
+	}
+	os.MkdirAll(filepath.Join(dir, "resume"), 0o755)
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, "resume", name), []byte("data"), 0o644); err != nil {
+			t.Fatalf("DEV ERR: cannot write file - %s", err)
+		}
+	}
+	return tf
+}
+
+func TestSaveLoad(t *testing.T) {
+	expected := &Data{
+		InfoHash:   [20]byte{1, 2, 3},
+		Bitfield:   []byte{0xa0},
+		Files:      []FileState{{Size: 6, ModTime: 1700000000123456789}, {Size: -1}},
+		Uploaded:   1 << 40,
+		Downloaded: 12345,
+		Peers:      []byte{127, 0, 0, 1, 0x1a, 0xe1},
+		Trackers:   []TrackerState{{URL: "udp://tracker:1337", LastAnnounce: 1700000000, NextAnnounce: 1700001800, Failures: 2}},
+	}
+
+	path := Path(t.TempDir(), expected.InfoHash)
+	if err := Save(path, expected); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", got, expected)
+	}
+
+	os.WriteFile(path, []byte("i42e"), 0o644)
+	if _, err := Load(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrCorrupt)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	type TestCase struct {
+		testname string
+		modify   func(dir string, data *Data)
+		isStale  bool
+	}
+
+	testcases := []TestCase{
+		{"unchanged", func(dir string, data *Data) {}, false},
+		{"other torrent", func(dir string, data *Data) { data.InfoHash[0] = 'X' }, true},
+		{"bitfield size", func(dir string, data *Data) { data.Bitfield = []byte{0xe0, 0x00} }, true},
+		{"spare bits set", func(dir string, data *Data) { data.Bitfield = []byte{0xff} }, true},
+		{"file resized", func(dir string, data *Data) {
+			os.WriteFile(filepath.Join(dir, "resume", "a"), []byte("longer data"), 0o644)
+		}, true},
+		{"file touched", func(dir string, data *Data) {
+			os.Chtimes(filepath.Join(dir, "resume", "b"), time.Now(), time.Now().Add(time.Hour))
+		}, true},
+		{"file removed", func(dir string, data *Data) { os.Remove(filepath.Join(dir, "resume", "b")) }, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			dir := t.TempDir()
+			tf := makeTorrent(t, dir)
+
+			files, err := Stat(tf, dir)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			data := &Data{InfoHash: tf.InfoHash, Bitfield: []byte{0xe0}, Files: files}
+			tc.modify(dir, data)
+
+			err = data.Check(tf, dir)
+			if tc.isStale && !errors.Is(err, ErrStale) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrStale)
+			}
+			if !tc.isStale && err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+			}
+		})
+	}
+}
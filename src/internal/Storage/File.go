@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ============ Struct Defs  ============ //
+
+/*
+FileStorage keeps a torrent as regular files under a directory. Files are only created once
+data is written to them and are truncated to their full length on creation, leaving a sparse
+file on filesystems that support them
+*/
+type FileStorage struct {
+	dir    string
+	layout *layout
+
+	mu      sync.Mutex
+	handles map[int]*os.File
+	closed  bool
+}
+
+// ============ Method Defs  ============ //
+
+func NewFileStorage(torrentFile *torrent.TorrentFile, dir string) (*FileStorage, error) {
+	layout, err := newLayout(torrentFile)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir, layout: layout, handles: map[int]*os.File{}}, nil
+}
+
+// Dir is the directory the files of the torrent are kept under
+func (s *FileStorage) Dir() string {
+	return s.dir
+}
+
+func (s *FileStorage) ReadAt(index int, p []byte, begin int64) (int, error) {
+	spans, err := s.layout.spans(index, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, sp := range spans {
+		f, err := s.open(sp.file, false)
+		if err != nil {
+			return n, err
+		}
+		read, err := f.ReadAt(p[sp.begin:sp.begin+sp.length], sp.offset)
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (s *FileStorage) WriteAt(index int, p []byte, begin int64) (int, error) {
+	spans, err := s.layout.spans(index, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, sp := range spans {
+		f, err := s.open(sp.file, true)
+		if err != nil {
+			return n, err
+		}
+		written, err := f.WriteAt(p[sp.begin:sp.begin+sp.length], sp.offset)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// MarkComplete flushes the files holding data of the piece to disk, zero length files that sit at the
+// pieces boundaries are created here as no data is ever written to them. Files that only touch the
+// boundary belong to the neighbouring piece and are left alone
+func (s *FileStorage) MarkComplete(index int) error {
+	if index < 0 || index >= len(s.layout.torrentFile.Pieces) {
+		return ErrOutOfRange
+	}
+	start := int64(index) * int64(s.layout.torrentFile.PieceLength)
+	end := start + int64(s.layout.torrentFile.PieceSize(index))
+
+	for i, file := range s.layout.files {
+		if file.Length == 0 {
+			if file.Offset < start || file.Offset > end {
+				continue
+			}
+			if _, err := s.open(i, true); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if file.Offset+file.Length <= start || file.Offset >= end {
+			continue
+		}
+		f, err := s.open(i, false)
+		if err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var errs []error
+	for i, f := range s.handles {
+		errs = append(errs, f.Close())
+		delete(s.handles, i)
+	}
+	return errors.Join(errs...)
+}
+
+// open returns the handle of a file, creating it when create is set and it does not exist yet
+func (s *FileStorage) open(file int, create bool) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, fs.ErrClosed
+	}
+	if f, ok := s.handles[file]; ok {
+		return f, nil
+	}
+
+	path := filepath.Join(s.dir, s.layout.files[file].Path)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) && create {
+		f, err = s.create(path, s.layout.files[file].Length)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.handles[file] = f
+	return f, nil
+}
+
+func (s *FileStorage) create(path string, length int64) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(length); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"io/fs"
+	"sync"
+
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ============ Struct Defs  ============ //
+
+// MemoryStorage keeps every piece in memory, pieces are allocated on their first write. Meant for tests
+type MemoryStorage struct {
+	torrentFile *torrent.TorrentFile
+
+	mu       sync.Mutex
+	pieces   map[int][]byte
+	complete map[int]bool
+	closed   bool
+}
+
+// ============ Method Defs  ============ //
+
+func NewMemoryStorage(torrentFile *torrent.TorrentFile) *MemoryStorage {
+	return &MemoryStorage{torrentFile: torrentFile, pieces: map[int][]byte{}, complete: map[int]bool{}}
+}
+
+// ReadAt reads from a piece, pieces never written read as zeros
+func (s *MemoryStorage) ReadAt(index int, p []byte, begin int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(index, begin, len(p)); err != nil {
+		return 0, err
+	}
+	piece, ok := s.pieces[index]
+	if !ok {
+		clear(p)
+		return len(p), nil
+	}
+	return copy(p, piece[begin:]), nil
+}
+
+func (s *MemoryStorage) WriteAt(index int, p []byte, begin int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(index, begin, len(p)); err != nil {
+		return 0, err
+	}
+	piece, ok := s.pieces[index]
+	if !ok {
+		piece = make([]byte, s.torrentFile.PieceSize(index))
+		s.pieces[index] = piece
+	}
+	return copy(piece[begin:], p), nil
+}
+
+func (s *MemoryStorage) MarkComplete(index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(index, 0, 0); err != nil {
+		return err
+	}
+	s.complete[index] = true
+	return nil
+}
+
+// Complete reports whether MarkComplete was called for a piece
+func (s *MemoryStorage) Complete(index int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.complete[index]
+}
+
+func (s *MemoryStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *MemoryStorage) check(index int, begin int64, length int) error {
+	if s.closed {
+		return fs.ErrClosed
+	}
+	if index < 0 || index >= len(s.torrentFile.Pieces) || begin < 0 || begin+int64(length) > int64(s.torrentFile.PieceSize(index)) {
+		return ErrOutOfRange
+	}
+	return nil
+}
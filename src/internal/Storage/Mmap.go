@@ -0,0 +1,134 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ============ Struct Defs  ============ //
+
+/*
+MmapStorage maps every file of a torrent into memory, reads and writes are plain copies and
+the kernel decides when pages reach the disk. Files are created and sized up front as a mapping
+cannot grow, the address space needed is the size of the torrent so it suits 64 bit hosts
+*/
+type MmapStorage struct {
+	dir    string
+	layout *layout
+
+	mu       sync.RWMutex // writers hold it to unmap on Close
+	mappings [][]byte     // nil for zero length files
+	closed   bool
+}
+
+// ============ Method Defs  ============ //
+
+func NewMmapStorage(torrentFile *torrent.TorrentFile, dir string) (*MmapStorage, error) {
+	layout, err := newLayout(torrentFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &MmapStorage{dir: dir, layout: layout, mappings: make([][]byte, len(layout.files))}
+	for i, file := range layout.files {
+		mapping, err := mapFile(filepath.Join(dir, file.Path), file.Length)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.mappings[i] = mapping
+	}
+	return s, nil
+}
+
+// mapFile creates the file at its full length and maps it shared, so writes land in the file
+func mapFile(path string, length int64) ([]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping stays valid once the descriptor is closed
+	defer f.Close()
+
+	if err := f.Truncate(length); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(length), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+// Dir is the directory the files of the torrent are kept under
+func (s *MmapStorage) Dir() string {
+	return s.dir
+}
+
+func (s *MmapStorage) ReadAt(index int, p []byte, begin int64) (int, error) {
+	return s.copySpans(index, p, begin, func(mapping []byte, sp span) {
+		copy(p[sp.begin:sp.begin+sp.length], mapping[sp.offset:])
+	})
+}
+
+func (s *MmapStorage) WriteAt(index int, p []byte, begin int64) (int, error) {
+	return s.copySpans(index, p, begin, func(mapping []byte, sp span) {
+		copy(mapping[sp.offset:], p[sp.begin:sp.begin+sp.length])
+	})
+}
+
+func (s *MmapStorage) copySpans(index int, p []byte, begin int64, copyFn func([]byte, span)) (int, error) {
+	spans, err := s.layout.spans(index, begin, len(p))
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return 0, fs.ErrClosed
+	}
+
+	for _, sp := range spans {
+		copyFn(s.mappings[sp.file], sp)
+	}
+	return len(p), nil
+}
+
+// MarkComplete does nothing beyond checking the index, dirty pages are written back by the kernel
+// and at the latest when the storage is closed
+func (s *MmapStorage) MarkComplete(index int) error {
+	if index < 0 || index >= len(s.layout.torrentFile.Pieces) {
+		return ErrOutOfRange
+	}
+	return nil
+}
+
+func (s *MmapStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var errs []error
+	for i, mapping := range s.mappings {
+		if mapping != nil {
+			errs = append(errs, syscall.Munmap(mapping))
+		}
+		s.mappings[i] = nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || freebsd)
+
+package storage
+
+import (
+	"fmt"
+
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ErrMmapUnsupported occurs when creating an MmapStorage on a platform without mmap support
+var ErrMmapUnsupported = fmt.Errorf("mmap storage is not supported on this platform")
+
+// MmapStorage is unavailable on this platform, use FileStorage instead
+type MmapStorage struct {
+	FileStorage
+}
+
+func NewMmapStorage(torrentFile *torrent.TorrentFile, dir string) (*MmapStorage, error) {
+	return nil, ErrMmapUnsupported
+}
@@ -0,0 +1,9 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+
+func init() {
+	backends = append(backends, backend{"mmap", func(tf *torrent.TorrentFile, dir string) (Storage, error) { return NewMmapStorage(tf, dir) }, true})
+}
@@ -0,0 +1,140 @@
+// Package storage persists the pieces of a torrent, pieces are mapped onto the files of the torrent
+// so a backend only needs to read and write ranges of those files
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ============ Struct Defs  ============ //
+
+/*
+Storage holds the data of a torrent, offsets are relative to the start of a piece.
+Implementations must be safe for concurrent use, embedding applications may supply their own
+*/
+type Storage interface {
+	ReadAt(index int, p []byte, begin int64) (int, error)
+	WriteAt(index int, p []byte, begin int64) (int, error)
+	// MarkComplete is called once a piece has been verified and fully written
+	MarkComplete(index int) error
+	Close() error
+}
+
+// File is one file of a torrent, Offset is where it starts within the torrent data
+type File struct {
+	Path   string // relative to the storage directory
+	Offset int64
+	Length int64
+}
+
+// span is the part of a file a range of a piece covers
+type span struct {
+	file   int
+	offset int64 // within the file
+	begin  int   // within the range
+	length int
+}
+
+// layout maps piece ranges onto files
+type layout struct {
+	torrentFile *torrent.TorrentFile
+	files       []File
+}
+
+var (
+	// ErrOutOfRange occurs when a read or write does not fit in its piece
+	ErrOutOfRange = fmt.Errorf("range outside of piece")
+	// ErrInvalidPath occurs when a file path of the torrent would escape the storage directory
+	ErrInvalidPath = fmt.Errorf("invalid file path")
+)
+
+// ============ Method Defs  ============ //
+
+/*
+Files lists the files of a torrent in the order their data appears. A single file torrent
+is one file named after the torrent, a multi file torrent places its files in a directory
+named after the torrent
+*/
+func Files(torrentFile *torrent.TorrentFile) ([]File, error) {
+	if err := validatePaths(torrentFile); err != nil {
+		return nil, err
+	}
+	return files(torrentFile), nil
+}
+
+func files(torrentFile *torrent.TorrentFile) []File {
+	if len(torrentFile.Files) == 0 {
+		return []File{{Path: torrentFile.Name, Length: int64(torrentFile.Length)}}
+	}
+
+	res := make([]File, 0, len(torrentFile.Files))
+	var offset int64
+	for _, field := range torrentFile.Files {
+		res = append(res, File{
+			Path:   filepath.Join(append([]string{torrentFile.Name}, field.Path...)...),
+			Offset: offset,
+			Length: field.Length,
+		})
+		offset += field.Length
+	}
+	return res
+}
+
+// validatePaths makes sure every file of the torrent stays within the storage directory
+func validatePaths(torrentFile *torrent.TorrentFile) error {
+	if err := validateElement(torrentFile.Name); err != nil {
+		return err
+	}
+	for _, field := range torrentFile.Files {
+		if len(field.Path) == 0 {
+			return fmt.Errorf("%w - empty path", ErrInvalidPath)
+		}
+		for _, element := range field.Path {
+			if err := validateElement(element); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateElement rejects path elements that are empty or could step outside the directory
+func validateElement(element string) error {
+	if element == "" || element == "." || element == ".." || strings.ContainsAny(element, `/\`) || strings.ContainsRune(element, 0) {
+		return fmt.Errorf("%w - %q", ErrInvalidPath, element)
+	}
+	return nil
+}
+
+func newLayout(torrentFile *torrent.TorrentFile) (*layout, error) {
+	files, err := Files(torrentFile)
+	if err != nil {
+		return nil, err
+	}
+	return &layout{torrentFile: torrentFile, files: files}, nil
+}
+
+// spans returns the parts of files a range of a piece covers, zero length files are skipped
+func (l *layout) spans(index int, begin int64, length int) ([]span, error) {
+	if index < 0 || index >= len(l.torrentFile.Pieces) || begin < 0 || begin+int64(length) > int64(l.torrentFile.PieceSize(index)) {
+		return nil, fmt.Errorf("%w - piece %d begin %d length %d", ErrOutOfRange, index, begin, length)
+	}
+
+	start := int64(index)*int64(l.torrentFile.PieceLength) + begin
+	end := start + int64(length)
+
+	var res []span
+	for i, file := range l.files {
+		fileEnd := file.Offset + file.Length
+		if file.Length == 0 || fileEnd <= start || file.Offset >= end {
+			continue
+		}
+		from, to := max(start, file.Offset), min(end, fileEnd)
+		res = append(res, span{file: i, offset: from - file.Offset, begin: int(from - start), length: int(to - from)})
+	}
+	return res, nil
+}
@@ -0,0 +1,318 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// backend creates a storage under dir, mmap is added on platforms that support it
+type backend struct {
+	name   string
+	create func(tf *torrent.TorrentFile, dir string) (Storage, error)
+	onDisk bool
+}
+
+var backends = []backend{
+	{"file", func(tf *torrent.TorrentFile, dir string) (Storage, error) { return NewFileStorage(tf, dir) }, true},
+	{"memory", func(tf *torrent.TorrentFile, dir string) (Storage, error) { return NewMemoryStorage(tf), nil }, false},
+}
+
+// multiFile is a torrent of 3 pieces of 10 bytes over files of 4, 0, 15 and 6 bytes, the last piece is short
+func multiFile() *torrent.TorrentFile {
+	return &torrent.TorrentFile{
+		Name:        "multi",
+		PieceLength: 10,
+		Pieces:      make([][20]byte, 3),
+		Files: []torrent.TorrentFileField{
+			{Path: []string{"a.txt"}, Length: 4},
+			{Path: []string{"empty"}, Length: 0},
+			{Path: []string{"sub", "b.txt"}, Length: 15},
+			{Path: []string{"c.txt"}, Length: 6},
+		},
+	}
+}
+
+func TestFiles(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		torrentFile *torrent.TorrentFile
+		expected    []File
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"single file", &torrent.TorrentFile{Name: "file.iso", Length: 100}, []File{{"file.iso", 0, 100}}, false},
+		{"multi file", multiFile(), []File{
+			{"multi/a.txt", 0, 4},
+			{"multi/empty", 4, 0},
+			{filepath.Join("multi", "sub", "b.txt"), 4, 15},
+			{"multi/c.txt", 19, 6},
+		}, false},
+		{"parent directory", &torrent.TorrentFile{Name: "x", Files: []torrent.TorrentFileField{{Path: []string{"..", "evil"}, Length: 1}}}, nil, true},
+		{"separator in element", &torrent.TorrentFile{Name: "x", Files: []torrent.TorrentFileField{{Path: []string{"a/../../evil"}, Length: 1}}}, nil, true},
+		{"empty path", &torrent.TorrentFile{Name: "x", Files: []torrent.TorrentFileField{{Length: 1}}}, nil, true},
+		{"bad name", &torrent.TorrentFile{Name: "..", Length: 1}, nil, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := Files(tc.torrentFile)
+			if tc.throwsError {
+				if !errors.Is(err, ErrInvalidPath) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSpans(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		index       int
+		begin       int64
+		length      int
+		expected    []span
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"across files", 0, 0, 10, []span{{0, 0, 0, 4}, {2, 0, 4, 6}}, false},
+		{"within a file", 1, 2, 5, []span{{2, 8, 0, 5}}, false},
+		{"short last piece", 2, 0, 5, []span{{3, 1, 0, 5}}, false},
+		{"past the piece", 2, 1, 5, nil, true},
+		{"negative begin", 0, -1, 1, nil, true},
+		{"unknown piece", 3, 0, 1, nil, true},
+	}
+
+	layout, err := newLayout(multiFile())
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot build layout - %s", err)
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := layout.spans(tc.index, tc.begin, tc.length)
+			if tc.throwsError && !errors.Is(err, ErrOutOfRange) {
+				t.Fatalf("Expected an error did not recieve any")
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBackends(t *testing.T) {
+	tf := multiFile()
+	data := make([]byte, 25)
+	rand.Read(data)
+
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := b.create(tf, dir)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+
+			// pieces are written out of order, the first in two halves
+			if _, err := store.WriteAt(2, data[20:], 0); err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			store.WriteAt(0, data[5:10], 5)
+			store.WriteAt(0, data[:5], 0)
+			store.WriteAt(1, data[10:20], 0)
+			for index := range 3 {
+				if err := store.MarkComplete(index); err != nil {
+					t.Fatalf("An error was thrown none expected, %v", err)
+				}
+			}
+
+			if _, err := store.WriteAt(2, make([]byte, 6), 0); !errors.Is(err, ErrOutOfRange) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrOutOfRange)
+			}
+
+			got := make([]byte, 0, len(data))
+			for index := range 3 {
+				buf := make([]byte, tf.PieceSize(index))
+				if _, err := store.ReadAt(index, buf, 0); err != nil {
+					t.Fatalf("An error was thrown none expected, %v", err)
+				}
+				got = append(got, buf...)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("Got and want are not equal\nGOT:%x\nWANT:%x\n", got, data)
+			}
+
+			if err := store.Close(); err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+			}
+			if _, err := store.ReadAt(0, make([]byte, 1), 0); !errors.Is(err, fs.ErrClosed) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, fs.ErrClosed)
+			}
+
+			if !b.onDisk {
+				return
+			}
+			files, _ := Files(tf)
+			var onDisk []byte
+			for _, file := range files {
+				content, err := os.ReadFile(filepath.Join(dir, file.Path))
+				if err != nil {
+					t.Fatalf("An error was thrown none expected, %v", err)
+				}
+				onDisk = append(onDisk, content...)
+			}
+			if !bytes.Equal(onDisk, data) {
+				t.Errorf("Got and want are not equal\nGOT:%x\nWANT:%x\n", onDisk, data)
+			}
+		})
+	}
+}
+
+func TestFileStorageLazyCreation(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStorage(multiFile(), dir)
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	defer store.Close()
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("files created before any write, %v", entries)
+	}
+	if _, err := store.ReadAt(0, make([]byte, 4), 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, fs.ErrNotExist)
+	}
+
+	// only the file written to exists, sized to its full length
+	store.WriteAt(1, []byte{1}, 5)
+	info, err := os.Stat(filepath.Join(dir, "multi", "sub", "b.txt"))
+	if err != nil || info.Size() != 15 {
+		t.Errorf("Got and want are not equal\nGOT:%v %v\nWANT:15 bytes\n", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "multi", "a.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("file created without being written, %v", err)
+	}
+}
+
+func TestFileStorageMarkComplete(t *testing.T) {
+	// piece 0 ends where b starts, the empty file sits on the same boundary
+	tf := &torrent.TorrentFile{
+		Name:        "edge",
+		PieceLength: 10,
+		Pieces:      make([][20]byte, 2),
+		Files: []torrent.TorrentFileField{
+			{Path: []string{"a"}, Length: 10},
+			{Path: []string{"empty"}, Length: 0},
+			{Path: []string{"b"}, Length: 10},
+		},
+	}
+	dir := t.TempDir()
+	store, err := NewFileStorage(tf, dir)
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot create storage - %s", err)
+	}
+	defer store.Close()
+
+	store.WriteAt(0, make([]byte, 10), 0)
+	if err := store.MarkComplete(0); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "edge", "empty")); err != nil {
+		t.Errorf("the empty file on the boundary was not created, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "edge", "b")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("the file after the boundary was created, %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tf := multiFile()
+	data := make([]byte, 25)
+	rand.Read(data)
+	for index := range tf.Pieces {
+		begin := index * int(tf.PieceLength)
+		tf.Pieces[index] = sha1.Sum(data[begin:min(begin+int(tf.PieceLength), len(data))])
+	}
+
+	type TestCase struct {
+		testname string
+		written  []int // pieces written, piece 1 is written corrupt when listed twice
+		expected []byte
+		verified []int64 // per file
+	}
+
+	testcases := []TestCase{
+		{"nothing written", nil, []byte{0x00}, []int64{0, 0, 0, 0}},
+		{"every piece", []int{0, 1, 2}, []byte{0xe0}, []int64{4, 0, 15, 6}},
+		{"first and last", []int{0, 2}, []byte{0xa0}, []int64{4, 0, 6, 5}},
+		{"corrupt middle piece", []int{0, 1, 1, 2}, []byte{0xa0}, []int64{4, 0, 6, 5}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			store, err := NewFileStorage(tf, t.TempDir())
+			if err != nil {
+				t.Fatalf("DEV ERR: cannot create storage - %s", err)
+			}
+			defer store.Close()
+
+			for i, index := range tc.written {
+				begin := index * int(tf.PieceLength)
+				piece := append([]byte{}, data[begin:begin+int(tf.PieceSize(index))]...)
+				if i > 0 && tc.written[i-1] == index {
+					piece[0] ^= 0xff
+				}
+				store.WriteAt(index, piece, 0)
+			}
+
+			dir := store.Dir()
+			before, _ := os.ReadDir(filepath.Join(dir, "multi"))
+			got, err := Verify(context.Background(), store, tf, 2)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if after, _ := os.ReadDir(filepath.Join(dir, "multi")); len(after) != len(before) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", after, before)
+			}
+			if !bytes.Equal(got.Bitfield, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%08b\nWANT:%08b\n", got.Bitfield, tc.expected)
+			}
+
+			var verified []int64
+			var left int64
+			for _, file := range got.Files {
+				verified = append(verified, file.Verified)
+				left += file.Length - file.Verified
+			}
+			if !reflect.DeepEqual(verified, tc.verified) || got.Left() != uint64(left) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", verified, tc.verified)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Verify(ctx, NewMemoryStorage(tf), tf, 1); err != context.Canceled {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, context.Canceled)
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha1"
+	"runtime"
+	"sync"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ============ Struct Defs  ============ //
+
+// FileProgress is how much of a file is covered by pieces that passed verification
+type FileProgress struct {
+	File
+	Verified int64 // bytes
+}
+
+// VerifyResult is the state of the data found in a storage
+type VerifyResult struct {
+	Bitfield peers.Bitfield // pieces matching their hash
+	Files    []FileProgress
+}
+
+// ============ Method Defs  ============ //
+
+/*
+Verify hashes every piece held by store against the hashes of torrentFile, spreading the work
+over workers goroutines, 0 uses one per cpu. Pieces that cannot be read, such as those of
+files never created, count as missing rather than failing the verification. store is only
+read from, nothing is created on disk
+*/
+func Verify(ctx context.Context, store Storage, torrentFile *torrent.TorrentFile, workers int) (*VerifyResult, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	numPieces := len(torrentFile.Pieces)
+
+	indexes := make(chan int)
+	good := make([]bool, numPieces) // each index is written by a single worker
+	var wg sync.WaitGroup
+	for range min(workers, max(numPieces, 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, torrentFile.PieceLength)
+			for index := range indexes {
+				piece := buf[:torrentFile.PieceSize(index)]
+				if _, err := store.ReadAt(index, piece, 0); err != nil {
+					continue
+				}
+				good[index] = sha1.Sum(piece) == torrentFile.Pieces[index]
+			}
+		}()
+	}
+
+feed:
+	for index := range numPieces {
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	res := &VerifyResult{Bitfield: peers.MakeBitfield(numPieces)}
+	for _, file := range files(torrentFile) {
+		res.Files = append(res.Files, FileProgress{File: file})
+	}
+
+	for index, ok := range good {
+		if !ok {
+			continue
+		}
+		res.Bitfield.SetPiece(index)
+
+		start := int64(index) * int64(torrentFile.PieceLength)
+		end := start + int64(torrentFile.PieceSize(index))
+		for i := range res.Files {
+			file := &res.Files[i]
+			file.Verified += max(0, min(end, file.Offset+file.Length)-max(start, file.Offset))
+		}
+	}
+
+	return res, nil
+}
+
+// Left is the number of bytes not covered by verified pieces, what trackers are told is left to download
+func (r *VerifyResult) Left() uint64 {
+	var res int64
+	for _, file := range r.Files {
+		res += file.Length - file.Verified
+	}
+	return uint64(res)
+}
@@ -22,6 +22,8 @@ type TorrentFile struct {
 	Pieces       [][20]byte
 	Length       uint64
 	Files        []TorrentFileField
+	Private      bool     // BEP 27, peers may only come from the trackers of the torrent
+	Nodes        []string // BEP 5, host:port of dht nodes to bootstrap from in trackerless torrents
 }
 
 // ============ Raw Data Structs  ============ //
@@ -33,6 +35,7 @@ type RawTorrentInfo struct {
 	PieceLength int64              `bencode:"piece length" json:"piece length"`
 	Piece       string             `bencode:"pieces" json:"pieces"`
 	Files       []TorrentFileField `bencode:"files" json:"files"`
+	Private     int64              `bencode:"private" json:"private"`
 }
 
 type TorrentFileField struct {
@@ -45,6 +48,7 @@ type RawTorrentData struct {
 	Announce     string         `bencode:"announce" json:"announce"`
 	AnnounceList [][]any        `bencode:"announce list" json:"announce-list"`
 	CreationDate int64          `bencode:"creation date" json:"creation date"`
+	Nodes        [][]any        `bencode:"nodes" json:"nodes"` // [host, port] pairs
 	Info         RawTorrentInfo `bencode:"info" json:"info"`
 }
 
@@ -62,6 +66,31 @@ func (t *TorrentFile) IsMultiFile() bool {
 	panic("Torrentfile is neither SFM or MFM")
 }
 
+// TotalLength returns the length of all the data in the torrent, the sum of every file for MFM
+func (t *TorrentFile) TotalLength() uint64 {
+	if t.Length != 0 || len(t.Files) == 0 {
+		return t.Length
+	}
+
+	var res uint64
+	for _, file := range t.Files {
+		res += uint64(file.Length)
+	}
+	return res
+}
+
+// PieceSize returns the length of a piece, every piece is PieceLength bar the last which holds the remainder
+func (t *TorrentFile) PieceSize(index int) uint64 {
+	if index < 0 || index >= len(t.Pieces) {
+		return 0
+	}
+
+	if index < len(t.Pieces)-1 {
+		return t.PieceLength
+	}
+	return t.TotalLength() - uint64(index)*t.PieceLength
+}
+
 // BuildTrackerURL builds a tracker url given an announce url string
 func (t TorrentFile) BuildTrackerURL(announce string, peerID string, port uint16) (string, error) {
 	_, err := url.Parse(announce)
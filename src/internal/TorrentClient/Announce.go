@@ -0,0 +1,96 @@
+package torrentclient
+
+import (
+	"context"
+	"time"
+
+	tracker "github.com/firozt/go-torrent/src/internal/Tracker"
+)
+
+var (
+	// how often the announce loop looks for trackers whose interval passed
+	announceCheckInterval = time.Second
+	// stopped announces are best effort, a tracker that is down must not hold up closing
+	stoppedAnnounceTimeout = 5 * time.Second
+	// bounds a single announce, udp trackers retransmit a few times within it
+	announceTimeout = 2 * time.Minute
+	// used when a tracker sends no interval, trackers asking for less than minAnnounceInterval are not announced to more often
+	defaultAnnounceInterval = 30 * time.Minute
+	minAnnounceInterval     = time.Minute
+)
+
+// ========== Method Defs =========== //
+
+// announceLoop announces a torrent again to every tracker whose NextAnnounce passed, so they keep
+// handing out peers and learn our transfer totals, until the torrent is stopped
+func (t *TorrentClient) announceLoop(active *activeTorrent) {
+	ticker := time.NewTicker(announceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-active.stopped:
+			return
+		case <-ticker.C:
+			t.announceTorrent(context.Background(), active, tracker.EventNone, t.shouldAnnounce)
+		}
+	}
+}
+
+// announceTorrent announces event for a torrent to each of its trackers due says should be contacted and connects
+// to the peers they return, the errors of the trackers that failed are returned
+func (t *TorrentClient) announceTorrent(ctx context.Context, active *activeTorrent, event tracker.Event, due func(infoHash [20]byte, announceURL string) bool) []error {
+	var errs []error
+	for _, announceURL := range active.torrentFile.Announce {
+		if !due(active.torrentFile.InfoHash, announceURL) {
+			continue
+		}
+		// a stopped torrent only has its stopped event left to send
+		select {
+		case <-active.stopped:
+			if event != tracker.EventStopped {
+				return errs
+			}
+		default:
+		}
+
+		resp, err := t.announce(ctx, announceURL, &active.torrentFile, event)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if event == tracker.EventStopped {
+			continue
+		}
+
+		found, err := resp.GetPeers()
+		if err != nil {
+			continue
+		}
+		for _, peer := range *found {
+			t.addKnownPeer(active, peer)
+			go t.PeerHandshakeProtocol(peer, active.torrentFile.InfoHash)
+		}
+	}
+	return errs
+}
+
+// announceStopped tells the trackers of a torrent Download announced that it stopped, only the first call does
+func (t *TorrentClient) announceStopped(active *activeTorrent) {
+	t.mu.Lock()
+	announcing := active.announcing
+	active.announcing = false
+	t.mu.Unlock()
+	if !announcing {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stoppedAnnounceTimeout)
+	defer cancel()
+	t.announceTorrent(ctx, active, tracker.EventStopped, t.notBackingOff)
+}
+
+// notBackingOff is used for the completed and stopped events, they are sent regardless of the interval
+func (t *TorrentClient) notBackingOff(infoHash [20]byte, announceURL string) bool {
+	return !t.backingOff(infoHash, announceURL)
+}
@@ -0,0 +1,229 @@
+package torrentclient
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// ========== Struct Defs =========== //
+
+// BanPolicy decides how long a peer that sent data failing a hash check is banned for
+type BanPolicy struct {
+	Duration       time.Duration // ban of a first offence, doubled for every further one
+	PermanentAfter int           // offences after which the ban never expires, 0 never bans permanently
+}
+
+func DefaultBanPolicy() BanPolicy {
+	return BanPolicy{Duration: time.Hour, PermanentAfter: 3}
+}
+
+// ban is a banned ip, bans that expired are kept so repeat offences are banned for longer
+type ban struct {
+	until    time.Time // zero for a permanent ban
+	offences int
+}
+
+// banFile is the bencoded ban list kept in the resume directory
+type banFile struct {
+	Bans []banEntry `bencode:"bans"`
+}
+
+type banEntry struct {
+	IP       string `bencode:"ip"`
+	Until    int64  `bencode:"until"` // unix seconds, 0 for a permanent ban
+	Offences int64  `bencode:"offences"`
+}
+
+// the ban list is saved next to the resume data of the torrents
+const banFileName = "bans"
+
+var (
+	// ErrBanned occurs when connecting to or accepting a banned peer
+	ErrBanned = fmt.Errorf("peer is banned")
+	// ErrCorruptBanList occurs when a saved ban list cannot be decoded
+	ErrCorruptBanList = fmt.Errorf("corrupt ban list")
+)
+
+// ========== Method Defs =========== //
+
+// SetBanPolicy changes how long peers found sending bad data are banned, bans already made keep their expiry
+func (t *TorrentClient) SetBanPolicy(policy BanPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.banPolicy = policy
+}
+
+// BanPeer bans ip for duration, 0 bans it permanently. Every connection from ip is closed
+func (t *TorrentClient) BanPeer(ip net.IP, duration time.Duration) error {
+	t.mu.Lock()
+	b := t.banFor(ip)
+	b.offences++
+	b.until = time.Time{}
+	if duration > 0 {
+		b.until = time.Now().Add(duration)
+	}
+	t.disconnectLocked(ip)
+	t.mu.Unlock()
+
+	return t.saveBans()
+}
+
+// Unban lifts the ban of ip and forgets its offences
+func (t *TorrentClient) Unban(ip net.IP) error {
+	t.mu.Lock()
+	delete(t.bans, ip.String())
+	t.mu.Unlock()
+
+	return t.saveBans()
+}
+
+// Banned reports whether connections to and from ip are refused
+func (t *TorrentClient) Banned(ip net.IP) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bannedLocked(ip)
+}
+
+func (t *TorrentClient) bannedLocked(ip net.IP) bool {
+	b, ok := t.bans[ip.String()]
+	return ok && (b.until.IsZero() || time.Now().Before(b.until))
+}
+
+// banFor returns the ban entry of ip, creating one that is not yet in effect, callers hold the client lock
+func (t *TorrentClient) banFor(ip net.IP) *ban {
+	if t.bans == nil {
+		t.bans = map[string]*ban{}
+	}
+	b, ok := t.bans[ip.String()]
+	if !ok {
+		b = &ban{}
+		t.bans[ip.String()] = b
+	}
+	return b
+}
+
+// punish bans ip for one more offence as the BanPolicy asks, callers hold the client lock
+func (t *TorrentClient) punish(ip net.IP) {
+	b := t.banFor(ip)
+	b.offences++
+	if t.banPolicy.PermanentAfter > 0 && b.offences >= t.banPolicy.PermanentAfter {
+		b.until = time.Time{}
+		return
+	}
+	b.until = time.Now().Add(t.banPolicy.Duration << min(b.offences-1, 16))
+}
+
+// badPeer bans a peer the engine found sending bad data. It is called on the engine goroutine, the
+// ban is made from its own goroutine as saving the ban list writes to disk
+func (t *TorrentClient) badPeer(active *activeTorrent, conn *peers.PeerConn) {
+	conn.Close()
+	go func() {
+		ip := remoteIP(conn.RemoteAddr())
+		if ip == nil {
+			return
+		}
+
+		t.mu.Lock()
+		t.punish(ip)
+		t.logPeer("peer banned", active, conn)
+		t.disconnectLocked(ip)
+		t.mu.Unlock()
+
+		t.saveBans()
+	}()
+}
+
+// disconnectLocked closes every connection from ip, callers hold the client lock
+func (t *TorrentClient) disconnectLocked(ip net.IP) {
+	for _, active := range t.torrents {
+		for conn := range active.conns {
+			if remoteIP(conn.RemoteAddr()).Equal(ip) {
+				conn.Close()
+			}
+		}
+	}
+}
+
+// saveBans writes the ban list to the resume directory, nothing is saved when fast resume is disabled
+func (t *TorrentClient) saveBans() error {
+	t.mu.Lock()
+	resumeDir := t.resumeDir
+	file := banFile{Bans: make([]banEntry, 0, len(t.bans))}
+	for ip, b := range t.bans {
+		entry := banEntry{IP: ip, Offences: int64(b.offences)}
+		if !b.until.IsZero() {
+			entry.Until = b.until.Unix()
+		}
+		file.Bans = append(file.Bans, entry)
+	}
+	t.mu.Unlock()
+
+	if resumeDir == "" {
+		return nil
+	}
+	encoded, err := bencodeparser.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(resumeDir, 0o755); err != nil {
+		return err
+	}
+
+	// through a temporary file so a crash never leaves a partial list behind
+	path := filepath.Join(resumeDir, banFileName)
+	if err := os.WriteFile(path+".tmp", encoded, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// loadBans adds the ban list saved in dir to the bans made so far, a missing list is not an error
+func (t *TorrentClient) loadBans(dir string) error {
+	raw, err := os.ReadFile(filepath.Join(dir, banFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ir, err := bencodeparser.DecodeBytes(raw)
+	if err != nil {
+		return fmt.Errorf("%w - %s", ErrCorruptBanList, err)
+	}
+	dict, ok := ir.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w - not a dictionary", ErrCorruptBanList)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries, _ := dict["bans"].([]any)
+	for _, raw := range entries {
+		entry, _ := raw.(map[string]any)
+		ipString, _ := entry["ip"].(string)
+		ip := net.ParseIP(ipString)
+		if ip == nil {
+			continue
+		}
+		if _, ok := t.bans[ip.String()]; ok {
+			continue
+		}
+
+		b := t.banFor(ip)
+		offences, _ := entry["offences"].(int64)
+		b.offences = int(offences)
+		if until, _ := entry["until"].(int64); until != 0 {
+			b.until = time.Unix(until, 0)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package torrentclient
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+)
+
+func TestBanPolicy(t *testing.T) {
+	type TestCase struct {
+		testname  string
+		offences  int
+		duration  time.Duration // 0 for a permanent ban
+		permanent bool
+	}
+
+	testcases := []TestCase{
+		{"first offence", 1, time.Hour, false},
+		{"second offence doubles", 2, 2 * time.Hour, false},
+		{"third offence is permanent", 3, 0, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			client := NewTorrentClient(0)
+			defer client.Close()
+			ip := net.IPv4(10, 0, 0, 1)
+
+			client.mu.Lock()
+			for range tc.offences {
+				client.punish(ip)
+			}
+			until := client.bans[ip.String()].until
+			client.mu.Unlock()
+
+			if until.IsZero() != tc.permanent {
+				t.Errorf("Got and want are not equal\nGOT:permanent %v\nWANT:%v\n", until.IsZero(), tc.permanent)
+			}
+			if left := time.Until(until); !tc.permanent && (left > tc.duration || left < tc.duration-time.Minute) {
+				t.Errorf("Got and want are not equal\nGOT:%s\nWANT:%s\n", left, tc.duration)
+			}
+			if !client.Banned(ip) {
+				t.Errorf("the peer is not banned")
+			}
+		})
+	}
+}
+
+func TestBanList(t *testing.T) {
+	dir := t.TempDir()
+	temporary, permanent, expiring := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)
+
+	client := NewTorrentClient(0)
+	if err := client.SetResumeDir(dir); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	client.BanPeer(temporary, time.Hour)
+	client.BanPeer(permanent, 0)
+	client.BanPeer(expiring, 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if client.Banned(expiring) {
+		t.Errorf("an expired ban is still in effect")
+	}
+	if client.Banned(net.IPv4(10, 0, 0, 4)) {
+		t.Errorf("a peer never banned is banned")
+	}
+	client.Close()
+
+	// the list survives a restart
+	restarted := NewTorrentClient(0)
+	defer restarted.Close()
+	if err := restarted.SetResumeDir(dir); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if !restarted.Banned(temporary) || !restarted.Banned(permanent) || restarted.Banned(expiring) {
+		t.Errorf("Got and want are not equal\nGOT:%v %v %v\nWANT:true true false\n", restarted.Banned(temporary), restarted.Banned(permanent), restarted.Banned(expiring))
+	}
+	if b := restarted.bans[expiring.String()]; b == nil || b.offences != 1 {
+		t.Errorf("the offences of an expired ban were forgotten, %+v", b)
+	}
+
+	restarted.Unban(permanent)
+	if restarted.Banned(permanent) {
+		t.Errorf("an unbanned peer is still banned")
+	}
+}
+
+func TestBadPeerBanned(t *testing.T) {
+	TF, data := randomTorrent(64 * 1024)
+	corrupt := append([]byte{}, data...)
+	corrupt[0] ^= 0xff
+	_, addr := startSeederClient(t, TF, corrupt)
+
+	dir := t.TempDir()
+	leecher := NewTorrentClient(0)
+	leecher.SetResumeDir(dir)
+	engine := leecher.AddTorrent(TF, storage.NewMemoryStorage(&TF), nil)
+	peer := peers.NewPeer(addr.IP, uint16(addr.Port))
+	if _, err := leecher.PeerHandshakeProtocol(peer, TF.InfoHash); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !leecher.Banned(addr.IP) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !leecher.Banned(addr.IP) {
+		t.Fatalf("the peer sending bad data was not banned, stats %+v", engine.Stats())
+	}
+	if _, err := leecher.PeerHandshakeProtocol(peer, TF.InfoHash); !errors.Is(err, ErrBanned) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrBanned)
+	}
+	if stats := engine.Stats(); stats.BadPeers != 1 || stats.HashFailures == 0 {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:a hash failure and a bad peer\n", stats)
+	}
+	leecher.Close()
+
+	restarted := NewTorrentClient(0)
+	defer restarted.Close()
+	restarted.SetResumeDir(dir)
+	if !restarted.Banned(addr.IP) {
+		t.Errorf("the ban was not kept across sessions")
+	}
+}
@@ -0,0 +1,95 @@
+package torrentclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	dht "github.com/firozt/go-torrent/src/internal/DHT"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// dhtAnnounceInterval is how often a downloading torrent looks up peers on the dht and announces itself
+var dhtAnnounceInterval = 15 * time.Minute
+
+// ErrDHTEnabled occurs when enabling the dht a second time
+var ErrDHTEnabled = fmt.Errorf("dht already enabled")
+
+// ========== Method Defs =========== //
+
+// EnableDHT starts a dht node used to find peers of torrents that are not private, the routing
+// table is persisted to config.StatePath when the client closes
+func (t *TorrentClient) EnableDHT(config dht.Config) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.dht != nil {
+		return ErrDHTEnabled
+	}
+	server, err := dht.NewServer(config)
+	if err != nil {
+		return err
+	}
+	t.dht = server
+	go server.Bootstrap(context.Background())
+	return nil
+}
+
+// dhtFor returns the dht node if it may be used for the torrent, it is nil for private ones as
+// torrent.TorrentFile.Private explains
+func (t *TorrentClient) dhtFor(active *activeTorrent) *dht.Server {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if active == nil || active.torrentFile.Private {
+		return nil
+	}
+	return t.dht
+}
+
+// onPort adds the dht node of a peer to the routing table, it runs on the engine goroutine
+func (t *TorrentClient) onPort(active *activeTorrent, conn *peers.PeerConn, port uint16) {
+	go func() {
+		server := t.dhtFor(active)
+		if ip := remoteIP(conn.RemoteAddr()); server != nil && ip != nil {
+			server.AddNode(&net.UDPAddr{IP: ip, Port: int(port)})
+		}
+	}()
+}
+
+// findDHTPeers bootstraps through the nodes of the torrent, then looks up its peers and announces
+// every dhtAnnounceInterval until the torrent is removed, like announceLoop it outlives the call to Download
+func (t *TorrentClient) findDHTPeers(active *activeTorrent, server *dht.Server) {
+	// lookups in flight are cancelled with the torrent
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-active.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if len(active.torrentFile.Nodes) > 0 || len(server.Nodes()) == 0 {
+		server.Bootstrap(ctx, active.torrentFile.Nodes...)
+	}
+
+	ticker := time.NewTicker(dhtAnnounceInterval)
+	defer ticker.Stop()
+
+	for {
+		found, _ := server.Announce(ctx, active.torrentFile.InfoHash, int(t.listenPort()))
+		for _, peer := range found {
+			t.addKnownPeer(active, peer)
+			go t.PeerHandshakeProtocol(peer, active.torrentFile.InfoHash)
+		}
+
+		select {
+		case <-active.stopped:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package torrentclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	dht "github.com/firozt/go-torrent/src/internal/DHT"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+)
+
+func localDHTConfig() dht.Config {
+	config := dht.DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+	config.BootstrapNodes = nil
+	config.QueryTimeout = time.Second
+	return config
+}
+
+func TestDownloadTrackerless(t *testing.T) {
+	TF, data := randomTorrent(64 * 1024)
+
+	// nothing to find peers through
+	client := NewTorrentClient(6881)
+	if err := client.Download(context.Background(), TF, storage.NewMemoryStorage(&TF)); !errors.Is(err, ErrNoPeerSources) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrNoPeerSources)
+	}
+	if registered(client, TF.InfoHash) {
+		t.Errorf("a torrent without peer sources was left running")
+	}
+	client.Close()
+
+	// the seeder announces itself to a node listed in the torrent
+	router, err := dht.NewServer(localDHTConfig())
+	if err != nil {
+		t.Fatalf("DEV ERR: unable to start dht node, %v", err)
+	}
+	defer router.Close()
+	TF.Nodes = []string{router.Addr().String()}
+
+	seederConfig := localDHTConfig()
+	seederConfig.BootstrapNodes = TF.Nodes
+	seeder, err := dht.NewServer(seederConfig)
+	if err != nil {
+		t.Fatalf("DEV ERR: unable to start dht node, %v", err)
+	}
+	defer seeder.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := seeder.Bootstrap(ctx); err != nil {
+		t.Fatalf("DEV ERR: unable to bootstrap, %v", err)
+	}
+	compact := startFakeSeeder(t, TF, data)
+	if _, err := seeder.Announce(ctx, TF.InfoHash, int(binary.BigEndian.Uint16(compact[4:]))); err != nil {
+		t.Fatalf("DEV ERR: unable to announce, %v", err)
+	}
+
+	client = NewTorrentClient(6881)
+	defer client.Close()
+	if err := client.EnableDHT(localDHTConfig()); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if err := client.EnableDHT(localDHTConfig()); !errors.Is(err, ErrDHTEnabled) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrDHTEnabled)
+	}
+
+	store := storage.NewMemoryStorage(&TF)
+	if err := client.Download(ctx, TF, store); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if !bytes.Equal(readAll(store, TF), data) {
+		t.Errorf("downloaded data does not match the torrent data")
+	}
+}
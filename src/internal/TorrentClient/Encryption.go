@@ -0,0 +1,80 @@
+package torrentclient
+
+import (
+	"context"
+	"net"
+	"time"
+
+	mse "github.com/firozt/go-torrent/src/internal/MSE"
+)
+
+// ========== Struct Defs =========== //
+
+// EncryptionPolicy decides which peer connections are obfuscated with MSE, the zero value keeps every
+// connection plaintext
+type EncryptionPolicy struct {
+	Outgoing mse.Policy // prefer falls back to a plaintext connection when the peer does not answer the handshake
+	Incoming mse.Policy // prefer accepts both plaintext and encrypted connections
+}
+
+// ========== Method Defs =========== //
+
+func (t *TorrentClient) SetEncryption(policy EncryptionPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.encryption = policy
+}
+
+// connectPeer dials a peer of a torrent and encrypts the connection as the outgoing policy asks, the
+// BitTorrent handshake is then sent over the returned connection
+func (t *TorrentClient) connectPeer(ctx context.Context, address string, infoHash [20]byte) (net.Conn, error) {
+	conn, err := t.dialPeer(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	policy := t.encryption.Outgoing
+	t.mu.Unlock()
+	if policy == mse.PolicyDisabled {
+		return conn, nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	encrypted, err := mse.Initiate(conn, infoHash, policy)
+	if err == nil {
+		conn.SetDeadline(time.Time{})
+		return encrypted, nil
+	}
+	conn.Close()
+	if policy == mse.PolicyRequire {
+		return nil, err
+	}
+	// peers without MSE drop the connection when they cannot read a handshake, try again in plaintext
+	return t.dialPeer(ctx, address)
+}
+
+// acceptEncrypted reads the start of an incoming connection, completing the MSE handshake when the peer
+// starts one and the incoming policy allows it
+func (t *TorrentClient) acceptEncrypted(conn net.Conn) (net.Conn, error) {
+	t.mu.Lock()
+	policy := t.encryption.Incoming
+	infoHashes := make([][20]byte, 0, len(t.torrents))
+	for infoHash := range t.torrents {
+		infoHashes = append(infoHashes, infoHash)
+	}
+	t.mu.Unlock()
+
+	if policy == mse.PolicyDisabled {
+		return conn, nil
+	}
+	return mse.Accept(conn, infoHashes, policy)
+}
+
+// encrypted reports whether the MSE handshake was done on a connection
+func encrypted(conn net.Conn) bool {
+	c, ok := conn.(*mse.Conn)
+	return ok && c.Method() != 0
+}
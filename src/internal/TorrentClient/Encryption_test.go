@@ -0,0 +1,83 @@
+package torrentclient
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	mse "github.com/firozt/go-torrent/src/internal/MSE"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+)
+
+func TestEncryptionPolicies(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		outgoing    mse.Policy // of the leecher
+		incoming    mse.Policy // of the seeder
+		encrypted   bool
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"plaintext", mse.PolicyDisabled, mse.PolicyDisabled, false, false},
+		{"prefer both ways", mse.PolicyPrefer, mse.PolicyPrefer, true, false},
+		{"required and accepted", mse.PolicyRequire, mse.PolicyPrefer, true, false},
+		{"plaintext accepted", mse.PolicyDisabled, mse.PolicyPrefer, false, false},
+		{"prefer falls back to plaintext", mse.PolicyPrefer, mse.PolicyDisabled, false, false},
+		{"required but unsupported", mse.PolicyRequire, mse.PolicyDisabled, false, true},
+		{"plaintext refused", mse.PolicyDisabled, mse.PolicyRequire, false, true},
+	}
+
+	TF, data := randomTorrent(128 * 1024)
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			seeder, addr := startSeederClient(t, TF, data)
+			seeder.SetEncryption(EncryptionPolicy{Incoming: tc.incoming})
+
+			leecher := NewTorrentClient(0)
+			defer leecher.Close()
+			leecher.SetEncryption(EncryptionPolicy{Outgoing: tc.outgoing})
+			store := storage.NewMemoryStorage(&TF)
+			engine := leecher.AddTorrent(TF, store, nil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := leecher.connectPeer(ctx, addr.String(), TF.InfoHash)
+			var remote *peers.PeerHandshake
+			if err == nil {
+				// a seeder refusing the connection closes it instead of answering the handshake
+				remote, err = peers.Handshake(conn, leecher.handshake(TF.InfoHash), 2*time.Second)
+			}
+			if tc.throwsError {
+				if err == nil {
+					t.Errorf("Expected an error did not recieve any")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if encrypted(conn) != tc.encrypted {
+				t.Errorf("Got and want are not equal\nGOT:encrypted %v\nWANT:%v\n", encrypted(conn), tc.encrypted)
+			}
+
+			leecher.mu.Lock()
+			active := leecher.torrents[TF.InfoHash]
+			leecher.mu.Unlock()
+			if _, err := leecher.addConn(active, conn, remote); err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			select {
+			case <-engine.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("download did not complete")
+			}
+			if !bytes.Equal(readAll(store, TF), data) {
+				t.Errorf("downloaded data does not match the torrent data")
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package torrentclient
+
+import (
+	"fmt"
+
+	extension "github.com/firozt/go-torrent/src/internal/Extension"
+	pex "github.com/firozt/go-torrent/src/internal/PEX"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// pexConfig is used by every torrent that is not private
+var pexConfig = pex.DefaultConfig()
+
+// ========== Method Defs =========== //
+
+// handshake builds our handshake for a torrent advertising the capabilities the client supports
+func (t *TorrentClient) handshake(infoHash [20]byte) *peers.PeerHandshake {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := peers.NewBitTorrentProtocolHandshake(infoHash, t.peerID)
+	h.SetCapability(peers.CapabilityExtensions)
+	h.SetCapability(peers.CapabilityFast)
+	if active, ok := t.torrents[infoHash]; t.dht != nil && (!ok || !active.torrentFile.Private) {
+		h.SetCapability(peers.CapabilityDHT)
+	}
+	return h
+}
+
+// RegisterExtension adds a BEP 10 extension to a torrent, peers already connected are sent a new
+// extended handshake advertising it
+func (t *TorrentClient) RegisterExtension(infoHash [20]byte, ext extension.Extension) error {
+	t.mu.Lock()
+	active, ok := t.torrents[infoHash]
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w - %x", ErrUnknownInfoHash, infoHash)
+	}
+	return active.extensions.Register(ext)
+}
+
+// connectFound connects to peers learnt through pex, the connection limits keep a flood in check
+func (t *TorrentClient) connectFound(active *activeTorrent, found []pex.Found) {
+	for _, f := range found {
+		t.addKnownPeer(active, f.Peer)
+		go t.PeerHandshakeProtocol(f.Peer, active.torrentFile.InfoHash)
+	}
+}
+
+func (t *TorrentClient) hasKnownPeers(active *activeTorrent) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(active.knownPeers) > 0
+}
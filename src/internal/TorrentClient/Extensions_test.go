@@ -0,0 +1,75 @@
+package torrentclient
+
+import (
+	"net"
+	"testing"
+
+	pex "github.com/firozt/go-torrent/src/internal/PEX"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+func TestPEXPrivateTorrents(t *testing.T) {
+	type TestCase struct {
+		testname string
+		private  bool
+		expected bool // ut_pex offered
+	}
+
+	testcases := []TestCase{
+		{"public", false, true},
+		{"private", true, false},
+	}
+
+	for i, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			client := NewTorrentClient(6881)
+			defer client.Close()
+
+			tf := torrent.TorrentFile{Name: "file", InfoHash: [20]byte{byte(i + 1)}, PieceLength: 16, Length: 16, Pieces: make([][20]byte, 1), Private: tc.private}
+			client.AddTorrent(tf, storage.NewMemoryStorage(&tf), nil)
+
+			active := client.torrents[tf.InfoHash]
+			if got := active.pex != nil; got != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, tc.expected)
+			}
+			err := client.RegisterExtension(tf.InfoHash, pex.New(pex.DefaultConfig(), 1, nil))
+			if got := err != nil; got != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:registering ut_pex again failed %v\nWANT:%v\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestExtendedHandshakeLate(t *testing.T) {
+	TF, _ := randomTorrent(16 * 1024)
+	client := NewTorrentClient(0)
+	defer client.Close()
+
+	// the torrent is added before the port is bound and the client id is set
+	client.AddTorrent(TF, storage.NewMemoryStorage(&TF), nil)
+	addr, err := client.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot listen - %s", err)
+	}
+	if err := client.SetClientID("TR", "2940"); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := peers.NewPeerConn(local, peers.NewBitTorrentProtocolHandshake(TF.InfoHash, [20]byte{1}), len(TF.Pieces), make(chan peers.PeerEvent, 1), peers.DefaultPeerConnConfig())
+	defer conn.Close()
+
+	client.mu.Lock()
+	registry := client.torrents[TF.InfoHash].extensions
+	client.mu.Unlock()
+	h := registry.Handshake(conn)
+	if got, want := h.P, uint16(addr.(*net.TCPAddr).Port); got != want {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", got, want)
+	}
+	if h.V != "Transmission 2.94" {
+		t.Errorf("Got and want are not equal\nGOT:%q\nWANT:%q\n", h.V, "Transmission 2.94")
+	}
+}
@@ -0,0 +1,54 @@
+package torrentclient
+
+import (
+	"fmt"
+
+	lsd "github.com/firozt/go-torrent/src/internal/LSD"
+)
+
+// ErrLSDEnabled occurs when enabling local service discovery a second time
+var ErrLSDEnabled = fmt.Errorf("local service discovery already enabled")
+
+// ========== Method Defs =========== //
+
+// EnableLSD announces every torrent that is not private on the local network and connects to the
+// local peers announcing them
+func (t *TorrentClient) EnableLSD(config lsd.Config) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.lsd != nil {
+		return ErrLSDEnabled
+	}
+	discovery, err := lsd.New(config, t.port, func(found lsd.Found) { go t.connectLocal(found) })
+	if err != nil {
+		return err
+	}
+	t.lsd = discovery
+	for infoHash, active := range t.torrents {
+		if !active.torrentFile.Private {
+			discovery.Add(infoHash)
+		}
+	}
+	return nil
+}
+
+// connectLocal connects to a peer announcing one of our torrents on the local network
+func (t *TorrentClient) connectLocal(found lsd.Found) {
+	t.mu.Lock()
+	active, ok := t.torrents[found.InfoHash]
+	t.mu.Unlock()
+	if !ok || active.torrentFile.Private {
+		return
+	}
+
+	t.addKnownPeer(active, found.Peer)
+	t.PeerHandshakeProtocol(found.Peer, found.InfoHash)
+}
+
+// usesLSD reports whether local peers are looked for, never for private torrents (torrent.TorrentFile.Private)
+func (t *TorrentClient) usesLSD(active *activeTorrent) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lsd != nil && !active.torrentFile.Private
+}
@@ -0,0 +1,112 @@
+package torrentclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	lsd "github.com/firozt/go-torrent/src/internal/LSD"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// loopbackLSDConfig announces on the loopback interface to a free port so tests never reach the real network
+func loopbackLSDConfig(t *testing.T) lsd.Config {
+	t.Helper()
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface, %v", err)
+	}
+	free, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("DEV ERR: unable to listen, %v", err)
+	}
+	port := free.LocalAddr().(*net.UDPAddr).Port
+	free.Close()
+
+	config := lsd.DefaultConfig()
+	config.Group = net.JoinHostPort("239.192.152.143", strconv.Itoa(port))
+	config.Interface = lo
+	return config
+}
+
+func TestLSDPrivateTorrents(t *testing.T) {
+	config := loopbackLSDConfig(t)
+	group, _ := net.ResolveUDPAddr("udp4", config.Group)
+	listener, err := net.ListenMulticastUDP("udp4", config.Interface, group)
+	if err != nil {
+		t.Skipf("multicast unavailable, %v", err)
+	}
+	defer listener.Close()
+
+	client := NewTorrentClient(6881)
+	defer client.Close()
+	public := torrent.TorrentFile{Name: "public", InfoHash: [20]byte{1}, PieceLength: 16, Length: 16, Pieces: make([][20]byte, 1)}
+	private := torrent.TorrentFile{Name: "private", InfoHash: [20]byte{2}, PieceLength: 16, Length: 16, Pieces: make([][20]byte, 1), Private: true}
+	client.AddTorrent(private, storage.NewMemoryStorage(&private), nil)
+	if err := client.EnableLSD(config); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	client.AddTorrent(public, storage.NewMemoryStorage(&public), nil)
+
+	// only the public torrent is ever announced
+	listener.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	buf := make([]byte, 2048)
+	announces := 0
+	for {
+		n, _, err := listener.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		announces++
+		if bytes.Contains(buf[:n], []byte("0200000000000000000000000000000000000000")) {
+			t.Errorf("the private torrent was announced")
+		}
+		if !bytes.Contains(buf[:n], []byte("0100000000000000000000000000000000000000")) {
+			t.Errorf("Got and want are not equal\nGOT:%q\nWANT:an announce of the public torrent\n", buf[:n])
+		}
+	}
+	if announces != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%d announces\nWANT:1\n", announces)
+	}
+}
+
+func TestDownloadLocalPeers(t *testing.T) {
+	config := loopbackLSDConfig(t)
+	TF, data := randomTorrent(64 * 1024)
+
+	// the seeder keeps announcing until the client has the torrent added
+	compact := startFakeSeeder(t, TF, data)
+	seederConfig := config
+	seederConfig.Interval = 50 * time.Millisecond
+	seeder, err := lsd.New(seederConfig, binary.BigEndian.Uint16(compact[4:]), func(lsd.Found) {})
+	if err != nil {
+		t.Skipf("multicast unavailable, %v", err)
+	}
+	defer seeder.Close()
+	seeder.Add(TF.InfoHash)
+
+	client := NewTorrentClient(6881)
+	defer client.Close()
+	if err := client.EnableLSD(config); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if err := client.EnableLSD(config); !errors.Is(err, ErrLSDEnabled) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrLSDEnabled)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store := storage.NewMemoryStorage(&TF)
+	if err := client.Download(ctx, TF, store); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if !bytes.Equal(readAll(store, TF), data) {
+		t.Errorf("downloaded data does not match the torrent data")
+	}
+}
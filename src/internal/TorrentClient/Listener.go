@@ -0,0 +1,367 @@
+package torrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	choker "github.com/firozt/go-torrent/src/internal/Choker"
+	download "github.com/firozt/go-torrent/src/internal/Download"
+	extension "github.com/firozt/go-torrent/src/internal/Extension"
+	pex "github.com/firozt/go-torrent/src/internal/PEX"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// ========== Struct Defs =========== //
+
+// ConnLimits caps the number of open peer connections, incoming and outgoing alike
+type ConnLimits struct {
+	Global     int // across every torrent
+	PerTorrent int
+}
+
+func DefaultConnLimits() ConnLimits {
+	return ConnLimits{Global: 200, PerTorrent: 50}
+}
+
+// activeTorrent is a torrent the client is serving, incoming connections are routed to its engine by info hash
+type activeTorrent struct {
+	torrentFile torrent.TorrentFile
+	engine      *download.Engine
+	stop        context.CancelFunc // stops the engine, closing its connections
+	stopped     <-chan struct{}    // closed once stop is called
+	conns       map[*peers.PeerConn]struct{}
+	pending     map[[20]byte]struct{} // peer ids of connections being handed to the engine, counted against the limits
+	dialing     map[string]struct{}   // addresses being connected to
+	store       storage.Storage
+	extensions  *extension.Registry
+	pex         *pex.PEX // nil for private torrents
+	announcing  bool     // set once Download announces to the trackers, they are told when it stops
+
+	// carried over between runs with resume data
+	knownPeers map[string]peers.Peer // keyed by address
+	uploaded   uint64                // totals of earlier runs
+	downloaded uint64
+}
+
+// the handshake must arrive promptly, anything slower is likely a port scan
+const inboundHandshakeTimeout = 10 * time.Second
+
+var (
+	// ErrUnknownInfoHash occurs when a peer asks for a torrent the client is not serving
+	ErrUnknownInfoHash = fmt.Errorf("unknown info hash")
+	// ErrSelfConnection occurs when a handshake carries our own peer id, e.g. when a tracker returns our address
+	ErrSelfConnection = fmt.Errorf("connected to self")
+	// ErrConnLimit occurs when accepting a connection would exceed the ConnLimits
+	ErrConnLimit = fmt.Errorf("connection limit reached")
+	// ErrAlreadyConnected occurs when connecting to a peer of a torrent that is already connected or being connected to
+	ErrAlreadyConnected = fmt.Errorf("already connected to peer")
+	// ErrNoPeerSources occurs when downloading a torrent without trackers, dht, local service discovery or known peers
+	ErrNoPeerSources = fmt.Errorf("no trackers, dht, local service discovery or known peers to find peers through")
+)
+
+// ========== Method Defs =========== //
+
+// AddTorrent registers a torrent and starts its download engine, verified pieces are written to store.
+// have lists the pieces store already holds, such as those found by storage.Verify, and may be nil.
+// Incoming connections for the torrent are accepted from here on
+func (t *TorrentClient) AddTorrent(torrentFile torrent.TorrentFile, store storage.Storage, have peers.Bitfield) *download.Engine {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.torrents == nil {
+		t.torrents = map[[20]byte]*activeTorrent{}
+	}
+	if active, ok := t.torrents[torrentFile.InfoHash]; ok {
+		return active.engine
+	}
+
+	config := download.DefaultConfig()
+	if t.newChoker != nil {
+		config.NewChoker = t.newChoker
+	}
+	// the port and client are read per handshake as torrents may be added before Listen binds the port
+	// or SetClientID is called
+	config.Extensions = extension.NewRegistry(extension.Config{
+		Reqq: config.MaxUploadQueue,
+		Fill: func(h *peers.ExtendedHandshake) { h.V, h.P = t.clientVersion(), t.listenPort() },
+	})
+	var active *activeTorrent
+	config.OnPort = func(conn *peers.PeerConn, port uint16) { t.onPort(active, conn, port) }
+	config.OnBadPeer = func(conn *peers.PeerConn) { t.badPeer(active, conn) }
+
+	active = &activeTorrent{
+		torrentFile: torrentFile,
+		engine:      download.NewEngine(&torrentFile, store, have, config),
+		conns:       map[*peers.PeerConn]struct{}{},
+		pending:     map[[20]byte]struct{}{},
+		dialing:     map[string]struct{}{},
+		store:       store,
+		extensions:  config.Extensions,
+	}
+	t.torrents[torrentFile.InfoHash] = active
+
+	ctx, cancel := context.WithCancel(context.Background())
+	active.stop, active.stopped = cancel, ctx.Done()
+	go active.engine.Run(ctx)
+
+	// no peer exchange for private torrents, see torrent.TorrentFile.Private
+	if !torrentFile.Private {
+		active.pex = pex.New(pexConfig, len(torrentFile.Pieces), func(found []pex.Found) { go t.connectFound(active, found) })
+		active.extensions.Register(active.pex)
+		go active.pex.Run(ctx)
+		if t.lsd != nil {
+			t.lsd.Add(torrentFile.InfoHash)
+		}
+	}
+
+	return active.engine
+}
+
+// RemoveTorrent closes the open connections of a torrent, tells its trackers it stopped and stops accepting connections for it
+func (t *TorrentClient) RemoveTorrent(infoHash [20]byte) {
+	t.mu.Lock()
+	active, ok := t.torrents[infoHash]
+	discovery := t.lsd
+	t.mu.Unlock()
+
+	if ok {
+		active.stop()
+		// still registered so the final totals are reported
+		t.announceStopped(active)
+		t.mu.Lock()
+		if t.torrents[infoHash] == active {
+			delete(t.torrents, infoHash)
+		}
+		t.mu.Unlock()
+	}
+	if discovery != nil {
+		discovery.Remove(infoHash)
+	}
+}
+
+// SetConnLimits replaces the connection limits, open connections above a new limit are kept
+func (t *TorrentClient) SetConnLimits(limits ConnLimits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connLimits = limits
+}
+
+// SetChoker replaces the choking policy of torrents added from here on, every torrent gets its own
+// choker from newChoker. Torrents use a choker.TitForTat by default
+func (t *TorrentClient) SetChoker(newChoker func() choker.Choker[*peers.PeerConn]) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.newChoker = newChoker
+}
+
+// NumConns returns the number of open peer connections for a torrent
+func (t *TorrentClient) NumConns(infoHash [20]byte) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if active, ok := t.torrents[infoHash]; ok {
+		return len(active.conns)
+	}
+	return 0
+}
+
+// listenPort returns the client port, the bound port once Listen was called with port 0
+func (t *TorrentClient) listenPort() uint16 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.port
+}
+
+// Listen accepts incoming peer connections on addr, an empty addr listens on the client port on
+// every interface. When the port is 0 the client port is updated so trackers are told the bound port
+func (t *TorrentClient) Listen(addr string) (net.Addr, error) {
+	if addr == "" {
+		addr = ":" + strconv.Itoa(int(t.port))
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if t.listener != nil {
+		t.mu.Unlock()
+		ln.Close()
+		return nil, fmt.Errorf("client is already listening on %s", t.listener.Addr())
+	}
+	t.listener = ln
+	t.port = uint16(ln.Addr().(*net.TCPAddr).Port)
+	t.mu.Unlock()
+
+	go t.acceptLoop(ln)
+
+	return ln.Addr(), nil
+}
+
+func (t *TorrentClient) acceptLoop(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		go func() {
+			if err := t.handleInbound(conn); err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// handleInbound reads the peers handshake, routes it to the torrent it asks for and replies with ours
+func (t *TorrentClient) handleInbound(conn net.Conn) error {
+	if !t.underGlobalLimit() {
+		return ErrConnLimit
+	}
+	if t.Banned(remoteIP(conn.RemoteAddr())) {
+		return fmt.Errorf("%w - %s", ErrBanned, conn.RemoteAddr())
+	}
+
+	conn.SetDeadline(time.Now().Add(inboundHandshakeTimeout))
+	conn, err := t.acceptEncrypted(conn)
+	if err != nil {
+		return err
+	}
+	remote, err := peers.ReadHandshake(conn)
+	if err != nil {
+		return err
+	}
+
+	if remote.PeerID == t.localPeerID() {
+		return ErrSelfConnection
+	}
+
+	t.mu.Lock()
+	active, ok := t.torrents[remote.InfoHash]
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w - %x", ErrUnknownInfoHash, remote.InfoHash)
+	}
+
+	ours := t.handshake(remote.InfoHash)
+	if _, err := conn.Write(ours.SerializePeerHandshake()); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	_, err = t.addConn(active, conn, remote)
+	return err
+}
+
+func (t *TorrentClient) underGlobalLimit() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.numConnsLocked() < t.connLimits.Global
+}
+
+func (t *TorrentClient) numConnsLocked() int {
+	res := 0
+	for _, active := range t.torrents {
+		res += len(active.conns) + len(active.pending)
+	}
+	return res
+}
+
+/*
+addConn checks the limits and bans and hands the connection to the torrents engine, it is forgotten again once it closes.
+The slot is reserved under the client lock but the lock is not held while the engine takes the peer, the engine
+goroutine may be busy with other peers and every other call on the client would wait behind it
+*/
+func (t *TorrentClient) addConn(active *activeTorrent, conn net.Conn, remote *peers.PeerHandshake) (*peers.PeerConn, error) {
+	t.mu.Lock()
+	if t.bannedLocked(remoteIP(conn.RemoteAddr())) {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%w - %s", ErrBanned, conn.RemoteAddr())
+	}
+	if active.connectedLocked(remote.PeerID) {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%w - %s", ErrAlreadyConnected, conn.RemoteAddr())
+	}
+	if t.numConnsLocked() >= t.connLimits.Global || len(active.conns)+len(active.pending) >= t.connLimits.PerTorrent {
+		t.mu.Unlock()
+		return nil, ErrConnLimit
+	}
+	active.pending[remote.PeerID] = struct{}{}
+	t.mu.Unlock()
+
+	peerConn, err := active.engine.AddPeer(conn, remote)
+
+	t.mu.Lock()
+	delete(active.pending, remote.PeerID)
+	if err != nil {
+		t.mu.Unlock()
+		return nil, err
+	}
+	// the peer may have been banned while the engine took it
+	if t.bannedLocked(remoteIP(conn.RemoteAddr())) {
+		t.mu.Unlock()
+		peerConn.Close()
+		return nil, fmt.Errorf("%w - %s", ErrBanned, conn.RemoteAddr())
+	}
+	active.conns[peerConn] = struct{}{}
+	t.logPeer("peer connected", active, peerConn)
+
+	// BEP 5 peers that run a dht node are told where ours listens
+	var dhtPort uint16
+	if t.dht != nil && !active.torrentFile.Private && remote.Supports(peers.CapabilityDHT) {
+		dhtPort = uint16(t.dht.Addr().Port)
+	}
+	t.mu.Unlock()
+
+	if dhtPort != 0 {
+		peerConn.Send(peers.NewPort(dhtPort))
+	}
+
+	go func() {
+		<-peerConn.Done()
+		t.mu.Lock()
+		delete(active.conns, peerConn)
+		t.logPeer("peer disconnected", active, peerConn)
+		t.mu.Unlock()
+	}()
+
+	return peerConn, nil
+}
+
+// connectedLocked reports whether a peer is connected or being handed to the engine, callers hold the client lock
+func (active *activeTorrent) connectedLocked(peerID [20]byte) bool {
+	if _, ok := active.pending[peerID]; ok {
+		return true
+	}
+	for conn := range active.conns {
+		if conn.PeerID() == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+// dialLocked marks address as being connected to, false when it already is or a connection to it is open.
+// Callers hold the client lock and call the returned func once the attempt is over
+func (active *activeTorrent) dialLocked(address string) (func(), bool) {
+	if _, ok := active.dialing[address]; ok {
+		return nil, false
+	}
+	for conn := range active.conns {
+		if conn.RemoteAddr().String() == address {
+			return nil, false
+		}
+	}
+	active.dialing[address] = struct{}{}
+	return func() { delete(active.dialing, address) }, true
+}
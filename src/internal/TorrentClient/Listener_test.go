@@ -0,0 +1,208 @@
+package torrentclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	download "github.com/firozt/go-torrent/src/internal/Download"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// dialHandshake connects to addr and sends a handshake, returning the connection and the reply if any
+func dialHandshake(t *testing.T, addr string, infoHash, peerID [20]byte) (net.Conn, *peers.PeerHandshake, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot dial listener - %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	remote, err := peers.Handshake(conn, peers.NewBitTorrentProtocolHandshake(infoHash, peerID), 2*time.Second)
+	return conn, remote, err
+}
+
+func TestListenerRouting(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		infoHash    [20]byte
+		selfID      bool // send the clients own peer id
+		throwsError bool
+	}
+
+	known := [20]byte{'K', 'N', 'O', 'W', 'N'}
+	testcases := []TestCase{
+		{"known info hash", known, false, false},
+		{"unknown info hash", [20]byte{'U', 'N', 'K'}, false, true},
+		{"self connection", known, true, true},
+	}
+
+	client := NewTorrentClient(0)
+	defer client.Close()
+	client.AddTorrent(torrent.TorrentFile{InfoHash: known, PieceLength: 1, Length: 4, Pieces: make([][20]byte, 4)}, nil, nil)
+
+	addr, err := client.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot listen - %s", err)
+	}
+	if int(client.port) != addr.(*net.TCPAddr).Port {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%s\n", client.port, addr)
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			peerID := [20]byte{'R', 'E', 'M', 'O', 'T', 'E'}
+			if tc.selfID {
+				peerID = client.peerID
+			}
+
+			conn, remote, err := dialHandshake(t, addr.String(), tc.infoHash, peerID)
+			if tc.throwsError && err == nil {
+				t.Fatalf("Expected an error did not recieve any")
+			}
+			if !tc.throwsError && err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if tc.throwsError {
+				return
+			}
+
+			if remote.PeerID != client.peerID {
+				t.Errorf("Got and want are not equal\nGOT:%x\nWANT:%x\n", remote.PeerID, client.peerID)
+			}
+
+			// the torrents engine now owns the connection and wants the pieces we announce
+			peers.WriteMessage(conn, peers.NewBitfield([]byte{0xf0}))
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			msg, err := peers.ReadMessage(conn)
+			if err != nil || msg == nil || msg.ID != peers.MsgInterested {
+				t.Errorf("Got and want are not equal\nGOT:%v %v\nWANT:interested\n", msg, err)
+			}
+		})
+	}
+}
+
+func TestListenerConnLimits(t *testing.T) {
+	type TestCase struct {
+		testname string
+		limits   ConnLimits
+		expected int // connections accepted out of 3
+	}
+
+	testcases := []TestCase{
+		{"per torrent limit", ConnLimits{Global: 10, PerTorrent: 2}, 2},
+		{"global limit", ConnLimits{Global: 1, PerTorrent: 10}, 1},
+	}
+
+	infoHash := [20]byte{'L', 'I', 'M', 'I', 'T'}
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			client := NewTorrentClient(0)
+			defer client.Close()
+			client.SetConnLimits(tc.limits)
+			client.AddTorrent(torrent.TorrentFile{InfoHash: infoHash}, nil, nil)
+
+			addr, err := client.Listen("127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("DEV ERR: cannot listen - %s", err)
+			}
+
+			for i := range 3 {
+				conn, _, err := dialHandshake(t, addr.String(), infoHash, [20]byte{byte(i + 1)})
+				if err != nil {
+					continue
+				}
+				// a rejected connection is closed after the handshake, wait for either outcome
+				conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				conn.Read(make([]byte, 1))
+			}
+
+			if got := client.NumConns(infoHash); got != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAddConnReservesSlot(t *testing.T) {
+	TF, _ := randomTorrent(16 * 1024)
+	client := NewTorrentClient(0)
+	defer client.Close()
+	client.SetConnLimits(ConnLimits{Global: 10, PerTorrent: 1})
+
+	// the engine is not running yet so handing it a peer blocks
+	engine := download.NewEngine(&TF, storage.NewMemoryStorage(&TF), nil, download.DefaultConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	active := &activeTorrent{torrentFile: TF, engine: engine, conns: map[*peers.PeerConn]struct{}{}, pending: map[[20]byte]struct{}{}, dialing: map[string]struct{}{}, stop: cancel, stopped: ctx.Done()}
+	client.mu.Lock()
+	client.torrents[TF.InfoHash] = active
+	client.mu.Unlock()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	added := make(chan error, 1)
+	go func() {
+		_, err := client.addConn(active, local, peers.NewBitTorrentProtocolHandshake(TF.InfoHash, [20]byte{1}))
+		added <- err
+	}()
+
+	// meanwhile the client is not locked and the slot being filled counts against the limit
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		client.mu.Lock()
+		pending := len(active.pending)
+		client.mu.Unlock()
+		if pending == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	other, _ := net.Pipe()
+	defer other.Close()
+	if _, err := client.addConn(active, other, peers.NewBitTorrentProtocolHandshake(TF.InfoHash, [20]byte{2})); !errors.Is(err, ErrConnLimit) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrConnLimit)
+	}
+	// the same peer is turned away whatever the limits
+	if _, err := client.addConn(active, other, peers.NewBitTorrentProtocolHandshake(TF.InfoHash, [20]byte{1})); !errors.Is(err, ErrAlreadyConnected) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrAlreadyConnected)
+	}
+
+	go engine.Run(ctx)
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("the peer was never added")
+	}
+	if n := client.NumConns(TF.InfoHash); n != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:1\n", n)
+	}
+}
+
+func TestPeerHandshakeSkipsConnected(t *testing.T) {
+	TF, data := randomTorrent(64 * 1024)
+	_, addr := startSeederClient(t, TF, data)
+
+	leecher := NewTorrentClient(0)
+	defer leecher.Close()
+	leecher.AddTorrent(TF, storage.NewMemoryStorage(&TF), nil)
+
+	// a peer handed out again by a tracker is not dialled a second time
+	peer := peers.NewPeer(addr.IP, uint16(addr.Port))
+	if _, err := leecher.PeerHandshakeProtocol(peer, TF.InfoHash); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if _, err := leecher.PeerHandshakeProtocol(peer, TF.InfoHash); !errors.Is(err, ErrAlreadyConnected) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrAlreadyConnected)
+	}
+	if n := leecher.NumConns(TF.InfoHash); n != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:1\n", n)
+	}
+}
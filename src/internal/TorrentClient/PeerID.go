@@ -0,0 +1,84 @@
+package torrentclient
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	download "github.com/firozt/go-torrent/src/internal/Download"
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+)
+
+// the Azureus style prefix of our peer id, -GT0100- for go-torrent 0.1
+const (
+	clientCode        = "GT"
+	clientCodeVersion = "0100"
+)
+
+// ========== Method Defs =========== //
+
+// SetClientID replaces the peer id with a new one starting with an Azureus style prefix, code is two
+// alphanumeric characters naming the client and version four. Peers and trackers that already know
+// the old peer id keep it, so call it before adding torrents
+func (t *TorrentClient) SetClientID(code, version string) error {
+	peerID, err := peers.NewPeerID(code, version)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peerID = peerID
+	return nil
+}
+
+// localPeerID returns the peer id set by SetClientID
+func (t *TorrentClient) localPeerID() [20]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.peerID
+}
+
+// clientVersion names the client and version of our peer id, sent as v in our extended handshake
+func (t *TorrentClient) clientVersion() string {
+	client, _ := peers.ParseClient(t.localPeerID())
+	return client.String()
+}
+
+// SetLogger receives a line for every peer that connects or disconnects, the default discards them
+func (t *TorrentClient) SetLogger(logger *slog.Logger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.logger = logger
+}
+
+// PeerStats returns a snapshot of the peers connected for a torrent, each with the client it runs
+func (t *TorrentClient) PeerStats(infoHash [20]byte) []download.PeerStats {
+	t.mu.Lock()
+	active, ok := t.torrents[infoHash]
+	t.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return active.engine.PeerStats()
+}
+
+// logPeer logs a peer of a torrent connecting or disconnecting, callers hold the client lock
+func (t *TorrentClient) logPeer(msg string, active *activeTorrent, conn *peers.PeerConn) {
+	client := conn.Client()
+	t.logger.Info(msg,
+		"torrent", hex.EncodeToString(active.torrentFile.InfoHash[:]),
+		"addr", conn.RemoteAddr().String(),
+		"client", client.Name,
+		"version", client.Version,
+	)
+}
+
+// newPeerID generates our default peer id, the prefix is constant so it is written without the checks of
+// peers.NewPeerID
+func newPeerID() [20]byte {
+	var peerID [20]byte
+	copy(peerID[:], "-"+clientCode+clientCodeVersion+"-")
+	rand.Read(peerID[8:])
+	return peerID
+}
@@ -0,0 +1,89 @@
+package torrentclient
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+)
+
+// logBuffer collects log lines written from several goroutines
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func TestClientID(t *testing.T) {
+	client := NewTorrentClient(0)
+	defer client.Close()
+	if prefix := string(client.peerID[:8]); prefix != "-GT0100-" {
+		t.Errorf("Got and want are not equal\nGOT:%q\nWANT:-GT0100-\n", prefix)
+	}
+	if v := client.clientVersion(); v != "go-torrent 0.1" {
+		t.Errorf("Got and want are not equal\nGOT:%q\nWANT:go-torrent 0.1\n", v)
+	}
+
+	if err := client.SetClientID("XY", "12"); !errors.Is(err, peers.ErrInvalidClientID) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, peers.ErrInvalidClientID)
+	}
+	if err := client.SetClientID("XY", "1234"); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if prefix := string(client.peerID[:8]); prefix != "-XY1234-" {
+		t.Errorf("Got and want are not equal\nGOT:%q\nWANT:-XY1234-\n", prefix)
+	}
+}
+
+func TestPeerClientReported(t *testing.T) {
+	TF, data := randomTorrent(64 * 1024)
+	seeder, addr := startSeederClient(t, TF, data)
+	if err := seeder.SetClientID("TR", "2940"); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	logs := &logBuffer{}
+	leecher := NewTorrentClient(0)
+	defer leecher.Close()
+	leecher.SetLogger(slog.New(slog.NewTextHandler(logs, nil)))
+	leecher.AddTorrent(TF, storage.NewMemoryStorage(&TF), nil)
+
+	if _, err := leecher.PeerHandshakeProtocol(peers.NewPeer(addr.IP, uint16(addr.Port)), TF.InfoHash); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	stats := leecher.PeerStats(TF.InfoHash)
+	if len(stats) != 1 || stats[0].Client != (peers.Client{Name: "Transmission", Version: "2.94"}) {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:a single Transmission 2.94 peer\n", stats)
+	}
+	if line := logs.String(); !strings.Contains(line, `msg="peer connected"`) || !strings.Contains(line, "client=Transmission version=2.94") {
+		t.Errorf("Got and want are not equal\nGOT:%s\nWANT:a peer connected line naming Transmission 2.94\n", line)
+	}
+
+	// the seeder going away is logged too
+	seeder.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(logs.String(), `msg="peer disconnected"`) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), `msg="peer disconnected"`) {
+		t.Errorf("Got and want are not equal\nGOT:%s\nWANT:a peer disconnected line\n", logs.String())
+	}
+}
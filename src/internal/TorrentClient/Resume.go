@@ -0,0 +1,182 @@
+package torrentclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"time"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	resume "github.com/firozt/go-torrent/src/internal/Resume"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+// dirStorage is implemented by storages keeping files in a directory, only their state can be resumed
+// as resume data is validated against the stat of those files
+type dirStorage interface {
+	Dir() string
+}
+
+// keep the resume file small, peers beyond this are likely stale anyway
+const maxResumePeers = 200
+
+// SetResumeDir enables fast resume, the state of every torrent is saved to dir when its download
+// returns and when the client closes. Torrents whose saved state still matches their files skip the hash check.
+// The ban list is kept in dir too, bans saved by an earlier run are loaded here
+func (t *TorrentClient) SetResumeDir(dir string) error {
+	t.mu.Lock()
+	t.resumeDir = dir
+	t.mu.Unlock()
+
+	if dir == "" {
+		return nil
+	}
+	return t.loadBans(dir)
+}
+
+// loadState returns the pieces store already holds, taken from resume data when it is still valid
+// and found by hash checking every piece otherwise. saved is nil when the hash check was needed
+func (t *TorrentClient) loadState(ctx context.Context, torrentFile *torrent.TorrentFile, store storage.Storage) (have peers.Bitfield, saved *resume.Data, err error) {
+	t.mu.Lock()
+	resumeDir := t.resumeDir
+	t.mu.Unlock()
+
+	if ds, ok := store.(dirStorage); ok && resumeDir != "" {
+		saved, err := resume.Load(resume.Path(resumeDir, torrentFile.InfoHash))
+		if err == nil {
+			err = saved.Check(torrentFile, ds.Dir())
+		}
+		if err == nil {
+			return saved.Bitfield, saved, nil
+		}
+	}
+
+	existing, err := storage.Verify(ctx, store, torrentFile, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return existing.Bitfield, nil, nil
+}
+
+/*
+restoreState carries over the counters, tracker backoff and peers of a resumed torrent, trackers
+the torrent already announced to this session keep their current state. The interval of a tracker that accepted
+the last announce is not carried over, this session has to tell it our peer id and port straight
+away, while a tracker that was failing keeps backing off until the saved time passes
+*/
+func (t *TorrentClient) restoreState(active *activeTorrent, saved *resume.Data) {
+	t.mu.Lock()
+	active.uploaded = saved.Uploaded
+	active.downloaded = saved.Downloaded
+	for _, trk := range saved.Trackers {
+		key := trackerKey{active.torrentFile.InfoHash, trk.URL}
+		if _, ok := t.trackers[key]; ok {
+			continue
+		}
+		status := &TrackerStatus{InfoHash: key.infoHash, URL: trk.URL, LastAnnounce: time.Unix(trk.LastAnnounce, 0)}
+		if trk.Failures > 0 {
+			status.Failures = int(trk.Failures)
+			status.NextAnnounce = time.Unix(trk.NextAnnounce, 0)
+		}
+		t.trackers[key] = status
+	}
+	t.mu.Unlock()
+
+	known, err := peers.MakePeer(saved.Peers)
+	if err != nil {
+		return
+	}
+	for _, peer := range known {
+		t.addKnownPeer(active, peer)
+		go t.PeerHandshakeProtocol(peer, active.torrentFile.InfoHash)
+	}
+}
+
+// addKnownPeer remembers a peer so it can be tried again after a restart
+func (t *TorrentClient) addKnownPeer(active *activeTorrent, peer peers.Peer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if active.knownPeers == nil {
+		active.knownPeers = map[string]peers.Peer{}
+	}
+	if len(active.knownPeers) < maxResumePeers && peer.IP().To4() != nil {
+		active.knownPeers[peer.Address()] = peer
+	}
+}
+
+// SaveResume writes the state of a torrent to the resume directory, it does nothing when no
+// resume directory is set or the torrent is not kept in a directory
+func (t *TorrentClient) SaveResume(infoHash [20]byte) error {
+	t.mu.Lock()
+	active, ok := t.torrents[infoHash]
+	resumeDir := t.resumeDir
+	t.mu.Unlock()
+	if !ok || resumeDir == "" {
+		return nil
+	}
+	ds, ok := active.store.(dirStorage)
+	if !ok {
+		return nil
+	}
+
+	files, err := resume.Stat(&active.torrentFile, ds.Dir())
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	data := &resume.Data{
+		InfoHash: infoHash,
+		Bitfield: active.engine.Bitfield(),
+		Files:    files,
+	}
+	data.Uploaded, data.Downloaded = active.totals()
+	for _, peer := range active.knownPeers {
+		data.Peers = binary.BigEndian.AppendUint16(append(data.Peers, peer.IP().To4()...), peer.Port())
+	}
+	for _, announceURL := range active.torrentFile.Announce {
+		if status, ok := t.trackers[trackerKey{infoHash, announceURL}]; ok {
+			data.Trackers = append(data.Trackers, resume.TrackerState{
+				URL:          announceURL,
+				LastAnnounce: status.LastAnnounce.Unix(),
+				NextAnnounce: status.NextAnnounce.Unix(),
+				Failures:     int64(status.Failures),
+			})
+		}
+	}
+	t.mu.Unlock()
+
+	return resume.Save(resume.Path(resumeDir, infoHash), data)
+}
+
+// saveAll saves the state of every torrent, a torrent whose files are gone is skipped
+func (t *TorrentClient) saveAll() error {
+	t.mu.Lock()
+	infoHashes := make([][20]byte, 0, len(t.torrents))
+	for infoHash := range t.torrents {
+		infoHashes = append(infoHashes, infoHash)
+	}
+	t.mu.Unlock()
+
+	var errs []error
+	for _, infoHash := range infoHashes {
+		if err := t.SaveResume(infoHash); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// leftFor is the number of bytes of the pieces missing from have
+func leftFor(torrentFile *torrent.TorrentFile, have peers.Bitfield) uint64 {
+	var res uint64
+	for index := range torrentFile.Pieces {
+		if !have.HasPiece(index) {
+			res += torrentFile.PieceSize(index)
+		}
+	}
+	return res
+}
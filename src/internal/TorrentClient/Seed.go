@@ -0,0 +1,83 @@
+package torrentclient
+
+import (
+	"time"
+)
+
+// ========== Struct Defs =========== //
+
+// SeedLimits decides when a completed torrent stops seeding and is removed, a zero field is no limit
+// and with both zero a torrent seeds until it is removed
+type SeedLimits struct {
+	Ratio float64       // uploaded bytes divided by the size of the torrent
+	Time  time.Duration // since the download completed
+}
+
+// how often the ratio is checked against the limit
+var seedCheckInterval = time.Second
+
+// ========== Method Defs =========== //
+
+func (t *TorrentClient) SetSeedLimits(limits SeedLimits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seedLimits = limits
+}
+
+// totals returns the bytes uploaded and downloaded for a torrent across every run, callers hold the client lock
+func (active *activeTorrent) totals() (uploaded uint64, downloaded uint64) {
+	stats := active.engine.Stats()
+	return active.uploaded + stats.Uploaded, active.downloaded + stats.Downloaded
+}
+
+// seed keeps a completed torrent serving its peers until a seed limit is reached, then removes it
+func (t *TorrentClient) seed(active *activeTorrent) {
+	t.mu.Lock()
+	limits := t.seedLimits
+	t.mu.Unlock()
+	if limits.Ratio <= 0 && limits.Time <= 0 {
+		return
+	}
+
+	var deadline <-chan time.Time
+	if limits.Time > 0 {
+		timer := time.NewTimer(limits.Time)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(seedCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-active.stopped:
+			return
+		case <-deadline:
+			t.stopSeeding(active)
+			return
+		case <-ticker.C:
+			if limits.Ratio > 0 && t.ratio(active) >= limits.Ratio {
+				t.stopSeeding(active)
+				return
+			}
+		}
+	}
+}
+
+func (t *TorrentClient) ratio(active *activeTorrent) float64 {
+	t.mu.Lock()
+	uploaded, _ := active.totals()
+	t.mu.Unlock()
+
+	size := active.torrentFile.TotalLength()
+	if size == 0 {
+		return 0
+	}
+	return float64(uploaded) / float64(size)
+}
+
+// stopSeeding saves the final state of the torrent before removing it
+func (t *TorrentClient) stopSeeding(active *activeTorrent) {
+	t.SaveResume(active.torrentFile.InfoHash)
+	t.RemoveTorrent(active.torrentFile.InfoHash)
+}
@@ -0,0 +1,61 @@
+package torrentclient
+
+import (
+	"testing"
+	"time"
+
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
+)
+
+func TestSeedLimits(t *testing.T) {
+	type TestCase struct {
+		testname string
+		limits   SeedLimits
+		uploaded uint64 // of earlier runs, the torrent is 100 bytes
+		removed  bool
+	}
+
+	testcases := []TestCase{
+		{"no limits", SeedLimits{}, 1000, false},
+		{"time limit", SeedLimits{Time: 20 * time.Millisecond}, 0, true},
+		{"ratio reached", SeedLimits{Ratio: 1.5}, 150, true},
+		{"ratio not reached", SeedLimits{Ratio: 1.5}, 149, false},
+	}
+
+	defer func(interval time.Duration) { seedCheckInterval = interval }(seedCheckInterval)
+	seedCheckInterval = 5 * time.Millisecond
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			tf := torrent.TorrentFile{InfoHash: [20]byte{'S', 'E', 'E', 'D'}, PieceLength: 100, Length: 100, Pieces: make([][20]byte, 1)}
+			client := NewTorrentClient(0)
+			defer client.Close()
+			client.SetSeedLimits(tc.limits)
+			client.AddTorrent(tf, storage.NewMemoryStorage(&tf), nil)
+
+			client.mu.Lock()
+			active := client.torrents[tf.InfoHash]
+			active.uploaded = tc.uploaded
+			client.mu.Unlock()
+
+			done := make(chan struct{})
+			go func() {
+				client.seed(active)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			client.mu.Lock()
+			_, ok := client.torrents[tf.InfoHash]
+			client.mu.Unlock()
+			if ok == tc.removed {
+				t.Errorf("Got and want are not equal\nGOT:removed %v\nWANT:%v\n", !ok, tc.removed)
+			}
+		})
+	}
+}
@@ -2,20 +2,28 @@
 package torrentclient
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
-	"net/http"
-	"net/url"
+	"sort"
+	"sync"
 	"time"
 
-	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+	choker "github.com/firozt/go-torrent/src/internal/Choker"
+	dht "github.com/firozt/go-torrent/src/internal/DHT"
+	lsd "github.com/firozt/go-torrent/src/internal/LSD"
+	pex "github.com/firozt/go-torrent/src/internal/PEX"
 	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	proxy "github.com/firozt/go-torrent/src/internal/Proxy"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
 	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
 	tracker "github.com/firozt/go-torrent/src/internal/Tracker"
+	utp "github.com/firozt/go-torrent/src/internal/UTP"
 )
 
 // ========== Struct Defs =========== //
@@ -31,357 +39,473 @@ type TorrentClient struct {
 	key         uint32
 	// RateLimitUp   uint64
 	// RateLimitDown uint64
+
+	mu           sync.Mutex
+	trackers     map[trackerKey]*TrackerStatus
+	retryPolicy  tracker.RetryPolicy
+	dialers      map[TrafficClass]proxy.Dialer
+	trackerConns map[string]tracker.Tracker  // keyed by announce url
+	torrents     map[[20]byte]*activeTorrent // keyed by info hash
+	connLimits   ConnLimits
+	listener     net.Listener
+	resumeDir    string // fast resume is disabled when empty
+	seedLimits   SeedLimits
+	newChoker    func() choker.Choker[*peers.PeerConn] // nil uses the engine default
+	dht          *dht.Server                           // nil until EnableDHT
+	lsd          *lsd.LSD                              // nil until EnableLSD
+	utp          *utp.Socket                           // nil until EnableUTP
+	encryption   EncryptionPolicy
+	logger       *slog.Logger
+	banPolicy    BanPolicy
+	bans         map[string]*ban // keyed by ip
+}
+
+// TrafficClass groups outgoing connections so each group can be routed through its own proxy
+type TrafficClass int
+
+const (
+	TrafficTracker TrafficClass = iota // http(s) and udp tracker announces
+	TrafficPeer                        // peer wire connections
+)
+
+// TrackerStatus is a snapshot of the last announce of a torrent made to a single tracker
+type TrackerStatus struct {
+	InfoHash       [20]byte
+	URL            string
+	LastAnnounce   time.Time
+	NextAnnounce   time.Time              // the tracker is skipped until this time
+	Failures       int                    // consecutive failed announces, resets on success
+	LastError      *tracker.AnnounceError // nil if the last announce succeeded
+	WarningMessage string                 // set when the tracker accepted the announce with a warning, see tracker.ErrorKind
+	NumPeers       int
+}
+
+// trackerKey identifies the announce state of a torrent on a tracker, torrents sharing a tracker
+// each have their own interval
+type trackerKey struct {
+	infoHash    [20]byte
+	announceURL string
 }
 
 // ========== Method Defs =========== //
 
 func NewTorrentClient(port uint16) *TorrentClient {
 	return &TorrentClient{
-		peerID:      random20Bytes(),
+		peerID:      newPeerID(),
 		port:        port,
 		uploaded:    0,
 		downloaded:  0,
 		left:        0,
 		activePeers: []peers.Peer{},
 		key:         randomUint32(),
+		trackers:    map[trackerKey]*TrackerStatus{},
+		retryPolicy: tracker.DefaultRetryPolicy(),
+		torrents:    map[[20]byte]*activeTorrent{},
+		connLimits:  DefaultConnLimits(),
+		logger:      slog.New(slog.DiscardHandler),
+		banPolicy:   DefaultBanPolicy(),
+		bans:        map[string]*ban{},
 		// RateLimitUp:
 		// RateLimitDown:
 	}
 }
 
-func random20Bytes() [20]byte {
-	var b [20]byte
-	_, err := rand.Read(b[:])
-	if err != nil {
-		panic("random 20 bytes panic")
+// SetDialer routes all traffic of the given class through dialer, see the proxy package for
+// SOCKS5 and HTTP CONNECT implementations. udp trackers require a dialer that can relay udp
+func (t *TorrentClient) SetDialer(class TrafficClass, dialer proxy.Dialer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.dialers == nil {
+		t.dialers = map[TrafficClass]proxy.Dialer{}
 	}
-	return b
-}
+	t.dialers[class] = dialer
 
-func (t *TorrentClient) GetPeerStringID() string {
-	return string(t.peerID[:])
+	// cached trackers hold the old dialer
+	if class == TrafficTracker {
+		for _, trk := range t.trackerConns {
+			trk.Close()
+		}
+		t.trackerConns = nil
+	}
 }
 
-func (t *TorrentClient) StartTorrent(torrentfile torrent.TorrentFile) {
+// dialer returns the dialer for a traffic class, connecting directly if none was set
+func (t *TorrentClient) dialer(class TrafficClass) proxy.Dialer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	var trackerResponse *tracker.TrackerResponse
-	var err error
+	if d, ok := t.dialers[class]; ok {
+		return d
+	}
+	return &proxy.Direct{}
+}
 
-	for _, tracker := range torrentfile.Announce {
-		trackerResponse, err = t.getTrackerResponse(tracker, &torrentfile)
+func (t *TorrentClient) GetPeerStringID() string {
+	peerID := t.localPeerID()
+	return string(peerID[:])
+}
 
+// StartTorrent announces to the torrents trackers in order until one accepts, trackers still
+// backing off from earlier failures or waiting for their interval are skipped. A tracker waiting for its
+// interval accepted our last announce so nil is returned, an error is returned if no tracker gave a valid response
+func (t *TorrentClient) StartTorrent(torrentfile torrent.TorrentFile) error {
+	var errs []error
+
+	for _, announceURL := range torrentfile.Announce {
+		if !t.shouldAnnounce(torrentfile.InfoHash, announceURL) {
+			if !t.backingOff(torrentfile.InfoHash, announceURL) {
+				return nil
+			}
+			continue
+		}
+
+		_, err := t.announce(context.Background(), announceURL, &torrentfile, tracker.EventStarted)
 		if err == nil {
-			break
+			return nil
 		}
+		errs = append(errs, err)
 	}
 
-	if trackerResponse == nil {
-		panic("No valid tracker annoucne responses")
+	if len(errs) == 0 {
+		return fmt.Errorf("no valid tracker announce responses - all trackers are backing off")
 	}
-
+	return fmt.Errorf("no valid tracker announce responses - %w", errors.Join(errs...))
 }
 
-// url can either point to a http server or a udp server
-func (t *TorrentClient) getTrackerResponse(trackerURL string, torrentFile *torrent.TorrentFile) (*tracker.TrackerResponse, error) {
-	u, err := url.Parse(trackerURL)
+// TrackerStatuses returns a copy of the announce state of every torrent on each of its trackers
+func (t *TorrentClient) TrackerStatuses() []TrackerStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	if err != nil {
-		return nil, err
+	res := make([]TrackerStatus, 0, len(t.trackers))
+	for _, status := range t.trackers {
+		res = append(res, *status)
 	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].URL != res[j].URL {
+			return res[i].URL < res[j].URL
+		}
+		return bytes.Compare(res[i].InfoHash[:], res[j].InfoHash[:]) < 0
+	})
+	return res
+}
 
-	if u.Scheme == "udp" {
-		return t.udpHandshakeProtocol(u, torrentFile)
-	}
+// shouldAnnounce reports whether a torrent is due to announce to a tracker
+func (t *TorrentClient) shouldAnnounce(infoHash [20]byte, announceURL string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	if u.Scheme == "http" || u.Scheme == "https" {
-		return t.httpHandshakeProtocol(u, torrentFile)
-	}
+	status, ok := t.trackers[trackerKey{infoHash, announceURL}]
+	return !ok || !time.Now().Before(status.NextAnnounce)
+}
+
+// backingOff reports whether a tracker is skipped for a torrent because its last announce failed
+func (t *TorrentClient) backingOff(infoHash [20]byte, announceURL string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	return nil, fmt.Errorf("unknown url scheme - %s", u.Scheme)
+	status, ok := t.trackers[trackerKey{infoHash, announceURL}]
+	return ok && status.Failures > 0
 }
 
-func (t TorrentClient) httpHandshakeProtocol(httpURL *url.URL, torrentFile *torrent.TorrentFile) (*tracker.TrackerResponse, error) {
-	if httpURL.Scheme != "http" && httpURL.Scheme != "https" {
-		return nil, fmt.Errorf("url provided is not a http url instead is %s", httpURL.Scheme)
+// announce contacts a single tracker with event and records the outcome, a failure reason
+// in the response is returned as an error of kind tracker.ErrKindFailure
+func (t *TorrentClient) announce(ctx context.Context, announceURL string, torrentFile *torrent.TorrentFile, event tracker.Event) (*tracker.TrackerResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, announceTimeout)
+	defer cancel()
+
+	resp, err := t.getTrackerResponse(ctx, announceURL, torrentFile, event)
+	if err == nil && resp.FailureReason != "" {
+		err = tracker.NewFailureError(announceURL, resp.FailureReason)
 	}
 
-	// add params to url
-	fullTrackerURL, _ := torrentFile.BuildTrackerURL(httpURL.String(), string(t.peerID[:]), 12345)
+	announceErr := tracker.ClassifyError(announceURL, err)
+	t.recordAnnounce(torrentFile.InfoHash, announceURL, resp, announceErr)
 
-	// we need to make a request, and cancel out if it hangs as server may be down
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+	if announceErr != nil {
+		return nil, announceErr
 	}
+	return resp, nil
+}
 
-	resp, err := client.Get(fullTrackerURL)
+func (t *TorrentClient) recordAnnounce(infoHash [20]byte, announceURL string, resp *tracker.TrackerResponse, announceErr *tracker.AnnounceError) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	if err != nil {
-		return nil, err
+	if t.trackers == nil {
+		t.trackers = map[trackerKey]*TrackerStatus{}
 	}
-
-	// read and parse body
-	defer resp.Body.Close()
-	reader := bufio.NewReader(resp.Body)
-	trackerResponse := &tracker.TrackerResponse{}
-	err = bencodeparser.Read(reader, trackerResponse)
-	if err != nil {
-		return nil, err
+	key := trackerKey{infoHash, announceURL}
+	status, ok := t.trackers[key]
+	if !ok {
+		status = &TrackerStatus{InfoHash: infoHash, URL: announceURL}
+		t.trackers[key] = status
 	}
-	return trackerResponse, nil
-}
 
-func (t TorrentClient) udpHandshakeProtocol(udpURL *url.URL, torrentfile *torrent.TorrentFile) (*tracker.TrackerResponse, error) {
-	if udpURL.Scheme != "udp" {
-		return nil, fmt.Errorf("invalid scheme, wanted udp got %s", udpURL.Scheme)
+	now := time.Now()
+	status.LastAnnounce = now
+	status.LastError = announceErr
+
+	if announceErr != nil {
+		status.Failures++
+		status.NextAnnounce = now.Add(t.retryPolicy.Backoff(status.Failures))
+		return
 	}
 
-	// send connect request and get a valid transactionID
-	connectionID, err := t.sendConnectUDPReq(udpURL)
+	status.Failures = 0
+	status.WarningMessage = resp.WarningMessage
+	status.NumPeers = len(resp.RawPeers)/6 + len(resp.RawPeers6)/18
+	interval := time.Duration(resp.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultAnnounceInterval
+	}
+	interval = max(interval, time.Duration(resp.MinInterval)*time.Second, minAnnounceInterval)
+	status.NextAnnounce = now.Add(interval)
+}
 
+// getTrackerResponse announces to a single tracker, the implementation is picked from the
+// tracker registry by url scheme so applications may register their own with tracker.Register
+func (t *TorrentClient) getTrackerResponse(ctx context.Context, trackerURL string, torrentFile *torrent.TorrentFile, event tracker.Event) (*tracker.TrackerResponse, error) {
+	trk, err := t.trackerFor(trackerURL)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("passed connect request with connID of %d, attempting announce request\n", connectionID)
-
-	// send announce request with returned connectionID for verification
-	return t.sendAnnounceReq(udpURL, connectionID, torrentfile)
+	return trk.Announce(ctx, t.announceRequest(torrentFile, event))
 }
 
-/*
-announce message packet structure
-Offset  Size    Name    Value
-0       64-bit integer  connection_id
-8       32-bit integer  action          1 // announce
-12      32-bit integer  transaction_id
-16      20-byte string  info_hash
-36      20-byte string  peer_id
-56      64-bit integer  downloaded
-64      64-bit integer  left
-72      64-bit integer  uploaded
-80      32-bit integer  event           0 // 0: none; 1: completed; 2: started; 3: stopped
-84      32-bit integer  IP address      0 // default
-88      32-bit integer  key
-92      32-bit integer  num_want        -1 // default
-96      16-bit integer  port
-98
-sendAnnounceReq sends to the tracker a request to recieve valid peers
-*/
-
-func (t TorrentClient) sendAnnounceReq(udpURL *url.URL, connectionID uint64, torrentfile *torrent.TorrentFile) (*tracker.TrackerResponse, error) {
-	if udpURL.Scheme != "udp" {
-		return nil, fmt.Errorf("url scheme is not udp instead is %s ", udpURL.Host)
+// trackerFor returns the cached tracker for an announce url, creating it on first use
+func (t *TorrentClient) trackerFor(trackerURL string) (tracker.Tracker, error) {
+	t.mu.Lock()
+	if trk, ok := t.trackerConns[trackerURL]; ok {
+		t.mu.Unlock()
+		return trk, nil
 	}
+	t.mu.Unlock()
 
-	transactionID := randomUint32()
-
-	// Build announce packet
-	buf := new(bytes.Buffer)
-
-	// connection_id
-	binary.Write(buf, binary.BigEndian, connectionID)
-
-	// action = announce (1)
-	binary.Write(buf, binary.BigEndian, uint32(1))
-
-	// transaction_id
-	binary.Write(buf, binary.BigEndian, transactionID)
-
-	// info_hash
-	buf.Write(torrentfile.InfoHash[:])
-
-	// peer_id
-	buf.Write(t.peerID[:])
-
-	// downloaded
-	binary.Write(buf, binary.BigEndian, t.downloaded)
-
-	// left
-	binary.Write(buf, binary.BigEndian, t.left)
-
-	// uploaded
-	binary.Write(buf, binary.BigEndian, t.uploaded)
-
-	// event = started (2)
-	binary.Write(buf, binary.BigEndian, uint32(2))
-
-	// IP address = 0 (default)
-	binary.Write(buf, binary.BigEndian, uint32(0))
-
-	// key (random)
-	binary.Write(buf, binary.BigEndian, randomUint32())
-
-	// num_want = -1
-	binary.Write(buf, binary.BigEndian, int32(-1))
-
-	// port
-	binary.Write(buf, binary.BigEndian, uint16(t.port))
-
-	resp, err := sendAndRecvUDP(udpURL, buf.Bytes())
+	trk, err := tracker.New(trackerURL, tracker.Options{Dialer: t.dialer(TrafficTracker)})
 	if err != nil {
 		return nil, err
 	}
 
-	// response:
-	// Offset      Size            Name            Value
-	// 0           32-bit integer  action          1 // announce
-	// 4           32-bit integer  transaction_id
-	// 8           32-bit integer  interval
-	// 12          32-bit integer  leechers
-	// 16          32-bit integer  seeders
-	// 20 + 6 * n  32-bit integer  IP address
-
-	// now we validate the response is valid
-
-	if len(resp) < 20 {
-		// cannot be a valid response
-		return nil, fmt.Errorf("response malformed : number of bytes is less than 20")
-	}
-
-	// obtain each value returned into a easy to handle variable
-	action := binary.BigEndian.Uint32(resp[:4])
-	respTransactionID := binary.BigEndian.Uint32(resp[4:8])
-
-	if action != 1 {
-		return nil, fmt.Errorf("response unexpected value - the value of announce in the response was not 1 (announce request response)")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.trackerConns == nil {
+		t.trackerConns = map[string]tracker.Tracker{}
 	}
-
-	if respTransactionID != transactionID {
-		return nil, fmt.Errorf("transaction ID's do not match")
+	// another announce may have raced us here, keep the first
+	if existing, ok := t.trackerConns[trackerURL]; ok {
+		trk.Close()
+		return existing, nil
 	}
+	t.trackerConns[trackerURL] = trk
+	return trk, nil
+}
 
-	// valid response now
-
-	peerBlob := resp[20:]
-	if len(peerBlob)%6 != 0 {
-		return nil, fmt.Errorf("length of peer blob is not a valid size 6N")
+// announceRequest builds an announce for a torrent, torrents that have been added report their own transfer totals
+// and the bytes of the pieces they still miss
+func (t *TorrentClient) announceRequest(torrentFile *torrent.TorrentFile, event tracker.Event) tracker.AnnounceRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	uploaded, downloaded, left := t.uploaded, t.downloaded, t.left
+	if active, ok := t.torrents[torrentFile.InfoHash]; ok {
+		uploaded, downloaded = active.totals()
+		left = leftFor(&active.torrentFile, active.engine.Bitfield())
+	}
+
+	return tracker.AnnounceRequest{
+		InfoHash:   torrentFile.InfoHash,
+		PeerID:     t.peerID,
+		Port:       t.port,
+		Uploaded:   uploaded,
+		Downloaded: downloaded,
+		Left:       left,
+		Event:      event,
+		NumWant:    -1,
+		Key:        t.key,
 	}
-
-	return &tracker.TrackerResponse{
-		RawPeers: peerBlob,
-	}, nil
 }
 
-/*
-msg structure to be sent:
-Offset  Size            Name            Value
-0       64-bit integer  protocol_id     0x41727101980  magic constant
-8       32-bit integer  action          0 // connect
-12      32-bit integer  transaction_id
-16
+// Close saves the resume data of every torrent and the ban list, tells the trackers every torrent is stopped, stops listening,
+// closes every peer connection, releases every tracker the client has announced to and stops the dht node and local service discovery
+func (t *TorrentClient) Close() error {
+	errs := []error{t.saveAll(), t.saveBans()}
 
-sendConnectUDPReq takes a udp url scheme and sends a connect request
-to the corresponding tracker server, returns the connectionID to be presented
-on each subsequent request to the tracker server from this ip:port combo until expired
-*/
-func (t TorrentClient) sendConnectUDPReq(udpURL *url.URL) (uint64, error) {
-	// check url protocol
-	if udpURL.Scheme != "udp" {
-		return 0, fmt.Errorf("url scheme is not udp instead is %s ", udpURL.Host)
+	t.mu.Lock()
+	actives := make([]*activeTorrent, 0, len(t.torrents))
+	for _, active := range t.torrents {
+		actives = append(actives, active)
+	}
+	t.mu.Unlock()
+	for _, active := range actives {
+		t.announceStopped(active)
 	}
 
-	// build connect connectMsg
-	connectMsg, transactionID := tracker.NewUDPConnectRequest()
-
-	response, err := sendAndRecvUDP(udpURL, connectMsg.Serialize())
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	if err != nil {
-		return 0, err
+	if t.listener != nil {
+		errs = append(errs, t.listener.Close())
+		t.listener = nil
 	}
-
-	// parses resp
-	responseStruct, err := tracker.DeserializeUDPConnectResponse(response)
-	if err != nil {
-		return 0, err
+	for _, active := range t.torrents {
+		active.stop()
 	}
-
-	if transactionID != responseStruct.TransactionID {
-		return 0, fmt.Errorf("transactionID does not match with generated number in request, expected %d, got %d", responseStruct.TransactionID, transactionID)
+	for _, trk := range t.trackerConns {
+		errs = append(errs, trk.Close())
 	}
-	return responseStruct.ConnectionID, nil
-
+	t.trackerConns = nil
+	if t.dht != nil {
+		errs = append(errs, t.dht.Close())
+		t.dht = nil
+	}
+	if t.lsd != nil {
+		errs = append(errs, t.lsd.Close())
+		t.lsd = nil
+	}
+	if t.utp != nil {
+		errs = append(errs, t.utp.Close())
+		t.utp = nil
+	}
+	return errors.Join(errs...)
 }
 
 // PeerHandshakeProtocol attempts to start a connection to a peer using the peer communications protocol
-// this is always done via tcp or utp
-func (c TorrentClient) PeerHandshakeProtocol(peer peers.Peer, infoHash [20]byte) (*net.TCPConn, error) {
+// this is done via utp when enabled, falling back to tcp, and encrypted as the EncryptionPolicy asks. The torrent must have been added with AddTorrent, on success
+// the connection is handed to its engine
+func (c *TorrentClient) PeerHandshakeProtocol(peer peers.Peer, infoHash [20]byte) (*peers.PeerConn, error) {
 	if len(peer.IP()) == 0 || peer.Port() == 0 {
 		return nil, fmt.Errorf("peer is malformed - %s", peer.Address())
 	}
+	if c.Banned(peer.IP()) {
+		return nil, fmt.Errorf("%w - %s", ErrBanned, peer.Address())
+	}
 
-	// build initHandshakeMsg
-	initHandshakeMsg := peers.NewBitTorrentProtocolHandshake(infoHash, c.peerID)
+	// trackers, the dht and pex hand out peers we are already talking to every time they are asked
+	c.mu.Lock()
+	active, ok := c.torrents[infoHash]
+	var done func()
+	if ok {
+		done, ok = active.dialLocked(peer.Address())
+		if !ok {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("%w - %s", ErrAlreadyConnected, peer.Address())
+		}
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w - %x", ErrUnknownInfoHash, infoHash)
+	}
+	defer func() {
+		c.mu.Lock()
+		done()
+		c.mu.Unlock()
+	}()
 
 	// attempt to connect, 5 second timeout
-	conn, err := net.DialTimeout("tcp", peer.Address(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := c.connectPeer(ctx, peer.Address(), infoHash)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
-	// send init msg
-	conn.Write(initHandshakeMsg.SerializePeerHandshake())
-
-	// wait for a response 5 second timeout
-
-	readBuf := make([]byte, 1024)
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	n, err := conn.Read(readBuf)
+	remote, err := peers.Handshake(conn, c.handshake(infoHash), 5*time.Second)
+	if err == nil && remote.PeerID == c.localPeerID() {
+		err = ErrSelfConnection
+	}
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	if n != 68 {
-		return nil, fmt.Errorf("number of bytes returned is not 68, the length of the expected response instead its %d", n)
-	}
-
-	peerHandshakeResponse, err := peers.DeserializePeerHandshake([68]byte(readBuf))
+	peerConn, err := c.addConn(active, conn, remote)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-
-	if peerHandshakeResponse.InfoHash != infoHash {
-		return nil, fmt.Errorf("the infohash returned in the handshake are not equivilant, expected %x, got %x", infoHash, peerHandshakeResponse.InfoHash)
+	if active.pex != nil {
+		flags := pex.FlagReachable
+		if _, ok := conn.RemoteAddr().(*net.UDPAddr); ok {
+			flags |= pex.FlagUTP
+		}
+		if encrypted(conn) {
+			flags |= pex.FlagEncryption
+		}
+		active.pex.Connected(peerConn, peer, flags)
 	}
-
-	return nil, nil
+	return peerConn, nil
 }
 
-// genertic func that sends a message to a url over udp and waits 5s for a response and returns it
-func sendAndRecvUDP(udpURL *url.URL, msg []byte) ([]byte, error) {
-	// get address
-	raddr, err := net.ResolveUDPAddr("udp", udpURL.Host)
+// Download adds the torrent, announces to its trackers, the dht and the local network when enabled and connects to every peer found,
+// blocking until every piece has been verified and written to store or ctx is cancelled. Trackers are announced to again
+// whenever their interval passes and told once the download completes, a torrent already complete is announced all the same.
+// Data already in store is hash checked first so only missing pieces are downloaded, unless resume
+// data saved by an earlier run still matches the files, see SetResumeDir.
+// A completed torrent keeps seeding, one that returns an error is saved and removed again
+func (t *TorrentClient) Download(ctx context.Context, torrentFile torrent.TorrentFile, store storage.Storage) (err error) {
+	have, saved, err := t.loadState(ctx, &torrentFile, store)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	engine := t.AddTorrent(torrentFile, store, have)
+	defer func() {
+		t.SaveResume(torrentFile.InfoHash)
+		if err != nil {
+			t.RemoveTorrent(torrentFile.InfoHash)
+		}
+	}()
 
-	// start up a socket to send the mesage
-	conn, err := net.DialUDP("udp", nil, raddr)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
+	t.mu.Lock()
+	active := t.torrents[torrentFile.InfoHash]
+	t.mu.Unlock()
 
-	// listen on the socket for a response
-	_, err = conn.Write(msg)
-	if err != nil {
-		return nil, err
+	if saved != nil {
+		t.restoreState(active, saved)
+	}
+	// a torrent complete from the start still announces so peers can find the seed
+	complete := false
+	select {
+	case <-engine.Done():
+		complete = true
+	default:
 	}
 
-	// add a timeout of 5s
-	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	if err != nil {
-		return nil, err
+	// trackerless torrents rely on the dht, local peers and the peers of an earlier run
+	server := t.dhtFor(active)
+	otherSources := server != nil || t.usesLSD(active)
+	if !complete && len(torrentFile.Announce) == 0 && !otherSources && !t.hasKnownPeers(active) {
+		return ErrNoPeerSources
+	}
+	if server != nil {
+		go t.findDHTPeers(active, server)
 	}
 
-	// read response
-	buf := make([]byte, 2048)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return nil, err
+	t.mu.Lock()
+	active.announcing = true
+	t.mu.Unlock()
+	errs := t.announceTorrent(ctx, active, tracker.EventStarted, t.shouldAnnounce)
+
+	// with every tracker down the dht, local peers, the peers of an earlier run and those they tell us about are all we have
+	if !complete && len(errs) == len(torrentFile.Announce) && len(errs) > 0 && !otherSources && !t.hasKnownPeers(active) {
+		return fmt.Errorf("no valid tracker announce responses - %w", errors.Join(errs...))
+	}
+	if len(torrentFile.Announce) > 0 {
+		go t.announceLoop(active)
 	}
 
-	return buf[:n], nil
+	select {
+	case <-engine.Done():
+		if !complete {
+			t.announceTorrent(ctx, active, tracker.EventCompleted, t.notBackingOff)
+		}
+		go t.seed(active)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func randomUint32() uint32 {
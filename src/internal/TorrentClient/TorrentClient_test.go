@@ -1,219 +1,785 @@
 package torrentclient
 
 import (
-	"net/http"
-	"net/http/httptest"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	proxy "github.com/firozt/go-torrent/src/internal/Proxy"
+	proxytest "github.com/firozt/go-torrent/src/internal/ProxyTest"
+	resume "github.com/firozt/go-torrent/src/internal/Resume"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
 	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
 	tracker "github.com/firozt/go-torrent/src/internal/Tracker"
+	trackerserver "github.com/firozt/go-torrent/src/internal/TrackerServer"
 )
 
-func TestHandleHTTPScheme(t *testing.T) {
+func TestUDPHandshake(t *testing.T) {
+	type Input struct {
+		url         string
+		torrentFile torrent.TorrentFile
+	}
 	type TestCase struct {
-		testname    string
-		input       string // tobe converted to url obj
-		expected    *tracker.TrackerResponse
-		throwsError bool
+		testname  string
+		input     Input
+		expected  tracker.TrackerResponse
+		throwsErr bool
 	}
 
-	testcase := []TestCase{
+	testcases := []TestCase{
 		{
 			testname: "sanity check",
-			input:    "https://torrent.ubuntu.com/announce",
-			expected: &tracker.TrackerResponse{
-				FailureReason: "Requested download is not authorized for use with this tracker.",
+			input: Input{
+				url: "udp://tracker.opentrackr.org:1337/announce",
+				torrentFile: torrent.TorrentFile{
+					InfoHash: [20]byte{
+						0x12, 0x34, 0x56, 0x78,
+						0x9a, 0xbc, 0xde, 0xf0,
+						0x11, 0x22, 0x33, 0x44,
+						0x55, 0x66, 0x77, 0x88,
+						0x99, 0xaa, 0xbb, 0xcc,
+					},
+				},
 			},
-			throwsError: false,
-		},
-		{
-			testname:    "invalid scheme",
-			input:       "udp://tracker.dmcomic.org:2710/announce",
-			expected:    nil,
-			throwsError: true,
-		},
-	}
-	TF := &torrent.TorrentFile{
-		Name:         "ubuntu-24.04.iso",
-		Announce:     []string{"https://torrent.ubuntu.com/announce"},
-		InfoHash:     [20]byte{'T', 'E', 'S', 'T', 'I', 'N', 'G', 'H', 'A', 'S', 'H'},
-		CreationDate: 1700000000,
-		PieceLength:  256 * 1024,
-		Pieces: [][20]byte{
-			[20]byte{'P', 'I', 'E', 'C', 'E', '0', '0', '1'},
-			[20]byte{'P', 'I', 'E', 'C', 'E', '0', '0', '2'},
-		},
-		Length: 1024 * 1024 * 1024,
-		Files: []torrent.TorrentFileField{
-			{
-				Path:   []string{"ubuntu-24.04.iso"},
-				Length: 1024 * 1024 * 1024,
+
+			throwsErr: false,
+			expected: tracker.TrackerResponse{
+				FailureReason: "Your client forgot to send your torrent's info_hash. Please upgrade your client.",
 			},
 		},
 	}
-	for _, tc := range testcase[:] {
+
+	for _, tc := range testcases {
 		t.Run(tc.testname, func(t *testing.T) {
 			client := NewTorrentClient(1234)
-
-			u, _ := url.Parse(tc.input)
-			got, err := client.httpHandshakeProtocol(u, TF)
-
-			// err expected and none given
-			if tc.throwsError && err == nil {
-				t.Errorf("An error was expected however none were thrown")
-				return
+			got, err := client.getTrackerResponse(context.Background(), tc.input.url, &tc.input.torrentFile, tracker.EventStarted)
+			if tc.throwsErr && err == nil {
+				t.Errorf("Expected an error however recieved none")
 			}
-
-			// err thrown none expected
-			if !tc.throwsError && err != nil {
+			if !tc.throwsErr && err != nil {
 				t.Errorf("An error was thrown none expected, %v", err)
-				return
-			}
-
-			// valid err
-			if err != nil {
-				return
 			}
-
-			// compare only fields we can know before making the request
-			if tc.expected.FailureReason != got.FailureReason {
-				t.Errorf("Got and want are not equal\nGOT:\n%+v\nWANT:\n%+v\n", *got, *tc.expected)
+			if reflect.DeepEqual(got, &tc.expected) {
+				t.Errorf("got and expected are not equal\nGOT:\n%v,WANTED:\n%v", got, tc.expected)
 			}
 
-			// if !reflect.DeepEqual(got, tc.expected) {
-			// 	t.Errorf("Got and want are not equal\nGOT:\n%+v\nWANT:\n%+v\n", *got, *tc.expected)
-			// }
 		})
 	}
 }
 
-func TestHTTPURLSchemeSlowServer(t *testing.T) {
+// startLocalTracker runs an embedded tracker on loopback, standing in for public trackers
+func startLocalTracker(t *testing.T) (httpURL string, udpURL string) {
+	t.Helper()
 
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(200 * time.Millisecond)
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer server.Close()
+	server := trackerserver.NewServer(trackerserver.DefaultConfig())
+	httpAddr, err := server.ListenHTTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot start http tracker - %s", err)
+	}
+	udpAddr, err := server.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot start udp tracker - %s", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	return "http://" + httpAddr.String() + "/announce", "udp://" + udpAddr.String() + "/announce"
+}
 
-	client := TorrentClient{}
-	t.Run("Slow server check", func(t *testing.T) {
-		u, err := url.Parse(server.URL)
+func TestLocalTrackerAnnounce(t *testing.T) {
+	httpURL, udpURL := startLocalTracker(t)
 
+	t.Run("http announce", func(t *testing.T) {
+		TF := &torrent.TorrentFile{InfoHash: [20]byte{'H', 'T', 'T', 'P'}, Length: 1024}
+		client := NewTorrentClient(1234)
+		got, err := client.getTrackerResponse(context.Background(), httpURL, TF, tracker.EventStarted)
 		if err != nil {
-			t.Errorf("DEV ERR: cannot make server - %s", err)
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+		if got.FailureReason != "" || got.Interval != 1800 {
+			t.Errorf("Got and want are not equal\nGOT:\n%+v\nWANT:\ninterval of 1800", *got)
+		}
+	})
+
+	t.Run("udp announce returns other peers", func(t *testing.T) {
+		TF := &torrent.TorrentFile{InfoHash: [20]byte{'U', 'D', 'P'}, Length: 1024}
+		first := NewTorrentClient(1111)
+		first.left = TF.Length // seeders are not handed to other seeders
+		if _, err := first.getTrackerResponse(context.Background(), udpURL, TF, tracker.EventStarted); err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
 		}
-		_, serverErr := client.httpHandshakeProtocol(u, &torrent.TorrentFile{})
 
-		if serverErr == nil {
-			t.Errorf("Expected an error did not recieve any")
+		second := NewTorrentClient(2222)
+		got, err := second.getTrackerResponse(context.Background(), udpURL, TF, tracker.EventStarted)
+		if err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+		// 127.0.0.1:1111 registered by the first client
+		if !reflect.DeepEqual(got.RawPeers, []byte{127, 0, 0, 1, 0x04, 0x57}) {
+			t.Errorf("Got and want are not equal\nGOT:\n%v\nWANT:\n127.0.0.1:1111", got.RawPeers)
 		}
 	})
+}
 
+/*
+http://tracker.dmcomic.org:2710/announce
+
+curl -G http://tracker.dmcomic.org:2710/announce\
+  --data-urlencode "info_hash=\x12\x34\x56\x78\x90\xab\xcd\xef\x12\x34\x56\x78\x90\xab\xcd\xef\x12\x34\x56\x78" \
+  --data-urlencode "peer_id=-GO0001-123456789012" \
+  --data "port=6881" \
+  --data "uploaded=0" \
+  --data "downloaded=0" \
+  --data "left=123456789" \
+  --data "compact=1" \
+  --data "event=started"
+
+*/
+
+func TestStartTorrentTrackerStatus(t *testing.T) {
+	// only the allowed torrent is tracked, the rest are rejected with a failure reason
+	allowed := [20]byte{'A', 'L', 'L', 'O', 'W', 'E', 'D'}
+	config := trackerserver.DefaultConfig()
+	config.Allowlist = [][20]byte{allowed}
+	server := trackerserver.NewServer(config)
+	httpAddr, _ := server.ListenHTTP("127.0.0.1:0")
+	udpAddr, _ := server.ListenUDP("127.0.0.1:0")
+	defer server.Close()
+
+	httpURL := "http://" + httpAddr.String() + "/announce"
+	udpURL := "udp://" + udpAddr.String() + "/announce"
+
+	t.Run("all trackers reject", func(t *testing.T) {
+		client := NewTorrentClient(1234)
+		TF := torrent.TorrentFile{Announce: []string{httpURL, udpURL}, InfoHash: [20]byte{'N', 'O', 'P', 'E'}}
+
+		err := client.StartTorrent(TF)
+		if err == nil {
+			t.Fatalf("Expected an error however recieved none")
+		}
+
+		statuses := client.TrackerStatuses()
+		if len(statuses) != 2 {
+			t.Fatalf("expected a status per tracker, got %d", len(statuses))
+		}
+		for _, status := range statuses {
+			if status.LastError == nil || status.LastError.Kind != tracker.ErrKindFailure {
+				t.Errorf("%s: expected a tracker failure got %v", status.URL, status.LastError)
+			}
+			if status.Failures != 1 || !status.NextAnnounce.After(status.LastAnnounce) {
+				t.Errorf("%s: expected tracker to back off, got %+v", status.URL, status)
+			}
+		}
+
+		// every tracker is backing off so nothing is contacted
+		if err := client.StartTorrent(TF); err == nil {
+			t.Errorf("Expected an error however recieved none")
+		}
+		for _, status := range client.TrackerStatuses() {
+			if status.Failures != 1 {
+				t.Errorf("%s: tracker was contacted while backing off", status.URL)
+			}
+		}
+	})
+
+	t.Run("falls through to working tracker", func(t *testing.T) {
+		client := NewTorrentClient(1234)
+		TF := torrent.TorrentFile{Announce: []string{"udp://127.0.0.1:1/announce", httpURL}, InfoHash: allowed}
+
+		if err := client.StartTorrent(TF); err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+
+		statuses := client.TrackerStatuses()
+		for _, status := range statuses {
+			if status.URL == httpURL && status.LastError != nil {
+				t.Errorf("expected http tracker to succeed got %v", status.LastError)
+			}
+			if status.URL != httpURL && status.LastError == nil {
+				t.Errorf("expected unreachable tracker to fail")
+			}
+		}
+	})
+}
+
+func TestUDPAnnounceURLData(t *testing.T) {
+	config := trackerserver.DefaultConfig()
+	config.Authorize = func(infoHash [20]byte, path string, query url.Values) error {
+		if path != "/announce" || query.Get("passkey") != "secret" {
+			return fmt.Errorf("invalid passkey")
+		}
+		return nil
+	}
+	server := trackerserver.NewServer(config)
+	udpAddr, err := server.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot start udp tracker - %s", err)
+	}
+	defer server.Close()
+
+	type TestCase struct {
+		testname  string
+		input     string
+		throwsErr bool
+	}
+
+	testcases := []TestCase{
+		{"valid passkey", "udp://" + udpAddr.String() + "/announce?passkey=secret", false},
+		{"wrong passkey", "udp://" + udpAddr.String() + "/announce?passkey=guess", true},
+		{"no path", "udp://" + udpAddr.String(), true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			client := NewTorrentClient(1234)
+			_, err := client.getTrackerResponse(context.Background(), tc.input, &torrent.TorrentFile{InfoHash: [20]byte{'P'}}, tracker.EventStarted)
+
+			if tc.throwsErr && err == nil {
+				t.Errorf("Expected an error however recieved none")
+			}
+			if !tc.throwsErr && err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+			}
+		})
+	}
 }
 
-func TestSendConnectUDPReq(t *testing.T) {
+func TestProxiedTrackerAnnounce(t *testing.T) {
+	httpURL, udpURL := startLocalTracker(t)
+	socksServer, err := proxytest.NewSOCKS5Server("127.0.0.1:0", &proxytest.Credentials{Username: "u", Password: "p"})
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot start proxy - %s", err)
+	}
+	defer socksServer.Close()
+
 	type TestCase struct {
 		testname    string
-		input       string // tobe converted to url obj
-		expected    []byte
+		dialer      proxy.Dialer
+		input       string
 		throwsError bool
 	}
 
-	testcase := []TestCase{
-		{
-			testname:    "sanity check",
-			input:       "udp://wepzone.net:6969/announce ",
-			throwsError: false,
-		},
+	testcases := []TestCase{
+		{"http tracker over socks5", proxy.NewSOCKS5Dialer(socksServer.Addr().String(), &proxy.Auth{Username: "u", Password: "p"}), httpURL, false},
+		{"udp tracker over socks5 udp associate", proxy.NewSOCKS5Dialer(socksServer.Addr().String(), &proxy.Auth{Username: "u", Password: "p"}), udpURL, false},
+		{"udp tracker over http connect is refused", proxy.NewHTTPConnectDialer("127.0.0.1:1", nil), udpURL, true},
 	}
 
-	for _, tc := range testcase {
+	for _, tc := range testcases {
 		t.Run(tc.testname, func(t *testing.T) {
-			u, _ := url.Parse(tc.input)
-			client := TorrentClient{}
-			got, gotErr := client.sendConnectUDPReq(u)
+			client := NewTorrentClient(1234)
+			client.SetDialer(TrafficTracker, tc.dialer)
 
-			if tc.throwsError && gotErr == nil {
+			_, err := client.getTrackerResponse(context.Background(), tc.input, &torrent.TorrentFile{InfoHash: [20]byte{'P', 'R', 'O', 'X', 'Y'}}, tracker.EventStarted)
+			if tc.throwsError && err == nil {
 				t.Errorf("Expected an error however recieved none")
 			}
-			if !tc.throwsError && gotErr != nil {
-				t.Errorf("An error was thrown none expected, %v", gotErr)
+			if !tc.throwsError && err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
 			}
+		})
+	}
+
+	u, _ := url.Parse(httpURL)
+	v, _ := url.Parse(udpURL)
+	log := socksServer.RequestLog()
+	if len(log) < 2 || log[0] != u.Host {
+		t.Errorf("expected the http tracker %s to be dialed through the proxy, log was %v", u.Host, log)
+	}
+	// udp associate requests carry 0.0.0.0:0, the tracker itself is only seen by the relay
+	if len(log) >= 2 && log[1] != "0.0.0.0:0" {
+		t.Errorf("expected a udp associate for %s, log was %v", v.Host, log)
+	}
+}
+
+// memoryTracker records announces in memory, registered under a custom scheme
+type memoryTracker struct {
+	peers    []byte // compact peers returned, a single made up peer when empty
+	interval int64  // seconds between announces, 60 when zero
 
-			if got == 0 {
-				t.Errorf("Got and want are not equal\nGOT:\n%v\nWANT:\nNON-ZERO-NUM", got)
+	mu        sync.Mutex
+	announces []tracker.AnnounceRequest
+}
+
+func (m *memoryTracker) Announce(ctx context.Context, req tracker.AnnounceRequest) (*tracker.TrackerResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.announces = append(m.announces, req)
+
+	interval := m.interval
+	if interval == 0 {
+		interval = 60
+	}
+	if len(m.peers) > 0 {
+		return &tracker.TrackerResponse{Interval: interval, RawPeers: m.peers}, nil
+	}
+	return &tracker.TrackerResponse{Interval: interval, RawPeers: []byte{127, 0, 0, 1, 0x1a, 0xe1}}, nil
+}
+
+// sent returns a copy of the announces received so far
+func (m *memoryTracker) sent() []tracker.AnnounceRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]tracker.AnnounceRequest{}, m.announces...)
+}
+
+func (m *memoryTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]tracker.ScrapeStats, error) {
+	return nil, tracker.ErrScrapeNotSupported
+}
+
+func (m *memoryTracker) Close() error {
+	return nil
+}
+
+func TestCustomTrackerScheme(t *testing.T) {
+	mem := &memoryTracker{}
+	tracker.Register("memory", func(announceURL *url.URL, opts tracker.Options) (tracker.Tracker, error) {
+		return mem, nil
+	})
+	defer tracker.Unregister("memory")
+
+	client := NewTorrentClient(6881)
+	defer client.Close()
+
+	TF := torrent.TorrentFile{InfoHash: [20]byte{'M', 'E', 'M'}, Announce: []string{"memory://swarm"}}
+	if err := client.StartTorrent(TF); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	if len(mem.sent()) != 1 {
+		t.Fatalf("Got and want are not equal\nGOT:%d announces\nWANT:1\n", len(mem.sent()))
+	}
+	got := mem.sent()[0]
+	if got.InfoHash != TF.InfoHash || got.PeerID != client.peerID || got.Port != 6881 || got.Event != tracker.EventStarted {
+		t.Errorf("announce request does not match client state - %+v", got)
+	}
+
+	statuses := client.TrackerStatuses()
+	if len(statuses) != 1 || statuses[0].NumPeers != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:one tracker with one peer\n", statuses)
+	}
+
+	// the tracker is waiting for its interval, not backing off, so starting again is no error
+	if err := client.StartTorrent(TF); err != nil {
+		t.Errorf("An error was thrown none expected, %v", err)
+	}
+	if len(mem.sent()) != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%d announces\nWANT:1\n", len(mem.sent()))
+	}
+
+	// the interval belongs to the torrent that announced, another torrent on the tracker announces straight away
+	other := torrent.TorrentFile{InfoHash: [20]byte{'O', 'T', 'H', 'E', 'R'}, Announce: TF.Announce}
+	if err := client.StartTorrent(other); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	sent := mem.sent()
+	if len(sent) != 2 || sent[1].InfoHash != other.InfoHash {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:an announce of the other torrent\n", sent)
+	}
+	if statuses := client.TrackerStatuses(); len(statuses) != 2 {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:a status per torrent\n", statuses)
+	}
+}
+
+func TestReannounce(t *testing.T) {
+	defer func(check, min time.Duration) { announceCheckInterval, minAnnounceInterval = check, min }(announceCheckInterval, minAnnounceInterval)
+	announceCheckInterval, minAnnounceInterval = 10*time.Millisecond, time.Second
+
+	TF, data := randomTorrent(64 * 1024)
+	TF.Announce = []string{"reannounce://swarm"}
+	mem := &memoryTracker{peers: startFakeSeeder(t, TF, data), interval: 1}
+	tracker.Register("reannounce", func(announceURL *url.URL, opts tracker.Options) (tracker.Tracker, error) {
+		return mem, nil
+	})
+	defer tracker.Unregister("reannounce")
+
+	// the client downloads then seeds to a second client
+	client := NewTorrentClient(0)
+	defer client.Close()
+	addr, err := client.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot listen - %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Download(ctx, TF, storage.NewMemoryStorage(&TF)); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	leecher := NewTorrentClient(0)
+	defer leecher.Close()
+	engine := leecher.AddTorrent(TF, storage.NewMemoryStorage(&TF), nil)
+	tcpAddr := addr.(*net.TCPAddr)
+	if _, err := leecher.PeerHandshakeProtocol(peers.NewPeer(tcpAddr.IP, uint16(tcpAddr.Port)), TF.InfoHash); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	select {
+	case <-engine.Done():
+	case <-ctx.Done():
+		t.Fatalf("the second client did not complete")
+	}
+
+	// once the interval passes the tracker hears about the upload
+	var reannounce *tracker.AnnounceRequest
+	for reannounce == nil && ctx.Err() == nil {
+		for _, req := range mem.sent() {
+			if req.Event == tracker.EventNone && req.Uploaded > 0 {
+				reannounce = &req
 			}
-		})
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if reannounce == nil {
+		t.Fatalf("Got and want are not equal\nGOT:%+v\nWANT:an announce reporting the upload\n", mem.sent())
+	}
+	if reannounce.Uploaded < uint64(len(data)) || reannounce.Left != 0 {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%d uploaded nothing left\n", *reannounce, len(data))
+	}
+
+	// removing the torrent tells the tracker we left
+	client.RemoveTorrent(TF.InfoHash)
+	sent := mem.sent()
+	if last := sent[len(sent)-1]; last.Event != tracker.EventStopped || last.Uploaded < uint64(len(data)) {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:a stopped announce with the final totals\n", last)
 	}
 }
 
-func TestUDPHandshake(t *testing.T) {
-	type Input struct {
-		url         string
-		torrentFile torrent.TorrentFile
+func TestDownloadRemovedOnError(t *testing.T) {
+	// the tracker hands out a peer that never answers
+	mem := &memoryTracker{peers: []byte{127, 0, 0, 1, 0, 1}}
+	tracker.Register("stalled", func(announceURL *url.URL, opts tracker.Options) (tracker.Tracker, error) {
+		return mem, nil
+	})
+	defer tracker.Unregister("stalled")
+
+	TF, _ := randomTorrent(64 * 1024)
+	TF.Announce = []string{"stalled://swarm"}
+	client := NewTorrentClient(6881)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.Download(ctx, TF, storage.NewMemoryStorage(&TF)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, context.DeadlineExceeded)
+	}
+	if registered(client, TF.InfoHash) {
+		t.Errorf("an unfinished torrent was left running")
+	}
+}
+
+func TestAnnounceLeft(t *testing.T) {
+	TF, _ := randomTorrent(100 * 1024)
+	client := NewTorrentClient(6881)
+	defer client.Close()
+
+	// each torrent reports what it misses, not the client wide counter
+	have := peers.MakeBitfield(len(TF.Pieces))
+	have.SetPiece(0)
+	have.SetPiece(3)
+	client.AddTorrent(TF, storage.NewMemoryStorage(&TF), have)
+
+	expected := TF.Length - TF.PieceLength - TF.PieceSize(3)
+	if left := client.announceRequest(&TF, tracker.EventNone).Left; left != expected {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", left, expected)
 	}
+}
+
+func TestAnnounceInterval(t *testing.T) {
 	type TestCase struct {
-		testname  string
-		input     Input
-		expected  tracker.TrackerResponse
-		throwsErr bool
+		testname string
+		input    tracker.TrackerResponse
+		expected time.Duration
 	}
 
 	testcases := []TestCase{
-		{
-			testname: "sanity check",
-			input: Input{
-				url: "udp://tracker.opentrackr.org:1337/announce",
-				torrentFile: torrent.TorrentFile{
-					InfoHash: [20]byte{
-						0x12, 0x34, 0x56, 0x78,
-						0x9a, 0xbc, 0xde, 0xf0,
-						0x11, 0x22, 0x33, 0x44,
-						0x55, 0x66, 0x77, 0x88,
-						0x99, 0xaa, 0xbb, 0xcc,
-					},
-				},
-			},
+		{"interval", tracker.TrackerResponse{Interval: 1800}, 30 * time.Minute},
+		{"min interval above interval", tracker.TrackerResponse{Interval: 120, MinInterval: 300}, 5 * time.Minute},
+		{"no interval", tracker.TrackerResponse{}, defaultAnnounceInterval},
+		{"interval too short", tracker.TrackerResponse{Interval: 1}, minAnnounceInterval},
+	}
 
-			throwsErr: false,
-			expected: tracker.TrackerResponse{
-				FailureReason: "Your client forgot to send your torrent's info_hash. Please upgrade your client.",
-			},
-		},
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			client := NewTorrentClient(6881)
+			client.recordAnnounce([20]byte{'I'}, "udp://interval", &tc.input, nil)
+
+			status := client.TrackerStatuses()[0]
+			if got := status.NextAnnounce.Sub(status.LastAnnounce); got != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, tc.expected)
+			}
+		})
+	}
+}
+
+// startFakeSeeder serves every piece of data to whoever connects, returning the compact address to hand out
+func startFakeSeeder(t *testing.T, tf torrent.TorrentFile, data []byte) []byte {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot listen - %s", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, err := peers.ReadHandshake(conn); err != nil {
+			return
+		}
+		conn.Write(peers.NewBitTorrentProtocolHandshake(tf.InfoHash, [20]byte{'S', 'E', 'E', 'D'}).SerializePeerHandshake())
+
+		all := peers.MakeBitfield(len(tf.Pieces))
+		for i := range tf.Pieces {
+			all.SetPiece(i)
+		}
+		peers.WriteMessage(conn, peers.NewBitfield(all))
+
+		for {
+			msg, err := peers.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			switch {
+			case msg == nil:
+			case msg.ID == peers.MsgInterested:
+				peers.WriteMessage(conn, &peers.Message{ID: peers.MsgUnchoke})
+			case msg.ID == peers.MsgRequest:
+				index, begin, length, _ := peers.ParseRequest(msg)
+				offset := index*uint32(tf.PieceLength) + begin
+				peers.WriteMessage(conn, peers.NewPiece(index, begin, data[offset:offset+length]))
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return binary.BigEndian.AppendUint16(addr.IP.To4(), uint16(addr.Port))
+}
+
+func TestDownload(t *testing.T) {
+	TF, data := randomTorrent(100 * 1024)
+	TF.Announce = []string{"seeder://local"}
+
+	type TestCase struct {
+		testname     string
+		existing     []int  // pieces already in the store
+		expectedLeft uint64 // bytes left announced when started
+		announces    int    // started then completed, a torrent complete from the start is only started
+	}
+
+	testcases := []TestCase{
+		{"from scratch", nil, uint64(len(data)), 2},
+		{"resume", []int{0, 3}, uint64(2 * 32 * 1024), 2},
+		{"already complete", []int{0, 1, 2, 3}, 0, 1},
 	}
 
 	for _, tc := range testcases {
 		t.Run(tc.testname, func(t *testing.T) {
-			client := NewTorrentClient(1234)
-			got, err := client.getTrackerResponse(tc.input.url, &tc.input.torrentFile)
-			if tc.throwsErr && err == nil {
-				t.Errorf("Expected an error however recieved none")
+			mem := &memoryTracker{peers: startFakeSeeder(t, TF, data)}
+			tracker.Register("seeder", func(announceURL *url.URL, opts tracker.Options) (tracker.Tracker, error) {
+				return mem, nil
+			})
+			defer tracker.Unregister("seeder")
+
+			client := NewTorrentClient(6881)
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			store := storage.NewMemoryStorage(&TF)
+			for _, index := range tc.existing {
+				begin := index * int(TF.PieceLength)
+				store.WriteAt(index, data[begin:begin+int(TF.PieceSize(index))], 0)
 			}
-			if !tc.throwsErr && err != nil {
-				t.Errorf("An error was thrown none expected, %v", err)
+
+			if err := client.Download(ctx, TF, store); err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
 			}
-			if reflect.DeepEqual(got, &tc.expected) {
-				t.Errorf("got and expected are not equal\nGOT:\n%v,WANTED:\n%v", got, tc.expected)
+
+			if !bytes.Equal(readAll(store, TF), data) {
+				t.Errorf("downloaded data does not match the torrent data")
+			}
+
+			announces := mem.sent()
+			if len(announces) != tc.announces {
+				t.Fatalf("Got and want are not equal\nGOT:%d announces\nWANT:%d\n", len(announces), tc.announces)
+			}
+			if announces[0].Event != tracker.EventStarted || announces[0].Left != tc.expectedLeft {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:started with %d left\n", announces[0], tc.expectedLeft)
+			}
+			if tc.announces > 1 {
+				if done := announces[1]; done.Event != tracker.EventCompleted || done.Left != 0 || done.Downloaded == 0 {
+					t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:completed with nothing left\n", done)
+				}
 			}
 
+			// the seed tells its trackers when it goes
+			client.RemoveTorrent(TF.InfoHash)
+			announces = mem.sent()
+			if last := announces[len(announces)-1]; last.Event != tracker.EventStopped {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:a stopped announce\n", last)
+			}
 		})
 	}
 }
 
-/*
-http://tracker.dmcomic.org:2710/announce
+// countingStore counts reads so tests can tell whether data was hash checked
+type countingStore struct {
+	*storage.FileStorage
+	reads atomic.Int32
+}
 
-curl -G http://tracker.dmcomic.org:2710/announce\
-  --data-urlencode "info_hash=\x12\x34\x56\x78\x90\xab\xcd\xef\x12\x34\x56\x78\x90\xab\xcd\xef\x12\x34\x56\x78" \
-  --data-urlencode "peer_id=-GO0001-123456789012" \
-  --data "port=6881" \
-  --data "uploaded=0" \
-  --data "downloaded=0" \
-  --data "left=123456789" \
-  --data "compact=1" \
-  --data "event=started"
+func (c *countingStore) ReadAt(index int, p []byte, begin int64) (int, error) {
+	c.reads.Add(1)
+	return c.FileStorage.ReadAt(index, p, begin)
+}
 
-*/
+func TestDownloadFastResume(t *testing.T) {
+	TF, data := randomTorrent(100 * 1024)
+	TF.Name, TF.Announce = "fast", []string{"seeder://local"}
+
+	mem := &memoryTracker{peers: startFakeSeeder(t, TF, data)}
+	tracker.Register("seeder", func(announceURL *url.URL, opts tracker.Options) (tracker.Tracker, error) {
+		return mem, nil
+	})
+	defer tracker.Unregister("seeder")
+
+	dataDir, resumeDir := t.TempDir(), t.TempDir()
+
+	// run downloads with a fresh client each time, as after a restart
+	run := func(t *testing.T) *countingStore {
+		t.Helper()
+		fileStore, err := storage.NewFileStorage(&TF, dataDir)
+		if err != nil {
+			t.Fatalf("DEV ERR: cannot create storage - %s", err)
+		}
+		store := &countingStore{FileStorage: fileStore}
+		defer store.Close()
+
+		client := NewTorrentClient(6881)
+		client.SetResumeDir(resumeDir)
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Download(ctx, TF, store); err != nil {
+			t.Fatalf("An error was thrown none expected, %v", err)
+		}
+		return store
+	}
+
+	run(t)
+	saved, err := resume.Load(resume.Path(resumeDir, TF.InfoHash))
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if saved.Downloaded != uint64(len(data)) || len(saved.Peers) != 6 || len(saved.Trackers) != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:all data downloaded from one peer via one tracker\n", saved)
+	}
+
+	if reads := run(t).reads.Load(); reads != 0 {
+		t.Errorf("Got and want are not equal\nGOT:%d reads\nWANT:no hash check\n", reads)
+	}
+
+	// a file touched since the state was saved forces a hash check
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dataDir, "fast"), future, future)
+	if reads := run(t).reads.Load(); reads != int32(len(TF.Pieces)) {
+		t.Errorf("Got and want are not equal\nGOT:%d reads\nWANT:%d\n", reads, len(TF.Pieces))
+	}
+}
+
+func TestRestoreTrackerState(t *testing.T) {
+	TF, _ := randomTorrent(32 * 1024)
+	client := NewTorrentClient(6881)
+	defer client.Close()
+	client.AddTorrent(TF, storage.NewMemoryStorage(&TF), nil)
+	client.mu.Lock()
+	active := client.torrents[TF.InfoHash]
+	client.mu.Unlock()
+
+	later := time.Now().Add(time.Hour).Unix()
+	client.restoreState(active, &resume.Data{Trackers: []resume.TrackerState{
+		{URL: "udp://waiting", LastAnnounce: time.Now().Unix(), NextAnnounce: later},
+		{URL: "udp://failing", LastAnnounce: time.Now().Unix(), NextAnnounce: later, Failures: 2},
+	}})
+
+	// a new session announces to a tracker that was only waiting for its interval, failing ones keep backing off
+	if !client.shouldAnnounce(TF.InfoHash, "udp://waiting") {
+		t.Errorf("the interval of the last session was carried over")
+	}
+	if client.shouldAnnounce(TF.InfoHash, "udp://failing") {
+		t.Errorf("the backoff of the last session was not carried over")
+	}
+	// the backoff is kept for the torrent it was saved with only
+	if !client.shouldAnnounce([20]byte{'O', 'T', 'H', 'E', 'R'}, "udp://failing") {
+		t.Errorf("the backoff of one torrent applied to another")
+	}
+}
+
+// randomTorrent is a torrent of size random bytes in 32KiB pieces
+func randomTorrent(size int) (torrent.TorrentFile, []byte) {
+	data := make([]byte, size)
+	rand.Read(data)
+	TF := torrent.TorrentFile{InfoHash: sha1.Sum(data), PieceLength: 32 * 1024, Length: uint64(size)}
+	for begin := 0; begin < size; begin += int(TF.PieceLength) {
+		TF.Pieces = append(TF.Pieces, sha1.Sum(data[begin:min(begin+int(TF.PieceLength), size)]))
+	}
+	return TF, data
+}
+
+// startSeederClient returns a client seeding the torrent, listening on loopback
+func startSeederClient(t *testing.T, TF torrent.TorrentFile, data []byte) (*TorrentClient, *net.TCPAddr) {
+	t.Helper()
+	seeder := NewTorrentClient(0)
+	t.Cleanup(func() { seeder.Close() })
+
+	full := storage.NewMemoryStorage(&TF)
+	all := peers.MakeBitfield(len(TF.Pieces))
+	for i := range TF.Pieces {
+		begin := i * int(TF.PieceLength)
+		full.WriteAt(i, data[begin:begin+int(TF.PieceSize(i))], 0)
+		all.SetPiece(i)
+	}
+	seeder.AddTorrent(TF, full, all)
+	addr, err := seeder.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot listen - %s", err)
+	}
+	return seeder, addr.(*net.TCPAddr)
+}
+
+func readAll(store storage.Storage, TF torrent.TorrentFile) []byte {
+	var res []byte
+	for i := range TF.Pieces {
+		buf := make([]byte, TF.PieceSize(i))
+		store.ReadAt(i, buf, 0)
+		res = append(res, buf...)
+	}
+	return res
+}
+
+// registered reports whether the client still serves a torrent
+func registered(client *TorrentClient, infoHash [20]byte) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	_, ok := client.torrents[infoHash]
+	return ok
+}
@@ -0,0 +1,68 @@
+package torrentclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	utp "github.com/firozt/go-torrent/src/internal/UTP"
+)
+
+// utpDialTimeout bounds a uTP connection attempt before falling back to tcp, peers without uTP never answer
+var utpDialTimeout = 2 * time.Second
+
+// ErrUTPEnabled occurs when enabling uTP a second time
+var ErrUTPEnabled = fmt.Errorf("utp already enabled")
+
+// ========== Method Defs =========== //
+
+// EnableUTP accepts peer connections over uTP on the udp port matching the client port and dials
+// peers over uTP first, falling back to tcp. Call it after Listen when the client port is 0. The
+// dht must then be bound to another udp port
+func (t *TorrentClient) EnableUTP(config utp.Config) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.utp != nil {
+		return ErrUTPEnabled
+	}
+	socket, err := utp.Listen(":"+strconv.Itoa(int(t.port)), config)
+	if err != nil {
+		return err
+	}
+	t.utp = socket
+	go t.acceptLoop(socket)
+	return nil
+}
+
+// dialPeer connects to a peer over uTP when enabled and over tcp otherwise or when the peer does not answer.
+// A proxy cannot carry uTP so peers are only dialled over tcp when a peer proxy is set
+func (t *TorrentClient) dialPeer(ctx context.Context, address string) (net.Conn, error) {
+	t.mu.Lock()
+	socket := t.utp
+	_, proxied := t.dialers[TrafficPeer]
+	t.mu.Unlock()
+
+	if socket != nil && !proxied {
+		utpCtx, cancel := context.WithTimeout(ctx, utpDialTimeout)
+		conn, err := socket.DialContext(utpCtx, address)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return t.dialer(TrafficPeer).DialContext(ctx, "tcp", address)
+}
+
+// remoteIP is the ip of a peer connected over either transport
+func remoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
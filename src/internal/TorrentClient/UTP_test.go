@@ -0,0 +1,69 @@
+package torrentclient
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	peers "github.com/firozt/go-torrent/src/internal/Peers"
+	storage "github.com/firozt/go-torrent/src/internal/Storage"
+	utp "github.com/firozt/go-torrent/src/internal/UTP"
+)
+
+func TestUTPTransfer(t *testing.T) {
+	type TestCase struct {
+		testname  string
+		seederUTP bool   // a seeder without uTP is reached over tcp
+		network   string // of the connection made
+	}
+
+	testcases := []TestCase{
+		{"over utp", true, "udp"},
+		{"tcp fallback", false, "tcp"},
+	}
+
+	defer func(timeout time.Duration) { utpDialTimeout = timeout }(utpDialTimeout)
+	utpDialTimeout = 200 * time.Millisecond
+
+	TF, data := randomTorrent(256 * 1024)
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			seeder, addr := startSeederClient(t, TF, data)
+			if tc.seederUTP {
+				if err := seeder.EnableUTP(utp.DefaultConfig()); err != nil {
+					t.Fatalf("An error was thrown none expected, %v", err)
+				}
+				if err := seeder.EnableUTP(utp.DefaultConfig()); !errors.Is(err, ErrUTPEnabled) {
+					t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrUTPEnabled)
+				}
+			}
+
+			leecher := NewTorrentClient(0)
+			defer leecher.Close()
+			if err := leecher.EnableUTP(utp.DefaultConfig()); err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			store := storage.NewMemoryStorage(&TF)
+			engine := leecher.AddTorrent(TF, store, nil)
+
+			peerConn, err := leecher.PeerHandshakeProtocol(peers.NewPeer(addr.IP, uint16(addr.Port)), TF.InfoHash)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if got := peerConn.RemoteAddr().Network(); got != tc.network {
+				t.Errorf("Got and want are not equal\nGOT:%s\nWANT:%s\n", got, tc.network)
+			}
+
+			select {
+			case <-engine.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("download did not complete")
+			}
+			if !bytes.Equal(readAll(store, TF), data) {
+				t.Errorf("downloaded data does not match the torrent data")
+			}
+		})
+	}
+}
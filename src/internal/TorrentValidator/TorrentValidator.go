@@ -2,9 +2,80 @@ package torrentvalidator
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
 	torrent "github.com/firozt/go-torrent/src/internal/Torrent"
 )
 
+// ParseTorrentFile reads a .torrent file and validates it, the raw data is mapped by hand
+// rather than through bencodeparser.Read so the binary pieces string arrives intact
+func ParseTorrentFile(r io.Reader) (*torrent.TorrentFile, error) {
+	ir, infoHash, err := bencodeparser.DecodeTorrent(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info := ir["info"].(map[string]any)
+	data := &torrent.RawTorrentData{
+		InfoHash:     infoHash,
+		Announce:     asString(ir["announce"]),
+		CreationDate: asInt(ir["creation date"]),
+		Info: torrent.RawTorrentInfo{
+			Name:        asString(info["name"]),
+			Length:      asInt(info["length"]),
+			PieceLength: asInt(info["piece length"]),
+			Piece:       asString(info["pieces"]),
+			Private:     asInt(info["private"]),
+		},
+	}
+
+	if tiers, ok := ir["announce-list"].([]any); ok {
+		for _, tier := range tiers {
+			if urls, ok := tier.([]any); ok {
+				data.AnnounceList = append(data.AnnounceList, urls)
+			}
+		}
+	}
+
+	if nodes, ok := ir["nodes"].([]any); ok {
+		for _, node := range nodes {
+			if pair, ok := node.([]any); ok {
+				data.Nodes = append(data.Nodes, pair)
+			}
+		}
+	}
+
+	files, _ := info["files"].([]any)
+	for _, raw := range files {
+		file, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid entry in info files")
+		}
+		field := torrent.TorrentFileField{Length: asInt(file["length"])}
+		elements, _ := file["path"].([]any)
+		for _, element := range elements {
+			field.Path = append(field.Path, asString(element))
+		}
+		data.Info.Files = append(data.Info.Files, field)
+	}
+
+	return ValidateBencodeData(data)
+}
+
+// asString and asInt return the zero value for missing or mistyped fields, validation rejects those
+func asString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func asInt(v any) int64 {
+	i, _ := v.(int64)
+	return i
+}
+
 // entry, takes bencode data and verifies all fields,
 // makes sure its correct for either SFM or MFM
 // returns a Torrent interface struct and error value
@@ -34,12 +105,13 @@ func ValidateBencodeData(data *torrent.RawTorrentData) (*torrent.TorrentFile, er
 
 // checks wether it has the fields shared between SFM and MFM (base)
 // MUST HAVE:
-// announce
+// announce, or dht nodes for trackerless torrents
 // info
 // ---- piece length
 // ---- piece
 func attemptParseBase(data *torrent.RawTorrentData, torrentfile *torrent.TorrentFile) error {
-	if data.Announce == "" {
+	nodes := parseNodes(data.Nodes)
+	if data.Announce == "" && len(nodes) == 0 {
 		return fmt.Errorf("data could not be parsed into a base torrent file, announce is empty")
 	}
 
@@ -62,7 +134,10 @@ func attemptParseBase(data *torrent.RawTorrentData, torrentfile *torrent.Torrent
 	}
 
 	flattendList := flattenAnnounceList(data.AnnounceList)
-	combinedAnnounce := append([]string{data.Announce}, flattendList...)
+	combinedAnnounce := flattendList
+	if data.Announce != "" {
+		combinedAnnounce = append([]string{data.Announce}, flattendList...)
+	}
 	torrentfile.Name = data.Info.Name
 	torrentfile.Announce = combinedAnnounce
 	torrentfile.PieceLength = uint64(data.Info.PieceLength)
@@ -70,6 +145,8 @@ func attemptParseBase(data *torrent.RawTorrentData, torrentfile *torrent.Torrent
 	torrentfile.InfoHash = data.InfoHash
 	torrentfile.CreationDate = uint64(data.CreationDate)
 	torrentfile.Length = uint64(data.Info.Length)
+	torrentfile.Private = data.Info.Private == 1
+	torrentfile.Nodes = nodes
 
 	return nil
 }
@@ -103,6 +180,23 @@ func flattenAnnounceList(input [][]any) []string {
 	return out
 }
 
+// parseNodes turns [host, port] pairs into host:port, malformed pairs are skipped
+func parseNodes(input [][]any) []string {
+	var out []string
+	for _, pair := range input {
+		if len(pair) != 2 {
+			continue
+		}
+		host, ok := pair[0].(string)
+		port, isInt := pair[1].(int64)
+		if !ok || !isInt || host == "" || port <= 0 || port > 65535 {
+			continue
+		}
+		out = append(out, net.JoinHostPort(host, strconv.FormatInt(port, 10)))
+	}
+	return out
+}
+
 func isInfoExist(info torrent.RawTorrentInfo) bool {
 	if len(info.Piece) == 0 { // must have a piece string
 		return false
@@ -1,6 +1,7 @@
 package torrentvalidator
 
 import (
+	"os"
 	"reflect"
 	"testing"
 
@@ -81,6 +82,31 @@ func TestPackage(t *testing.T) {
 			},
 			throwsErr: false,
 		},
+		{
+			testname: "trackerless with dht nodes",
+			input: &torrent.RawTorrentData{
+				Info: torrent.RawTorrentInfo{
+					Name:        "trackerless.txt",
+					Length:      1024,
+					PieceLength: 16384,
+					Piece:       "\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14",
+				},
+				Nodes:    [][]any{{"127.0.0.1", int64(6881)}, {"2001:db8::1", int64(6882)}, {"no port"}, {"bad port", int64(0)}},
+				InfoHash: [20]byte{0x01},
+			},
+			expected: &torrent.TorrentFile{
+				Name:        "trackerless.txt",
+				PieceLength: 16384,
+				Pieces: [][20]byte{
+					{1, 2, 3, 4, 5, 6, 7, 8, 9, 10,
+						11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+				},
+				InfoHash: [20]byte{0x01},
+				Length:   1024,
+				Nodes:    []string{"127.0.0.1:6881", "[2001:db8::1]:6882"},
+			},
+			throwsErr: false,
+		},
 		{
 			testname: "invalid pieces length",
 			input: &torrent.RawTorrentData{
@@ -114,3 +140,50 @@ func TestPackage(t *testing.T) {
 	}
 
 }
+
+func TestParseTorrentFile(t *testing.T) {
+	type TestCase struct {
+		fileName       string
+		expectedName   string
+		expectedLength uint64
+		throwsError    bool
+	}
+
+	testcases := []TestCase{
+		{"alice.torrent", "", 0, true}, // trackerless, no announce
+		{"cosmos-laundromat.torrent", "Cosmos Laundromat", 220864086, false},
+		{"sintel.torrent", "Sintel", 129302391, false},
+		{"big-buck-bunny.torrent", "Big Buck Bunny", 276445467, false},
+		{"wired-cd.torrent", "The WIRED CD - Rip. Sample. Mash. Share", 56070710, false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.fileName, func(t *testing.T) {
+			f, err := os.Open("../testdata/" + tc.fileName)
+			if err != nil {
+				t.Fatalf("DEV ERR: cannot open test file - %s", err)
+			}
+			defer f.Close()
+
+			got, err := ParseTorrentFile(f)
+			if tc.throwsError && err == nil {
+				t.Fatalf("Expected an error did not recieve any")
+			}
+			if !tc.throwsError && err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if tc.throwsError {
+				return
+			}
+
+			if got.Name != tc.expectedName || got.TotalLength() != tc.expectedLength {
+				t.Errorf("Got and want are not equal\nGOT:%s %d\nWANT:%s %d\n", got.Name, got.TotalLength(), tc.expectedName, tc.expectedLength)
+			}
+			// every hash survived, the pieces cover the data exactly
+			numPieces := uint64(len(got.Pieces))
+			if numPieces*got.PieceLength < tc.expectedLength || (numPieces-1)*got.PieceLength >= tc.expectedLength {
+				t.Errorf("%d pieces of %d bytes do not cover %d bytes", numPieces, got.PieceLength, tc.expectedLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+)
+
+// HTTPTracker announces over http or https as described in the bittorrent spec
+type HTTPTracker struct {
+	announceURL *url.URL
+	client      *http.Client
+	opts        Options
+}
+
+// NewHTTPTracker is the Factory registered for the http and https schemes
+func NewHTTPTracker(announceURL *url.URL, opts Options) (Tracker, error) {
+	if announceURL.Scheme != "http" && announceURL.Scheme != "https" {
+		return nil, fmt.Errorf("url provided is not a http url instead is %s", announceURL.Scheme)
+	}
+
+	return &HTTPTracker{
+		announceURL: announceURL,
+		opts:        opts,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				DialContext: opts.Dialer.DialContext,
+			},
+		},
+	}, nil
+}
+
+func (h *HTTPTracker) Announce(ctx context.Context, req AnnounceRequest) (*TrackerResponse, error) {
+	params := url.Values{}
+	params.Set("port", strconv.Itoa(int(req.Port)))
+	params.Set("uploaded", strconv.FormatUint(req.Uploaded, 10))
+	params.Set("downloaded", strconv.FormatUint(req.Downloaded, 10))
+	params.Set("left", strconv.FormatUint(req.Left, 10))
+	params.Set("compact", "1")
+	params.Set("key", strconv.FormatUint(uint64(req.Key), 16))
+	if req.Event != EventNone {
+		params.Set("event", req.Event.String())
+	}
+	if req.NumWant >= 0 {
+		params.Set("numwant", strconv.Itoa(int(req.NumWant)))
+	}
+
+	// info_hash and peer_id are raw bytes so they are escaped by hand, url.Values would sort them anyway
+	query := "info_hash=" + encodeBinary(req.InfoHash[:]) + "&peer_id=" + encodeBinary(req.PeerID[:]) + "&" + params.Encode()
+
+	body, err := h.get(ctx, h.announceURL, query)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return DecodeTrackerResponse(bufio.NewReader(body))
+}
+
+// Scrape uses the scrape convention, the last "announce" path segment is replaced with "scrape"
+func (h *HTTPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeStats, error) {
+	idx := strings.LastIndex(h.announceURL.Path, "/")
+	if idx < 0 || !strings.HasPrefix(h.announceURL.Path[idx+1:], "announce") {
+		return nil, ErrScrapeNotSupported
+	}
+
+	scrapeURL := *h.announceURL
+	scrapeURL.Path = h.announceURL.Path[:idx+1] + "scrape" + strings.TrimPrefix(h.announceURL.Path[idx+1:], "announce")
+
+	parts := make([]string, 0, len(infoHashes))
+	for _, infoHash := range infoHashes {
+		parts = append(parts, "info_hash="+encodeBinary(infoHash[:]))
+	}
+
+	body, err := h.get(ctx, &scrapeURL, strings.Join(parts, "&"))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	decoded, err := bencodeparser.Decode(bufio.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode scrape response - %w", err)
+	}
+	dict, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("scrape response is not a dictionary")
+	}
+	if reason, ok := dict["failure reason"].(string); ok {
+		return nil, NewFailureError(h.announceURL.String(), reason)
+	}
+
+	files, _ := dict["files"].(map[string]any)
+	res := make(map[[20]byte]ScrapeStats, len(files))
+	for rawHash, rawStats := range files {
+		stats, ok := rawStats.(map[string]any)
+		if len(rawHash) != 20 || !ok {
+			continue
+		}
+		complete, _ := stats["complete"].(int64)
+		downloaded, _ := stats["downloaded"].(int64)
+		incomplete, _ := stats["incomplete"].(int64)
+		res[[20]byte([]byte(rawHash))] = ScrapeStats{
+			Complete:   uint64(complete),
+			Downloaded: uint64(downloaded),
+			Incomplete: uint64(incomplete),
+		}
+	}
+
+	return res, nil
+}
+
+func (h *HTTPTracker) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+// get appends query to any query already on u (private tracker passkeys) and returns the body of a 200 response
+func (h *HTTPTracker) get(ctx context.Context, u *url.URL, query string) (io.ReadCloser, error) {
+	ctx, cancel := withTimeout(ctx, h.opts.Timeout)
+
+	fullURL := *u
+	if fullURL.RawQuery != "" {
+		fullURL.RawQuery += "&" + query
+	} else {
+		fullURL.RawQuery = query
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("tracker responded with http status %s", resp.Status)
+	}
+
+	return &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}, nil
+}
+
+// cancelOnClose releases the request context once the body has been read
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// encodeBinary percent encodes every byte, info hashes and peer ids are raw bytes
+func encodeBinary(b []byte) string {
+	var buf strings.Builder
+	for _, v := range b {
+		fmt.Fprintf(&buf, "%%%02X", v)
+	}
+	return buf.String()
+}
@@ -0,0 +1,142 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	proxy "github.com/firozt/go-torrent/src/internal/Proxy"
+)
+
+// ============ Interface Defs  ============ //
+
+// Tracker is a connection to a single announce url, implementations are created through New
+// using the factory registered for the url scheme
+type Tracker interface {
+	Announce(ctx context.Context, req AnnounceRequest) (*TrackerResponse, error)
+	Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeStats, error)
+	Close() error
+}
+
+// Factory builds a Tracker for an announce url, registered per scheme with Register
+type Factory func(announceURL *url.URL, opts Options) (Tracker, error)
+
+// ============ Struct Defs  ============ //
+
+// Event is sent with an announce, values match the udp protocol
+type Event uint32
+
+const (
+	EventNone Event = iota
+	EventCompleted
+	EventStarted
+	EventStopped
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventCompleted:
+		return "completed"
+	case EventStarted:
+		return "started"
+	case EventStopped:
+		return "stopped"
+	default:
+		return ""
+	}
+}
+
+// AnnounceRequest holds the protocol independent announce parameters
+type AnnounceRequest struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       uint16
+	Uploaded   uint64
+	Downloaded uint64
+	Left       uint64
+	Event      Event
+	NumWant    int32 // -1 lets the tracker decide
+	Key        uint32
+}
+
+// ScrapeStats is the per torrent result of a scrape
+type ScrapeStats struct {
+	Complete   uint64 // seeders
+	Downloaded uint64 // number of times the torrent was completed
+	Incomplete uint64 // leechers
+}
+
+// Options are handed to every Factory
+type Options struct {
+	Dialer  proxy.Dialer  // nil connects directly
+	Timeout time.Duration // per request timeout of http trackers when the context has no deadline, defaults to 5s. udp trackers retransmit as BEP 15 asks instead
+}
+
+// ErrUnknownScheme occurs when no factory is registered for an announce url scheme
+var ErrUnknownScheme = fmt.Errorf("unknown url scheme")
+
+// ErrScrapeNotSupported occurs when a tracker has no scrape endpoint
+var ErrScrapeNotSupported = fmt.Errorf("tracker does not support scrape")
+
+// ============ Registry  ============ //
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+func init() {
+	Register("http", NewHTTPTracker)
+	Register("https", NewHTTPTracker)
+	Register("udp", NewUDPTracker)
+}
+
+// Register makes a tracker implementation available for a url scheme, registering a scheme
+// twice replaces the previous factory so applications can override the built in ones
+func Register(scheme string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[scheme] = factory
+}
+
+// Unregister removes the factory for a scheme
+func Unregister(scheme string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, scheme)
+}
+
+// New parses announceURL and builds a Tracker with the factory registered for its scheme
+func New(announceURL string, opts Options) (Tracker, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[u.Scheme]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w - %s", ErrUnknownScheme, u.Scheme)
+	}
+
+	if opts.Dialer == nil {
+		opts.Dialer = &proxy.Direct{}
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+
+	return factory(u, opts)
+}
+
+// withTimeout applies the default timeout when the caller did not set a deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
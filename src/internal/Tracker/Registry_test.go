@@ -0,0 +1,118 @@
+package tracker_test
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+
+	tracker "github.com/firozt/go-torrent/src/internal/Tracker"
+	trackerserver "github.com/firozt/go-torrent/src/internal/TrackerServer"
+)
+
+// the tracker server imports this package so loopback tests live in the external test package
+
+func TestTrackerAnnounceAndScrape(t *testing.T) {
+	server := trackerserver.NewServer(trackerserver.DefaultConfig())
+	httpAddr, err := server.ListenHTTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot start http tracker - %s", err)
+	}
+	udpAddr, err := server.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot start udp tracker - %s", err)
+	}
+	defer server.Close()
+
+	type TestCase struct {
+		testname string
+		input    string
+		infoHash [20]byte
+	}
+
+	testcases := []TestCase{
+		{"http", "http://" + httpAddr.String() + "/announce", [20]byte{'H', 'T', 'T', 'P'}},
+		{"udp", "udp://" + udpAddr.String() + "/announce", [20]byte{'U', 'D', 'P'}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			trk, err := tracker.New(tc.input, tracker.Options{})
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			defer trk.Close()
+
+			ctx := context.Background()
+			seeder := tracker.AnnounceRequest{InfoHash: tc.infoHash, PeerID: [20]byte{'S'}, Port: 1111, Event: tracker.EventStarted, NumWant: -1}
+			if _, err := trk.Announce(ctx, seeder); err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+
+			leecher := tracker.AnnounceRequest{InfoHash: tc.infoHash, PeerID: [20]byte{'L'}, Port: 2222, Left: 100, Event: tracker.EventStarted, NumWant: -1}
+			resp, err := trk.Announce(ctx, leecher)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			got, err := resp.GetPeers()
+			if err != nil || len(*got) != 1 || (*got)[0].Port() != 1111 {
+				t.Errorf("Got and want are not equal\nGOT:%v %v\nWANT:one peer on port 1111\n", got, err)
+			}
+
+			stats, err := trk.Scrape(ctx, [][20]byte{tc.infoHash})
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			want := map[[20]byte]tracker.ScrapeStats{tc.infoHash: {Complete: 1, Incomplete: 1}}
+			if !reflect.DeepEqual(stats, want) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", stats, want)
+			}
+		})
+	}
+}
+
+// staticTracker answers every announce with the same response
+type staticTracker struct {
+	resp *tracker.TrackerResponse
+}
+
+func (s *staticTracker) Announce(ctx context.Context, req tracker.AnnounceRequest) (*tracker.TrackerResponse, error) {
+	return s.resp, nil
+}
+
+func (s *staticTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]tracker.ScrapeStats, error) {
+	return nil, tracker.ErrScrapeNotSupported
+}
+
+func (s *staticTracker) Close() error {
+	return nil
+}
+
+func TestRegisterCustomScheme(t *testing.T) {
+	want := &tracker.TrackerResponse{Interval: 60, RawPeers: []byte{127, 0, 0, 1, 0x1a, 0xe1}}
+	var gotURL *url.URL
+
+	tracker.Register("static", func(announceURL *url.URL, opts tracker.Options) (tracker.Tracker, error) {
+		gotURL = announceURL
+		return &staticTracker{resp: want}, nil
+	})
+	defer tracker.Unregister("static")
+
+	trk, err := tracker.New("static://swarm/announce", tracker.Options{})
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	got, err := trk.Announce(context.Background(), tracker.AnnounceRequest{})
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+	if got != want || gotURL.Host != "swarm" {
+		t.Errorf("Got and want are not equal\nGOT:%v %v\nWANT:%v\n", got, gotURL, want)
+	}
+
+	tracker.Unregister("static")
+	if _, err := tracker.New("static://swarm/announce", tracker.Options{}); err == nil {
+		t.Errorf("Expected an error did not recieve any")
+	}
+}
@@ -5,40 +5,130 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"net"
 
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
 	peers "github.com/firozt/go-torrent/src/internal/Peers"
 )
 
 // this struct depicts the tracker response given connect + announce (or just announce via http) has been accomplished
 // successfully
 type TrackerResponse struct {
-	FailureReason string        `json:"failure reason"`
-	Interval      int64         `json:"interval"`
-	TrackerID     string        `json:"tracker"`
-	Complete      int64         `json:"complete"`
-	Incomplete    int64         `json:"incomplete"`
-	peers         *[]peers.Peer // holds parsed info from peers blob
-	RawPeers      []byte        `json:"peers"`
+	FailureReason  string        `json:"failure reason"`
+	WarningMessage string        `json:"warning message"`
+	Interval       int64         `json:"interval"`
+	MinInterval    int64         `json:"min interval"`
+	TrackerID      string        `json:"tracker"`
+	Complete       int64         `json:"complete"`
+	Incomplete     int64         `json:"incomplete"`
+	peers          *[]peers.Peer // holds parsed info from peers blob
+	RawPeers       []byte        `json:"peers"`
+	RawPeers6      []byte        `json:"peers6"` // BEP 7, 18 bytes per peer
 }
 
-// GetPeers gets peers and if non existant will generate from raw peers
+// GetPeers gets peers and if non existant will generate from raw peers, ipv6 peers follow the ipv4 ones
 // May return a raw peers does not exist error
 func (t *TrackerResponse) GetPeers() (*[]peers.Peer, error) {
 
-	if len(*t.peers) > 0 {
+	if t.peers != nil && len(*t.peers) > 0 {
 		return t.peers, nil
 	}
 
-	if len(t.RawPeers) == 0 {
+	if len(t.RawPeers) == 0 && len(t.RawPeers6) == 0 {
 		return nil, fmt.Errorf("peers does not exist for this variable")
 	}
 	val, err := peers.MakePeer([]byte(t.RawPeers))
 	if err != nil {
 		return nil, err
 	}
+	val6, err := peers.MakePeer6(t.RawPeers6)
+	if err != nil {
+		return nil, err
+	}
+	val = append(val, val6...)
 	return &val, nil
 }
 
+// DecodeTrackerResponse parses the bencoded body of a http announce response
+// peers may be given either in the compact binary form or as a list of dicts, both end up in RawPeers / RawPeers6
+// A failure reason is not treated as an error here, see ClassifyError for that
+func DecodeTrackerResponse(r io.Reader) (*TrackerResponse, error) {
+	decoded, err := bencodeparser.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode tracker response - %w", err)
+	}
+
+	dict, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("tracker response is not a dictionary")
+	}
+
+	res := &TrackerResponse{}
+	res.FailureReason, _ = dict["failure reason"].(string)
+	res.WarningMessage, _ = dict["warning message"].(string)
+	res.TrackerID, _ = dict["tracker id"].(string)
+	res.Interval, _ = dict["interval"].(int64)
+	res.MinInterval, _ = dict["min interval"].(int64)
+	res.Complete, _ = dict["complete"].(int64)
+	res.Incomplete, _ = dict["incomplete"].(int64)
+
+	if res.FailureReason != "" {
+		return res, nil
+	}
+
+	switch p := dict["peers"].(type) {
+	case string:
+		res.RawPeers = []byte(p)
+	case []any:
+		// non compact form, each entry is a dict with ip and port
+		for _, entry := range p {
+			peerDict, ok := entry.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("peer entry is not a dictionary")
+			}
+			rawIP, _ := peerDict["ip"].(string)
+			port, _ := peerDict["port"].(int64)
+			ip := net.ParseIP(rawIP)
+			if ip == nil || port <= 0 || port > 65535 {
+				continue
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				res.RawPeers = append(res.RawPeers, ip4...)
+				res.RawPeers = binary.BigEndian.AppendUint16(res.RawPeers, uint16(port))
+			} else {
+				res.RawPeers6 = append(res.RawPeers6, ip.To16()...)
+				res.RawPeers6 = binary.BigEndian.AppendUint16(res.RawPeers6, uint16(port))
+			}
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("peers field has unexpected type %T", p)
+	}
+
+	if p6, ok := dict["peers6"].(string); ok {
+		res.RawPeers6 = append(res.RawPeers6, p6...)
+	}
+
+	if len(res.RawPeers)%6 != 0 || len(res.RawPeers6)%18 != 0 {
+		return nil, fmt.Errorf("length of peer blob is not a valid size")
+	}
+
+	return res, nil
+}
+
+// DeserializeUDPError parses the error action (3) a udp tracker sends instead of a response
+// Offset  Size            Name            Value
+// 0       32-bit integer  action          3 // error
+// 4       32-bit integer  transaction_id
+// 8       string  message
+func DeserializeUDPError(rawInput []byte) (transactionID uint32, message string, ok bool) {
+	if len(rawInput) < 8 || binary.BigEndian.Uint32(rawInput[:4]) != 3 {
+		return 0, "", false
+	}
+	return binary.BigEndian.Uint32(rawInput[4:8]), string(rawInput[8:]), true
+}
+
 /*
 UDPConnectRequest represents the connect request via udp described in
 https://www.bittorrent.org/beps/bep_0015.html, data is in the form of
@@ -79,6 +169,26 @@ func (r UDPConnectRequest) Serialize() []byte {
 	return msg
 }
 
+// DeserializeUDPConnectRequest parses a raw connect packet, used by the tracker server
+// An error will return if the packet is too short or does not carry the magic protocol id
+func DeserializeUDPConnectRequest(rawInput []byte) (*UDPConnectRequest, error) {
+	if len(rawInput) < 16 {
+		return nil, fmt.Errorf("input is not of a valid size (16 bytes) instead is %d", len(rawInput))
+	}
+
+	r := &UDPConnectRequest{
+		ProtocolID:    binary.BigEndian.Uint64(rawInput[:8]),
+		Action:        binary.BigEndian.Uint32(rawInput[8:12]),
+		TransactionID: binary.BigEndian.Uint32(rawInput[12:16]),
+	}
+
+	if r.ProtocolID != 0x41727101980 {
+		return nil, fmt.Errorf("protocol id is not the magic constant instead is %x", r.ProtocolID)
+	}
+
+	return r, nil
+}
+
 // func DeserializeTrackerConnect(raw []byte) (*UDPConnectRequest, error) {
 // 	if len(raw) != 16 {
 // 		return nil, fmt.Errorf("input is not of a valid size (16 bytes) instead is %d", len(raw))
@@ -130,9 +240,84 @@ type UDPAnnounceRequest struct {
 	Port          uint16
 }
 
+// Serialize packs the announce request into the 98 byte packet described above
 func (r UDPAnnounceRequest) Serialize() []byte {
-	// TODO: implement
-	return nil
+	msg := make([]byte, 98)
+	binary.BigEndian.PutUint64(msg, uint64(r.ConnectionID))
+	binary.BigEndian.PutUint32(msg[8:], 1) // action = announce
+	binary.BigEndian.PutUint32(msg[12:], uint32(r.TransactionID))
+	copy(msg[16:], r.InfoHash[:])
+	copy(msg[36:], r.PeerID[:])
+	binary.BigEndian.PutUint64(msg[56:], uint64(r.Downloaded))
+	binary.BigEndian.PutUint64(msg[64:], uint64(r.Left))
+	binary.BigEndian.PutUint64(msg[72:], uint64(r.Uploaded))
+	binary.BigEndian.PutUint32(msg[80:], uint32(r.Event))
+	binary.BigEndian.PutUint32(msg[84:], r.IPAddress)
+	binary.BigEndian.PutUint32(msg[88:], r.Key)
+	binary.BigEndian.PutUint32(msg[92:], uint32(r.NumWant))
+	binary.BigEndian.PutUint16(msg[96:], r.Port)
+
+	return msg
+}
+
+// DeserializeUDPAnnounceRequest parses an announce packet, used by the tracker server
+// An error will return if the input is too short or the action is not announce
+func DeserializeUDPAnnounceRequest(rawInput []byte) (*UDPAnnounceRequest, error) {
+	if len(rawInput) < 98 {
+		return nil, fmt.Errorf("not enough bytes to be a valid announce request, got %d", len(rawInput))
+	}
+
+	if action := binary.BigEndian.Uint32(rawInput[8:12]); action != 1 {
+		return nil, fmt.Errorf("action is not announce (1) instead is %d", action)
+	}
+
+	r := &UDPAnnounceRequest{
+		ConnectionID:  int64(binary.BigEndian.Uint64(rawInput[:8])),
+		TransactionID: int32(binary.BigEndian.Uint32(rawInput[12:16])),
+		Downloaded:    int64(binary.BigEndian.Uint64(rawInput[56:64])),
+		Left:          int64(binary.BigEndian.Uint64(rawInput[64:72])),
+		Uploaded:      int64(binary.BigEndian.Uint64(rawInput[72:80])),
+		Event:         int32(binary.BigEndian.Uint32(rawInput[80:84])),
+		IPAddress:     binary.BigEndian.Uint32(rawInput[84:88]),
+		Key:           binary.BigEndian.Uint32(rawInput[88:92]),
+		NumWant:       int32(binary.BigEndian.Uint32(rawInput[92:96])),
+		Port:          binary.BigEndian.Uint16(rawInput[96:98]),
+	}
+	copy(r.InfoHash[:], rawInput[16:36])
+	copy(r.PeerID[:], rawInput[36:56])
+
+	return r, nil
+}
+
+/*
+UDPAnnounceResponse represents the response given to an announce request
+over UDP, peers are 6 bytes each for ipv4 and 18 bytes each for ipv6
+Offset      Size            Name            Value
+0           32-bit integer  action          1 // announce
+4           32-bit integer  transaction_id
+8           32-bit integer  interval
+12          32-bit integer  leechers
+16          32-bit integer  seeders
+20 + 6 * n  32-bit integer  IP address
+24 + 6 * n  16-bit integer  TCP port
+*/
+type UDPAnnounceResponse struct {
+	TransactionID uint32
+	Interval      uint32
+	Leechers      uint32
+	Seeders       uint32
+	RawPeers      []byte
+}
+
+func (r UDPAnnounceResponse) Serialize() []byte {
+	msg := make([]byte, 20, 20+len(r.RawPeers))
+	binary.BigEndian.PutUint32(msg, 1) // action = announce
+	binary.BigEndian.PutUint32(msg[4:], r.TransactionID)
+	binary.BigEndian.PutUint32(msg[8:], r.Interval)
+	binary.BigEndian.PutUint32(msg[12:], r.Leechers)
+	binary.BigEndian.PutUint32(msg[16:], r.Seeders)
+
+	return append(msg, r.RawPeers...)
 }
 
 /*
@@ -150,8 +335,14 @@ type UDPConnectResponse struct {
 	ConnectionID  uint64
 }
 
-func (r UDPConnectResponse) Serialize() { // TODO: implement
+// Serialize arranges the response as Action || TransactionID || ConnectionID
+func (r UDPConnectResponse) Serialize() []byte {
+	msg := make([]byte, 16)
+	binary.BigEndian.PutUint32(msg, r.Action)
+	binary.BigEndian.PutUint32(msg[4:], r.TransactionID)
+	binary.BigEndian.PutUint64(msg[8:], r.ConnectionID)
 
+	return msg
 }
 
 // DeserializeUDPConnectResponse takes an input of raw bytes, that represents a response
@@ -183,3 +374,66 @@ func randomUint32() uint32 {
 	rand.Read(b[:])
 	return binary.BigEndian.Uint32(b[:])
 }
+
+// BEP 41 option types appended after the 98 byte announce request
+const (
+	udpOptionEndOfOptions byte = 0x0
+	udpOptionNOP          byte = 0x1
+	udpOptionURLData      byte = 0x2
+)
+
+/*
+EncodeURLData builds the BEP 41 option data carrying the path and query of a udp announce url
+(https://www.bittorrent.org/beps/bep_0041.html), each URLData option holds at most 255 bytes so
+longer strings are split across several options which the tracker concatenates
+Offset  Size            Name            Value
+0       8-bit integer   option type     2 // URLData
+1       8-bit integer   length          N
+2       N-byte string   data
+*/
+func EncodeURLData(requestString string) []byte {
+	if requestString == "" {
+		return nil
+	}
+
+	res := []byte{}
+	for len(requestString) > 0 {
+		chunk := requestString[:min(255, len(requestString))]
+		requestString = requestString[len(chunk):]
+
+		res = append(res, udpOptionURLData, byte(len(chunk)))
+		res = append(res, chunk...)
+	}
+
+	return append(res, udpOptionEndOfOptions)
+}
+
+// ParseURLData reads the BEP 41 options following an announce request and returns the
+// concatenated URLData. Every option but EndOfOptions and NOP carries a length byte, so options
+// of an unknown type are skipped
+func ParseURLData(options []byte) (string, error) {
+	res := []byte{}
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case udpOptionEndOfOptions:
+			return string(res), nil
+		case udpOptionNOP:
+			i++
+			continue
+		}
+
+		if i+1 >= len(options) {
+			return "", fmt.Errorf("udp option type %d is missing its length", options[i])
+		}
+		length := int(options[i+1])
+		if i+2+length > len(options) {
+			return "", fmt.Errorf("udp option type %d length %d exceeds packet", options[i], length)
+		}
+		if options[i] == udpOptionURLData {
+			res = append(res, options[i+2:i+2+length]...)
+		}
+		i += 2 + length
+	}
+
+	return string(res), nil
+}
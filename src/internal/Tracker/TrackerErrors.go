@@ -0,0 +1,125 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+/*
+ErrorKind classifies why an announce failed so callers can decide how to react. A warning message is
+not one of them, the tracker still accepted the announce and returned peers so it is neither retried
+nor backed off and is read from TrackerResponse.WarningMessage instead
+*/
+type ErrorKind int
+
+const (
+	ErrKindNetwork  ErrorKind = iota // dns failures, refused connections, unreachable hosts
+	ErrKindTimeout                   // the tracker did not answer in time
+	ErrKindProtocol                  // the tracker answered with something we could not understand
+	ErrKindFailure                   // the tracker answered with a failure reason
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrKindNetwork:
+		return "network"
+	case ErrKindTimeout:
+		return "timeout"
+	case ErrKindProtocol:
+		return "protocol"
+	case ErrKindFailure:
+		return "tracker failure"
+	default:
+		return "unknown"
+	}
+}
+
+// AnnounceError is returned for any failed announce, wrapping the underlying error
+type AnnounceError struct {
+	Kind    ErrorKind
+	Tracker string // announce url
+	Err     error
+}
+
+func (e *AnnounceError) Error() string {
+	return fmt.Sprintf("announce to %s failed (%s) - %s", e.Tracker, e.Kind, e.Err)
+}
+
+func (e *AnnounceError) Unwrap() error {
+	return e.Err
+}
+
+// ErrTrackerFailure is wrapped by errors built from a "failure reason" or a udp error action
+var ErrTrackerFailure = fmt.Errorf("tracker reported failure")
+
+// NewFailureError builds the error for a tracker that explicitly rejected the announce
+func NewFailureError(trackerURL string, reason string) *AnnounceError {
+	return &AnnounceError{
+		Kind:    ErrKindFailure,
+		Tracker: trackerURL,
+		Err:     fmt.Errorf("%w: %s", ErrTrackerFailure, reason),
+	}
+}
+
+// ClassifyError wraps err into an AnnounceError, errors that are already classified are returned as is
+func ClassifyError(trackerURL string, err error) *AnnounceError {
+	if err == nil {
+		return nil
+	}
+
+	var announceErr *AnnounceError
+	if errors.As(err, &announceErr) {
+		return announceErr
+	}
+
+	res := &AnnounceError{Tracker: trackerURL, Err: err, Kind: ErrKindProtocol}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrTrackerFailure):
+		res.Kind = ErrKindFailure
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		res.Kind = ErrKindTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		res.Kind = ErrKindTimeout
+	case errors.As(err, &netErr):
+		res.Kind = ErrKindNetwork
+	}
+
+	return res
+}
+
+// RetryPolicy describes the exponential backoff applied to a tracker after consecutive failures
+type RetryPolicy struct {
+	BaseDelay time.Duration // delay after the first failure
+	MaxDelay  time.Duration // the delay never grows past this
+}
+
+// DefaultRetryPolicy starts at 15 seconds and caps at 30 minutes, similar to libtorrent
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		BaseDelay: 15 * time.Second,
+		MaxDelay:  30 * time.Minute,
+	}
+}
+
+// Backoff returns how long to wait before retrying after the given number of consecutive failures
+func (p RetryPolicy) Backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	return min(delay, p.MaxDelay)
+}
@@ -1 +1,586 @@
 package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	proxy "github.com/firozt/go-torrent/src/internal/Proxy"
+)
+
+func TestUDPAnnounceRequestRoundTrip(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    UDPAnnounceRequest
+	}
+
+	testcases := []TestCase{
+		{
+			testname: "sanity check",
+			input: UDPAnnounceRequest{
+				ConnectionID:  0x41727101980,
+				TransactionID: 1234,
+				InfoHash:      [20]byte{1, 2, 3},
+				PeerID:        [20]byte{'-', 'G', 'O'},
+				Downloaded:    10,
+				Left:          20,
+				Uploaded:      30,
+				Event:         2,
+				Key:           99,
+				NumWant:       -1,
+				Port:          6881,
+			},
+		},
+		{
+			testname: "zero values",
+			input:    UDPAnnounceRequest{},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			raw := tc.input.Serialize()
+			if len(raw) != 98 {
+				t.Fatalf("serialized announce is not 98 bytes instead is %d", len(raw))
+			}
+
+			got, err := DeserializeUDPAnnounceRequest(raw)
+			if err != nil {
+				t.Fatalf("Unexpected error - %s", err)
+			}
+
+			if !reflect.DeepEqual(*got, tc.input) {
+				t.Errorf("Got and want are not equal\nGOT:\n%+v\nWANT:\n%+v\n", *got, tc.input)
+			}
+		})
+	}
+}
+
+func TestDeserializeUDPConnectRequest(t *testing.T) {
+	type TestCase struct {
+		testname  string
+		input     []byte
+		throwsErr bool
+	}
+
+	valid, _ := NewUDPConnectRequest()
+
+	testcases := []TestCase{
+		{"valid request", valid.Serialize(), false},
+		{"too short", []byte{0, 1, 2}, true},
+		{"wrong protocol id", make([]byte, 16), true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := DeserializeUDPConnectRequest(tc.input)
+
+			if tc.throwsErr && err == nil {
+				t.Errorf("Expected an error got none")
+				return
+			}
+			if !tc.throwsErr && err != nil {
+				t.Errorf("Unexpected error - %s", err)
+				return
+			}
+			if !tc.throwsErr && !reflect.DeepEqual(*got, *valid) {
+				t.Errorf("Got and want are not equal\nGOT:\n%+v\nWANT:\n%+v\n", *got, *valid)
+			}
+		})
+	}
+}
+
+func TestUDPConnectResponseRoundTrip(t *testing.T) {
+	want := UDPConnectResponse{Action: 0, TransactionID: 42, ConnectionID: 0xdeadbeef}
+
+	got, err := DeserializeUDPConnectResponse(want.Serialize())
+	if err != nil {
+		t.Fatalf("Unexpected error - %s", err)
+	}
+	if *got != want {
+		t.Errorf("Got and want are not equal\nGOT:\n%+v\nWANT:\n%+v\n", *got, want)
+	}
+}
+
+func TestDecodeTrackerResponse(t *testing.T) {
+	type TestCase struct {
+		testname  string
+		input     string
+		expected  *TrackerResponse
+		throwsErr bool
+	}
+
+	testcases := []TestCase{
+		{
+			testname: "compact peers",
+			input:    "d8:completei1e10:incompletei2e8:intervali1800e5:peers6:\x7f\x00\x00\x01\x1a\xe1e",
+			expected: &TrackerResponse{
+				Complete:   1,
+				Incomplete: 2,
+				Interval:   1800,
+				RawPeers:   []byte{0x7f, 0x00, 0x00, 0x01, 0x1a, 0xe1},
+			},
+		},
+		{
+			testname: "non compact peers with ipv6",
+			input:    "d8:intervali60e5:peersld2:ip9:127.0.0.14:porti6881eed2:ip3:::14:porti1eeee",
+			expected: &TrackerResponse{
+				Interval:  60,
+				RawPeers:  []byte{0x7f, 0x00, 0x00, 0x01, 0x1a, 0xe1},
+				RawPeers6: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1},
+			},
+		},
+		{
+			testname: "failure reason",
+			input:    "d14:failure reason11:not allowede",
+			expected: &TrackerResponse{FailureReason: "not allowed"},
+		},
+		{
+			testname: "warning message",
+			input:    "d8:intervali60e15:warning message4:slowe",
+			expected: &TrackerResponse{Interval: 60, WarningMessage: "slow"},
+		},
+		{
+			testname:  "not a dict",
+			input:     "li1ee",
+			throwsErr: true,
+		},
+		{
+			testname:  "malformed peer blob",
+			input:     "d5:peers5:abcdee",
+			throwsErr: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got, err := DecodeTrackerResponse(strings.NewReader(tc.input))
+
+			if tc.throwsErr && err == nil {
+				t.Errorf("Expected an error got none")
+				return
+			}
+			if !tc.throwsErr && err != nil {
+				t.Errorf("Unexpected error - %s", err)
+				return
+			}
+			if tc.throwsErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:\n%+v\nWANT:\n%+v\n", *got, *tc.expected)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    error
+		expected ErrorKind
+	}
+
+	testcases := []TestCase{
+		{"timeout", &net.OpError{Op: "read", Err: timeoutError{}}, ErrKindTimeout},
+		{"connection refused", &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, ErrKindNetwork},
+		{"dns", &net.DNSError{Err: "no such host", Name: "example.invalid"}, ErrKindNetwork},
+		{"failure reason", NewFailureError("udp://a", "denied"), ErrKindFailure},
+		{"garbage response", fmt.Errorf("transaction ID's do not match"), ErrKindProtocol},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			got := ClassifyError("udp://a", tc.input)
+			if got.Kind != tc.expected {
+				t.Errorf("Got and want are not equal\nGOT:%s\nWANT:%s\n", got.Kind, tc.expected)
+			}
+		})
+	}
+
+	if ClassifyError("udp://a", nil) != nil {
+		t.Errorf("nil error should not be classified")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	expected := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+
+	for failures, want := range expected {
+		if got := policy.Backoff(failures); got != want {
+			t.Errorf("failures %d: Got and want are not equal\nGOT:%s\nWANT:%s\n", failures, got, want)
+		}
+	}
+}
+
+func TestURLDataRoundTrip(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    string
+		expected []byte // nil skips the exact byte comparison
+	}
+
+	long := "/announce?passkey=" + strings.Repeat("a", 300)
+
+	testcases := []TestCase{
+		{"empty path sends nothing", "", []byte{}},
+		{"short path", "/a?k=1", []byte{0x2, 0x6, '/', 'a', '?', 'k', '=', '1', 0x0}},
+		{"long path split over options", long, nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			encoded := EncodeURLData(tc.input)
+			if tc.expected != nil && !bytes.Equal(encoded, tc.expected) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", encoded, tc.expected)
+			}
+
+			got, err := ParseURLData(encoded)
+			if err != nil {
+				t.Fatalf("Unexpected error - %s", err)
+			}
+			if got != tc.input {
+				t.Errorf("Got and want are not equal\nGOT:%q\nWANT:%q\n", got, tc.input)
+			}
+		})
+	}
+}
+
+func TestParseURLDataUnknownOption(t *testing.T) {
+	// options added after BEP 41 are skipped by their length
+	got, err := ParseURLData([]byte{0x9, 0x2, 'x', 'y', 0x1, 0x2, 0x2, '/', 'a', 0x0})
+	if err != nil {
+		t.Fatalf("Unexpected error - %s", err)
+	}
+	if got != "/a" {
+		t.Errorf("Got and want are not equal\nGOT:%q\nWANT:%q\n", got, "/a")
+	}
+}
+
+func TestParseURLDataMalformed(t *testing.T) {
+	testcases := map[string][]byte{
+		"missing length":  {0x2},
+		"length too long": {0x2, 0x5, 'a'},
+		"unknown option":  {0x9, 0x2, 'a'},
+	}
+
+	for testname, input := range testcases {
+		t.Run(testname, func(t *testing.T) {
+			if _, err := ParseURLData(input); err == nil {
+				t.Errorf("Expected an error got none")
+			}
+		})
+	}
+}
+
+func TestHTTPTrackerAnnounce(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		input       string // tobe converted to url obj
+		expected    *TrackerResponse
+		throwsError bool
+	}
+
+	testcase := []TestCase{
+		{
+			testname: "sanity check",
+			input:    "https://torrent.ubuntu.com/announce",
+			expected: &TrackerResponse{
+				FailureReason: "Requested download is not authorized for use with this tracker.",
+			},
+			throwsError: false,
+		},
+		{
+			testname:    "invalid scheme",
+			input:       "udp://tracker.dmcomic.org:2710/announce",
+			expected:    nil,
+			throwsError: true,
+		},
+	}
+
+	req := AnnounceRequest{
+		InfoHash: [20]byte{'T', 'E', 'S', 'T', 'I', 'N', 'G', 'H', 'A', 'S', 'H'},
+		PeerID:   [20]byte{'-', 'G', 'O'},
+		Port:     1234,
+		Left:     1024 * 1024 * 1024,
+		Event:    EventStarted,
+		NumWant:  -1,
+	}
+
+	for _, tc := range testcase {
+		t.Run(tc.testname, func(t *testing.T) {
+			u, _ := url.Parse(tc.input)
+			var got *TrackerResponse
+			trk, err := NewHTTPTracker(u, Options{Dialer: &proxy.Direct{}, Timeout: 5 * time.Second})
+			if err == nil {
+				got, err = trk.Announce(context.Background(), req)
+			}
+
+			// err expected and none given
+			if tc.throwsError && err == nil {
+				t.Errorf("An error was expected however none were thrown")
+				return
+			}
+
+			// err thrown none expected
+			if !tc.throwsError && err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+				return
+			}
+
+			// valid err
+			if err != nil {
+				return
+			}
+
+			// compare only fields we can know before making the request
+			if tc.expected.FailureReason != got.FailureReason {
+				t.Errorf("Got and want are not equal\nGOT:\n%+v\nWANT:\n%+v\n", *got, *tc.expected)
+			}
+		})
+	}
+}
+
+func TestHTTPTrackerSlowServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	t.Run("Slow server check", func(t *testing.T) {
+		trk, err := New(server.URL, Options{})
+		if err != nil {
+			t.Errorf("DEV ERR: cannot make tracker - %s", err)
+			return
+		}
+		_, serverErr := trk.Announce(context.Background(), AnnounceRequest{})
+
+		if serverErr == nil {
+			t.Errorf("Expected an error did not recieve any")
+		}
+	})
+}
+
+func TestHTTPTrackerQuery(t *testing.T) {
+	var gotQuery url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query()
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer server.Close()
+
+	trk, err := New(server.URL+"/announce?passkey=secret", Options{})
+	if err != nil {
+		t.Fatalf("DEV ERR: cannot make tracker - %s", err)
+	}
+
+	req := AnnounceRequest{InfoHash: [20]byte{0xff, ' ', '&'}, Port: 6881, Left: 10, Event: EventCompleted, NumWant: -1}
+	if _, err := trk.Announce(context.Background(), req); err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	expected := map[string]string{
+		"passkey":   "secret",
+		"info_hash": string(req.InfoHash[:]),
+		"port":      "6881",
+		"left":      "10",
+		"event":     "completed",
+		"numwant":   "",
+	}
+	for key, want := range expected {
+		if got := gotQuery.Get(key); got != want {
+			t.Errorf("%s: Got and want are not equal\nGOT:%q\nWANT:%q\n", key, got, want)
+		}
+	}
+}
+
+func TestHTTPTrackerScrapeURL(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		path        string
+		throwsError bool
+	}
+
+	testcases := []TestCase{
+		{"announce path", "/announce", false},
+		{"announce with suffix", "/x/announce.php", false},
+		{"no announce segment", "/a/tracker", true},
+	}
+
+	infoHash := [20]byte{'S', 'C', 'R', 'A', 'P', 'E'}
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		fmt.Fprintf(w, "d5:filesd20:%sd8:completei3e10:downloadedi5e10:incompletei7eeee", infoHash[:])
+	}))
+	defer server.Close()
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			trk, err := New(server.URL+tc.path, Options{})
+			if err != nil {
+				t.Fatalf("DEV ERR: cannot make tracker - %s", err)
+			}
+
+			got, err := trk.Scrape(context.Background(), [][20]byte{infoHash})
+			if tc.throwsError && err == nil {
+				t.Fatalf("Expected an error did not recieve any")
+			}
+			if !tc.throwsError && err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if tc.throwsError {
+				return
+			}
+
+			want := map[[20]byte]ScrapeStats{infoHash: {Complete: 3, Downloaded: 5, Incomplete: 7}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", got, want)
+			}
+			if !strings.Contains(gotPath, "scrape") {
+				t.Errorf("scrape was sent to %s", gotPath)
+			}
+		})
+	}
+}
+
+func TestUDPTrackerConnect(t *testing.T) {
+	type TestCase struct {
+		testname    string
+		input       string // tobe converted to url obj
+		throwsError bool
+	}
+
+	testcase := []TestCase{
+		{
+			testname:    "sanity check",
+			input:       "udp://wepzone.net:6969/announce ",
+			throwsError: false,
+		},
+	}
+
+	for _, tc := range testcase {
+		t.Run(tc.testname, func(t *testing.T) {
+			trk, err := New(tc.input, Options{})
+			if err != nil {
+				t.Fatalf("DEV ERR: cannot make tracker - %s", err)
+			}
+			got, gotErr := trk.(*UDPTracker).Connect(context.Background())
+
+			if tc.throwsError && gotErr == nil {
+				t.Errorf("Expected an error however recieved none")
+			}
+			if !tc.throwsError && gotErr != nil {
+				t.Errorf("An error was thrown none expected, %v", gotErr)
+			}
+
+			if got == 0 {
+				t.Errorf("Got and want are not equal\nGOT:\n%v\nWANT:\nNON-ZERO-NUM", got)
+			}
+		})
+	}
+}
+
+func TestNewUnknownScheme(t *testing.T) {
+	_, err := New("ws://tracker.example/announce", Options{})
+	if !errors.Is(err, ErrUnknownScheme) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrUnknownScheme)
+	}
+}
+
+// startLossyUDPTracker answers connects and announces with a single peer of the address family it
+// listens on, the first datagram is dropped as if it was lost
+func startLossyUDPTracker(t *testing.T, addr string, peer []byte) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.MustParseAddrPort(addr)))
+	if err != nil {
+		t.Skipf("cannot listen on %s, %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for dropped := false; ; dropped = true {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !dropped || n < 16 {
+				continue
+			}
+
+			transactionID := binary.BigEndian.Uint32(buf[12:16])
+			if binary.BigEndian.Uint32(buf[8:12]) == 0 {
+				conn.WriteToUDP(UDPConnectResponse{Action: 0, TransactionID: transactionID, ConnectionID: 7}.Serialize(), from)
+				continue
+			}
+			resp := binary.BigEndian.AppendUint32(nil, 1)
+			resp = binary.BigEndian.AppendUint32(resp, transactionID)
+			resp = append(resp, make([]byte, 12)...)
+			binary.BigEndian.PutUint32(resp[8:], 1800)
+			conn.WriteToUDP(append(resp, peer...), from)
+		}
+	}()
+	return conn
+}
+
+func TestUDPTrackerLossAndIPv6(t *testing.T) {
+	defer func(timeout time.Duration) { udpRetransmitTimeout = timeout }(udpRetransmitTimeout)
+	udpRetransmitTimeout = 20 * time.Millisecond
+
+	type TestCase struct {
+		testname string
+		addr     string
+		peer     []byte // compact peer the tracker returns
+		ipv6     bool
+	}
+
+	testcases := []TestCase{
+		{"ipv4", "127.0.0.1:0", []byte{10, 0, 0, 1, 0x1a, 0xe1}, false},
+		{"ipv6", "[::1]:0", append(net.ParseIP("2001:db8::1").To16(), 0x1a, 0xe1), true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			server := startLossyUDPTracker(t, tc.addr, tc.peer)
+			trk, err := New("udp://"+server.LocalAddr().String()+"/announce", Options{})
+			if err != nil {
+				t.Fatalf("DEV ERR: cannot make tracker - %s", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			resp, err := trk.Announce(ctx, AnnounceRequest{InfoHash: [20]byte{'U', 'D', 'P'}, NumWant: -1})
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+
+			raw, other := resp.RawPeers, resp.RawPeers6
+			if tc.ipv6 {
+				raw, other = other, raw
+			}
+			if !bytes.Equal(raw, tc.peer) || len(other) != 0 || resp.Interval != 1800 {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:the peer %v\n", resp, tc.peer)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// a connection id may be used for one minute after the tracker handed it out
+const udpConnectionTTL = time.Minute
+
+// a request is sent again when no reply arrives within udpRetransmitTimeout * 2^n, for n up to
+// udpMaxRetransmits, see BEP 15
+var (
+	udpRetransmitTimeout = 15 * time.Second
+	udpMaxRetransmits    = 8
+)
+
+// UDPTracker speaks the udp tracker protocol https://www.bittorrent.org/beps/bep_0015.html
+type UDPTracker struct {
+	announceURL *url.URL
+	opts        Options
+
+	mu           sync.Mutex
+	connectionID uint64
+	connectedAt  time.Time
+}
+
+// NewUDPTracker is the Factory registered for the udp scheme
+func NewUDPTracker(announceURL *url.URL, opts Options) (Tracker, error) {
+	if announceURL.Scheme != "udp" {
+		return nil, fmt.Errorf("invalid scheme, wanted udp got %s", announceURL.Scheme)
+	}
+	return &UDPTracker{announceURL: announceURL, opts: opts}, nil
+}
+
+/*
+Connect returns a connection id to present on each subsequent request, the id is cached
+and reused until it expires
+Offset  Size            Name            Value
+0       64-bit integer  protocol_id     0x41727101980  magic constant
+8       32-bit integer  action          0 // connect
+12      32-bit integer  transaction_id
+16
+*/
+func (u *UDPTracker) Connect(ctx context.Context) (uint64, error) {
+	u.mu.Lock()
+	if !u.connectedAt.IsZero() && time.Since(u.connectedAt) < udpConnectionTTL {
+		defer u.mu.Unlock()
+		return u.connectionID, nil
+	}
+	u.mu.Unlock()
+
+	connectMsg, transactionID := NewUDPConnectRequest()
+
+	response, _, err := u.sendAndRecv(ctx, connectMsg.Serialize())
+	if err != nil {
+		return 0, err
+	}
+
+	if errTransactionID, message, ok := DeserializeUDPError(response); ok && errTransactionID == transactionID {
+		return 0, NewFailureError(u.announceURL.String(), message)
+	}
+
+	responseStruct, err := DeserializeUDPConnectResponse(response)
+	if err != nil {
+		return 0, err
+	}
+
+	if transactionID != responseStruct.TransactionID {
+		return 0, fmt.Errorf("transactionID does not match with generated number in request, expected %d, got %d", transactionID, responseStruct.TransactionID)
+	}
+
+	u.mu.Lock()
+	u.connectionID = responseStruct.ConnectionID
+	u.connectedAt = time.Now()
+	u.mu.Unlock()
+
+	return responseStruct.ConnectionID, nil
+}
+
+// Announce connects if needed then sends the 98 byte announce followed by BEP 41 url data. Peers are
+// returned in RawPeers6 when the tracker is reached over ipv6
+func (u *UDPTracker) Announce(ctx context.Context, req AnnounceRequest) (*TrackerResponse, error) {
+	connectionID, err := u.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionID := randomUint32()
+	msg := UDPAnnounceRequest{
+		ConnectionID:  int64(connectionID),
+		TransactionID: int32(transactionID),
+		InfoHash:      req.InfoHash,
+		PeerID:        req.PeerID,
+		Downloaded:    int64(req.Downloaded),
+		Left:          int64(req.Left),
+		Uploaded:      int64(req.Uploaded),
+		Event:         int32(req.Event),
+		Key:           req.Key,
+		NumWant:       req.NumWant,
+		Port:          req.Port,
+	}.Serialize()
+
+	// private trackers authenticate with the path / passkey query
+	msg = append(msg, EncodeURLData(u.requestString())...)
+
+	resp, remote, err := u.sendAndRecv(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tracker may reject the announce with an error action instead
+	if errTransactionID, message, ok := DeserializeUDPError(resp); ok && errTransactionID == transactionID {
+		return nil, NewFailureError(u.announceURL.String(), message)
+	}
+
+	if len(resp) < 20 {
+		return nil, fmt.Errorf("response malformed : number of bytes is less than 20")
+	}
+
+	if action := binary.BigEndian.Uint32(resp[:4]); action != 1 {
+		return nil, fmt.Errorf("response unexpected value - the value of announce in the response was not 1 (announce request response)")
+	}
+
+	if binary.BigEndian.Uint32(resp[4:8]) != transactionID {
+		return nil, fmt.Errorf("transaction ID's do not match")
+	}
+
+	res := &TrackerResponse{
+		Interval:   int64(binary.BigEndian.Uint32(resp[8:12])),
+		Incomplete: int64(binary.BigEndian.Uint32(resp[12:16])),
+		Complete:   int64(binary.BigEndian.Uint32(resp[16:20])),
+	}
+
+	// the tracker answers with peers of the address family it was reached over
+	peerBlob := resp[20:]
+	if isIPv6(remote) {
+		if len(peerBlob)%18 != 0 {
+			return nil, fmt.Errorf("length of peer blob is not a valid size 18N")
+		}
+		res.RawPeers6 = peerBlob
+		return res, nil
+	}
+	if len(peerBlob)%6 != 0 {
+		return nil, fmt.Errorf("length of peer blob is not a valid size 6N")
+	}
+	res.RawPeers = peerBlob
+	return res, nil
+}
+
+/*
+Scrape requests swarm stats for up to 74 torrents at once
+Offset          Size            Name            Value
+0               64-bit integer  connection_id
+8               32-bit integer  action          2 // scrape
+12              32-bit integer  transaction_id
+16 + 20 * n     20-byte string  info_hash
+response, 12 bytes per info hash in request order
+8 + 12 * n      32-bit integer  seeders
+12 + 12 * n     32-bit integer  completed
+16 + 12 * n     32-bit integer  leechers
+*/
+func (u *UDPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeStats, error) {
+	connectionID, err := u.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionID := randomUint32()
+	msg := make([]byte, 16, 16+20*len(infoHashes))
+	binary.BigEndian.PutUint64(msg, connectionID)
+	binary.BigEndian.PutUint32(msg[8:], 2) // action = scrape
+	binary.BigEndian.PutUint32(msg[12:], transactionID)
+	for _, infoHash := range infoHashes {
+		msg = append(msg, infoHash[:]...)
+	}
+
+	resp, _, err := u.sendAndRecv(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if errTransactionID, message, ok := DeserializeUDPError(resp); ok && errTransactionID == transactionID {
+		return nil, NewFailureError(u.announceURL.String(), message)
+	}
+
+	if len(resp) < 8 || binary.BigEndian.Uint32(resp[:4]) != 2 {
+		return nil, fmt.Errorf("response is not a valid scrape response")
+	}
+	if binary.BigEndian.Uint32(resp[4:8]) != transactionID {
+		return nil, fmt.Errorf("transaction ID's do not match")
+	}
+	if len(resp)-8 != 12*len(infoHashes) {
+		return nil, fmt.Errorf("scrape response has %d bytes of stats, expected %d", len(resp)-8, 12*len(infoHashes))
+	}
+
+	res := make(map[[20]byte]ScrapeStats, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		stats := resp[8+12*i:]
+		res[infoHash] = ScrapeStats{
+			Complete:   uint64(binary.BigEndian.Uint32(stats[0:4])),
+			Downloaded: uint64(binary.BigEndian.Uint32(stats[4:8])),
+			Incomplete: uint64(binary.BigEndian.Uint32(stats[8:12])),
+		}
+	}
+
+	return res, nil
+}
+
+// Close forgets the cached connection id, sockets are only held for the length of a request
+func (u *UDPTracker) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.connectedAt = time.Time{}
+	return nil
+}
+
+/*
+sendAndRecv sends a message over a socket opened through the dialer and waits for the reply, returning it with
+the address of the tracker. Datagrams may be lost so the message is sent again whenever no reply arrives in
+time, waiting twice as long each time as BEP 15 asks, until ctx is done
+*/
+func (u *UDPTracker) sendAndRecv(ctx context.Context, msg []byte) ([]byte, net.Addr, error) {
+	conn, err := u.opts.Dialer.DialContext(ctx, "udp", u.announceURL.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	// a cancelled request must not wait out the retransmit timeout
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buf := make([]byte, 2048)
+	for n := 0; ; n++ {
+		deadline := time.Now().Add(udpRetransmitTimeout << n)
+		last := n >= udpMaxRetransmits
+		if ctxDeadline, ok := ctx.Deadline(); ok && !ctxDeadline.After(deadline) {
+			deadline, last = ctxDeadline, true
+		}
+		conn.SetDeadline(deadline)
+
+		if _, err := conn.Write(msg); err != nil {
+			return nil, nil, udpRequestErr(ctx, err)
+		}
+
+		read, err := conn.Read(buf)
+		if err == nil {
+			return buf[:read], conn.RemoteAddr(), nil
+		}
+		var netErr net.Error
+		if last || !errors.As(err, &netErr) || !netErr.Timeout() {
+			return nil, nil, udpRequestErr(ctx, err)
+		}
+	}
+}
+
+// udpRequestErr prefers the error of ctx over that of the socket it closed
+func udpRequestErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// isIPv6 reports whether addr is an ipv6 address, hostnames a proxy resolves count as ipv4
+func isIPv6(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+// requestString returns the path and query of the announce url as sent in BEP 41 url data
+func (u *UDPTracker) requestString() string {
+	res := u.announceURL.EscapedPath()
+	if u.announceURL.RawQuery != "" {
+		res += "?" + u.announceURL.RawQuery
+	}
+	return res
+}
@@ -0,0 +1,195 @@
+package trackerserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+)
+
+// ServeHTTP implements http.Handler, routing /announce and /scrape
+// trackers reply with status 200 and a "failure reason" dict on bad requests as clients only read the body
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/announce"):
+		s.handleHTTPAnnounce(w, r)
+	case strings.HasSuffix(r.URL.Path, "/scrape"):
+		s.handleHTTPScrape(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleHTTPAnnounce(w http.ResponseWriter, r *http.Request) {
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		writeFailure(w, "malformed query string")
+		return
+	}
+
+	req, err := parseHTTPAnnounce(query, r.RemoteAddr)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+	req.path = r.URL.Path
+	req.query = query
+
+	res, err := s.announce(*req)
+	if err != nil {
+		writeFailure(w, err.Error())
+		return
+	}
+
+	body := map[string]any{
+		"interval":   int64(s.config.Interval.Seconds()),
+		"complete":   res.seeders,
+		"incomplete": res.leechers,
+	}
+	if s.config.MinInterval > 0 {
+		body["min interval"] = int64(s.config.MinInterval.Seconds())
+	}
+
+	if query.Get("compact") == "1" {
+		v4, v6 := compactPeers(res.peers)
+		body["peers"] = v4
+		if len(v6) > 0 {
+			body["peers6"] = v6
+		}
+	} else {
+		noPeerID := query.Get("no_peer_id") == "1"
+		peerList := make([]any, 0, len(res.peers))
+		for _, p := range res.peers {
+			entry := map[string]any{
+				"ip":   p.ip.String(),
+				"port": p.port,
+			}
+			if !noPeerID {
+				entry["peer id"] = p.peerID[:]
+			}
+			peerList = append(peerList, entry)
+		}
+		body["peers"] = peerList
+	}
+
+	writeBencode(w, body)
+}
+
+func (s *Server) handleHTTPScrape(w http.ResponseWriter, r *http.Request) {
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		writeFailure(w, "malformed query string")
+		return
+	}
+
+	infoHashes := [][20]byte{}
+	for _, raw := range query["info_hash"] {
+		if len(raw) != 20 {
+			writeFailure(w, "info_hash must be 20 bytes")
+			return
+		}
+		infoHashes = append(infoHashes, [20]byte([]byte(raw)))
+	}
+
+	files := map[string]any{}
+	for h, stats := range s.scrape(infoHashes) {
+		files[string(h[:])] = stats
+	}
+
+	writeBencode(w, map[string]any{"files": files})
+}
+
+// parseHTTPAnnounce validates the query parameters defined in the bittorrent spec
+func parseHTTPAnnounce(query url.Values, remoteAddr string) (*announceRequest, error) {
+	req := &announceRequest{numWant: -1}
+
+	infoHash := query.Get("info_hash")
+	if len(infoHash) != 20 {
+		return nil, fmt.Errorf("info_hash must be 20 bytes")
+	}
+	copy(req.infoHash[:], infoHash)
+
+	peerID := query.Get("peer_id")
+	if len(peerID) != 20 {
+		return nil, fmt.Errorf("peer_id must be 20 bytes")
+	}
+	copy(req.peerID[:], peerID)
+
+	port, err := strconv.ParseUint(query.Get("port"), 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port")
+	}
+	req.port = uint16(port)
+
+	// counters are optional for lenient clients, but must be numbers if present
+	counters := []struct {
+		name string
+		dst  *uint64
+	}{
+		{"uploaded", &req.uploaded},
+		{"downloaded", &req.downloaded},
+		{"left", &req.left},
+	}
+	for _, c := range counters {
+		raw := query.Get(c.name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s", c.name)
+		}
+		*c.dst = v
+	}
+
+	if raw := query.Get("numwant"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid numwant")
+		}
+		req.numWant = n
+	}
+
+	switch query.Get("event") {
+	case "", "empty":
+		req.event = EventNone
+	case "started":
+		req.event = EventStarted
+	case "completed":
+		req.event = EventCompleted
+	case "stopped":
+		req.event = EventStopped
+	default:
+		return nil, fmt.Errorf("unknown event")
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine peer address")
+	}
+	req.ip = net.ParseIP(host)
+	if req.ip == nil {
+		return nil, fmt.Errorf("unable to determine peer address")
+	}
+
+	return req, nil
+}
+
+func writeFailure(w http.ResponseWriter, reason string) {
+	writeBencode(w, map[string]any{"failure reason": reason})
+}
+
+func writeBencode(w http.ResponseWriter, body map[string]any) {
+	encoded, err := bencodeparser.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(encoded)
+}
@@ -0,0 +1,415 @@
+// Package trackerserver is an embedded bittorrent tracker, it keeps swarm state in memory and
+// answers announce / scrape requests over HTTP and UDP (https://www.bittorrent.org/beps/bep_0015.html)
+package trackerserver
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ============ Struct Defs  ============ //
+
+// Event is the announce event sent by a client, values match the UDP tracker protocol
+type Event uint32
+
+const (
+	EventNone Event = iota
+	EventCompleted
+	EventStarted
+	EventStopped
+)
+
+// Config holds the tunables of the tracker server
+type Config struct {
+	Interval    time.Duration // how often clients are told to re-announce
+	MinInterval time.Duration // clients must not re-announce faster than this
+	PeerTTL     time.Duration // peers not heard from within this window are expired
+	DefaultWant int           // number of peers returned when the client does not ask for a number
+	MaxWant     int           // hard cap on the number of peers returned
+	Allowlist   [][20]byte    // if non empty only these info hashes are tracked
+
+	// Authorize is an optional hook for private trackers, it receives the announce path and query
+	// (for udp these come from the BEP 41 url data) and rejects the announce by returning an error
+	Authorize func(infoHash [20]byte, path string, query url.Values) error
+}
+
+// DefaultConfig returns the values used by most public trackers
+func DefaultConfig() Config {
+	return Config{
+		Interval:    30 * time.Minute,
+		MinInterval: 5 * time.Minute,
+		PeerTTL:     45 * time.Minute,
+		DefaultWant: 50,
+		MaxWant:     200,
+	}
+}
+
+// Server holds the swarm state shared by the HTTP and UDP front ends
+type Server struct {
+	config  Config
+	allowed map[[20]byte]struct{}
+
+	mu            sync.Mutex
+	swarms        map[[20]byte]*swarm
+	connectionIDs map[uint64]time.Time // udp connection ids and when they expire
+
+	httpServer *http.Server
+	udpConn    net.PacketConn
+	startOnce  sync.Once
+	done       chan struct{}
+	wg         sync.WaitGroup
+
+	now func() time.Time // swapped in tests to move time forward
+}
+
+type swarm struct {
+	peers     map[[20]byte]*peerEntry // keyed by peer id
+	completed uint64                  // number of completed events seen, the scrape "downloaded" field
+}
+
+type peerEntry struct {
+	peerID   [20]byte
+	ip       net.IP
+	port     uint16
+	left     uint64
+	lastSeen time.Time
+}
+
+// announceRequest is the protocol independent form of an announce
+type announceRequest struct {
+	infoHash   [20]byte
+	peerID     [20]byte
+	ip         net.IP
+	port       uint16
+	uploaded   uint64
+	downloaded uint64
+	left       uint64
+	event      Event
+	numWant    int
+	path       string     // announce path, used by Config.Authorize
+	query      url.Values // announce query, used by Config.Authorize
+}
+
+// announceResult is what gets encoded back to the client by either front end
+type announceResult struct {
+	peers    []peerEntry
+	seeders  int
+	leechers int
+}
+
+// ScrapeStats mirrors the per torrent dict of a scrape response
+type ScrapeStats struct {
+	Complete   uint64 `bencode:"complete"`
+	Downloaded uint64 `bencode:"downloaded"`
+	Incomplete uint64 `bencode:"incomplete"`
+}
+
+// ErrNotAllowed occurs when an info hash is not on the allowlist
+var ErrNotAllowed = fmt.Errorf("info hash is not allowed on this tracker")
+
+// ============ Method Defs  ============ //
+
+func NewServer(config Config) *Server {
+	defaults := DefaultConfig()
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.PeerTTL <= 0 {
+		config.PeerTTL = defaults.PeerTTL
+	}
+	if config.DefaultWant <= 0 {
+		config.DefaultWant = defaults.DefaultWant
+	}
+	if config.MaxWant <= 0 {
+		config.MaxWant = defaults.MaxWant
+	}
+
+	var allowed map[[20]byte]struct{}
+	if len(config.Allowlist) > 0 {
+		allowed = make(map[[20]byte]struct{}, len(config.Allowlist))
+		for _, h := range config.Allowlist {
+			allowed[h] = struct{}{}
+		}
+	}
+
+	return &Server{
+		config:        config,
+		allowed:       allowed,
+		swarms:        map[[20]byte]*swarm{},
+		connectionIDs: map[uint64]time.Time{},
+		done:          make(chan struct{}),
+		now:           time.Now,
+	}
+}
+
+// ListenHTTP starts serving announce and scrape over HTTP on addr, returns the bound address
+func (s *Server) ListenHTTP(addr string) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.httpServer = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	s.start()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.httpServer.Serve(ln)
+	}()
+
+	return ln.Addr(), nil
+}
+
+// ListenUDP starts serving the BEP 15 protocol on addr, returns the bound address
+func (s *Server) ListenUDP(addr string) (net.Addr, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.udpConn = conn
+	s.start()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.serveUDP(conn)
+	}()
+
+	return conn.LocalAddr(), nil
+}
+
+// Close shuts down both front ends and the expiry loop
+func (s *Server) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+
+	var err error
+	if s.httpServer != nil {
+		err = s.httpServer.Close()
+	}
+	if s.udpConn != nil {
+		if udpErr := s.udpConn.Close(); err == nil {
+			err = udpErr
+		}
+	}
+
+	s.wg.Wait()
+	return err
+}
+
+// start runs the expiry loop once for the lifetime of the server
+func (s *Server) start() {
+	s.startOnce.Do(func() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			ticker := time.NewTicker(max(s.config.PeerTTL/4, time.Second))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.done:
+					return
+				case <-ticker.C:
+					s.Sweep()
+				}
+			}
+		}()
+	})
+}
+
+// Sweep drops peers that have not announced within PeerTTL and empty swarms,
+// it returns the number of peers removed
+func (s *Server) Sweep() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	removed := 0
+	for infoHash, sw := range s.swarms {
+		for id, p := range sw.peers {
+			if now.Sub(p.lastSeen) > s.config.PeerTTL {
+				delete(sw.peers, id)
+				removed++
+			}
+		}
+		if len(sw.peers) == 0 && sw.completed == 0 {
+			delete(s.swarms, infoHash)
+		}
+	}
+
+	for id, expires := range s.connectionIDs {
+		if now.After(expires) {
+			delete(s.connectionIDs, id)
+		}
+	}
+
+	return removed
+}
+
+func (s *Server) isAllowed(infoHash [20]byte) bool {
+	if s.allowed == nil {
+		return true
+	}
+	_, ok := s.allowed[infoHash]
+	return ok
+}
+
+// announce updates the swarm with the requesting peer and returns a random selection of other peers
+func (s *Server) announce(req announceRequest) (*announceResult, error) {
+	if !s.isAllowed(req.infoHash) {
+		return nil, ErrNotAllowed
+	}
+	if s.config.Authorize != nil {
+		if err := s.config.Authorize(req.infoHash, req.path, req.query); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sw, ok := s.swarms[req.infoHash]
+	if !ok {
+		sw = &swarm{peers: map[[20]byte]*peerEntry{}}
+		s.swarms[req.infoHash] = sw
+	}
+
+	if req.event == EventStopped {
+		delete(sw.peers, req.peerID)
+	} else {
+		entry, ok := sw.peers[req.peerID]
+		if !ok {
+			entry = &peerEntry{peerID: req.peerID}
+			sw.peers[req.peerID] = entry
+		}
+		// only count a completion when the peer transitions into a seeder
+		if req.event == EventCompleted && (!ok || entry.left != 0) {
+			sw.completed++
+		}
+		entry.ip = req.ip
+		entry.port = req.port
+		entry.left = req.left
+		entry.lastSeen = s.now()
+	}
+
+	numWant := req.numWant
+	if numWant < 0 {
+		numWant = s.config.DefaultWant
+	}
+	numWant = min(numWant, s.config.MaxWant)
+
+	res := &announceResult{}
+	candidates := make([]peerEntry, 0, len(sw.peers))
+	for id, p := range sw.peers {
+		if p.left == 0 {
+			res.seeders++
+		} else {
+			res.leechers++
+		}
+		if id == req.peerID {
+			continue
+		}
+		// seeders have nothing to gain from other seeders
+		if req.left == 0 && p.left == 0 {
+			continue
+		}
+		candidates = append(candidates, *p)
+	}
+
+	mathrand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	res.peers = candidates[:min(numWant, len(candidates))]
+
+	return res, nil
+}
+
+// scrape returns stats for the requested info hashes, or every tracked torrent when none are given
+func (s *Server) scrape(infoHashes [][20]byte) map[[20]byte]ScrapeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(infoHashes) == 0 {
+		for h := range s.swarms {
+			infoHashes = append(infoHashes, h)
+		}
+	}
+
+	res := make(map[[20]byte]ScrapeStats, len(infoHashes))
+	for _, h := range infoHashes {
+		if !s.isAllowed(h) {
+			continue
+		}
+		stats := ScrapeStats{}
+		if sw, ok := s.swarms[h]; ok {
+			stats.Downloaded = sw.completed
+			for _, p := range sw.peers {
+				if p.left == 0 {
+					stats.Complete++
+				} else {
+					stats.Incomplete++
+				}
+			}
+		}
+		res[h] = stats
+	}
+
+	return res
+}
+
+// newConnectionID hands out a udp connection id valid for two minutes as per BEP 15
+func (s *Server) newConnectionID() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	id := binary.BigEndian.Uint64(b[:])
+
+	s.mu.Lock()
+	s.connectionIDs[id] = s.now().Add(2 * time.Minute)
+	s.mu.Unlock()
+
+	return id
+}
+
+func (s *Server) validConnectionID(id uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, ok := s.connectionIDs[id]
+	return ok && s.now().Before(expires)
+}
+
+// ============ Helpers  ============ //
+
+// compactPeers packs peers into the 6 byte (ipv4) and 18 byte (ipv6) formats
+func compactPeers(peerList []peerEntry) (v4 []byte, v6 []byte) {
+	for _, p := range peerList {
+		var port [2]byte
+		binary.BigEndian.PutUint16(port[:], p.port)
+		if ip4 := p.ip.To4(); ip4 != nil {
+			v4 = append(v4, ip4...)
+			v4 = append(v4, port[:]...)
+			continue
+		}
+		if ip6 := p.ip.To16(); ip6 != nil {
+			v6 = append(v6, ip6...)
+			v6 = append(v6, port[:]...)
+		}
+	}
+	return v4, v6
+}
@@ -0,0 +1,267 @@
+package trackerserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	bencodeparser "github.com/firozt/go-torrent/src/internal/BencodeParser"
+	tracker "github.com/firozt/go-torrent/src/internal/Tracker"
+)
+
+var testInfoHash = [20]byte{'I', 'N', 'F', 'O', 'H', 'A', 'S', 'H'}
+
+func peerIDFor(c byte) [20]byte {
+	var id [20]byte
+	for i := range id {
+		id[i] = c
+	}
+	return id
+}
+
+// httpAnnounce performs an announce through the http handler from remoteAddr and decodes the reply
+func httpAnnounce(t *testing.T, s *Server, remoteAddr string, peerID [20]byte, params url.Values) map[string]any {
+	t.Helper()
+
+	query := url.Values{
+		"info_hash": {string(testInfoHash[:])},
+		"peer_id":   {string(peerID[:])},
+		"port":      {"6881"},
+		"left":      {"100"},
+	}
+	for k, v := range params {
+		query[k] = v
+	}
+
+	req := httptest.NewRequest("GET", "/announce?"+query.Encode(), nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	decoded, err := bencodeparser.DecodeBytes(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("unable to decode response %q - %s", rec.Body.String(), err)
+	}
+	return decoded.(map[string]any)
+}
+
+func TestHTTPAnnounce(t *testing.T) {
+	type TestCase struct {
+		testname      string
+		remoteAddr    string
+		params        url.Values
+		expectedKey   string
+		expectedBytes int // size of the peers value, or number of dicts when non compact
+	}
+
+	testcases := []TestCase{
+		{"compact ipv4", "10.0.0.3:1000", url.Values{"compact": {"1"}}, "peers", 12},
+		{"compact ipv6 peers split out", "10.0.0.3:1000", url.Values{"compact": {"1"}}, "peers6", 18},
+		{"non compact", "10.0.0.3:1000", url.Values{}, "peers", 3},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			s := NewServer(DefaultConfig())
+			httpAnnounce(t, s, "10.0.0.1:1000", peerIDFor('a'), nil)
+			httpAnnounce(t, s, "10.0.0.2:1000", peerIDFor('b'), nil)
+			httpAnnounce(t, s, "[2001:db8::1]:1000", peerIDFor('c'), nil)
+
+			got := httpAnnounce(t, s, tc.remoteAddr, peerIDFor('d'), tc.params)
+
+			if reason, ok := got["failure reason"]; ok {
+				t.Fatalf("unexpected failure - %s", reason)
+			}
+			if got["interval"] != int64(1800) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:1800", got["interval"])
+			}
+			if got["incomplete"] != int64(4) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:4", got["incomplete"])
+			}
+
+			switch v := got[tc.expectedKey].(type) {
+			case string:
+				if len(v) != tc.expectedBytes {
+					t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d", len(v), tc.expectedBytes)
+				}
+			case []any:
+				if len(v) != tc.expectedBytes {
+					t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d", len(v), tc.expectedBytes)
+				}
+				first := v[0].(map[string]any)
+				if _, ok := first["peer id"]; !ok {
+					t.Errorf("expected peer id in non compact peer dict, got %v", first)
+				}
+			default:
+				t.Errorf("unexpected type for %s - %T", tc.expectedKey, v)
+			}
+		})
+	}
+}
+
+func TestHTTPAnnounceFailures(t *testing.T) {
+	type TestCase struct {
+		testname string
+		config   Config
+		params   url.Values
+	}
+
+	testcases := []TestCase{
+		{"not on allowlist", Config{Allowlist: [][20]byte{{'o', 't', 'h', 'e', 'r'}}}, nil},
+		{"bad info hash", DefaultConfig(), url.Values{"info_hash": {"short"}}},
+		{"bad port", DefaultConfig(), url.Values{"port": {"notaport"}}},
+		{"bad event", DefaultConfig(), url.Values{"event": {"exploded"}}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			s := NewServer(tc.config)
+			got := httpAnnounce(t, s, "10.0.0.1:1000", peerIDFor('a'), tc.params)
+			if _, ok := got["failure reason"]; !ok {
+				t.Errorf("Expected a failure reason, got %v", got)
+			}
+		})
+	}
+}
+
+func TestPeerExpiry(t *testing.T) {
+	config := DefaultConfig()
+	config.PeerTTL = time.Minute
+	s := NewServer(config)
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	httpAnnounce(t, s, "10.0.0.1:1000", peerIDFor('a'), nil)
+	now = now.Add(30 * time.Second)
+	httpAnnounce(t, s, "10.0.0.2:1000", peerIDFor('b'), nil)
+
+	now = now.Add(45 * time.Second)
+	if removed := s.Sweep(); removed != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:1", removed)
+	}
+
+	stats := s.scrape([][20]byte{testInfoHash})[testInfoHash]
+	if stats.Incomplete != 1 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:1", stats.Incomplete)
+	}
+}
+
+func TestStoppedAndCompletedEvents(t *testing.T) {
+	s := NewServer(DefaultConfig())
+
+	httpAnnounce(t, s, "10.0.0.1:1000", peerIDFor('a'), nil)
+	httpAnnounce(t, s, "10.0.0.2:1000", peerIDFor('b'), nil)
+	httpAnnounce(t, s, "10.0.0.1:1000", peerIDFor('a'), url.Values{"event": {"completed"}, "left": {"0"}})
+	httpAnnounce(t, s, "10.0.0.2:1000", peerIDFor('b'), url.Values{"event": {"stopped"}})
+
+	got := s.scrape(nil)[testInfoHash]
+	want := ScrapeStats{Complete: 1, Downloaded: 1, Incomplete: 0}
+	if got != want {
+		t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v", got, want)
+	}
+}
+
+func TestUDPProtocol(t *testing.T) {
+	s := NewServer(DefaultConfig())
+	addr, err := s.ListenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen - %s", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatalf("unable to dial - %s", err)
+	}
+	defer conn.Close()
+
+	roundTrip := func(msg []byte) []byte {
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatalf("unable to write - %s", err)
+		}
+		buf := make([]byte, 2048)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("unable to read - %s", err)
+		}
+		return buf[:n]
+	}
+
+	connectReq, transactionID := tracker.NewUDPConnectRequest()
+	connectResp, err := tracker.DeserializeUDPConnectResponse(roundTrip(connectReq.Serialize()))
+	if err != nil {
+		t.Fatalf("unexpected error - %s", err)
+	}
+	if connectResp.TransactionID != transactionID {
+		t.Fatalf("transaction ids differ, got %d wanted %d", connectResp.TransactionID, transactionID)
+	}
+
+	// another peer is already in the swarm
+	s.announce(announceRequest{infoHash: testInfoHash, peerID: peerIDFor('z'), ip: net.IPv4(10, 0, 0, 9), port: 51413, left: 0})
+
+	announce := tracker.UDPAnnounceRequest{
+		ConnectionID:  int64(connectResp.ConnectionID),
+		TransactionID: 77,
+		InfoHash:      testInfoHash,
+		PeerID:        peerIDFor('a'),
+		Left:          10,
+		Event:         int32(EventStarted),
+		NumWant:       -1,
+		Port:          6881,
+	}
+	resp := roundTrip(announce.Serialize())
+
+	if len(resp) != 26 {
+		t.Fatalf("expected one peer in the response, got %d bytes", len(resp))
+	}
+	if action := binary.BigEndian.Uint32(resp); action != actionAnnounce {
+		t.Fatalf("expected announce action, got %d - %s", action, resp[8:])
+	}
+	if !bytes.Equal(resp[20:], []byte{10, 0, 0, 9, 0xc8, 0xd5}) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:10.0.0.9:51413", resp[20:])
+	}
+
+	// scrape
+	scrape := make([]byte, 16, 36)
+	binary.BigEndian.PutUint64(scrape, connectResp.ConnectionID)
+	binary.BigEndian.PutUint32(scrape[8:], actionScrape)
+	binary.BigEndian.PutUint32(scrape[12:], 5)
+	scrape = append(scrape, testInfoHash[:]...)
+	resp = roundTrip(scrape)
+	if len(resp) != 20 || binary.BigEndian.Uint32(resp[8:]) != 1 || binary.BigEndian.Uint32(resp[16:]) != 1 {
+		t.Errorf("unexpected scrape response %v", resp)
+	}
+
+	// bad connection id
+	announce.ConnectionID = 1
+	resp = roundTrip(announce.Serialize())
+	if action := binary.BigEndian.Uint32(resp); action != actionError {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d", action, actionError)
+	}
+}
+
+func TestUDPAnnounceIPv6(t *testing.T) {
+	s := NewServer(DefaultConfig())
+	s.announce(announceRequest{infoHash: testInfoHash, peerID: peerIDFor('v'), ip: net.ParseIP("10.0.0.1"), port: 1, left: 1})
+	s.announce(announceRequest{infoHash: testInfoHash, peerID: peerIDFor('w'), ip: net.ParseIP("2001:db8::2"), port: 2, left: 1})
+
+	connectionID := s.newConnectionID()
+	announce := tracker.UDPAnnounceRequest{
+		ConnectionID: int64(connectionID),
+		InfoHash:     testInfoHash,
+		PeerID:       peerIDFor('a'),
+		NumWant:      -1,
+		Port:         6881,
+	}
+
+	resp := s.handleUDPPacket(announce.Serialize(), &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1000})
+	if len(resp) != 20+18 {
+		t.Errorf("expected a single 18 byte ipv6 peer, got %d bytes", len(resp))
+	}
+}
@@ -0,0 +1,171 @@
+package trackerserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/url"
+
+	tracker "github.com/firozt/go-torrent/src/internal/Tracker"
+)
+
+// udp actions as defined in BEP 15
+const (
+	actionConnect  uint32 = 0
+	actionAnnounce uint32 = 1
+	actionScrape   uint32 = 2
+	actionError    uint32 = 3
+)
+
+// serveUDP reads packets until the connection is closed, each packet gets at most one reply
+func (s *Server) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		resp := s.handleUDPPacket(buf[:n], addr)
+		if resp != nil {
+			conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// handleUDPPacket returns the reply for a single packet, or nil when it should be dropped silently
+func (s *Server) handleUDPPacket(packet []byte, addr net.Addr) []byte {
+	// every request starts with connection_id || action || transaction_id
+	if len(packet) < 16 {
+		return nil
+	}
+	action := binary.BigEndian.Uint32(packet[8:12])
+	transactionID := binary.BigEndian.Uint32(packet[12:16])
+
+	switch action {
+	case actionConnect:
+		req, err := tracker.DeserializeUDPConnectRequest(packet)
+		if err != nil {
+			return nil
+		}
+		return tracker.UDPConnectResponse{
+			Action:        actionConnect,
+			TransactionID: req.TransactionID,
+			ConnectionID:  s.newConnectionID(),
+		}.Serialize()
+
+	case actionAnnounce:
+		if !s.validConnectionID(binary.BigEndian.Uint64(packet[:8])) {
+			return udpError(transactionID, "invalid connection id")
+		}
+		req, err := tracker.DeserializeUDPAnnounceRequest(packet)
+		if err != nil {
+			return udpError(transactionID, err.Error())
+		}
+		return s.handleUDPAnnounce(req, packet[98:], addr)
+
+	case actionScrape:
+		if !s.validConnectionID(binary.BigEndian.Uint64(packet[:8])) {
+			return udpError(transactionID, "invalid connection id")
+		}
+		return s.handleUDPScrape(packet[16:], transactionID)
+
+	default:
+		return udpError(transactionID, "unknown action")
+	}
+}
+
+func (s *Server) handleUDPAnnounce(req *tracker.UDPAnnounceRequest, options []byte, addr net.Addr) []byte {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return udpError(uint32(req.TransactionID), "unable to determine peer address")
+	}
+
+	// BEP 41, the path and query of the announce url follow the fixed size request
+	path, query := "", url.Values{}
+	if len(options) > 0 {
+		requestString, err := tracker.ParseURLData(options)
+		if err != nil {
+			return udpError(uint32(req.TransactionID), err.Error())
+		}
+		if parsed, err := url.Parse(requestString); err == nil {
+			path, query = parsed.Path, parsed.Query()
+		}
+	}
+
+	res, err := s.announce(announceRequest{
+		infoHash:   req.InfoHash,
+		peerID:     req.PeerID,
+		ip:         udpAddr.IP,
+		port:       req.Port,
+		uploaded:   uint64(req.Uploaded),
+		downloaded: uint64(req.Downloaded),
+		left:       uint64(req.Left),
+		event:      Event(req.Event),
+		numWant:    int(req.NumWant),
+		path:       path,
+		query:      query,
+	})
+	if err != nil {
+		return udpError(uint32(req.TransactionID), err.Error())
+	}
+
+	// the address family of the request decides the peer format of the reply
+	v4, v6 := compactPeers(res.peers)
+	rawPeers := v4
+	if udpAddr.IP.To4() == nil {
+		rawPeers = v6
+	}
+
+	return tracker.UDPAnnounceResponse{
+		TransactionID: uint32(req.TransactionID),
+		Interval:      uint32(s.config.Interval.Seconds()),
+		Leechers:      uint32(res.leechers),
+		Seeders:       uint32(res.seeders),
+		RawPeers:      rawPeers,
+	}.Serialize()
+}
+
+/*
+scrape response structure
+Offset      Size            Name            Value
+0           32-bit integer  action          2 // scrape
+4           32-bit integer  transaction_id
+8 + 12 * n  32-bit integer  seeders
+12 + 12 * n 32-bit integer  completed
+16 + 12 * n 32-bit integer  leechers
+*/
+func (s *Server) handleUDPScrape(rawHashes []byte, transactionID uint32) []byte {
+	if len(rawHashes) == 0 || len(rawHashes)%20 != 0 {
+		return udpError(transactionID, "malformed info hash list")
+	}
+
+	infoHashes := make([][20]byte, 0, len(rawHashes)/20)
+	for i := 0; i < len(rawHashes); i += 20 {
+		infoHashes = append(infoHashes, [20]byte(rawHashes[i:i+20]))
+	}
+
+	stats := s.scrape(infoHashes)
+
+	msg := make([]byte, 8, 8+12*len(infoHashes))
+	binary.BigEndian.PutUint32(msg, actionScrape)
+	binary.BigEndian.PutUint32(msg[4:], transactionID)
+	for _, h := range infoHashes {
+		st := stats[h] // disallowed hashes are reported as zeros
+		msg = binary.BigEndian.AppendUint32(msg, uint32(st.Complete))
+		msg = binary.BigEndian.AppendUint32(msg, uint32(st.Downloaded))
+		msg = binary.BigEndian.AppendUint32(msg, uint32(st.Incomplete))
+	}
+
+	return msg
+}
+
+func udpError(transactionID uint32, message string) []byte {
+	msg := make([]byte, 8, 8+len(message))
+	binary.BigEndian.PutUint32(msg, actionError)
+	binary.BigEndian.PutUint32(msg[4:], transactionID)
+	return append(msg, message...)
+}
@@ -0,0 +1,673 @@
+package utp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ============ Struct Defs  ============ //
+
+type connState int
+
+const (
+	stateSynSent connState = iota
+	stateConnected
+)
+
+// the retransmission timeout backs off up to this long
+const maxTimeout = time.Minute
+
+var (
+	// ErrConnReset occurs when the peer resets the connection
+	ErrConnReset = fmt.Errorf("utp connection reset by peer")
+	// ErrTimeout occurs when the peer stops acknowledging packets for MaxRetransmits timeouts in a row
+	ErrTimeout = fmt.Errorf("utp connection timed out")
+)
+
+/*
+Conn is a single uTP connection, it satisfies net.Conn so the peer wire protocol runs over it as it
+would over tcp.
+Writes are split into packets of at most 1380 bytes that stay buffered until acknowledged, as
+many are kept in flight as the LEDBAT window and the receive window of the peer allow. A packet is
+sent again when the peer acknowledges three packets sent after it, through duplicate or selective
+acks, or once nothing was acknowledged for a whole timeout. Packets arriving out of order are held
+until the gap is filled and reported back in a selective ack so only the missing ones are resent
+*/
+type Conn struct {
+	socket      *Socket
+	config      Config
+	remote      net.Addr
+	recvID      uint16        // connection id of the packets we receive
+	sendID      uint16        // connection id of the packets we send
+	established chan struct{} // closed once connected or failed
+
+	mu          sync.Mutex
+	wake        chan struct{} // closed and replaced whenever blocked reads and writes should look again
+	state       connState
+	err         error // why the connection failed
+	localClosed bool
+	closedAt    time.Time
+
+	// send side
+	seq          uint16       // sequence number of the next packet queued
+	initialSeq   uint16       // our first sequence number, repeated when a SYN arrives again
+	outbuf       []*outPacket // unacknowledged packets in sequence order
+	buffered     int          // payload bytes in outbuf
+	inFlight     int          // payload bytes sent and neither acknowledged nor presumed lost
+	pendingCount int          // packets in outbuf waiting to be sent
+	peerWnd      int
+	cc           *ledbat
+	lastAck      uint16
+	dupAcks      int
+	lastLoss     time.Time
+	rtt, rttVar  time.Duration
+	rto          time.Duration
+	timeouts     int // consecutive timeouts
+	finSent      bool
+	finAcked     bool
+
+	// receive side
+	ack        uint16             // last sequence number received in order
+	ooo        map[uint16]*packet // received out of order, keyed by sequence number
+	oooBytes   int
+	readBuf    []byte
+	eof        bool   // the FIN of the peer was received in order
+	replyDelay uint32 // one way delay of the last packet received, echoed to the peer
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+type outPacket struct {
+	typ           packetType
+	seq           uint16
+	payload       []byte
+	sentAt        time.Time
+	transmissions int
+	inFlight      bool // counted in Conn.inFlight
+	pending       bool // waiting to be sent, for the first time or again
+	fastResent    bool // already resent as lost since the last timeout
+}
+
+// ============ Method Defs  ============ //
+
+func newConn(s *Socket, remote net.Addr, recvID, sendID uint16) *Conn {
+	return &Conn{
+		socket:      s,
+		config:      s.config,
+		remote:      remote,
+		recvID:      recvID,
+		sendID:      sendID,
+		established: make(chan struct{}),
+		wake:        make(chan struct{}),
+		peerWnd:     s.config.MaxWindow,
+		cc:          newLEDBAT(s.config.Target, s.config.MaxWindow),
+		// BEP 29 starts at one second, twice the default minimum
+		rto: 2 * s.config.MinTimeout,
+		ooo: map[uint16]*packet{},
+	}
+}
+
+// connect sends the SYN of an outgoing connection, it carries the id we receive on
+func (c *Conn) connect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initialSeq, c.seq = 1, 1
+	c.queue(stSyn, nil)
+	c.flush(time.Now())
+}
+
+// accepted answers the SYN of an incoming connection, the connection is established once our state packet is sent
+func (c *Conn) accepted(syn *packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state = stateConnected
+	c.ack = syn.seq
+	c.replyDelay = timestamp(time.Now()) - syn.timestamp
+	c.peerWnd = int(syn.wnd)
+	c.initialSeq = randomUint16()
+	c.seq, c.lastAck = c.initialSeq, c.initialSeq-1
+	c.setEstablished()
+	c.sendState(c.seq)
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.socket.Addr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Read reads data received in order, io.EOF is returned once the peer closed and every byte was read
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		switch {
+		case c.localClosed:
+			return 0, net.ErrClosed
+		case expired(c.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		case len(c.readBuf) > 0:
+			before := c.recvWindow()
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			// a peer stalled on our window must hear that it opened again
+			if before < packetSize && c.recvWindow() >= packetSize && c.err == nil {
+				c.sendState(c.seq)
+			}
+			return n, nil
+		case c.eof:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		}
+
+		if !c.wait(c.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Write queues b for sending, blocking while MaxWindow bytes are unacknowledged or the window is full
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	written := 0
+	for written < len(b) {
+		switch {
+		case c.localClosed || c.finSent:
+			return written, net.ErrClosed
+		case c.err != nil:
+			return written, c.err
+		case expired(c.writeDeadline):
+			return written, os.ErrDeadlineExceeded
+		}
+
+		if c.pendingCount == 0 && c.buffered < c.config.MaxWindow {
+			n := min(maxPayload, len(b)-written)
+			c.queue(stData, append([]byte{}, b[written:written+n]...))
+			c.flush(time.Now())
+			written += n
+			continue
+		}
+
+		if !c.wait(c.writeDeadline) {
+			return written, os.ErrDeadlineExceeded
+		}
+	}
+	return written, nil
+}
+
+// Close sends a FIN after the data already written, the connection lingers in the socket until the
+// FIN is acknowledged and the peer has closed too, or Linger passes
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.localClosed {
+		return nil
+	}
+	c.localClosed, c.closedAt = true, time.Now()
+	c.readBuf = nil
+
+	if c.state == stateSynSent {
+		c.failLocked(net.ErrClosed)
+	}
+	c.sendFin()
+	return nil
+}
+
+// CloseWrite sends a FIN after the data already written, the peer reads io.EOF once it has read
+// everything while we may still read what it sends
+func (c *Conn) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.localClosed {
+		return net.ErrClosed
+	}
+	c.sendFin()
+	return nil
+}
+
+func (c *Conn) sendFin() {
+	if c.err == nil && !c.finSent {
+		c.finSent = true
+		c.queue(stFin, nil)
+		c.flush(time.Now())
+	}
+	c.broadcast()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.broadcast()
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.broadcast()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	c.broadcast()
+	return nil
+}
+
+// wait releases the lock until the connection changes or deadline passes, false on the deadline
+func (c *Conn) wait(deadline time.Time) bool {
+	wake := c.wake
+	c.mu.Unlock()
+	defer c.mu.Lock()
+
+	if deadline.IsZero() {
+		<-wake
+		return true
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-wake:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (c *Conn) broadcast() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+func (c *Conn) setEstablished() {
+	select {
+	case <-c.established:
+	default:
+		close(c.established)
+	}
+}
+
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failLocked(err)
+}
+
+// failLocked records the first error, every blocked read and write returns it
+func (c *Conn) failLocked(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.setEstablished()
+	c.broadcast()
+}
+
+func (c *Conn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// ============ Receiving  ============ //
+
+// handle applies a packet from the peer
+func (c *Conn) handle(p *packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	now := time.Now()
+	c.replyDelay = timestamp(now) - p.timestamp
+
+	switch {
+	case p.typ == stReset:
+		if c.state == stateSynSent {
+			c.failLocked(ErrConnRefused)
+		} else {
+			c.failLocked(ErrConnReset)
+		}
+		return
+	case p.typ == stSyn:
+		// our answer to the SYN was lost
+		if c.state == stateConnected && p.seq == c.ack {
+			c.sendState(c.initialSeq)
+		}
+		return
+	case c.state == stateSynSent:
+		// data sent before our SYN was acknowledged is resent by the peer
+		if p.typ != stState || p.ack != c.initialSeq {
+			return
+		}
+		c.state = stateConnected
+		c.ack = p.seq - 1
+		c.lastAck = p.ack
+		c.setEstablished()
+	}
+
+	c.peerWnd = int(p.wnd)
+	c.processAck(p, now)
+	if p.typ == stData || p.typ == stFin {
+		c.receive(p)
+	}
+	c.flush(now)
+	c.broadcast()
+}
+
+// receive delivers data in order, holding packets past a gap until it is filled
+func (c *Conn) receive(p *packet) {
+	defer c.sendState(c.seq)
+
+	ahead := seqDiff(p.seq, c.ack+1)
+	switch {
+	case c.eof || ahead < 0:
+		// already received, our ack was lost
+		return
+	case ahead >= maxSackBits || len(p.payload) > c.recvWindow():
+		return
+	case ahead > 0:
+		if _, ok := c.ooo[p.seq]; !ok {
+			c.ooo[p.seq] = p
+			c.oooBytes += len(p.payload)
+		}
+		return
+	}
+
+	c.deliver(p)
+	for !c.eof {
+		next, ok := c.ooo[c.ack+1]
+		if !ok {
+			break
+		}
+		delete(c.ooo, next.seq)
+		c.oooBytes -= len(next.payload)
+		c.deliver(next)
+	}
+	if c.eof {
+		clear(c.ooo)
+		c.oooBytes = 0
+	}
+}
+
+func (c *Conn) deliver(p *packet) {
+	c.ack = p.seq
+	if p.typ == stFin {
+		c.eof = true
+		return
+	}
+	// data arriving after we closed is acknowledged so the peer can finish, but nobody reads it
+	if !c.localClosed {
+		c.readBuf = append(c.readBuf, p.payload...)
+	}
+}
+
+func (c *Conn) recvWindow() int {
+	return max(0, c.config.MaxWindow-len(c.readBuf)-c.oooBytes)
+}
+
+// sackMask builds the selective ack of the packets held out of order, in multiples of 32 bits
+func (c *Conn) sackMask() []byte {
+	if len(c.ooo) == 0 {
+		return nil
+	}
+
+	highest := 0
+	for seq := range c.ooo {
+		highest = max(highest, seqDiff(seq, c.ack+2))
+	}
+	mask := make([]byte, (highest/32+1)*4)
+	for seq := range c.ooo {
+		if bit := seqDiff(seq, c.ack+2); bit >= 0 {
+			mask[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return mask
+}
+
+// sacked lists the sequence numbers set in a selective ack, in order
+func sacked(mask []byte, ack uint16) []uint16 {
+	var res []uint16
+	for bit := range len(mask) * 8 {
+		if mask[bit/8]&(1<<(bit%8)) != 0 {
+			res = append(res, ack+2+uint16(bit))
+		}
+	}
+	return res
+}
+
+func isSacked(mask []byte, ack, seq uint16) bool {
+	bit := seqDiff(seq, ack+2)
+	return bit >= 0 && bit < len(mask)*8 && mask[bit/8]&(1<<(bit%8)) != 0
+}
+
+// ============ Sending  ============ //
+
+// processAck drops every packet the peer acknowledged and looks for packets it is missing
+func (c *Conn) processAck(p *packet, now time.Time) {
+	// acks of packets we never sent are ignored
+	if seqDiff(p.ack, c.seq-1) > 0 {
+		return
+	}
+
+	selective := sacked(p.sack, p.ack)
+	ackedBytes := 0
+	c.outbuf = slices.DeleteFunc(c.outbuf, func(op *outPacket) bool {
+		if seqDiff(op.seq, p.ack) > 0 && !isSacked(p.sack, p.ack, op.seq) {
+			return false
+		}
+		ackedBytes += len(op.payload)
+		c.acked(op, now)
+		return true
+	})
+
+	switch advanced := seqDiff(p.ack, c.lastAck) > 0; {
+	case advanced:
+		c.lastAck, c.dupAcks = p.ack, 0
+	case p.typ == stState && ackedBytes == 0 && len(c.outbuf) > 0:
+		c.dupAcks++
+	}
+
+	if ackedBytes > 0 {
+		c.timeouts = 0
+		c.cc.onAck(ackedBytes, p.timestampDiff, now)
+	}
+	c.detectLoss(selective, now)
+}
+
+// acked forgets a packet the peer has received
+func (c *Conn) acked(op *outPacket, now time.Time) {
+	// retransmitted packets give no rtt sample as it is unknown which transmission was acknowledged
+	if op.transmissions == 1 {
+		c.updateRTT(now.Sub(op.sentAt))
+	}
+	if op.inFlight {
+		c.inFlight -= len(op.payload)
+	}
+	if op.pending {
+		c.pendingCount--
+	}
+	c.buffered -= len(op.payload)
+	if op.typ == stFin {
+		c.finAcked = true
+	}
+}
+
+// detectLoss presumes a packet lost once three packets sent after it arrived, as told by duplicate
+// acks or the selective ack, the window is halved at most once per round trip
+func (c *Conn) detectLoss(selective []uint16, now time.Time) {
+	// both are in sequence order, so the acks after a packet are those past the ones before it
+	lost, before := false, 0
+	for _, op := range c.outbuf {
+		for before < len(selective) && seqDiff(selective[before], op.seq) < 0 {
+			before++
+		}
+		if op.fastResent || !op.inFlight {
+			continue
+		}
+		after := len(selective) - before
+		first := op.seq == c.lastAck+1 && c.dupAcks >= 3
+		if after < 3 && !first {
+			continue
+		}
+		c.lose(op)
+		op.fastResent = true
+		lost = true
+	}
+
+	if lost && now.Sub(c.lastLoss) > c.rtt {
+		c.lastLoss = now
+		c.cc.onLoss()
+	}
+}
+
+func (c *Conn) lose(op *outPacket) {
+	if op.inFlight {
+		op.inFlight = false
+		c.inFlight -= len(op.payload)
+	}
+	if !op.pending {
+		op.pending = true
+		c.pendingCount++
+	}
+}
+
+func (c *Conn) updateRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt, c.rttVar = sample, sample/2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar += (delta - c.rttVar) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.rto = min(max(c.rtt+4*c.rttVar, c.config.MinTimeout), maxTimeout)
+}
+
+// queue adds a packet to the send buffer, it is sent by flush once the window allows
+func (c *Conn) queue(typ packetType, payload []byte) {
+	c.outbuf = append(c.outbuf, &outPacket{typ: typ, seq: c.seq, payload: payload, pending: true})
+	c.seq++
+	c.pendingCount++
+	c.buffered += len(payload)
+}
+
+// flush sends pending packets in sequence order while the window allows, at least one packet is
+// always allowed in flight so a closed window is probed
+func (c *Conn) flush(now time.Time) {
+	for _, op := range c.outbuf {
+		if c.pendingCount == 0 {
+			return
+		}
+		if !op.pending {
+			continue
+		}
+		if c.inFlight > 0 && c.inFlight+len(op.payload) > min(c.cc.window(), c.peerWnd) {
+			return
+		}
+		c.transmit(op, now)
+	}
+}
+
+func (c *Conn) transmit(op *outPacket, now time.Time) {
+	c.socket.send(c.packet(op.typ, op.seq, op.payload), c.remote)
+	op.sentAt = now
+	op.transmissions++
+	op.pending = false
+	c.pendingCount--
+	if !op.inFlight {
+		op.inFlight = true
+		c.inFlight += len(op.payload)
+	}
+}
+
+// sendState acknowledges what we received, seq is the sequence number of our next packet
+func (c *Conn) sendState(seq uint16) {
+	p := c.packet(stState, seq, nil)
+	p.sack = c.sackMask()
+	c.socket.send(p, c.remote)
+}
+
+func (c *Conn) packet(typ packetType, seq uint16, payload []byte) *packet {
+	connID := c.sendID
+	// the SYN carries the id the initiator receives on
+	if typ == stSyn {
+		connID = c.recvID
+	}
+	return &packet{
+		typ:           typ,
+		connID:        connID,
+		timestamp:     timestamp(time.Now()),
+		timestampDiff: c.replyDelay,
+		wnd:           uint32(c.recvWindow()),
+		seq:           seq,
+		ack:           c.ack,
+		payload:       payload,
+	}
+}
+
+// tick resends packets after a timeout and reports when the connection can be forgotten
+func (c *Conn) tick(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return true
+	}
+	if c.localClosed && ((c.finAcked && c.eof) || now.Sub(c.closedAt) >= c.config.Linger) {
+		c.failLocked(net.ErrClosed)
+		return true
+	}
+
+	var oldest time.Time
+	for _, op := range c.outbuf {
+		if op.inFlight && (oldest.IsZero() || op.sentAt.Before(oldest)) {
+			oldest = op.sentAt
+		}
+	}
+	if oldest.IsZero() || now.Sub(oldest) < c.rto {
+		return false
+	}
+
+	c.timeouts++
+	if c.timeouts > c.config.MaxRetransmits {
+		c.failLocked(ErrTimeout)
+		return true
+	}
+
+	// nothing came back for a whole timeout, everything in flight is presumed lost
+	c.rto = min(2*c.rto, maxTimeout)
+	c.cc.onTimeout()
+	for _, op := range c.outbuf {
+		c.lose(op)
+		op.fastResent = false
+	}
+	c.flush(now)
+	return false
+}
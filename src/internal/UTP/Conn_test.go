@@ -0,0 +1,317 @@
+package utp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	mathrand "math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn simulates a bad link, packets are dropped at random and delivered after latency plus
+// up to jitter. Without jitter packets arrive in the order they were sent, jitter reorders them
+type lossyConn struct {
+	net.PacketConn
+	loss    float64
+	latency time.Duration
+	jitter  time.Duration
+
+	mu      sync.Mutex
+	rand    *mathrand.Rand
+	inOrder chan delayed
+}
+
+type delayed struct {
+	at   time.Time
+	buf  []byte
+	addr net.Addr
+}
+
+// deliver writes packets queued by WriteTo in order once their time comes
+func (l *lossyConn) deliver(done <-chan struct{}) {
+	for {
+		select {
+		case d := <-l.inOrder:
+			time.Sleep(time.Until(d.at))
+			l.PacketConn.WriteTo(d.buf, d.addr)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (l *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	l.mu.Lock()
+	drop := l.rand.Float64() < l.loss
+	delay := l.latency
+	if l.jitter > 0 {
+		delay += time.Duration(l.rand.Int64N(int64(l.jitter)))
+	}
+	l.mu.Unlock()
+
+	if drop {
+		return len(b), nil
+	}
+	if delay == 0 {
+		return l.PacketConn.WriteTo(b, addr)
+	}
+	buf := append([]byte{}, b...)
+	if l.jitter == 0 {
+		l.inOrder <- delayed{time.Now().Add(delay), buf, addr}
+		return len(b), nil
+	}
+	time.AfterFunc(delay, func() { l.PacketConn.WriteTo(buf, addr) })
+	return len(b), nil
+}
+
+func testConfig() Config {
+	config := DefaultConfig()
+	config.MinTimeout = 50 * time.Millisecond
+	config.Linger = time.Second
+	return config
+}
+
+// socketPair returns two sockets on loopback sending through links with the given loss and latency
+func socketPair(t *testing.T, loss float64, latency, jitter time.Duration) (*Socket, *Socket) {
+	t.Helper()
+	var res [2]*Socket
+	for i := range res {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("DEV ERR: cannot listen - %s", err)
+		}
+		link := &lossyConn{
+			PacketConn: pc, loss: loss, latency: latency, jitter: jitter,
+			rand:    mathrand.New(mathrand.NewPCG(uint64(i), 1)),
+			inOrder: make(chan delayed, 4096),
+		}
+		done := make(chan struct{})
+		go link.deliver(done)
+		res[i] = NewSocket(link, testConfig())
+		t.Cleanup(func() {
+			res[i].Close()
+			close(done)
+		})
+	}
+	return res[0], res[1]
+}
+
+// connPair dials from a to b and returns both ends
+func connPair(t *testing.T, a, b *Socket) (*Conn, net.Conn) {
+	t.Helper()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := b.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dialed, err := a.DialContext(ctx, b.Addr().String())
+	if err != nil {
+		t.Fatalf("An error was thrown none expected, %v", err)
+	}
+
+	select {
+	case conn := <-accepted:
+		return dialed, conn
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection was never accepted")
+		return nil, nil
+	}
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	return data
+}
+
+func TestTransfer(t *testing.T) {
+	type TestCase struct {
+		testname string
+		loss     float64
+		latency  time.Duration
+		jitter   time.Duration
+	}
+
+	testcases := []TestCase{
+		{"clean loopback", 0, 0, 0},
+		{"latency", 0, 20 * time.Millisecond, 0},
+		{"loss", 0.05, 0, 0},
+		{"loss latency and reordering", 0.05, 10 * time.Millisecond, 10 * time.Millisecond},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			a, b := socketPair(t, tc.loss, tc.latency, tc.jitter)
+			dialed, accepted := connPair(t, a, b)
+
+			// both directions at once, each side half closes once it has written everything
+			up, down := randomData(1<<20), randomData(256*1024)
+			ends := []struct {
+				conn net.Conn
+				send []byte
+				recv []byte // what the other end sends
+			}{{dialed, up, down}, {accepted, down, up}}
+
+			errs := make(chan error, 4)
+			for _, end := range ends {
+				go func() {
+					if _, err := end.conn.Write(end.send); err != nil {
+						errs <- err
+						return
+					}
+					errs <- end.conn.(interface{ CloseWrite() error }).CloseWrite()
+				}()
+				go func() {
+					end.conn.SetReadDeadline(time.Now().Add(20 * time.Second))
+					got, err := io.ReadAll(end.conn)
+					if err == nil && !bytes.Equal(got, end.recv) {
+						t.Errorf("Got and want are not equal\nGOT:%d bytes\nWANT:%d bytes sent by the peer\n", len(got), len(end.recv))
+					}
+					errs <- err
+				}()
+			}
+
+			for range 4 {
+				if err := <-errs; err != nil {
+					t.Fatalf("An error was thrown none expected, %v", err)
+				}
+			}
+			dialed.Close()
+			accepted.Close()
+		})
+	}
+}
+
+func TestMultiplexing(t *testing.T) {
+	a, b := socketPair(t, 0, 0, 0)
+
+	// several connections share each socket, every one must get its own data back
+	const numConns = 8
+	var wg sync.WaitGroup
+	for i := range numConns {
+		dialed, accepted := connPair(t, a, b)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			go io.Copy(accepted, accepted)
+
+			data := randomData(64*1024 + i)
+			go dialed.Write(data)
+			got := make([]byte, len(data))
+			dialed.SetReadDeadline(time.Now().Add(10 * time.Second))
+			if _, err := io.ReadFull(dialed, got); err != nil {
+				t.Errorf("An error was thrown none expected, %v", err)
+				return
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("connection %d got another connections data", i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDialUnreachable(t *testing.T) {
+	// every packet is lost, the dial gives up with the context
+	a, b := socketPair(t, 1, 0, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := a.DialContext(ctx, b.Addr().String()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, context.DeadlineExceeded)
+	}
+
+	// without a deadline the retransmissions run out
+	config := testConfig()
+	config.MaxRetransmits = 2
+	a.config = config
+	if _, err := a.DialContext(context.Background(), b.Addr().String()); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrTimeout)
+	}
+}
+
+func TestConnDeadlinesAndClose(t *testing.T) {
+	a, b := socketPair(t, 0, 0, 0)
+	dialed, accepted := connPair(t, a, b)
+
+	// nothing is sent, the read times out as a net.Error
+	dialed.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := dialed.Read(make([]byte, 1))
+	var netErr net.Error
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, os.ErrDeadlineExceeded)
+	}
+
+	// a read blocked before the deadline is moved forward is released by it
+	dialed.SetReadDeadline(time.Time{})
+	released := make(chan error, 1)
+	go func() {
+		_, err := dialed.Read(make([]byte, 1))
+		released <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	dialed.SetReadDeadline(time.Now())
+	if err := <-released; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, os.ErrDeadlineExceeded)
+	}
+
+	// closing sends a FIN after the data, the peer reads it all then io.EOF
+	dialed.Write([]byte("last words"))
+	dialed.Close()
+	accepted.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, err := io.ReadAll(accepted)
+	if err != nil || string(got) != "last words" {
+		t.Errorf("Got and want are not equal\nGOT:%q %v\nWANT:last words\n", got, err)
+	}
+	if _, err := dialed.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, net.ErrClosed)
+	}
+
+	// once both ends closed the connections are forgotten
+	accepted.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for (numConns(a) > 0 || numConns(b) > 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if numConns(a) != 0 || numConns(b) != 0 {
+		t.Errorf("Got and want are not equal\nGOT:%d and %d connections\nWANT:none\n", numConns(a), numConns(b))
+	}
+}
+
+func numConns(s *Socket) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func TestSocketClose(t *testing.T) {
+	a, b := socketPair(t, 0, 0, 0)
+	dialed, _ := connPair(t, a, b)
+
+	accepting := make(chan error, 1)
+	go func() {
+		_, err := b.Accept()
+		accepting <- err
+	}()
+	b.Close()
+	if err := <-accepting; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, net.ErrClosed)
+	}
+
+	// the peer vanished, writes stop once the retransmissions run out
+	a.Close()
+	if _, err := dialed.Write([]byte("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, net.ErrClosed)
+	}
+}
@@ -0,0 +1,109 @@
+package utp
+
+import (
+	"time"
+)
+
+// ============ Struct Defs  ============ //
+
+const (
+	// the window grows by at most this many bytes per round trip once out of slow start
+	maxWindowIncreasePerRTT = 3000
+	minWindow               = packetSize
+	// the base delay is the lowest delay seen over this many minutes, so a route change is noticed
+	baseDelayHistory = 2
+)
+
+/*
+ledbat is the congestion controller of a connection (https://www.rfc-editor.org/rfc/rfc6817).
+The receiver reports the one way delay of our packets, the lowest delay seen recently is taken to
+be the delay of an empty queue so anything above it is queuing. The window grows while queuing
+delay is below target and shrinks when it is above, so we back off before other traffic suffers.
+The window starts in slow start, doubling every round trip until the delay reaches half the target
+or a packet is lost
+*/
+type ledbat struct {
+	target    float64 // queuing delay aimed for, microseconds
+	maxWindow float64
+
+	cwnd      float64 // bytes allowed in flight
+	slowStart bool
+
+	baseDelays [baseDelayHistory]uint32 // lowest delay seen per minute, the current minute last
+	minute     time.Time                // when the current minute started
+	haveBase   bool
+}
+
+// ============ Method Defs  ============ //
+
+func newLEDBAT(target time.Duration, maxWindow int) *ledbat {
+	return &ledbat{
+		target:    float64(target.Microseconds()),
+		maxWindow: float64(maxWindow),
+		cwnd:      2 * minWindow,
+		slowStart: true,
+	}
+}
+
+func (l *ledbat) window() int {
+	return int(l.cwnd)
+}
+
+// onAck adjusts the window for bytes newly acknowledged, delay is the one way delay the peer
+// measured for the packet that carried the ack. A delay of 0 means the peer has not measured one yet
+func (l *ledbat) onAck(bytesAcked int, delay uint32, now time.Time) {
+	if bytesAcked <= 0 || delay == 0 {
+		return
+	}
+
+	queuing := float64(delay - l.baseDelay(delay, now))
+	if l.slowStart {
+		if queuing < l.target/2 {
+			l.cwnd = min(l.cwnd+float64(bytesAcked), l.maxWindow)
+			return
+		}
+		l.slowStart = false
+	}
+
+	offTarget := (l.target - queuing) / l.target
+	l.cwnd += maxWindowIncreasePerRTT * offTarget * float64(bytesAcked) / l.cwnd
+	l.cwnd = max(min(l.cwnd, l.maxWindow), minWindow)
+}
+
+// onLoss halves the window, called at most once per round trip
+func (l *ledbat) onLoss() {
+	l.slowStart = false
+	l.cwnd = max(l.cwnd/2, minWindow)
+}
+
+// onTimeout shrinks the window to a single packet, nothing was heard back for a whole timeout
+func (l *ledbat) onTimeout() {
+	l.slowStart = false
+	l.cwnd = minWindow
+}
+
+// baseDelay records a delay sample and returns the lowest delay of the history
+func (l *ledbat) baseDelay(delay uint32, now time.Time) uint32 {
+	if !l.haveBase {
+		for i := range l.baseDelays {
+			l.baseDelays[i] = delay
+		}
+		l.minute, l.haveBase = now, true
+	}
+
+	// start a new minute, forgetting the oldest
+	if now.Sub(l.minute) >= time.Minute {
+		copy(l.baseDelays[:], l.baseDelays[1:])
+		l.baseDelays[len(l.baseDelays)-1] = delay
+		l.minute = now
+	}
+
+	last := &l.baseDelays[len(l.baseDelays)-1]
+	*last = min(*last, delay)
+
+	res := l.baseDelays[0]
+	for _, d := range l.baseDelays {
+		res = min(res, d)
+	}
+	return res
+}
@@ -0,0 +1,266 @@
+package utp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ============ Struct Defs  ============ //
+
+// Config holds the tunables of a socket and its connections
+type Config struct {
+	MaxWindow      int           // bytes buffered per connection, bounding both the receive window and the bytes in flight
+	Target         time.Duration // queuing delay LEDBAT aims for, 100ms as per BEP 29
+	MinTimeout     time.Duration // lower bound of the retransmission timeout
+	MaxRetransmits int           // consecutive timeouts before a connection fails
+	Linger         time.Duration // how long a closed connection waits for the peer to finish
+	AcceptBacklog  int           // connections waiting for Accept, further connections are refused
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxWindow:      1 << 20,
+		Target:         100 * time.Millisecond,
+		MinTimeout:     500 * time.Millisecond,
+		MaxRetransmits: 6,
+		Linger:         10 * time.Second,
+		AcceptBacklog:  64,
+	}
+}
+
+// how often connections check for timeouts
+const tickInterval = 10 * time.Millisecond
+
+/*
+Socket multiplexes uTP connections over a single udp socket, packets are routed to their
+connection by the address they came from and their connection id. A socket both accepts incoming
+connections, satisfying net.Listener, and dials outgoing ones. Datagrams that are not uTP packets are
+dropped
+*/
+type Socket struct {
+	pc     net.PacketConn
+	config Config
+
+	mu     sync.Mutex
+	conns  map[connKey]*Conn
+	accept chan *Conn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type connKey struct {
+	addr string
+	id   uint16 // our receive id, the connection id of every packet the peer sends
+}
+
+// ErrConnRefused occurs when a connection is reset before it was established
+var ErrConnRefused = fmt.Errorf("utp connection refused")
+
+// ============ Method Defs  ============ //
+
+// Listen opens a socket on a udp address such as ":6881"
+func Listen(addr string, config Config) (*Socket, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocket(pc, config), nil
+}
+
+// NewSocket takes ownership of pc and starts serving connections on it
+func NewSocket(pc net.PacketConn, config Config) *Socket {
+	s := &Socket{
+		pc:     pc,
+		config: config,
+		conns:  map[connKey]*Conn{},
+		accept: make(chan *Conn, config.AcceptBacklog),
+		closed: make(chan struct{}),
+	}
+
+	go s.readLoop()
+	go s.tickLoop()
+
+	return s
+}
+
+// Addr is the udp address the socket is bound to
+func (s *Socket) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+// Accept waits for the next incoming connection, returning net.ErrClosed once the socket is closed
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.accept:
+		return c, nil
+	case <-s.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// DialContext connects to a uTP peer at address, returning once the peer acknowledged the connection
+func (s *Socket) DialContext(ctx context.Context, address string) (*Conn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	var c *Conn
+	for c == nil {
+		recvID := randomUint16()
+		if _, taken := s.conns[connKey{addr.String(), recvID}]; !taken {
+			c = newConn(s, addr, recvID, recvID+1)
+			s.conns[connKey{addr.String(), recvID}] = c
+		}
+	}
+	s.mu.Unlock()
+
+	c.connect()
+
+	select {
+	case <-c.established:
+	case <-ctx.Done():
+		c.fail(ctx.Err())
+	case <-s.closed:
+		c.fail(net.ErrClosed)
+	}
+	if err := c.failure(); err != nil {
+		s.remove(c)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes every connection and the socket, connections are not given the chance to finish
+func (s *Socket) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.pc.Close()
+
+		s.mu.Lock()
+		conns := s.conns
+		s.conns = map[connKey]*Conn{}
+		s.mu.Unlock()
+
+		for _, c := range conns {
+			c.fail(net.ErrClosed)
+		}
+	})
+	return err
+}
+
+func (s *Socket) remove(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := connKey{c.remote.String(), c.recvID}
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+}
+
+func (s *Socket) send(p *packet, addr net.Addr) {
+	s.pc.WriteTo(p.marshal(), addr)
+}
+
+func (s *Socket) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.Close()
+				return
+			}
+			// icmp errors surface as read errors on some platforms, they concern a single peer
+			continue
+		}
+
+		p, err := parsePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.dispatch(p, addr)
+	}
+}
+
+// dispatch routes a packet to its connection, a SYN for an unknown connection is a new incoming connection
+func (s *Socket) dispatch(p *packet, addr net.Addr) {
+	if p.typ == stSyn {
+		s.handleSyn(p, addr)
+		return
+	}
+
+	s.mu.Lock()
+	c, ok := s.conns[connKey{addr.String(), p.connID}]
+	s.mu.Unlock()
+	if ok {
+		c.handle(p)
+	}
+}
+
+// handleSyn accepts a connection, the initiator receives on the id in the SYN and we receive on the one after
+func (s *Socket) handleSyn(p *packet, addr net.Addr) {
+	key := connKey{addr.String(), p.connID + 1}
+
+	s.mu.Lock()
+	c, ok := s.conns[key]
+	if !ok {
+		c = newConn(s, addr, p.connID+1, p.connID)
+		s.conns[key] = c
+	}
+	s.mu.Unlock()
+
+	// a repeated SYN means our reply was lost
+	if ok {
+		c.handle(p)
+		return
+	}
+
+	c.accepted(p)
+	select {
+	case s.accept <- c:
+	default:
+		s.remove(c)
+		s.send(&packet{typ: stReset, connID: p.connID, ack: p.seq}, addr)
+	}
+}
+
+func (s *Socket) tickLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			conns := make([]*Conn, 0, len(s.conns))
+			for _, c := range s.conns {
+				conns = append(conns, c)
+			}
+			s.mu.Unlock()
+
+			for _, c := range conns {
+				if done := c.tick(now); done {
+					s.remove(c)
+				}
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func randomUint16() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
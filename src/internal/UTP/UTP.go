@@ -0,0 +1,150 @@
+// Package utp implements the micro transport protocol (BEP 29), a reliable ordered stream over udp.
+// Its LEDBAT congestion control backs off as soon as queuing delay builds up so BitTorrent traffic
+// yields to everything else sharing the link
+package utp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// ============ Packet Defs  ============ //
+
+type packetType uint8
+
+const (
+	stData  packetType = 0
+	stFin   packetType = 1
+	stState packetType = 2 // an ack, carries no payload and does not take a sequence number
+	stReset packetType = 3
+	stSyn   packetType = 4
+)
+
+func (t packetType) String() string {
+	switch t {
+	case stData:
+		return "ST_DATA"
+	case stFin:
+		return "ST_FIN"
+	case stState:
+		return "ST_STATE"
+	case stReset:
+		return "ST_RESET"
+	case stSyn:
+		return "ST_SYN"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(t))
+	}
+}
+
+const (
+	version    = 1
+	headerSize = 20
+	// packets are kept below the smallest common path mtu once ip and udp headers are added
+	packetSize = 1400
+	maxPayload = packetSize - headerSize
+
+	extNone         = 0
+	extSelectiveAck = 1
+	// a selective ack covers at most this many packets past ack_nr + 1, the extension length is a single byte
+	maxSackBits = 1024
+)
+
+// ErrInvalidPacket occurs when a datagram is not a uTP version 1 packet
+var ErrInvalidPacket = fmt.Errorf("invalid utp packet")
+
+/*
+packet is a single uTP datagram, every packet starts with the same 20 byte header
+<type 4 bits><version 4 bits><extension 1 byte><connection_id 2 bytes>
+<timestamp_microseconds 4 bytes><timestamp_difference_microseconds 4 bytes>
+<wnd_size 4 bytes><seq_nr 2 bytes><ack_nr 2 bytes>
+followed by a linked list of extensions and the payload, all big endian
+*/
+type packet struct {
+	typ           packetType
+	connID        uint16
+	timestamp     uint32 // when the packet was sent, microseconds on the senders clock
+	timestampDiff uint32 // the one way delay of the last packet the sender received, as measured by the sender
+	wnd           uint32 // bytes the sender can still receive
+	seq           uint16
+	ack           uint16
+	sack          []byte // selective ack bitmask, bit 0 of byte 0 is ack + 2. nil when absent
+	payload       []byte
+}
+
+// ============ Method Defs  ============ //
+
+func (p *packet) marshal() []byte {
+	size := headerSize + len(p.payload)
+	if p.sack != nil {
+		size += 2 + len(p.sack)
+	}
+
+	buf := make([]byte, headerSize, size)
+	buf[0] = byte(p.typ)<<4 | version
+	if p.sack != nil {
+		buf[1] = extSelectiveAck
+	}
+	binary.BigEndian.PutUint16(buf[2:], p.connID)
+	binary.BigEndian.PutUint32(buf[4:], p.timestamp)
+	binary.BigEndian.PutUint32(buf[8:], p.timestampDiff)
+	binary.BigEndian.PutUint32(buf[12:], p.wnd)
+	binary.BigEndian.PutUint16(buf[16:], p.seq)
+	binary.BigEndian.PutUint16(buf[18:], p.ack)
+
+	if p.sack != nil {
+		buf = append(buf, extNone, byte(len(p.sack)))
+		buf = append(buf, p.sack...)
+	}
+	return append(buf, p.payload...)
+}
+
+// parsePacket decodes a datagram, unknown extensions are skipped. The payload is copied so the
+// caller may reuse b
+func parsePacket(b []byte) (*packet, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("%w - %d bytes is shorter than the header", ErrInvalidPacket, len(b))
+	}
+	if b[0]&0x0f != version {
+		return nil, fmt.Errorf("%w - version %d", ErrInvalidPacket, b[0]&0x0f)
+	}
+
+	p := &packet{
+		typ:           packetType(b[0] >> 4),
+		connID:        binary.BigEndian.Uint16(b[2:]),
+		timestamp:     binary.BigEndian.Uint32(b[4:]),
+		timestampDiff: binary.BigEndian.Uint32(b[8:]),
+		wnd:           binary.BigEndian.Uint32(b[12:]),
+		seq:           binary.BigEndian.Uint16(b[16:]),
+		ack:           binary.BigEndian.Uint16(b[18:]),
+	}
+	if p.typ > stSyn {
+		return nil, fmt.Errorf("%w - type %s", ErrInvalidPacket, p.typ)
+	}
+
+	ext, offset := b[1], headerSize
+	for ext != extNone {
+		if offset+2 > len(b) || offset+2+int(b[offset+1]) > len(b) {
+			return nil, fmt.Errorf("%w - truncated extension", ErrInvalidPacket)
+		}
+		next, length := b[offset], int(b[offset+1])
+		if ext == extSelectiveAck {
+			p.sack = append([]byte{}, b[offset+2:offset+2+length]...)
+		}
+		ext, offset = next, offset+2+length
+	}
+
+	p.payload = append([]byte{}, b[offset:]...)
+	return p, nil
+}
+
+// seqDiff is how far a is ahead of b, negative when behind, taking wrap around into account
+func seqDiff(a, b uint16) int {
+	return int(int16(a - b))
+}
+
+// timestamp is the current time in microseconds, only differences between timestamps are meaningful
+func timestamp(now time.Time) uint32 {
+	return uint32(now.UnixMicro())
+}
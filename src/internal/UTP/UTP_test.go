@@ -0,0 +1,127 @@
+package utp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	type TestCase struct {
+		testname string
+		input    *packet
+	}
+
+	testcases := []TestCase{
+		{"syn", &packet{typ: stSyn, connID: 0x1234, timestamp: 1, wnd: 1 << 20, seq: 1, payload: []byte{}}},
+		{"data", &packet{typ: stData, connID: 7, timestamp: 0xffffffff, timestampDiff: 250, wnd: 65535, seq: 0xffff, ack: 3, payload: []byte("block")}},
+		{"state with selective ack", &packet{typ: stState, connID: 7, seq: 4, ack: 9, sack: []byte{0b101, 0, 0, 0x80}, payload: []byte{}}},
+		{"fin", &packet{typ: stFin, connID: 8, seq: 10, ack: 2, payload: []byte{}}},
+		{"reset", &packet{typ: stReset, connID: 9, payload: []byte{}}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testname, func(t *testing.T) {
+			raw := tc.input.marshal()
+			if raw[0] != byte(tc.input.typ)<<4|version {
+				t.Errorf("Got and want are not equal\nGOT:%08b\nWANT:type %d version 1\n", raw[0], tc.input.typ)
+			}
+
+			got, err := parsePacket(raw)
+			if err != nil {
+				t.Fatalf("An error was thrown none expected, %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.input) {
+				t.Errorf("Got and want are not equal\nGOT:%+v\nWANT:%+v\n", got, tc.input)
+			}
+		})
+	}
+}
+
+func TestParsePacketMalformed(t *testing.T) {
+	valid := (&packet{typ: stData, connID: 1, seq: 1}).marshal()
+	withExt := (&packet{typ: stState, sack: []byte{1, 0, 0, 0}}).marshal()
+
+	testcases := map[string][]byte{
+		"too short":           valid[:headerSize-1],
+		"wrong version":       append([]byte{byte(stData)<<4 | 2}, valid[1:]...),
+		"unknown type":        append([]byte{5<<4 | version}, valid[1:]...),
+		"truncated extension": withExt[:headerSize+3],
+		"not utp":             []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe"),
+	}
+
+	for testname, input := range testcases {
+		t.Run(testname, func(t *testing.T) {
+			if _, err := parsePacket(input); !errors.Is(err, ErrInvalidPacket) {
+				t.Errorf("Got and want are not equal\nGOT:%v\nWANT:%v\n", err, ErrInvalidPacket)
+			}
+		})
+	}
+}
+
+func TestSeqDiff(t *testing.T) {
+	type TestCase struct {
+		a, b     uint16
+		expected int
+	}
+
+	testcases := []TestCase{
+		{5, 3, 2},
+		{3, 5, -2},
+		{1, 0xffff, 2},
+		{0xffff, 1, -2},
+	}
+
+	for _, tc := range testcases {
+		if got := seqDiff(tc.a, tc.b); got != tc.expected {
+			t.Errorf("Got and want are not equal\nGOT:seqDiff(%d, %d) = %d\nWANT:%d\n", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestLEDBAT(t *testing.T) {
+	now := time.Now()
+	target := 100 * time.Millisecond
+
+	// a base delay of 10ms, slow start doubles the window while the queue stays empty
+	l := newLEDBAT(target, 1<<20)
+	l.onAck(l.window(), 10000, now)
+	if got := l.window(); got != 4*minWindow || !l.slowStart {
+		t.Fatalf("Got and want are not equal\nGOT:%d slow start %v\nWANT:%d in slow start\n", got, l.slowStart, 4*minWindow)
+	}
+
+	// queuing beyond half the target ends slow start, beyond the target shrinks the window
+	before := l.window()
+	l.onAck(minWindow, 10000+150000, now)
+	if got := l.window(); l.slowStart || got >= before {
+		t.Errorf("Got and want are not equal\nGOT:%d slow start %v\nWANT:below %d\n", got, l.slowStart, before)
+	}
+
+	// below the target it grows again, by at most 3000 bytes a round trip
+	before = l.window()
+	l.onAck(before, 10000, now)
+	if got := l.window(); got <= before || got > before+maxWindowIncreasePerRTT {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:between %d and %d\n", got, before, before+maxWindowIncreasePerRTT)
+	}
+
+	before = l.window()
+	l.onLoss()
+	if got, want := l.window(), max(before/2, minWindow); got < want || got > want+1 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", got, want)
+	}
+	l.onTimeout()
+	if got := l.window(); got != minWindow {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:%d\n", got, minWindow)
+	}
+
+	// the base delay forgets samples older than its history
+	l = newLEDBAT(target, 1<<20)
+	l.baseDelay(5000, now)
+	if got := l.baseDelay(9000, now.Add(time.Minute)); got != 5000 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:5000\n", got)
+	}
+	if got := l.baseDelay(9000, now.Add(2*time.Minute)); got != 9000 {
+		t.Errorf("Got and want are not equal\nGOT:%d\nWANT:9000\n", got)
+	}
+}